@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,28 +13,45 @@ import (
 )
 
 // AnalyzeProjectHandler handles GET /analyze-project requests
-func AnalyzeProjectHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AnalyzeProjectHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Get the current working directory to determine project root
-	currentDir, err := os.Getwd()
+	output, err := runProjectAnalyzer(r.Context())
 	if err != nil {
-		log.Printf("Error getting current directory: %v", err)
+		log.Printf("Error executing analyze command: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(AnalyzeProjectResponse{
 			Success: false,
-			Error:   "Failed to get current directory",
+			Error:   err.Error(),
+			Output:  output,
 		})
 		return
 	}
 
+	// Return successful response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AnalyzeProjectResponse{
+		Success: true,
+		Output:  output,
+	})
+}
+
+// runProjectAnalyzer runs code-analyzer on the project and returns its output without truncation warnings.
+// On failure the output collected so far is returned together with the error.
+func runProjectAnalyzer(ctx context.Context) (string, error) {
+	// Get the current working directory to determine project root
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get current directory: %w", err)
+	}
+
 	// Navigate to project root (assuming we're in back/ directory)
 	projectRoot := filepath.Dir(currentDir)
 
 	// Prepare the command - the executable is in code-analyzer directory
 	analyzerPath := filepath.Join(projectRoot, "code-analyzer", "code-analyzer")
-	cmd := exec.Command(analyzerPath, "analyze", "../28/back", "--verbose", "--exclude", "*.mod", "--exclude", "*.sum", "--exclude", "*.html")
+	cmd := exec.CommandContext(ctx, analyzerPath, "analyze", "../28/back", "--verbose", "--exclude", "*.mod", "--exclude", "*.sum", "--exclude", "*.html")
 	cmd.Dir = projectRoot
 
 	// Execute the command and capture output
@@ -42,22 +61,9 @@ func AnalyzeProjectHandler(w http.ResponseWriter, r *http.Request) {
 	filteredOutput := filterWarningLines(string(output))
 
 	if err != nil {
-		log.Printf("Error executing analyze command: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AnalyzeProjectResponse{
-			Success: false,
-			Error:   "Failed to execute analyze command: " + err.Error(),
-			Output:  filteredOutput,
-		})
-		return
+		return filteredOutput, fmt.Errorf("Failed to execute analyze command: %w", err)
 	}
-
-	// Return successful response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(AnalyzeProjectResponse{
-		Success: true,
-		Output:  filteredOutput,
-	})
+	return filteredOutput, nil
 }
 
 // filterWarningLines removes warning lines about file truncation from the output
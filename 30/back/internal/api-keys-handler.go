@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKeyRequest is the body of POST /api-keys
+type CreateAPIKeyRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	Name   string `json:"name,omitempty"`
+}
+
+// CreateAPIKeyResponse contains the plain key, which is not shown again
+type CreateAPIKeyResponse struct {
+	Key    string       `json:"key"`
+	APIKey *repo.APIKey `json:"api_key"`
+}
+
+// CreateAPIKeyHandler issues an API key for a user (admin only)
+func (a *App) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" || !ValidUserRole(req.Role) {
+		http.Error(w, "user_id and a valid role (viewer, builder, publisher, admin) are required", http.StatusBadRequest)
+		return
+	}
+
+	plain, key, err := CreateAPIKey(r.Context(), a.Repository, req.UserID, req.Role, req.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setAuditTarget(r.Context(), fmt.Sprintf("api_key:%d", key.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: plain, APIKey: key})
+}
+
+// ListAPIKeysHandler lists all API keys without their secrets (admin only)
+func (a *App) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	keys, err := a.Repository.GetAPIKeys(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get API keys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []*repo.APIKey{}
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKeyHandler revokes an API key (admin only)
+func (a *App) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API key id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.Repository.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WhoAmIHandler returns the identity of the caller
+func WhoAmIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	identity, ok := IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(identity)
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"chat-web-service-backend/mcp"
+	"chat-web-service-backend/repo"
+)
+
+// defaultShutdownTimeout bounds the graceful shutdown: open requests and running
+// builds get this long to finish before they are cancelled
+const defaultShutdownTimeout = 30 * time.Second
+
+// App owns the dependencies shared by the handlers, jobs and MCP tools: one
+// repository pool, the LLM providers of the roles and the MCP clients
+type App struct {
+	Config     *Config
+	Repository repo.Repository
+	Jobs       *JobQueue
+	Auth       *Authenticator
+	Quotas     *QuotaManager
+	Preview    *PreviewServer
+	Guard      *Guard
+	Schema     *RequirementsSchema
+
+	providersMu sync.Mutex
+	providers   map[string]LLMProvider
+
+	githubMCPMu sync.Mutex
+	githubMCP   *mcp.Client
+
+	sessionsMu sync.Mutex
+	sessions   map[string]*sessionLock // dialog sessions with a turn running, by user
+
+	projectsMu sync.Mutex
+	projects   map[int64]*projectLock // projects with a revise job running or waiting
+}
+
+// NewApp opens the repository and sets up the components of the service from the config.
+// The job queue is created but not started, see Start.
+func NewApp(cfg *Config) (*App, error) {
+	repository, err := repo.NewRepository(cfg.Database.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	a := &App{
+		Config:     cfg,
+		Repository: repository,
+		providers:  make(map[string]LLMProvider),
+	}
+
+	if err := a.init(); err != nil {
+		repository.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *App) init() error {
+	// The guard screens jobs and MCP tool calls too, so it is set up before them
+	guard, err := LoadGuard(a.Config.Files.Guard, a.Provider)
+	if err != nil {
+		return fmt.Errorf("invalid guard configuration: %w", err)
+	}
+	a.Guard = guard
+
+	authConfig, err := LoadAuthConfig(a.Config.Auth)
+	if err != nil {
+		return fmt.Errorf("invalid auth configuration: %w", err)
+	}
+	a.Auth = NewAuthenticator(authConfig, a.Repository)
+
+	a.Schema, err = LoadRequirementsSchema(a.Config.Files.RequirementsSchema)
+	if err != nil {
+		return fmt.Errorf("invalid requirements schema: %w", err)
+	}
+
+	quotasConfig, err := LoadQuotas(a.Config.Files.Quotas)
+	if err != nil {
+		return fmt.Errorf("invalid quota configuration: %w", err)
+	}
+	a.Quotas = NewQuotaManager(quotasConfig, a.Repository)
+
+	a.Preview, err = NewPreviewServer(a.Config.Preview, a.Repository, a.Guard)
+	if err != nil {
+		return fmt.Errorf("failed to create preview server: %w", err)
+	}
+
+	a.Jobs = NewJobQueue(a.Repository, a.Config.Jobs.BuildWorkers)
+	a.Jobs.Handle(JobTypeBuild, a.runBuildJob)
+	a.Jobs.Handle(JobTypePipeline, a.runPipelineJob)
+	a.Jobs.Handle(JobTypeRevise, a.runReviseJob)
+	a.Jobs.Handle(JobTypeVerify, a.runVerifyJob)
+	return nil
+}
+
+// Start resumes the jobs interrupted by a restart and starts the job workers
+func (a *App) Start(ctx context.Context) error {
+	return a.Jobs.Start(ctx)
+}
+
+// Provider returns the shared provider of the role. Providers are created on
+// first use and charge the tokens of every completion to the caller's quota.
+func (a *App) Provider(role string) (LLMProvider, ProviderConfig, error) {
+	cfg, err := a.Config.Provider(role)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	a.providersMu.Lock()
+	defer a.providersMu.Unlock()
+
+	if provider, ok := a.providers[role]; ok {
+		return provider, cfg, nil
+	}
+
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, cfg, err
+	}
+	metered := meteredProvider{LLMProvider: provider, repository: a.Repository}
+	a.providers[role] = metered
+	return metered, cfg, nil
+}
+
+// GitHubMCP returns the persistent client of the GitHub MCP server, launched
+// on first use with the github_mcp command and args of the config
+func (a *App) GitHubMCP() *mcp.Client {
+	a.githubMCPMu.Lock()
+	defer a.githubMCPMu.Unlock()
+
+	if a.githubMCP == nil {
+		a.githubMCP = mcp.NewClient(a.Config.GitHubMCP.ServerConfig("github"))
+	}
+	return a.githubMCP
+}
+
+// Run serves the API and the preview origin until ctx is cancelled, e.g. by
+// SIGTERM, then shuts down gracefully: the servers stop accepting connections
+// and finish open requests, running builds are drained, and only then the MCP
+// clients and the database are closed.
+func (a *App) Run(ctx context.Context, api http.Handler) error {
+	servers := []*http.Server{
+		{Addr: ":" + strconv.Itoa(a.Config.Server.Port), Handler: api},
+		{Addr: a.Preview.Addr(), Handler: a.Preview},
+	}
+
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("server on %s: %w", server.Addr, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down...")
+	case err = <-serveErr:
+		log.Printf("Shutting down after a server error: %v", err)
+	}
+
+	timeout := a.Config.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Printf("Server on %s did not shut down cleanly: %v", server.Addr, shutdownErr)
+		}
+	}
+	a.Jobs.Drain(shutdownCtx)
+
+	return errors.Join(err, a.Close())
+}
+
+// Close stops the job workers, the MCP servers started by the backend and closes the repository
+func (a *App) Close() error {
+	if a.Jobs != nil {
+		a.Jobs.Stop()
+	}
+
+	a.githubMCPMu.Lock()
+	if a.githubMCP != nil {
+		a.githubMCP.Close()
+		a.githubMCP = nil
+	}
+	a.githubMCPMu.Unlock()
+
+	return a.Repository.Close()
+}
@@ -1,16 +1,19 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+
+	"chat-web-service-backend/repo"
 )
 
 type AskRequest struct {
-	Message string `json:"message"`
-	UserID  string `json:"user_id,omitempty"`
+	Message string `json:"message" description:"Ответ пользователя в диалоге сбора требований"`
+	UserID  string `json:"user_id,omitempty" description:"Идентификатор пользователя, которому принадлежит диалог"`
 }
 
 type AskResponse struct {
@@ -19,13 +22,14 @@ type AskResponse struct {
 }
 
 type RequirementsResponse struct {
-	Status       string       `json:"status"`
-	Requirements Requirements `json:"requirements"`
-	IsComplete   bool         `json:"is_complete"`
-	History      []Message    `json:"history"`
+	Status       string            `json:"status"`
+	Requirements Requirements      `json:"requirements"`
+	MissingSlots []RequirementSlot `json:"missing_slots"`
+	IsComplete   bool              `json:"is_complete"`
+	History      []Message         `json:"history"`
 }
 
-func AskHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AskHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -46,6 +50,9 @@ func AskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The authenticated user replaces the client-supplied user_id
+	askReq.UserID = resolveUserID(r.Context(), askReq.UserID)
+
 	// Log the incoming request to console
 	log.Printf("=== Incoming /ask request ===")
 	log.Printf("Message: %s", askReq.Message)
@@ -53,19 +60,29 @@ func AskHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Raw body: %s", string(body))
 	log.Printf("=============================")
 
-	// Get or create session for the user
-	session := GetOrCreateSession(askReq.UserID)
+	ctx := r.Context()
 
-	// Add user message to session history
-	AddMessageToSession(session, "user", askReq.Message)
+	// Create LLM client for requirements extraction and the dialog answer
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// Get dialog history as string
-	history := GetHistoryAsString(session)
+	// The turn holds the session until the answer is stored
+	defer a.lockSession(askReq.UserID)()
+	session, systemPrompt, err := a.startAskTurn(ctx, llmClient, askReq)
+	if writeGuardRejection(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading dialog session: %v", err)
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// Create LLM client and get response using requirements gathering prompt
-	llmClient := NewLLMClient()
-	systemPrompt := GetRequirementsGatheringPrompt(history, session.Requirements)
-	llmResponse, err := llmClient.GetLLMResponse(askReq.Message, systemPrompt)
+	// Get response using requirements gathering prompt
+	llmResponse, err := llmClient.GetLLMResponse(ctx, askReq.Message, systemPrompt)
 
 	var response AskResponse
 	if err != nil {
@@ -74,26 +91,13 @@ func AskHandler(w http.ResponseWriter, r *http.Request) {
 			Status:  "error",
 			Message: fmt.Sprintf("Ошибка обработки запроса: %v", err),
 		}
+	} else if err := finishAskTurn(ctx, a.Repository, session, askReq.Message, llmResponse); err != nil {
+		log.Printf("Error saving dialog session: %v", err)
+		response = AskResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Ошибка сохранения диалога: %v", err),
+		}
 	} else {
-		log.Printf("=== LLM Response ===")
-		log.Printf("Response: %s", llmResponse)
-		log.Printf("===================")
-
-		// Add assistant response to session history
-		AddMessageToSession(session, "assistant", llmResponse)
-
-		// Update requirements based on the conversation
-		UpdateRequirementsFromResponse(session, askReq.Message, llmResponse)
-
-		// Log current requirements state
-		log.Printf("=== Current Requirements ===")
-		log.Printf("Site Type: %s", session.Requirements.SiteType)
-		log.Printf("Target Audience: %s", session.Requirements.TargetAudience)
-		log.Printf("Note: %s", session.Requirements.Note)
-		log.Printf("Current Question: %s", session.CurrentQuestion)
-		log.Printf("Is Complete: %t", session.IsComplete)
-		log.Printf("============================")
-
 		response = AskResponse{
 			Status:  "success",
 			Message: llmResponse,
@@ -104,20 +108,147 @@ func AskHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func RequirementsHandler(w http.ResponseWriter, r *http.Request) {
+// AskStreamHandler is the Server-Sent Events variant of AskHandler.
+// It sends "token" events while the LLM generates and a final "done" event with AskResponse.
+func (a *App) AskStreamHandler(w http.ResponseWriter, r *http.Request) {
+	var askReq AskRequest
+	if err := json.NewDecoder(r.Body).Decode(&askReq); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	askReq.UserID = resolveUserID(r.Context(), askReq.UserID)
+
+	log.Printf("=== Incoming /ask/stream request ===")
+	log.Printf("Message: %s", askReq.Message)
+	log.Printf("User ID: %s", askReq.UserID)
+	log.Printf("====================================")
+
+	ctx := r.Context()
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The turn holds the session until the answer is stored
+	defer a.lockSession(askReq.UserID)()
+	session, systemPrompt, err := a.startAskTurn(ctx, llmClient, askReq)
+	if writeGuardRejection(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading dialog session: %v", err)
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	llmResponse, err := llmClient.StreamLLMResponse(ctx, askReq.Message, systemPrompt, sse.Token)
+	if err != nil {
+		log.Printf("Error streaming LLM response: %v", err)
+		sse.Error(fmt.Errorf("Ошибка обработки запроса: %v", err))
+		return
+	}
+
+	if err := finishAskTurn(ctx, a.Repository, session, askReq.Message, llmResponse); err != nil {
+		log.Printf("Error saving dialog session: %v", err)
+		sse.Error(fmt.Errorf("Ошибка сохранения диалога: %v", err))
+		return
+	}
+
+	sse.Send(SSEEventDone, AskResponse{
+		Status:  "success",
+		Message: llmResponse,
+	})
+}
+
+// startAskTurn extracts requirement slots from the user message and builds the requirements
+// gathering prompt for the slots that are still missing. The message joins the history of the
+// prompt but is stored only by finishAskTurn, so a failed turn leaves no unanswered message.
+func (a *App) startAskTurn(ctx context.Context, llmClient *LLMClient, askReq AskRequest) (*DialogSession, string, error) {
+	// The message reaches both the extraction and the gathering prompt
+	if _, err := a.screenInput(ctx, "message", askReq.Message); err != nil {
+		return nil, "", err
+	}
+
+	// Get or create session for the user
+	session, err := GetOrCreateSession(ctx, a.Repository, askReq.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	linkAudit(ctx, 0, session.ChatID)
+
+	session.History = append(session.History, Message{Role: "user", Content: askReq.Message})
+
+	// Update requirements based on the user's answer
+	if err := UpdateRequirementsFromResponse(ctx, a.Repository, llmClient, a.Schema, session, askReq.Message); err != nil {
+		return nil, "", err
+	}
+
+	// Log current requirements state
+	log.Printf("=== Current Requirements ===")
+	for _, slot := range a.Schema.Slots {
+		log.Printf("%s: %s", slot.Label, session.Requirements[slot.Name])
+	}
+	log.Printf("Current Question: %s", session.CurrentQuestion)
+	log.Printf("Is Complete: %t", session.IsComplete)
+	log.Printf("============================")
+
+	// Get dialog history as string
+	history := GetHistoryAsString(session)
+
+	return session, GetRequirementsGatheringPrompt(history, a.Schema, session.Requirements), nil
+}
+
+// finishAskTurn records the user message of the turn together with the assistant answer
+func finishAskTurn(ctx context.Context, repository repo.Repository, session *DialogSession, userMessage, llmResponse string) error {
+	log.Printf("=== LLM Response ===")
+	log.Printf("Response: %s", llmResponse)
+	log.Printf("===================")
+
+	message, err := repository.CreateMessage(ctx, session.ChatID, "user", userMessage)
+	if err != nil {
+		return fmt.Errorf("failed to store message: %w", err)
+	}
+	if err := AddMessageToSession(ctx, repository, session, "assistant", llmResponse); err != nil {
+		// Без ответа вопрос пользователя не сохраняется, как и при ошибке LLM
+		if deleteErr := repository.DeleteMessage(context.WithoutCancel(ctx), message.ID); deleteErr != nil {
+			log.Printf("Failed to delete unanswered message %d: %v", message.ID, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *App) RequirementsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	userID := r.URL.Query().Get("user_id")
+	userID := resolveUserID(r.Context(), r.URL.Query().Get("user_id"))
 	if userID == "" {
 		userID = "default"
 	}
 
-	session := GetOrCreateSession(userID)
+	defer a.lockSession(userID)()
+	session, err := GetOrCreateSession(r.Context(), a.Repository, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Session error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	response := RequirementsResponse{
 		Status:       "success",
 		Requirements: session.Requirements,
+		MissingSlots: a.Schema.MissingSlots(session.Requirements),
 		IsComplete:   session.IsComplete,
 		History:      session.History,
 	}
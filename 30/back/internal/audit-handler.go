@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// Audit page sizes
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// AuditEventsResponse is a page of audit events, newest first.
+// NextCursor is passed as ?cursor= to get the next page; it is empty on the last page.
+type AuditEventsResponse struct {
+	Events     []*repo.AuditEvent `json:"events"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// ListAuditEventsHandler returns the audit events matching the query (admin only).
+// Filters: actor, action, outcome, target, project_id, chat_id, since, until (RFC 3339);
+// paging: limit and cursor.
+func (a *App) ListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageSize := filter.Limit
+	// One more event tells whether there is a next page
+	filter.Limit++
+
+	events, err := a.Repository.GetAuditEvents(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get audit events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := AuditEventsResponse{Events: events}
+	if len(events) > pageSize {
+		response.Events = events[:pageSize]
+		response.NextCursor = strconv.FormatInt(events[pageSize-1].ID, 10)
+	}
+	if response.Events == nil {
+		response.Events = []*repo.AuditEvent{}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseAuditFilter(query url.Values) (repo.AuditFilter, error) {
+	filter := repo.AuditFilter{
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Outcome: query.Get("outcome"),
+		Target:  query.Get("target"),
+		Limit:   defaultAuditPageSize,
+	}
+
+	parseID := func(name string, dest *int64) error {
+		value := query.Get(name)
+		if value == "" {
+			return nil
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid %s", name)
+		}
+		*dest = id
+		return nil
+	}
+	parseTime := func(name string, dest *time.Time) error {
+		value := query.Get(name)
+		if value == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s, expected RFC 3339 time", name)
+		}
+		*dest = t
+		return nil
+	}
+
+	if err := parseID("project_id", &filter.ProjectID); err != nil {
+		return filter, err
+	}
+	if err := parseID("chat_id", &filter.ChatID); err != nil {
+		return filter, err
+	}
+	if err := parseID("cursor", &filter.BeforeID); err != nil {
+		return filter, err
+	}
+	if err := parseTime("since", &filter.Since); err != nil {
+		return filter, err
+	}
+	if err := parseTime("until", &filter.Until); err != nil {
+		return filter, err
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+		filter.Limit = min(limit, maxAuditPageSize)
+	}
+
+	return filter, nil
+}
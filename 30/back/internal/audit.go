@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// auditActorOperator is the actor of calls without an identity: MCP over stdio and the CLI
+const auditActorOperator = "operator"
+
+// auditActorUnauthenticated is the actor of requests refused for missing or invalid credentials
+const auditActorUnauthenticated = "unauthenticated"
+
+// auditActionAuth is the action of requests refused by authentication or the role check
+const auditActionAuth = "auth"
+
+// maxAuditErrorLength limits the stored error text of a failed request
+const maxAuditErrorLength = 500
+
+type auditEventContextKey struct{}
+
+// withAuditEvent attaches the event being recorded, so the code handling the
+// request can name its target and link the project and chat
+func withAuditEvent(ctx context.Context, event *repo.AuditEvent) context.Context {
+	return context.WithValue(ctx, auditEventContextKey{}, event)
+}
+
+func currentAuditEvent(ctx context.Context) (*repo.AuditEvent, bool) {
+	event, ok := ctx.Value(auditEventContextKey{}).(*repo.AuditEvent)
+	return event, ok
+}
+
+// setAuditTarget names the target of the recorded action, e.g. "job:<id>"
+func setAuditTarget(ctx context.Context, target string) {
+	if event, ok := currentAuditEvent(ctx); ok {
+		event.Target = target
+	}
+}
+
+// linkAudit links the recorded action to a project and chat; zero IDs are ignored
+func linkAudit(ctx context.Context, projectID, chatID int64) {
+	event, ok := currentAuditEvent(ctx)
+	if !ok {
+		return
+	}
+	if projectID != 0 {
+		event.ProjectID = &projectID
+	}
+	if chatID != 0 {
+		event.ChatID = &chatID
+	}
+}
+
+// auditOutcome classifies the error of an action
+func auditOutcome(err error) string {
+	var exceeded *QuotaExceededError
+	switch {
+	case err == nil:
+		return repo.AuditOutcomeSuccess
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrUnauthenticated), errors.As(err, &exceeded):
+		return repo.AuditOutcomeDenied
+	default:
+		return repo.AuditOutcomeFailed
+	}
+}
+
+// hashRequest returns the hex SHA-256 of the request parts
+func hashRequest(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeAuditEvent fills the actor and times of the event and stores it. Unless set,
+// the actor is the authenticated caller, or else the actor of the action the context
+// belongs to, e.g. the owner of a job. A failed write is logged, it does not fail the audited action.
+func writeAuditEvent(ctx context.Context, repository repo.Repository, event *repo.AuditEvent) {
+	if event.Actor == "" {
+		if identity, ok := IdentityFromContext(ctx); ok {
+			event.Actor = identity.UserID
+			event.ActorRole = identity.Role
+		} else if parent, ok := currentAuditEvent(ctx); ok && parent != event && parent.Actor != "" {
+			event.Actor = parent.Actor
+			event.ActorRole = parent.ActorRole
+		} else {
+			event.Actor = auditActorOperator
+		}
+	}
+	if event.FinishedAt.IsZero() {
+		event.FinishedAt = time.Now()
+	}
+	if event.StartedAt.IsZero() {
+		event.StartedAt = event.FinishedAt
+	}
+	if event.Outcome == "" {
+		event.Outcome = repo.AuditOutcomeSuccess
+	}
+
+	// The action already happened, it is recorded even if the request was cancelled
+	if err := repository.CreateAuditEvent(context.WithoutCancel(ctx), event); err != nil {
+		log.Printf("Failed to record audit event %s by %s: %v", event.Action, event.Actor, err)
+	}
+}
+
+// Audit is the middleware recording the requests that change something (all
+// methods except GET and HEAD) under the action name; it must run after authentication
+func (a *App) Audit(action string, next http.HandlerFunc) http.HandlerFunc {
+	return auditRequests(a.Repository, action, false, next)
+}
+
+// AuditAll records every request under the action name, reads included
+func (a *App) AuditAll(action string, next http.HandlerFunc) http.HandlerFunc {
+	return auditRequests(a.Repository, action, true, next)
+}
+
+func auditRequests(repository repo.Repository, action string, reads bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !reads && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			next(w, r)
+			return
+		}
+
+		event := &repo.AuditEvent{
+			Action:    action,
+			Target:    r.Method + " " + r.URL.Path,
+			StartedAt: time.Now(),
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		event.RequestHash = hashRequest([]byte(r.Method), []byte(r.URL.RequestURI()), body)
+
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		ctx := withAuditEvent(r.Context(), event)
+		next(recorder, r.WithContext(ctx))
+
+		event.StatusCode = recorder.statusCode()
+		switch {
+		case event.StatusCode < http.StatusBadRequest:
+			event.Outcome = repo.AuditOutcomeSuccess
+		case event.StatusCode == http.StatusUnauthorized, event.StatusCode == http.StatusForbidden, event.StatusCode == http.StatusTooManyRequests:
+			event.Outcome = repo.AuditOutcomeDenied
+		default:
+			event.Outcome = repo.AuditOutcomeFailed
+		}
+		if event.Outcome != repo.AuditOutcomeSuccess {
+			// The body may be cut inside a multi-byte character
+			event.Error = strings.ToValidUTF8(strings.TrimSpace(recorder.errorBody.String()), "")
+		}
+
+		writeAuditEvent(ctx, repository, event)
+	}
+}
+
+// auditResponseWriter captures the status and the start of an error body.
+// It keeps flushing available for the streaming endpoints.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	errorBody bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.errorBody.Len() < maxAuditErrorLength {
+		w.errorBody.Write(data[:min(len(data), maxAuditErrorLength-w.errorBody.Len())])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *auditResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// auditedTool records every call of an MCP tool as "mcp.<name>"
+func auditedTool[In, Out any](repository repo.Repository, name string, tool func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, in In) (Out, error) {
+		event := &repo.AuditEvent{
+			Action:    "mcp." + name,
+			Target:    "tool:" + name,
+			StartedAt: time.Now(),
+		}
+		if args, err := json.Marshal(in); err == nil {
+			event.RequestHash = hashRequest([]byte(name), args)
+		}
+
+		ctx = withAuditEvent(ctx, event)
+		out, err := tool(ctx, in)
+
+		event.Outcome = auditOutcome(err)
+		if err != nil {
+			event.Error = err.Error()
+		}
+		writeAuditEvent(ctx, repository, event)
+		return out, err
+	}
+}
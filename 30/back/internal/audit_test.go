@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-web-service-backend/repo"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	app := &App{Repository: repository}
+	handler := app.Audit("build", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		linkAudit(r.Context(), 7, 3)
+		setAuditTarget(r.Context(), "job:abc")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	send := func(method, body string) {
+		req := httptest.NewRequest(method, "/build", strings.NewReader(body))
+		req = req.WithContext(WithIdentity(req.Context(), &Identity{UserID: "alice", Role: UserRoleBuilder}))
+		handler(httptest.NewRecorder(), req)
+	}
+	send(http.MethodPost, `{"message":"site"}`)
+	send(http.MethodGet, "")
+	send(http.MethodDelete, "")
+
+	events, err := repository.GetAuditEvents(ctx, repo.AuditFilter{})
+	if err != nil {
+		t.Fatalf("GetAuditEvents failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want reads to be skipped", len(events))
+	}
+
+	failed, created := events[0], events[1]
+	if created.Actor != "alice" || created.ActorRole != UserRoleBuilder || created.Action != "build" || created.Target != "job:abc" ||
+		created.Outcome != repo.AuditOutcomeSuccess || created.StatusCode != http.StatusAccepted {
+		t.Errorf("created event = %+v", created)
+	}
+	if created.ProjectID == nil || *created.ProjectID != 7 || created.ChatID == nil || *created.ChatID != 3 {
+		t.Errorf("created event is not linked to project 7 and chat 3: %+v", created)
+	}
+	if created.RequestHash != hashRequest([]byte("POST"), []byte("/build"), []byte(`{"message":"site"}`)) {
+		t.Errorf("request hash = %q", created.RequestHash)
+	}
+	if failed.Outcome != repo.AuditOutcomeFailed || failed.Error != "job not found" || failed.Target != "DELETE /build" {
+		t.Errorf("failed event = %+v", failed)
+	}
+
+	db, err := sql.Open("sqlite", "file:chat_service.db")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "DELETE FROM audit_events"); err == nil {
+		t.Errorf("audit events must not be deletable")
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE audit_events SET outcome = 'success'"); err == nil {
+		t.Errorf("audit events must not be updatable")
+	}
+}
+
+func TestAuditDenials(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	viewerKey, _, err := CreateAPIKey(ctx, repository, "bob", UserRoleViewer, "test")
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	app := &App{Repository: repository}
+	auth := NewAuthenticator(AuthConfig{}, repository)
+	route := auth.Require(UserRoleBuilder, app.Audit("build", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("refused request reached the handler")
+	}))
+
+	send := func(method, key string) int {
+		req := httptest.NewRequest(method, "/build", strings.NewReader(`{"message":"site"}`))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		route.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := send(http.MethodPost, viewerKey); code != http.StatusForbidden {
+		t.Fatalf("viewer key: %d, want 403", code)
+	}
+	if code := send(http.MethodGet, ""); code != http.StatusUnauthorized {
+		t.Fatalf("no credentials: %d, want 401", code)
+	}
+
+	events, err := repository.GetAuditEvents(ctx, repo.AuditFilter{Outcome: repo.AuditOutcomeDenied})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("GetAuditEvents = %d events, %v", len(events), err)
+	}
+	unauthenticated, forbidden := events[0], events[1]
+	if forbidden.Actor != "bob" || forbidden.ActorRole != UserRoleViewer || forbidden.Action != auditActionAuth ||
+		forbidden.Target != "POST /build" || forbidden.StatusCode != http.StatusForbidden || !strings.Contains(forbidden.Error, "builder role required") {
+		t.Errorf("403 event = %+v", forbidden)
+	}
+	if unauthenticated.Actor != auditActorUnauthenticated || unauthenticated.Target != "GET /build" || unauthenticated.StatusCode != http.StatusUnauthorized {
+		t.Errorf("401 event = %+v", unauthenticated)
+	}
+}
+
+func TestAuditedTool(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	tool := auditedTool(repository, "analyze_project", func(ctx context.Context, _ AnalyzeProjectToolRequest) (string, error) {
+		// Events of nested actions belong to the caller of the tool
+		writeAuditEvent(ctx, repository, &repo.AuditEvent{Action: "deploy", Target: "index.html"})
+		return "", ErrForbidden
+	})
+	if _, err := tool(ctx, AnalyzeProjectToolRequest{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("tool error = %v", err)
+	}
+
+	events, err := repository.GetAuditEvents(ctx, repo.AuditFilter{})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("GetAuditEvents = %d events, %v", len(events), err)
+	}
+	if events[0].Action != "mcp.analyze_project" || events[0].Actor != auditActorOperator || events[0].Outcome != repo.AuditOutcomeDenied {
+		t.Errorf("tool event = %+v", events[0])
+	}
+	if events[1].Action != "deploy" || events[1].Actor != auditActorOperator {
+		t.Errorf("nested event = %+v", events[1])
+	}
+}
+
+func TestListAuditEventsHandler(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	for _, actor := range []string{"alice", "bob", "alice", "alice"} {
+		if err := repository.CreateAuditEvent(ctx, &repo.AuditEvent{Actor: actor, Action: "build", Outcome: repo.AuditOutcomeSuccess}); err != nil {
+			t.Fatalf("CreateAuditEvent failed: %v", err)
+		}
+	}
+
+	app := &App{Repository: repository}
+	list := func(query string) (int, AuditEventsResponse) {
+		rec := httptest.NewRecorder()
+		app.ListAuditEventsHandler(rec, httptest.NewRequest(http.MethodGet, "/audit?"+query, nil))
+		var response AuditEventsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return rec.Code, response
+	}
+
+	_, page := list("actor=alice&limit=2")
+	if len(page.Events) != 2 || page.NextCursor == "" || page.Events[0].ID <= page.Events[1].ID {
+		t.Fatalf("first page = %+v, want the 2 newest events and a cursor", page)
+	}
+	_, page = list("actor=alice&limit=2&cursor=" + page.NextCursor)
+	if len(page.Events) != 1 || page.NextCursor != "" || page.Events[0].Actor != "alice" {
+		t.Errorf("last page = %+v, want 1 event and no cursor", page)
+	}
+
+	if code, _ := list("since=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("invalid since: %d, want 400", code)
+	}
+}
@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Access roles; every role includes the privileges of the previous ones
+const (
+	UserRoleViewer    = "viewer"
+	UserRoleBuilder   = "builder"
+	UserRolePublisher = "publisher"
+	UserRoleAdmin     = "admin"
+)
+
+var userRoleRanks = map[string]int{
+	UserRoleViewer:    1,
+	UserRoleBuilder:   2,
+	UserRolePublisher: 3,
+	UserRoleAdmin:     4,
+}
+
+// Authentication methods of an Identity
+const (
+	AuthMethodAPIKey    = "api_key"
+	AuthMethodJWT       = "jwt"
+	AuthMethodAnonymous = "anonymous"
+)
+
+// apiKeyPrefix marks keys issued by this service
+const apiKeyPrefix = "cws_"
+
+var (
+	// ErrUnauthenticated is returned when the request carries no valid credentials
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden is returned when the identity's role is not sufficient
+	ErrForbidden = errors.New("insufficient role")
+)
+
+// ValidUserRole reports whether role is one of the access roles
+func ValidUserRole(role string) bool {
+	_, ok := userRoleRanks[role]
+	return ok
+}
+
+// Identity is the authenticated caller. Its UserID replaces any user_id sent by the client.
+type Identity struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	Method string `json:"method"`
+	KeyID  int64  `json:"key_id,omitempty"`
+}
+
+// HasRole reports whether the identity has role or a higher one
+func (i *Identity) HasRole(role string) bool {
+	required, ok := userRoleRanks[role]
+	return ok && userRoleRanks[i.Role] >= required
+}
+
+type identityContextKey struct{}
+
+// WithIdentity returns a context carrying the authenticated identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity set by the auth middleware
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok
+}
+
+// resolveUserID returns the authenticated user. The client-supplied ID is only
+// used when the call was not authenticated at all (MCP over stdio, internal calls).
+func resolveUserID(ctx context.Context, clientUserID string) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.UserID
+	}
+	return clientUserID
+}
+
+// requireRole checks the role of the authenticated caller; unauthenticated
+// local calls (MCP over stdio) run with the operator's privileges
+func requireRole(ctx context.Context, role string) error {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !identity.HasRole(role) {
+		return fmt.Errorf("%w: %s role required", ErrForbidden, role)
+	}
+	return nil
+}
+
+// ownedByCaller reports whether the caller may see a resource of ownerID.
+// Anonymous callers own nothing: they all share the same identity.
+func ownedByCaller(ctx context.Context, ownerID string) bool {
+	identity, ok := IdentityFromContext(ctx)
+	return !ok || identity.HasRole(UserRoleAdmin) || (identity.UserID == ownerID && identity.Method != AuthMethodAnonymous)
+}
+
+// callerOwnerFilter returns the user whose resources a listing is limited to,
+// "" when the caller may list everything: admins and unauthenticated local calls
+func callerOwnerFilter(ctx context.Context) string {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok || identity.HasRole(UserRoleAdmin) {
+		return ""
+	}
+	return identity.UserID
+}
+
+// AuthConfig configures the accepted credentials
+type AuthConfig struct {
+	JWTSecret     []byte         // HS256 shared secret
+	JWTPublicKey  *rsa.PublicKey // RS256 verification key
+	JWTIssuer     string         // required "iss" claim when set
+	JWTAudience   string         // required "aud" claim when set
+	AnonymousRole string         // role of requests without credentials; empty requires authentication
+}
+
+// LoadAuthConfig reads the JWT public key of the auth settings
+func LoadAuthConfig(settings AuthSettings) (AuthConfig, error) {
+	cfg := AuthConfig{
+		JWTIssuer:     settings.JWTIssuer,
+		JWTAudience:   settings.JWTAudience,
+		AnonymousRole: settings.AnonymousRole,
+	}
+
+	if settings.JWTSecret != "" {
+		cfg.JWTSecret = []byte(settings.JWTSecret)
+	}
+
+	if path := settings.JWTPublicKeyFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read AUTH_JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+		cfg.JWTPublicKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid AUTH_JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+	}
+
+	if cfg.AnonymousRole != "" && !ValidUserRole(cfg.AnonymousRole) {
+		return cfg, fmt.Errorf("invalid AUTH_ANONYMOUS_ROLE %q", cfg.AnonymousRole)
+	}
+
+	return cfg, nil
+}
+
+// Authenticator resolves the identity of HTTP requests from API keys and JWTs
+type Authenticator struct {
+	cfg        AuthConfig
+	repository repo.Repository
+}
+
+// NewAuthenticator creates an authenticator; API keys are looked up in the repository
+func NewAuthenticator(cfg AuthConfig, repository repo.Repository) *Authenticator {
+	return &Authenticator{cfg: cfg, repository: repository}
+}
+
+// Require authenticates the request and checks that the caller has role before calling next.
+// Refused requests never reach the audit middleware behind it, so they are recorded here.
+func (a *Authenticator) Require(role string, next http.HandlerFunc) http.Handler {
+	return a.require(role, false, next)
+}
+
+// RequireUser is Require for routes of resources owned by a user: dialogs, jobs, pipeline
+// runs and projects. Anonymous callers are refused, since they would all share one user.
+func (a *Authenticator) RequireUser(role string, next http.HandlerFunc) http.Handler {
+	return a.require(role, true, next)
+}
+
+func (a *Authenticator) require(role string, user bool, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.Authenticate(r)
+		if err == nil && user && identity.Method == AuthMethodAnonymous {
+			err = fmt.Errorf("%w: anonymous callers have no resources of their own", ErrUnauthenticated)
+		}
+		if err != nil {
+			a.auditDenial(r, nil, http.StatusUnauthorized, err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chat-web-service"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !identity.HasRole(role) {
+			reason := fmt.Sprintf("Forbidden: %s role required", role)
+			a.auditDenial(r, identity, http.StatusForbidden, reason)
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
+
+// auditDenial records a refused request as an "auth" event of the caller, if known
+func (a *Authenticator) auditDenial(r *http.Request, identity *Identity, status int, reason string) {
+	if a.repository == nil {
+		return
+	}
+
+	event := &repo.AuditEvent{
+		Actor:      auditActorUnauthenticated,
+		Action:     auditActionAuth,
+		Target:     r.Method + " " + r.URL.Path,
+		Outcome:    repo.AuditOutcomeDenied,
+		StatusCode: status,
+		Error:      reason,
+	}
+	if identity != nil {
+		event.Actor = identity.UserID
+		event.ActorRole = identity.Role
+	}
+	writeAuditEvent(r.Context(), a.repository, event)
+}
+
+// Authenticate resolves the caller from "Authorization: Bearer <token>" or "X-API-Key".
+// Tokens with three dot-separated parts are JWTs, anything else is an API key.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, value, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+		}
+		token = strings.TrimSpace(value)
+	}
+
+	if token == "" {
+		if a.cfg.AnonymousRole != "" {
+			return &Identity{UserID: "anonymous", Role: a.cfg.AnonymousRole, Method: AuthMethodAnonymous}, nil
+		}
+		return nil, ErrUnauthenticated
+	}
+
+	if strings.Count(token, ".") == 2 {
+		return a.verifyJWT(token)
+	}
+	return a.verifyAPIKey(r.Context(), token)
+}
+
+// jwtClaims are the accepted token claims; "sub" is the user, "role" the access role
+type jwtClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+func (a *Authenticator) verifyJWT(token string) (*Identity, error) {
+	var methods []string
+	if a.cfg.JWTSecret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if a.cfg.JWTPublicKey != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("%w: JWT authentication is not configured", ErrUnauthenticated)
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if a.cfg.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(a.cfg.JWTIssuer))
+	}
+	if a.cfg.JWTAudience != "" {
+		options = append(options, jwt.WithAudience(a.cfg.JWTAudience))
+	}
+
+	claims := &jwtClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		// WithValidMethods guarantees the method matches a configured key
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return a.cfg.JWTSecret, nil
+		}
+		return a.cfg.JWTPublicKey, nil
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token: %v", ErrUnauthenticated, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	role := claims.Role
+	if role == "" {
+		role = UserRoleViewer
+	}
+	if !ValidUserRole(role) {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrUnauthenticated, role)
+	}
+
+	return &Identity{UserID: claims.Subject, Role: role, Method: AuthMethodJWT}, nil
+}
+
+func (a *Authenticator) verifyAPIKey(ctx context.Context, token string) (*Identity, error) {
+	key, err := a.repository.GetAPIKeyByHash(ctx, hashAPIKey(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check API key: %w", err)
+	}
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: API key has been revoked", ErrUnauthenticated)
+	}
+
+	if err := a.repository.TouchAPIKey(ctx, key.ID); err != nil {
+		log.Printf("Failed to update last use of API key %d: %v", key.ID, err)
+	}
+
+	return &Identity{UserID: key.UserID, Role: key.Role, Method: AuthMethodAPIKey, KeyID: key.ID}, nil
+}
+
+// hashAPIKey is the stored form of an API key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey issues a new key for the user. The plain key is returned only here.
+func CreateAPIKey(ctx context.Context, repository repo.Repository, userID, role, name string) (string, *repo.APIKey, error) {
+	if userID == "" {
+		return "", nil, fmt.Errorf("user_id cannot be empty")
+	}
+	if !ValidUserRole(role) {
+		return "", nil, fmt.Errorf("invalid role %q", role)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	plain := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &repo.APIKey{
+		UserID:  userID,
+		Role:    role,
+		Name:    name,
+		Prefix:  plain[:len(apiKeyPrefix)+8],
+		KeyHash: hashAPIKey(plain),
+	}
+	if err := repository.CreateAPIKey(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return plain, key, nil
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// identityHandler answers with the resolved user ID of the request
+func identityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(resolveUserID(r.Context(), "client-supplied")))
+}
+
+func authRequest(t *testing.T, handler http.Handler, header, value string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestAuthenticatorAPIKeys(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	builderKey, stored, err := CreateAPIKey(ctx, repository, "alice", UserRoleBuilder, "test")
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	if stored.KeyHash == builderKey || stored.KeyHash != hashAPIKey(builderKey) {
+		t.Fatalf("key must be stored hashed")
+	}
+	viewerKey, _, err := CreateAPIKey(ctx, repository, "bob", UserRoleViewer, "test")
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	auth := NewAuthenticator(AuthConfig{}, repository)
+	builderRoute := auth.Require(UserRoleBuilder, identityHandler)
+
+	rec := authRequest(t, builderRoute, "Authorization", "Bearer "+builderKey)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Errorf("builder key: %d %q, want the key's user to replace the client user_id", rec.Code, rec.Body.String())
+	}
+	if rec := authRequest(t, builderRoute, "X-API-Key", builderKey); rec.Code != http.StatusOK {
+		t.Errorf("X-API-Key header: %d", rec.Code)
+	}
+
+	if rec := authRequest(t, builderRoute, "Authorization", "Bearer "+viewerKey); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer key on builder route: %d, want 403", rec.Code)
+	}
+	if rec := authRequest(t, builderRoute, "", ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("no credentials: %d, want 401 with WWW-Authenticate", rec.Code)
+	}
+	if rec := authRequest(t, builderRoute, "Authorization", "Bearer cws_unknown"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: %d, want 401", rec.Code)
+	}
+
+	key, err := repository.GetAPIKeyByHash(ctx, hashAPIKey(builderKey))
+	if err != nil || key.LastUsedAt == nil {
+		t.Errorf("last use was not recorded: %+v %v", key, err)
+	}
+
+	if err := repository.RevokeAPIKey(ctx, stored.ID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if rec := authRequest(t, builderRoute, "Authorization", "Bearer "+builderKey); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d, want 401", rec.Code)
+	}
+}
+
+func TestAuthenticatorJWT(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	auth := NewAuthenticator(AuthConfig{JWTSecret: secret, JWTPublicKey: &rsaKey.PublicKey, JWTIssuer: "issuer"}, nil)
+	publisherRoute := auth.Require(UserRolePublisher, identityHandler)
+
+	valid := func(role string) jwt.MapClaims {
+		return jwt.MapClaims{"sub": "carol", "role": role, "iss": "issuer", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"hs256", signToken(t, jwt.SigningMethodHS256, secret, valid(UserRolePublisher)), http.StatusOK},
+		{"rs256", signToken(t, jwt.SigningMethodRS256, rsaKey, valid(UserRoleAdmin)), http.StatusOK},
+		{"insufficientRole", signToken(t, jwt.SigningMethodHS256, secret, valid(UserRoleBuilder)), http.StatusForbidden},
+		{"defaultRoleIsViewer", signToken(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "carol", "iss": "issuer", "exp": time.Now().Add(time.Hour).Unix()}), http.StatusForbidden},
+		{"unknownRole", signToken(t, jwt.SigningMethodHS256, secret, valid("root")), http.StatusUnauthorized},
+		{"wrongSecret", signToken(t, jwt.SigningMethodHS256, []byte("other"), valid(UserRolePublisher)), http.StatusUnauthorized},
+		{"expired", signToken(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "carol", "role": UserRoleAdmin, "iss": "issuer", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized},
+		{"noExpiry", signToken(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "carol", "role": UserRoleAdmin, "iss": "issuer"}), http.StatusUnauthorized},
+		{"wrongIssuer", signToken(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "carol", "role": UserRoleAdmin, "iss": "other", "exp": time.Now().Add(time.Hour).Unix()}), http.StatusUnauthorized},
+		{"algNone", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid(UserRoleAdmin)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := authRequest(t, publisherRoute, "Authorization", "Bearer "+tt.token)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+			if tt.code == http.StatusOK && rec.Body.String() != "carol" {
+				t.Errorf("user = %q, want the token subject", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("hs256RejectedWithoutSecret", func(t *testing.T) {
+		rsaOnly := NewAuthenticator(AuthConfig{JWTPublicKey: &rsaKey.PublicKey}, nil)
+		token := signToken(t, jwt.SigningMethodHS256, secret, valid(UserRoleAdmin))
+		if rec := authRequest(t, rsaOnly.Require(UserRoleViewer, identityHandler), "Authorization", "Bearer "+token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+}
+
+func TestAuthenticatorAnonymous(t *testing.T) {
+	auth := NewAuthenticator(AuthConfig{AnonymousRole: UserRoleBuilder}, nil)
+
+	if rec := authRequest(t, auth.Require(UserRoleBuilder, identityHandler), "", ""); rec.Code != http.StatusOK || rec.Body.String() != "anonymous" {
+		t.Errorf("anonymous builder: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := authRequest(t, auth.Require(UserRoleAdmin, identityHandler), "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("anonymous admin route: %d, want 403", rec.Code)
+	}
+
+	// Anonymous callers share one identity, so routes of owned resources need a credential
+	if rec := authRequest(t, auth.RequireUser(UserRoleViewer, identityHandler), "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous owned route: %d, want 401", rec.Code)
+	}
+
+	ctx := WithIdentity(context.Background(), &Identity{UserID: "anonymous", Role: UserRoleBuilder, Method: AuthMethodAnonymous})
+	if ownedByCaller(ctx, "anonymous") {
+		t.Errorf("anonymous caller owns the resources of other anonymous callers")
+	}
+	ctx = WithIdentity(context.Background(), &Identity{UserID: "anonymous", Role: UserRoleAdmin, Method: AuthMethodAnonymous})
+	if !ownedByCaller(ctx, "alice") {
+		t.Errorf("anonymous admin cannot see the resources of users")
+	}
+}
@@ -1,24 +1,27 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"chat-web-service-backend/mcp"
 	"chat-web-service-backend/repo"
 )
 
 type BuildRequest struct {
-	Message      string       `json:"message"`
-	UserID       string       `json:"user_id,omitempty"`
-	Requirements Requirements `json:"requirements,omitempty"`
+	Message      string       `json:"message" description:"Описание сайта, который нужно собрать"`
+	UserID       string       `json:"user_id,omitempty" description:"Идентификатор пользователя для квот и истории"`
+	Requirements Requirements `json:"requirements,omitempty" description:"Собранные требования: слот -> значение"`
+	MultiPage    bool         `json:"multi_page,omitempty" description:"Собрать многостраничный сайт с общим макетом и навигацией"`
 }
 
 type BuildResponse struct {
@@ -26,120 +29,76 @@ type BuildResponse struct {
 	Message   string `json:"message"`
 	File      string `json:"file,omitempty"`
 	GitHubURL string `json:"github_url,omitempty"`
-}
+	ProjectID int64  `json:"project_id,omitempty"`
 
-type MCPRequest struct {
-	JSONRPC string `json:"jsonrpc"`
-	ID      int    `json:"id"`
-	Method  string `json:"method"`
-	Params  struct {
-		Name      string `json:"name"`
-		Arguments struct {
-			FilePath      string `json:"filePath"`
-			TargetPath    string `json:"targetPath,omitempty"`
-			CommitMessage string `json:"commitMessage,omitempty"`
-		} `json:"arguments"`
-	} `json:"params"`
-}
+	// Pages are the pages of a multi-page site in sitemap order, File is its directory
+	Pages []string `json:"pages,omitempty"`
+	// BrokenLinks are the internal links to missing pages removed from a multi-page site
+	BrokenLinks []BrokenLink `json:"broken_links,omitempty"`
+
+	// PreviewURL is a signed, expiring URL of the site on the preview server
+	PreviewURL string `json:"preview_url,omitempty"`
 
-type MCPResponse struct {
-	Result struct {
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"content"`
-		IsError bool `json:"isError,omitempty"`
-	} `json:"result"`
+	// GuardFindings are the guard rules that fired on the input and the generated HTML
+	GuardFindings []*repo.GuardFinding `json:"guard_findings,omitempty"`
 }
 
 // pushToGitHubViaMCP отправляет файл в GitHub через MCP сервер
-func pushToGitHubViaMCP(filePath, targetPath, commitMessage string) (string, error) {
-	// Создаем MCP запрос
-	mcpReq := MCPRequest{
-		JSONRPC: "2.0",
-		ID:      1,
-		Method:  "tools/call",
-	}
-	mcpReq.Params.Name = "push_file_to_github"
-	mcpReq.Params.Arguments.FilePath = filePath
-	mcpReq.Params.Arguments.TargetPath = targetPath
-	mcpReq.Params.Arguments.CommitMessage = commitMessage
-
-	// Сериализуем запрос в JSON
-	requestJSON, err := json.Marshal(mcpReq)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal MCP request: %v", err)
-	}
-
-	// Запускаем MCP сервер
-	cmd := exec.Command("node", "index.js")
-	cmd.Dir = "../../github-mcp2" // Путь к MCP серверу относительно back директории
-	cmd.Stdin = bytes.NewReader(append(requestJSON, '\n'))
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("MCP server error: %v, stderr: %s", err, stderr.String())
-	}
-
-	// Получаем вывод и ищем JSON ответ
-	output := stdout.String()
-	lines := bytes.Split([]byte(output), []byte("\n"))
-
-	var jsonResponse []byte
-	for _, line := range lines {
-		line = bytes.TrimSpace(line)
-		if len(line) > 0 && line[0] == '{' {
-			jsonResponse = line
-			break
-		}
-	}
+func pushToGitHubViaMCP(ctx context.Context, client *mcp.Client, filePath, targetPath, commitMessage string) (string, error) {
+	return callGitHubPush(ctx, client, "push_file_to_github", map[string]string{
+		"filePath":      filePath,
+		"targetPath":    targetPath,
+		"commitMessage": commitMessage,
+	})
+}
 
-	if len(jsonResponse) == 0 {
-		return "", fmt.Errorf("no JSON response found in MCP output: %s", output)
-	}
+// pushDirectoryToGitHubViaMCP отправляет все файлы папки в GitHub одним коммитом
+func pushDirectoryToGitHubViaMCP(ctx context.Context, client *mcp.Client, dirPath, targetPath, commitMessage string) (string, error) {
+	return callGitHubPush(ctx, client, "push_directory_to_github", map[string]string{
+		"dirPath":       dirPath,
+		"targetPath":    targetPath,
+		"commitMessage": commitMessage,
+	})
+}
 
-	// Парсим ответ
-	var mcpResp MCPResponse
-	if err := json.Unmarshal(jsonResponse, &mcpResp); err != nil {
-		return "", fmt.Errorf("failed to parse MCP response: %v, raw output: %s", err, string(jsonResponse))
+// callGitHubPush вызывает инструмент GitHub MCP сервера и возвращает URL коммита
+func callGitHubPush(ctx context.Context, client *mcp.Client, tool string, arguments map[string]string) (string, error) {
+	result, err := client.CallTool(ctx, tool, arguments)
+	if err != nil {
+		return "", err
 	}
 
 	// Проверяем на ошибки
-	if mcpResp.Result.IsError || len(mcpResp.Result.Content) == 0 {
-		if len(mcpResp.Result.Content) > 0 {
-			return "", fmt.Errorf("MCP error: %s", mcpResp.Result.Content[0].Text)
+	if result.IsError || len(result.Content) == 0 {
+		if text := result.Text(); text != "" {
+			return "", fmt.Errorf("MCP error: %s", text)
 		}
 		return "", fmt.Errorf("unknown MCP error")
 	}
 
 	// Извлекаем URL коммита из ответа
-	responseText := mcpResp.Result.Content[0].Text
-	// Простой парсинг URL из текста ответа
-	if bytes.Contains([]byte(responseText), []byte("Commit URL: ")) {
-		lines := bytes.Split([]byte(responseText), []byte("\n"))
-		for _, line := range lines {
-			if bytes.Contains(line, []byte("Commit URL: ")) {
-				url := string(bytes.TrimPrefix(line, []byte("- Commit URL: ")))
-				return url, nil
-			}
+	for _, line := range strings.Split(result.Text(), "\n") {
+		if strings.Contains(line, "Commit URL: ") {
+			return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "- Commit URL: ")), nil
 		}
 	}
 
 	return "https://github.com/p12s/ai-advent-package", nil
 }
 
-func BuildHandler(w http.ResponseWriter, r *http.Request) {
-	repository, err := repo.NewRepository()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer repository.Close()
+// Build stages reported as job progress
+const (
+	BuildStagePlanning   = "planning"
+	BuildStageGenerating = "generating"
+	BuildStageVerifying  = "verifying"
+	BuildStageSaving     = "saving"
+	BuildStagePushing    = "pushing_to_github"
+)
 
-	ctx := context.Background()
+// BuildHandler queues a build job; the build quota is applied by the route middleware.
+// The result is available via GET /jobs/{id}.
+func (a *App) BuildHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -156,116 +115,253 @@ func BuildHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Rate limiting check
-	userID := buildReq.UserID
-	if userID == "" {
-		// If no user ID provided, use IP address as fallback
-		userID = r.RemoteAddr
-	}
-
-	// Get current date in YYYY-MM-DD format
-	currentDate := time.Now().Format("2006-01-02")
+	userID := resolveUserID(ctx, buildReq.UserID)
+	buildReq.UserID = userID
 
-	// Check current request count for this user today
-	requestCount, err := repository.GetUserRequestCount(ctx, userID, currentDate)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Rate limiting check failed: %v", err), http.StatusInternalServerError)
+	job, err := a.Jobs.Enqueue(ctx, JobTypeBuild, userID, buildReq)
+	if errors.Is(err, ErrJobQueueFull) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Очередь сборки переполнена, попробуйте позже", http.StatusServiceUnavailable)
 		return
 	}
-
-	// Check if user has exceeded the daily limit (3 requests)
-	const maxDailyRequests = 1
-	if requestCount >= maxDailyRequests {
-		w.WriteHeader(http.StatusTooManyRequests)
-		response := BuildResponse{
-			Status:  "error",
-			Message: fmt.Sprintf("Превышен дневной лимит запросов. Максимум %d запросов в день. Попробуйте завтра.", maxDailyRequests),
-		}
-		json.NewEncoder(w).Encode(response)
+	if errors.Is(err, ErrJobQueueStopped) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-
-	// Increment request count for this user
-	if err := repository.IncrementUserRequestCount(ctx, userID, currentDate); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update request count: %v", err), http.StatusInternalServerError)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to queue build: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	builderClient := NewWebsiteBuilderClient()
-	websiteHTML, err := builderClient.GenerateWebsite(buildReq.Message, buildReq.Requirements)
-	//websiteHTML, err := builderClient.GenerateWebsiteHF(buildReq.Message, buildReq.Requirements)
+	setAuditTarget(ctx, "job:"+job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(NewJobResponse(job))
+}
+
+// runBuildJob screens the request, generates the website, sanitises it,
+// saves it to result/ and pushes it to GitHub
+func (a *App) runBuildJob(ctx context.Context, repository repo.Repository, job *repo.Job, progress func(stage string)) (interface{}, error) {
+	var buildReq BuildRequest
+	if err := json.Unmarshal([]byte(job.Payload), &buildReq); err != nil {
+		return nil, fmt.Errorf("invalid build payload: %w", err)
+	}
 
-	var response BuildResponse
+	findings, err := a.screenInput(ctx, "message", buildReq.Message)
 	if err != nil {
-		response = BuildResponse{
-			Status:  "error",
-			Message: fmt.Sprintf("Ошибка генерации сайта: %v", err),
+		return nil, err
+	}
+	requirementFindings, err := a.screenRequirements(ctx, buildReq.Requirements)
+	findings = append(findings, requirementFindings...)
+	if err != nil {
+		return nil, err
+	}
+
+	builderClient, err := a.NewWebsiteBuilderClient()
+	if err != nil {
+		return nil, err
+	}
+	builderClient.Progress = progress
+
+	resultDir := "result"
+	// The start of the job id keeps builds finished in the same second apart
+	stamp := time.Now().Format("2006-01-02_15-04-05") + "_" + job.ID[:min(8, len(job.ID))]
+	var filename, filePath string
+	var trace []*repo.GenerationStep
+	var site *Site
+	var pages []string
+	var brokenLinks []BrokenLink
+
+	if buildReq.MultiPage {
+		site, trace, err = builderClient.GenerateSite(ctx, buildReq.Message, buildReq.Requirements)
+		if err != nil {
+			logGenerationTrace(trace)
+			return nil, fmt.Errorf("Ошибка генерации сайта: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Каждая страница очищается по политике guard, затем проверяются внутренние ссылки
+		for name, content := range site.Files {
+			if filepath.Ext(name) != ".html" {
+				continue
+			}
+			sanitized, outputFindings, err := a.sanitizeHTML(string(content))
+			if err != nil {
+				return nil, err
+			}
+			site.Files[name] = []byte(sanitized)
+			findings = append(findings, outputFindings...)
+		}
+		progress(BuildStageVerifying)
+		brokenLinks, err = validateSiteLinks(site.Files)
+		if err != nil {
+			return nil, err
+		}
+
+		// Многостраничный сайт сохраняется папкой с index.html
+		progress(BuildStageSaving)
+		for _, page := range site.Sitemap.Pages {
+			pages = append(pages, page.File)
+		}
+		filename = stamp
+		filePath = filepath.Join(resultDir, filename)
+		if err := writeSiteFiles(filePath, site.Files, 0644); err != nil {
+			return nil, fmt.Errorf("Ошибка сохранения сайта: %w", err)
 		}
 	} else {
-		resultDir := "result"
+		var websiteHTML string
+		websiteHTML, trace, err = builderClient.GenerateWebsite(ctx, buildReq.Message, buildReq.Requirements)
+		if err != nil {
+			logGenerationTrace(trace)
+			return nil, fmt.Errorf("Ошибка генерации сайта: %w", err)
+		}
+
+		// Отмененная во время генерации сборка не должна попасть в result и GitHub
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Сгенерированный HTML отдается с нашего origin, поэтому очищается по политике guard
+		var outputFindings []*repo.GuardFinding
+		websiteHTML, outputFindings, err = a.sanitizeHTML(websiteHTML)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, outputFindings...)
+
+		progress(BuildStageSaving)
 		if err := os.MkdirAll(resultDir, 0755); err != nil {
-			response = BuildResponse{
-				Status:  "error",
-				Message: fmt.Sprintf("Ошибка создания папки result: %v", err),
-			}
+			return nil, fmt.Errorf("Ошибка создания папки result: %w", err)
+		}
+
+		filename = stamp + ".html"
+		filePath = filepath.Join(resultDir, filename)
+
+		if err := os.WriteFile(filePath, []byte(websiteHTML), 0644); err != nil {
+			return nil, fmt.Errorf("Ошибка сохранения файла: %w", err)
+		}
+	}
+
+	// Файл успешно сохранен, теперь отправляем в GitHub через MCP
+	progress(BuildStagePushing)
+	absFilePath, _ := filepath.Abs(filePath)
+	commitMessage := fmt.Sprintf("Add generated website %s", filename)
+
+	pushStarted := time.Now()
+	var githubURL string
+	var githubErr error
+	if site != nil {
+		githubURL, githubErr = pushDirectoryToGitHubViaMCP(ctx, a.GitHubMCP(), absFilePath, filename, commitMessage)
+	} else {
+		githubURL, githubErr = pushToGitHubViaMCP(ctx, a.GitHubMCP(), absFilePath, filename, commitMessage)
+	}
+
+	// Создаем чат для этого проекта (если нужно)
+	chat, chatErr := repository.CreateChat(ctx, fmt.Sprintf("Generated Website - %s", filename), job.UserID)
+	if chatErr != nil {
+		// Если не удалось создать чат, используем ID = 1 как fallback
+		chat = &repo.Chat{ID: 1}
+	}
+
+	// Сохраняем информацию о проекте в базу данных
+	projectName := fmt.Sprintf("Website_%s", stamp)
+	projectDesc := fmt.Sprintf("Generated website based on request: %s", buildReq.Message)
+
+	var projectID int64
+	project, projectErr := repository.CreateProject(ctx, chat.ID, projectName, projectDesc, filePath, job.UserID)
+	if projectErr == nil {
+		projectID = project.ID
+		linkAudit(ctx, project.ID, chat.ID)
+
+		// Обновляем статус проекта в зависимости от результата GitHub push
+		if githubErr != nil {
+			repository.UpdateProjectStatus(ctx, project.ID, "completed_local")
 		} else {
-			now := time.Now()
-			filename := now.Format("2006-01-02_15-04-05") + ".html"
-			filePath := filepath.Join(resultDir, filename)
-
-			if err := os.WriteFile(filePath, []byte(websiteHTML), 0644); err != nil {
-				response = BuildResponse{
-					Status:  "error",
-					Message: fmt.Sprintf("Ошибка сохранения файла: %v", err),
-				}
-			} else {
-				// Файл успешно сохранен, теперь отправляем в GitHub через MCP
-				absFilePath, _ := filepath.Abs(filePath)
-				commitMessage := fmt.Sprintf("Add generated website %s", filename)
-
-				githubURL, githubErr := pushToGitHubViaMCP(absFilePath, filename, commitMessage)
-
-				// Создаем чат для этого проекта (если нужно)
-				chat, chatErr := repository.CreateChat(ctx, fmt.Sprintf("Generated Website - %s", filename))
-				if chatErr != nil {
-					// Если не удалось создать чат, используем ID = 1 как fallback
-					chat = &repo.Chat{ID: 1}
-				}
-
-				// Сохраняем информацию о проекте в базу данных
-				projectName := fmt.Sprintf("Website_%s", filename[:len(filename)-5]) // убираем .html
-				projectDesc := fmt.Sprintf("Generated website based on request: %s", buildReq.Message)
-
-				project, projectErr := repository.CreateProject(ctx, chat.ID, projectName, projectDesc, filePath)
-				if projectErr == nil {
-					// Обновляем статус проекта в зависимости от результата GitHub push
-					if githubErr != nil {
-						repository.UpdateProjectStatus(ctx, project.ID, "completed_local")
-					} else {
-						repository.UpdateProjectStatus(ctx, project.ID, "completed")
-					}
-				}
-
-				if githubErr != nil {
-					// GitHub push failed, but file was saved locally
-					response = BuildResponse{
-						Status:  "partial_success",
-						Message: fmt.Sprintf("Сайт сгенерирован и сохранен локально, но не удалось отправить в GitHub: %v", githubErr),
-						File:    filename,
-					}
-				} else {
-					// Full success - file saved and pushed to GitHub
-					response = BuildResponse{
-						Status:    "success",
-						Message:   "Сайт успешно сгенерирован, сохранен и отправлен в GitHub",
-						File:      filename,
-						GitHubURL: githubURL,
-					}
-				}
-			}
+			repository.UpdateProjectStatus(ctx, project.ID, "completed")
+		}
+
+		// Сохраняем шаги рассуждений, чтобы можно было сравнить план, черновик и итоговый HTML
+		if err := saveGenerationTrace(ctx, repository, project.ID, trace); err != nil {
+			log.Printf("Failed to save generation trace of project %d: %v", project.ID, err)
+		}
+		if err := saveGuardFindings(ctx, repository, project.ID, findings); err != nil {
+			log.Printf("Failed to save guard findings of project %d: %v", project.ID, err)
 		}
+		// Сохраняем сгенерированный сайт как первую ревизию проекта
+		if _, err := saveRevision(ctx, repository, project.ID, filePath, repo.RevisionSourceBuild); err != nil {
+			log.Printf("Failed to save revision of project %d: %v", project.ID, err)
+		}
+	} else {
+		logGenerationTrace(trace)
+	}
+
+	push := &repo.AuditEvent{Action: "github.push", Target: filename, StartedAt: pushStarted}
+	if githubErr != nil {
+		push.Outcome = repo.AuditOutcomeFailed
+		push.Error = githubErr.Error()
+	}
+	if projectID != 0 {
+		push.ProjectID = &projectID
+		push.ChatID = &chat.ID
+	}
+	writeAuditEvent(ctx, repository, push)
+
+	if githubErr != nil {
+		// GitHub push failed, but file was saved locally
+		return BuildResponse{
+			Status:        "partial_success",
+			Message:       fmt.Sprintf("Сайт сгенерирован и сохранен локально, но не удалось отправить в GitHub: %v", githubErr),
+			File:          filename,
+			ProjectID:     projectID,
+			Pages:         pages,
+			BrokenLinks:   brokenLinks,
+			PreviewURL:    a.previewURL(projectID),
+			GuardFindings: findings,
+		}, nil
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	// Full success - file saved and pushed to GitHub
+	return BuildResponse{
+		Status:        "success",
+		Message:       "Сайт успешно сгенерирован, сохранен и отправлен в GitHub",
+		File:          filename,
+		GitHubURL:     githubURL,
+		ProjectID:     projectID,
+		Pages:         pages,
+		BrokenLinks:   brokenLinks,
+		PreviewURL:    a.previewURL(projectID),
+		GuardFindings: findings,
+	}, nil
+}
+
+// saveGenerationTrace stores the reasoning steps of GenerateWebsite against the project
+func saveGenerationTrace(ctx context.Context, repository repo.Repository, projectID int64, trace []*repo.GenerationStep) error {
+	for _, step := range trace {
+		step.ProjectID = projectID
+		if err := repository.CreateGenerationStep(ctx, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveGuardFindings stores the guard rules that fired during the build against the project
+func saveGuardFindings(ctx context.Context, repository repo.Repository, projectID int64, findings []*repo.GuardFinding) error {
+	for _, finding := range findings {
+		finding.ProjectID = projectID
+		if err := repository.CreateGuardFinding(ctx, finding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logGenerationTrace logs a trace that cannot be stored because there is no project
+func logGenerationTrace(trace []*repo.GenerationStep) {
+	for _, step := range trace {
+		log.Printf("Generation step %d (%s): model=%s latency=%dms tokens=%d/%d error=%q",
+			step.Step, step.Name, step.Model, step.LatencyMS, step.PromptTokens, step.CompletionTokens, step.Error)
+	}
 }
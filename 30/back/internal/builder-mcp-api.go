@@ -1,46 +1,25 @@
 package internal
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
-)
-
-func NewWebsiteBuilderClient() *WebsiteBuilderClient {
-	baseURL := os.Getenv("BUILDER_LLM_URL")
-	if baseURL == "" {
-		panic("BUILDER_LLM_URL is not set")
-	}
 
-	model := os.Getenv("BUILDER_LLM_MODEL")
-	if model == "" {
-		panic("BUILDER_LLM_MODEL is not set")
-	}
+	"chat-web-service-backend/repo"
+)
 
-	timeoutStr := os.Getenv("BUILDER_LLM_TIMEOUT")
-	if timeoutStr == "" {
-		panic("BUILDER_LLM_TIMEOUT is not set")
-	}
-	var timeout int
-	if timeoutStr != "" {
-		if t, err := strconv.Atoi(timeoutStr); err == nil && t > 0 {
-			timeout = t
-		}
+// NewWebsiteBuilderClient creates a client of the builder role
+func (a *App) NewWebsiteBuilderClient() (*WebsiteBuilderClient, error) {
+	provider, cfg, err := a.Provider(RoleBuilder)
+	if err != nil {
+		return nil, fmt.Errorf("LLM configuration error: %w", err)
 	}
 
 	return &WebsiteBuilderClient{
-		BaseURL: baseURL,
-		Model:   model,
-		Client: &http.Client{
-			Timeout: time.Duration(timeout) * time.Second,
-		},
-	}
+		Provider: provider,
+		Config:   cfg,
+	}, nil
 }
 
 func (c *WebsiteBuilderClient) ProcessWebsiteRequest(userInput string, requirements Requirements) (*WebsiteRequest, error) {
@@ -63,84 +42,69 @@ func (c *WebsiteBuilderClient) ProcessWebsiteRequest(userInput string, requireme
 	return request, nil
 }
 
-func (c *WebsiteBuilderClient) SendToLLM(websiteReq *WebsiteRequest) (*LLMResponse, error) {
-	temperatureStr := os.Getenv("BUILDER_LLM_TEMPERATURE")
-	if temperatureStr == "" {
-		panic("BUILDER_LLM_TEMPERATURE is not set")
-	}
-	temperature, err := strconv.ParseFloat(temperatureStr, 64)
-	if err != nil {
-		panic("BUILDER_LLM_TEMPERATURE is not a valid float")
-	}
-
-	maxTokensStr := os.Getenv("BUILDER_LLM_MAX_TOKENS")
-	if maxTokensStr == "" {
-		panic("BUILDER_LLM_MAX_TOKENS is not set")
-	}
-	maxTokens, err := strconv.Atoi(maxTokensStr)
-	if err != nil {
-		panic("BUILDER_LLM_MAX_TOKENS is not a valid integer")
-	}
-
-	streamStr := os.Getenv("BUILDER_LLM_STREAM")
-	if streamStr == "" {
-		panic("BUILDER_LLM_STREAM is not set")
-	}
-	stream, err := strconv.ParseBool(streamStr)
-	if err != nil {
-		panic("BUILDER_LLM_STREAM is not a valid boolean")
-	}
+func (c *WebsiteBuilderClient) SendToLLM(ctx context.Context, websiteReq *WebsiteRequest) (*LLMResponse, error) {
+	return sendWebsiteRequest(ctx, c.Provider, c.Config, websiteReq)
+}
 
-	llmReq := LLMRequest{
-		Model:       c.Model,
+// sendWebsiteRequest sends a website request to the builder provider
+func sendWebsiteRequest(ctx context.Context, provider LLMProvider, cfg ProviderConfig, websiteReq *WebsiteRequest) (*LLMResponse, error) {
+	return complete(ctx, provider, cfg, ProviderRequest{
 		System:      websiteReq.System,
 		Prompt:      websiteReq.Message,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Stream:      stream,
-	}
-
-	jsonData, err := json.Marshal(llmReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+	})
+}
 
-	url := fmt.Sprintf("%s/api/generate", c.BaseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+// reportProgress notifies the Progress callback about the current generation stage
+func (c *WebsiteBuilderClient) reportProgress(stage string) {
+	if c.Progress != nil {
+		c.Progress(stage)
 	}
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// Names of the GenerateWebsite reasoning steps stored in the trace
+const (
+	GenerationStepPlan   = "plan"
+	GenerationStepDraft  = "draft"
+	GenerationStepVerify = "verify"
+)
 
-	resp, err := c.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request to LLM: %w", err)
+// tracedSend sends the request and appends the prompt, response, model, latency
+// and token counts of the call to the trace
+func (c *WebsiteBuilderClient) tracedSend(ctx context.Context, trace *[]*repo.GenerationStep, name string, websiteReq *WebsiteRequest) (*LLMResponse, error) {
+	started := time.Now()
+	llmResp, err := c.SendToLLM(ctx, websiteReq)
+
+	step := &repo.GenerationStep{
+		Step:         len(*trace) + 1,
+		Name:         name,
+		Provider:     c.Provider.Name(),
+		Model:        c.Provider.Model(),
+		SystemPrompt: websiteReq.System,
+		Prompt:       websiteReq.Message,
+		LatencyMS:    time.Since(started).Milliseconds(),
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("LLM API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var llmResp LLMResponse
-	if err := json.Unmarshal(body, &llmResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	if llmResp.Error != "" {
-		return nil, fmt.Errorf("LLM API error: %s", llmResp.Error)
+		step.Error = err.Error()
+	} else {
+		step.Response = llmResp.Response
+		step.PromptTokens = llmResp.PromptEvalCount
+		step.CompletionTokens = llmResp.EvalCount
+		if llmResp.Model != "" {
+			step.Model = llmResp.Model
+		}
 	}
+	*trace = append(*trace, step)
 
-	return &llmResp, nil
+	return llmResp, err
 }
 
-func (c *WebsiteBuilderClient) GenerateWebsite(userInput string, requirements Requirements) (string, error) {
+// GenerateWebsite plans, drafts and verifies the website. It returns the final HTML
+// and the trace of all steps, which is also returned on failure for debugging.
+func (c *WebsiteBuilderClient) GenerateWebsite(ctx context.Context, userInput string, requirements Requirements) (string, []*repo.GenerationStep, error) {
+	var trace []*repo.GenerationStep
+
 	// Step 1: Thought - Analyze the user request and plan the approach
 	thoughtReq := &WebsiteRequest{
 		Message: userInput,
@@ -155,13 +119,14 @@ func (c *WebsiteBuilderClient) GenerateWebsite(userInput string, requirements Re
 		Requirements: requirements,
 	}
 
-	thoughtResp, err := c.SendToLLM(thoughtReq)
+	c.reportProgress(BuildStagePlanning)
+	thoughtResp, err := c.tracedSend(ctx, &trace, GenerationStepPlan, thoughtReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to analyze request: %w", err)
+		return "", trace, fmt.Errorf("failed to analyze request: %w", err)
 	}
 
 	if thoughtResp.Response == "" {
-		return "", fmt.Errorf("received empty analysis response")
+		return "", trace, fmt.Errorf("received empty analysis response")
 	}
 
 	// Step 2: Generate website based on the analysis
@@ -177,13 +142,14 @@ func (c *WebsiteBuilderClient) GenerateWebsite(userInput string, requirements Re
 		Requirements: requirements,
 	}
 
-	llmResp, err := c.SendToLLM(websiteReq)
+	c.reportProgress(BuildStageGenerating)
+	llmResp, err := c.tracedSend(ctx, &trace, GenerationStepDraft, websiteReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate website: %w", err)
+		return "", trace, fmt.Errorf("failed to generate website: %w", err)
 	}
 
 	if llmResp.Response == "" {
-		return "", fmt.Errorf("received empty website response")
+		return "", trace, fmt.Errorf("received empty website response")
 	}
 
 	// Step 3: Verification - Check and improve the generated HTML
@@ -204,13 +170,14 @@ func (c *WebsiteBuilderClient) GenerateWebsite(userInput string, requirements Re
 		Requirements: requirements,
 	}
 
-	finalResp, err := c.SendToLLM(verificationReq)
+	c.reportProgress(BuildStageVerifying)
+	finalResp, err := c.tracedSend(ctx, &trace, GenerationStepVerify, verificationReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to verify HTML: %w", err)
+		return "", trace, fmt.Errorf("failed to verify HTML: %w", err)
 	}
 
 	if finalResp.Response == "" {
-		return "", fmt.Errorf("received empty verification response")
+		return "", trace, fmt.Errorf("received empty verification response")
 	}
 
 	// Clean up markdown code blocks from the response
@@ -220,5 +187,5 @@ func (c *WebsiteBuilderClient) GenerateWebsite(userInput string, requirements Re
 	cleanedHTML = strings.TrimSuffix(cleanedHTML, "```")
 	cleanedHTML = strings.TrimSpace(cleanedHTML)
 
-	return cleanedHTML, nil
+	return cleanedHTML, trace, nil
 }
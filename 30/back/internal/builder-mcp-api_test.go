@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// scriptedProvider returns the scripted responses in order, failing once they run out
+type scriptedProvider struct {
+	responses []string
+	requests  []ProviderRequest
+}
+
+func (p *scriptedProvider) Name() string  { return "scripted" }
+func (p *scriptedProvider) Model() string { return "scripted-model" }
+
+func (p *scriptedProvider) Generate(_ context.Context, req ProviderRequest) (*LLMResponse, error) {
+	p.requests = append(p.requests, req)
+	if len(p.responses) == 0 {
+		return nil, errors.New("no scripted response")
+	}
+
+	response := p.responses[0]
+	p.responses = p.responses[1:]
+	return &LLMResponse{Response: response, PromptEvalCount: len(req.Prompt), EvalCount: len(response)}, nil
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	return p.Generate(ctx, req)
+}
+
+func (p *scriptedProvider) Stream(ctx context.Context, req ProviderRequest, _ func(string) error) (*LLMResponse, error) {
+	return p.Generate(ctx, req)
+}
+
+func TestGenerateWebsiteTrace(t *testing.T) {
+	t.Run("complete", func(t *testing.T) {
+		provider := &scriptedProvider{responses: []string{"план", "<html>draft</html>", "```html\n<html>final</html>\n```"}}
+		client := &WebsiteBuilderClient{Provider: provider}
+
+		html, trace, err := client.GenerateWebsite(context.Background(), "лендинг", nil)
+		if err != nil {
+			t.Fatalf("GenerateWebsite failed: %v", err)
+		}
+		if html != "<html>final</html>" {
+			t.Errorf("html = %q", html)
+		}
+
+		if len(trace) != 3 {
+			t.Fatalf("trace has %d steps, want 3", len(trace))
+		}
+		names := []string{GenerationStepPlan, GenerationStepDraft, GenerationStepVerify}
+		for i, step := range trace {
+			if step.Step != i+1 || step.Name != names[i] {
+				t.Errorf("step %d = %d %s, want %d %s", i, step.Step, step.Name, i+1, names[i])
+			}
+			if step.Model != "scripted-model" || step.Provider != "scripted" {
+				t.Errorf("step %s model = %s/%s", step.Name, step.Provider, step.Model)
+			}
+			if step.Prompt != provider.requests[i].Prompt || step.SystemPrompt != provider.requests[i].System {
+				t.Errorf("step %s does not record the sent prompt", step.Name)
+			}
+			if step.CompletionTokens != len(step.Response) {
+				t.Errorf("step %s completion tokens = %d", step.Name, step.CompletionTokens)
+			}
+		}
+		if trace[1].Response != "<html>draft</html>" {
+			t.Errorf("draft response = %q", trace[1].Response)
+		}
+	})
+
+	t.Run("failureKeepsPartialTrace", func(t *testing.T) {
+		provider := &scriptedProvider{responses: []string{"план"}}
+		client := &WebsiteBuilderClient{Provider: provider}
+
+		_, trace, err := client.GenerateWebsite(context.Background(), "лендинг", nil)
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+		if len(trace) != 2 || trace[1].Error == "" || trace[1].Response != "" {
+			t.Errorf("trace = %+v, want the plan and the failed draft", trace)
+		}
+	})
+}
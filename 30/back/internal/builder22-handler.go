@@ -9,7 +9,28 @@ import (
 	"strings"
 )
 
-func Builder22Handler(w http.ResponseWriter, r *http.Request) {
+// builder22SystemPrompt turns a detailed specification into a complete HTML page
+const builder22SystemPrompt = `Ты - профессиональный веб-разработчик и верстальщик. Твоя задача - создать полноценную HTML-страницу на основе детального технического задания.
+
+ВАЖНЫЕ ТРЕБОВАНИЯ:
+1. Возвращай ТОЛЬКО чистый HTML код без комментариев, объяснений или дополнительного текста
+2. HTML должен быть полным - с DOCTYPE, head, body и всеми необходимыми тегами
+3. Включай встроенные CSS стили в <style> теге внутри <head>
+4. Используй современные CSS практики (flexbox, grid, responsive design)
+5. Добавляй реалистичный контент вместо placeholder'ов
+6. Код должен быть валидным и семантичным
+7. Включай мета-теги для SEO и viewport
+8. Используй красивые цвета, шрифты и современный дизайн
+
+НЕ ДОБАВЛЯЙ:
+- Комментарии в коде
+- Объяснения до или после HTML
+- Markdown разметку
+- Текст типа "Вот HTML код:" или подобное
+
+НАЧИНАЙ ОТВЕТ СРАЗУ С <!DOCTYPE html> И ЗАКАНЧИВАЙ </html>`
+
+func (a *App) Builder22Handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -36,33 +57,23 @@ func Builder22Handler(w http.ResponseWriter, r *http.Request) {
 	// Log the incoming request
 	log.Printf("=== Incoming /builder22 request ===")
 	log.Printf("Message: %s", req.Message)
-	log.Printf("User ID: %s", req.UserID)
+	log.Printf("User ID: %s", resolveUserID(r.Context(), req.UserID))
 	log.Printf("===================================")
 
-	// Create system prompt for HTML generation
-	systemPrompt := `Ты - профессиональный веб-разработчик и верстальщик. Твоя задача - создать полноценную HTML-страницу на основе детального технического задания.
-
-ВАЖНЫЕ ТРЕБОВАНИЯ:
-1. Возвращай ТОЛЬКО чистый HTML код без комментариев, объяснений или дополнительного текста
-2. HTML должен быть полным - с DOCTYPE, head, body и всеми необходимыми тегами
-3. Включай встроенные CSS стили в <style> теге внутри <head>
-4. Используй современные CSS практики (flexbox, grid, responsive design)
-5. Добавляй реалистичный контент вместо placeholder'ов
-6. Код должен быть валидным и семантичным
-7. Включай мета-теги для SEO и viewport
-8. Используй красивые цвета, шрифты и современный дизайн
-
-НЕ ДОБАВЛЯЙ:
-- Комментарии в коде
-- Объяснения до или после HTML
-- Markdown разметку
-- Текст типа "Вот HTML код:" или подобное
-
-НАЧИНАЙ ОТВЕТ СРАЗУ С <!DOCTYPE html> И ЗАКАНЧИВАЙ </html>`
+	if _, err := a.screenInput(r.Context(), "message", req.Message); writeGuardRejection(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Guard error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	// Create LLM client and get response
-	llmClient := NewLLMClient()
-	llmResponse, err := llmClient.GetLLMResponse(req.Message, systemPrompt)
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	llmResponse, err := llmClient.GetLLMResponse(r.Context(), req.Message, builder22SystemPrompt)
 
 	var response Builder22Response
 	if err != nil {
@@ -72,16 +83,22 @@ func Builder22Handler(w http.ResponseWriter, r *http.Request) {
 			Error:  fmt.Sprintf("Ошибка генерации HTML: %v", err),
 		}
 	} else {
-		// Clean the response to ensure it's pure HTML
-		cleanHTML := cleanHTMLResponse(llmResponse)
-		
-		log.Printf("=== LLM Response ===")
-		log.Printf("HTML length: %d characters", len(cleanHTML))
-		log.Printf("===================")
+		// Clean the response to ensure it's pure HTML and apply the guard HTML policy
+		cleanHTML, _, err := a.sanitizeHTML(cleanHTMLResponse(llmResponse))
+		if err != nil {
+			response = Builder22Response{
+				Status: "error",
+				Error:  fmt.Sprintf("Ошибка генерации HTML: %v", err),
+			}
+		} else {
+			log.Printf("=== LLM Response ===")
+			log.Printf("HTML length: %d characters", len(cleanHTML))
+			log.Printf("===================")
 
-		response = Builder22Response{
-			Status: "success",
-			HTML:   cleanHTML,
+			response = Builder22Response{
+				Status: "success",
+				HTML:   cleanHTML,
+			}
 		}
 	}
 
@@ -89,30 +106,84 @@ func Builder22Handler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// Builder22StreamHandler is the Server-Sent Events variant of Builder22Handler.
+// It sends "token" events while the LLM generates and a final "done" event with Builder22Response.
+func (a *App) Builder22StreamHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		UserID  string `json:"user_id,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("=== Incoming /builder22/stream request ===")
+	log.Printf("Message: %s", req.Message)
+	log.Printf("User ID: %s", resolveUserID(r.Context(), req.UserID))
+
+	if _, err := a.screenInput(r.Context(), "message", req.Message); writeGuardRejection(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Guard error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		sse.Error(err)
+		return
+	}
+	llmResponse, err := llmClient.StreamLLMResponse(r.Context(), req.Message, builder22SystemPrompt, sse.Token)
+	if err != nil {
+		log.Printf("Error streaming LLM response: %v", err)
+		sse.Error(fmt.Errorf("Ошибка генерации HTML: %v", err))
+		return
+	}
+
+	cleanHTML, _, err := a.sanitizeHTML(cleanHTMLResponse(llmResponse))
+	if err != nil {
+		sse.Error(fmt.Errorf("Ошибка генерации HTML: %v", err))
+		return
+	}
+
+	sse.Send(SSEEventDone, Builder22Response{
+		Status: "success",
+		HTML:   cleanHTML,
+	})
+}
+
 // cleanHTMLResponse removes any non-HTML content from the response
 func cleanHTMLResponse(response string) string {
 	// Trim whitespace
 	response = strings.TrimSpace(response)
-	
+
 	// Find the start of HTML (DOCTYPE or <html>)
 	doctypeIndex := strings.Index(strings.ToLower(response), "<!doctype")
 	htmlIndex := strings.Index(strings.ToLower(response), "<html")
-	
+
 	startIndex := -1
 	if doctypeIndex != -1 {
 		startIndex = doctypeIndex
 	} else if htmlIndex != -1 {
 		startIndex = htmlIndex
 	}
-	
+
 	// Find the end of HTML
 	endIndex := strings.LastIndex(strings.ToLower(response), "</html>")
-	
+
 	// Extract clean HTML
 	if startIndex != -1 && endIndex != -1 {
 		return response[startIndex:endIndex+7] // +7 for "</html>"
 	}
-	
+
 	// If no proper HTML structure found, return as is
 	return response
 }
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ChatCompletionsProvider talks to OpenAI-compatible /v1/chat/completions endpoints.
+// Hugging Face Inference Endpoints and GigaChat expose the same API, so all of them share it.
+type ChatCompletionsProvider struct {
+	URL    string
+	APIKey string
+	// Tokens issues short-lived bearer tokens instead of the static APIKey
+	Tokens TokenSource
+	name   string
+	model  string
+	Client *http.Client
+	// StreamClient is used for streams; it shares the connection pool of Client
+	StreamClient *http.Client
+}
+
+// TokenSource issues bearer tokens for providers with OAuth-style authorization
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	// Invalidate drops the current token after the server rejected it
+	Invalidate()
+}
+
+func NewChatCompletionsProvider(cfg ProviderConfig) *ChatCompletionsProvider {
+	url := strings.TrimSuffix(cfg.URL, "/")
+	if !strings.HasSuffix(url, "/chat/completions") {
+		url += "/chat/completions"
+	}
+
+	client, streamClient := newProviderClients(http.DefaultTransport.(*http.Transport).Clone(), cfg.Timeout)
+
+	return &ChatCompletionsProvider{
+		URL:          url,
+		APIKey:       cfg.APIKey,
+		name:         cfg.Provider,
+		model:        cfg.Model,
+		Client:       client,
+		StreamClient: streamClient,
+	}
+}
+
+func (p *ChatCompletionsProvider) Name() string {
+	return p.name
+}
+
+func (p *ChatCompletionsProvider) Model() string {
+	return p.model
+}
+
+// Generate sends the system prompt and the user prompt as a two-message chat
+func (p *ChatCompletionsProvider) Generate(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	req.Messages = nil
+	return p.Chat(ctx, req)
+}
+
+func (p *ChatCompletionsProvider) Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	resp, err := p.post(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp HuggingFaceChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("received empty choices from %s", p.name)
+	}
+
+	return &LLMResponse{
+		Model:           chatResp.Model,
+		Response:        chatResp.Choices[0].Message.Content,
+		Done:            true,
+		PromptEvalCount: chatResp.Usage.PromptTokens,
+		EvalCount:       chatResp.Usage.CompletionTokens,
+	}, nil
+}
+
+// Stream reads the server-sent events stream ("data: {...}" lines ending with "data: [DONE]").
+// A stream closed before [DONE] and before a chunk with a finish reason is an error.
+func (p *ChatCompletionsProvider) Stream(ctx context.Context, req ProviderRequest, onToken func(token string) error) (*LLMResponse, error) {
+	resp, err := p.post(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &LLMResponse{Model: p.model}
+	var text strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			result.Done = true
+			break
+		}
+
+		var chunk HuggingFaceChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+
+		if chunk.Usage != nil {
+			result.PromptEvalCount = chunk.Usage.PromptTokens
+			result.EvalCount = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		if chunk.Choices[0].FinishReason != "" {
+			result.Done = true
+		}
+		token := chunk.Choices[0].Delta.Content
+		if token != "" {
+			text.WriteString(token)
+			if onToken != nil {
+				if err := onToken(token); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+	if !result.Done {
+		return nil, fmt.Errorf("%s stream ended before [DONE]", p.name)
+	}
+	drainStream(resp.Body)
+
+	result.Response = text.String()
+	return result, nil
+}
+
+// post sends a chat completions request and returns the response if its status is 200
+func (p *ChatCompletionsProvider) post(ctx context.Context, req ProviderRequest, stream bool) (*http.Response, error) {
+	var messages []HuggingFaceChatMessage
+	for _, msg := range requestMessages(req) {
+		messages = append(messages, HuggingFaceChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+
+	chatReq := HuggingFaceChatRequest{
+		Model:       p.model,
+		Messages:    messages,
+		Stream:      stream,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// A rejected OAuth token is refreshed and the request is retried once
+	client := p.Client
+	if stream {
+		client = p.StreamClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := p.send(ctx, client, jsonData)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && p.Tokens != nil && attempt == 0 {
+			resp.Body.Close()
+			p.Tokens.Invalidate()
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			switch resp.StatusCode {
+			case http.StatusNotFound:
+				return nil, fmt.Errorf("endpoint not found. Check the URL")
+			case http.StatusUnauthorized:
+				return nil, fmt.Errorf("неверный API ключ %s. Проверьте настройки API_KEY", p.name)
+			case http.StatusServiceUnavailable:
+				return nil, fmt.Errorf("service unavailable. Try again later")
+			}
+			return nil, fmt.Errorf("%s API returned status %d: %s", p.name, resp.StatusCode, string(body))
+		}
+
+		return resp, nil
+	}
+}
+
+// send performs a single authorized HTTP request
+func (p *ChatCompletionsProvider) send(ctx context.Context, client *http.Client, jsonData []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.URL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	token := p.APIKey
+	if p.Tokens != nil {
+		if token, err = p.Tokens.Token(ctx); err != nil {
+			return nil, fmt.Errorf("failed to authorize in %s: %w", p.name, err)
+		}
+	}
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to %s: %w", p.name, err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestChatCompletionsProvider(serverURL string) *ChatCompletionsProvider {
+	return NewChatCompletionsProvider(ProviderConfig{Provider: ProviderHuggingFace, URL: serverURL + "/v1", Model: "qwen", APIKey: "hf-key", Timeout: 5 * time.Second})
+}
+
+func TestChatCompletionsProvider(t *testing.T) {
+	t.Run("chat", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/v1/chat/completions" {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+			if auth := r.Header.Get("Authorization"); auth != "Bearer hf-key" {
+				t.Errorf("Authorization = %q", auth)
+			}
+			var req HuggingFaceChatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+			}
+			want := []HuggingFaceChatMessage{{Role: "system", Content: "system"}, {Role: "user", Content: "hello"}}
+			if req.Model != "qwen" || req.Stream || req.Temperature != 0.5 || req.MaxTokens != 300 || len(req.Messages) != len(want) {
+				t.Fatalf("request = %+v", req)
+			}
+			for i := range want {
+				if req.Messages[i] != want[i] {
+					t.Errorf("message %d = %+v, want %+v", i, req.Messages[i], want[i])
+				}
+			}
+
+			json.NewEncoder(w).Encode(HuggingFaceChatResponse{
+				Model:   "qwen",
+				Choices: []HuggingFaceChatChoice{{Message: HuggingFaceChatMessage{Role: "assistant", Content: "hi"}}},
+				Usage:   HuggingFaceChatUsage{PromptTokens: 7, CompletionTokens: 1},
+			})
+		}))
+		defer server.Close()
+
+		provider := newTestChatCompletionsProvider(server.URL)
+		for _, req := range []ProviderRequest{
+			{System: "system", Prompt: "hello", Temperature: 0.5, MaxTokens: 300},
+			{System: "system", Messages: []Message{{Role: "user", Content: "hello"}}, Temperature: 0.5, MaxTokens: 300},
+		} {
+			resp, err := provider.Chat(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Chat failed: %v", err)
+			}
+			if resp.Response != "hi" || resp.Model != "qwen" || !resp.Done || resp.PromptEvalCount != 7 || resp.EvalCount != 1 {
+				t.Errorf("response = %+v", resp)
+			}
+		}
+
+		// Generate ignores the messages and sends the single prompt
+		resp, err := provider.Generate(context.Background(), ProviderRequest{
+			System: "system", Prompt: "hello", Temperature: 0.5, MaxTokens: 300,
+			Messages: []Message{{Role: "user", Content: "ignored"}},
+		})
+		if err != nil || resp.Response != "hi" {
+			t.Errorf("Generate = %+v, %v", resp, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Header.Get("Authorization") {
+			case "Bearer hf-key":
+				json.NewEncoder(w).Encode(HuggingFaceChatResponse{Model: "qwen"})
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		defer server.Close()
+
+		provider := newTestChatCompletionsProvider(server.URL)
+		if _, err := provider.Chat(context.Background(), ProviderRequest{Prompt: "hello"}); err == nil || !strings.Contains(err.Error(), "empty choices") {
+			t.Errorf("err = %v", err)
+		}
+		provider.APIKey = "wrong"
+		if _, err := provider.Chat(context.Background(), ProviderRequest{Prompt: "hello"}); err == nil || !strings.Contains(err.Error(), "неверный API ключ huggingface") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestChatCompletionsProviderStream(t *testing.T) {
+	const body = "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"При\"}}]}\n\n" +
+		": keep-alive\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"вет\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":6,\"completion_tokens\":2}}\n\n" +
+		"data: [DONE]\n\n"
+
+	t.Run("tokensSplitAcrossReads", func(t *testing.T) {
+		server, connections := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			var req HuggingFaceChatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if !req.Stream || r.Header.Get("Authorization") != "Bearer hf-key" {
+				t.Errorf("unexpected request %+v", req)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			// Cut inside the "data:" prefix, inside a JSON object and inside a multi-byte rune
+			writeStream(w, body, 3, 40, strings.Index(body, "вет")+1, len(body)-4)
+		})
+		defer server.Close()
+
+		provider := newTestChatCompletionsProvider(server.URL)
+		for i := 0; i < 2; i++ {
+			var tokens []string
+			resp, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, func(token string) error {
+				tokens = append(tokens, token)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			if strings.Join(tokens, "|") != "При|вет" {
+				t.Errorf("tokens = %q", tokens)
+			}
+			if resp.Response != "Привет" || !resp.Done || resp.PromptEvalCount != 6 || resp.EvalCount != 2 {
+				t.Errorf("response = %+v", resp)
+			}
+		}
+
+		// The streaming client is built once, so both streams share a connection
+		if n := connections.Load(); n != 1 {
+			t.Errorf("streams opened %d connections, want 1", n)
+		}
+	})
+
+	t.Run("malformedLine", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\ndata: {\"choices\":\n\ndata: [DONE]\n\n")
+		})
+		defer server.Close()
+
+		_, err := newTestChatCompletionsProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "failed to unmarshal stream chunk") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("closedMidStream", func(t *testing.T) {
+		var abort atomic.Bool
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n")
+			if abort.Load() {
+				panic(http.ErrAbortHandler)
+			}
+		})
+		defer server.Close()
+
+		// The response ends without [DONE] and without a finish reason
+		provider := newTestChatCompletionsProvider(server.URL)
+		if _, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil); err == nil || !strings.Contains(err.Error(), "huggingface stream ended before [DONE]") {
+			t.Errorf("err = %v", err)
+		}
+
+		// The connection is dropped
+		abort.Store(true)
+		if _, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil); err == nil || !strings.Contains(err.Error(), "failed to read stream") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("onTokenError", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, body)
+		})
+		defer server.Close()
+
+		stop := errors.New("client went away")
+		_, err := newTestChatCompletionsProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, func(string) error { return stop })
+		if !errors.Is(err, stop) {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
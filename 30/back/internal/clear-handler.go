@@ -23,7 +23,7 @@ func ClearHandler(w http.ResponseWriter, r *http.Request) {
 	// Log the incoming request
 	log.Printf("=== Incoming /clear request ===")
 	log.Printf("Raw HTML length: %d characters", len(clearReq.RawHTML))
-	log.Printf("User ID: %s", clearReq.UserID)
+	log.Printf("User ID: %s", resolveUserID(r.Context(), clearReq.UserID))
 	log.Printf("===============================")
 
 	// Clean the HTML from markdown artifacts
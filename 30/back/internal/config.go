@@ -0,0 +1,430 @@
+package internal
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/mcp"
+	"chat-web-service-backend/repo"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the typed configuration of the service. It is loaded once at startup from
+// the defaults, a YAML file, env variables and flags, each overriding the previous one.
+// Fields are tagged with their YAML key, env variable and whether they hold a secret;
+// the flag of a field is its YAML path, e.g. --llm.builder.model.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database" env:"DATABASE"`
+	LLM       LLMConfig       `yaml:"llm"`
+	GitHubMCP MCPServerConfig `yaml:"github_mcp" env:"GITHUB_MCP"`
+	Jobs      JobsConfig      `yaml:"jobs"`
+	Auth      AuthSettings    `yaml:"auth" env:"AUTH"`
+	Preview   PreviewConfig   `yaml:"preview" env:"PREVIEW"`
+	Deploy    DeployConfig    `yaml:"deploy"`
+	Files     FilesConfig     `yaml:"files"`
+	Personal  PersonalConfig  `yaml:"personal" env:"PERSONAL"`
+}
+
+// ServerConfig is the API server
+type ServerConfig struct {
+	Port            int           `yaml:"port" env:"PORT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"` // time given to open requests and running builds on SIGTERM
+}
+
+// DatabaseConfig selects the repository: a postgres:// URL or a SQLite file, see repo.ParseDSN
+type DatabaseConfig struct {
+	DSN string `yaml:"dsn" env:"URL" secret:"true"` // may hold the Postgres password
+}
+
+// LLMConfig holds every LLM role and the settings shared by the roles of a backend
+type LLMConfig struct {
+	Gathering       LLMRoleConfig     `yaml:"gathering" env:"GATHERING_REQUIREMENTS_LLM"`
+	Builder         LLMRoleConfig     `yaml:"builder" env:"BUILDER_LLM"`
+	PromptImprover  LLMRoleConfig     `yaml:"prompt_improver" env:"PROMPT_IMPROVER_LLM"`
+	GuardClassifier LLMRoleConfig     `yaml:"guard_classifier" env:"GUARD_CLASSIFIER_LLM"`
+	HuggingFace     HuggingFaceConfig `yaml:"huggingface" env:"HUGGINGFACE"`
+	GigaChat        GigaChatConfig    `yaml:"gigachat" env:"GIGACHAT_LLM"`
+}
+
+// LLMRoleConfig is the backend and model of a single LLM role
+type LLMRoleConfig struct {
+	Provider    string  `yaml:"provider" env:"PROVIDER"`
+	URL         string  `yaml:"url" env:"URL"`
+	Model       string  `yaml:"model" env:"MODEL"`
+	APIKey      string  `yaml:"api_key" env:"API_KEY" secret:"true"`
+	Timeout     int     `yaml:"timeout" env:"TIMEOUT"` // seconds
+	Temperature float64 `yaml:"temperature" env:"TEMPERATURE"`
+	MaxTokens   int     `yaml:"max_tokens" env:"MAX_TOKENS"`
+	Stream      bool    `yaml:"stream" env:"STREAM"`
+}
+
+// HuggingFaceConfig is used by huggingface roles without their own URL or API key
+type HuggingFaceConfig struct {
+	ChatURL string `yaml:"chat_url" env:"CHAT_URL"`
+	APIKey  string `yaml:"api_key" env:"API_KEY" secret:"true"`
+}
+
+// GigaChatConfig is used by gigachat roles: OAuth credentials and TLS settings
+type GigaChatConfig struct {
+	URL     string `yaml:"url" env:"URL"`
+	Model   string `yaml:"model" env:"MODEL"`
+	Creds   string `yaml:"creds" env:"CREDS" secret:"true"`
+	Scope   string `yaml:"scope" env:"SCOPE"`
+	AuthURL string `yaml:"auth_url" env:"AUTH_URL"`
+	CAFile  string `yaml:"ca_file" env:"CA_FILE"`
+	// SSL=false disables certificate verification,
+	// which is needed when the Russian Trusted Root CA is not installed
+	SSL bool `yaml:"ssl" env:"SSL"`
+}
+
+// MCPServerConfig is a stdio MCP server started by the backend
+type MCPServerConfig struct {
+	Command string `yaml:"command" env:"COMMAND"`
+	Args    string `yaml:"args" env:"ARGS"` // space-separated
+	Dir     string `yaml:"dir" env:"DIR"`
+	Timeout int    `yaml:"timeout" env:"TIMEOUT"` // seconds, 0 keeps the client default
+}
+
+// ServerConfig returns the mcp client settings of the server
+func (c MCPServerConfig) ServerConfig(name string) mcp.ServerConfig {
+	return mcp.ServerConfig{
+		Name:           name,
+		Command:        c.Command,
+		Args:           strings.Fields(c.Args),
+		Dir:            c.Dir,
+		RequestTimeout: time.Duration(c.Timeout) * time.Second,
+	}
+}
+
+// JobsConfig is the background job queue
+type JobsConfig struct {
+	BuildWorkers int `yaml:"build_workers" env:"BUILD_WORKERS"`
+}
+
+// AuthSettings are the JWT and anonymous access settings, see LoadAuthConfig
+type AuthSettings struct {
+	JWTSecret        string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file" env:"JWT_PUBLIC_KEY_FILE"`
+	JWTIssuer        string `yaml:"jwt_issuer" env:"JWT_ISSUER"`
+	JWTAudience      string `yaml:"jwt_audience" env:"JWT_AUDIENCE"`
+	AnonymousRole    string `yaml:"anonymous_role" env:"ANONYMOUS_ROLE"`
+}
+
+// FilesConfig points to custom declarative configs replacing the built-in ones
+type FilesConfig struct {
+	RequirementsSchema string `yaml:"requirements_schema" env:"REQUIREMENTS_SCHEMA_FILE"`
+	Pipelines          string `yaml:"pipelines" env:"PIPELINES_FILE"`
+	Quotas             string `yaml:"quotas" env:"QUOTAS_FILE"`
+	Guard              string `yaml:"guard" env:"GUARD_FILE"`
+}
+
+// PersonalConfig is the personal context of the prompt improver
+type PersonalConfig struct {
+	Profession string `yaml:"profession" env:"PROFESSION"`
+	Habit      string `yaml:"habit" env:"HABBIT"`
+	Lang       string `yaml:"lang" env:"LANG"`
+	Style      string `yaml:"style" env:"STYLE"`
+}
+
+// DefaultConfig returns the settings used when nothing overrides them
+func DefaultConfig() *Config {
+	return &Config{
+		Server:   ServerConfig{Port: 8080, ShutdownTimeout: defaultShutdownTimeout},
+		Database: DatabaseConfig{DSN: repo.DefaultSQLiteFile},
+		LLM: LLMConfig{
+			PromptImprover: LLMRoleConfig{
+				URL:         "http://localhost:11434",
+				Model:       "gemma3:12b",
+				Timeout:     120,
+				Temperature: 0.7,
+				MaxTokens:   1000,
+			},
+			GigaChat: GigaChatConfig{SSL: true},
+		},
+		GitHubMCP: MCPServerConfig{
+			Command: "node",
+			Args:    "index.js",
+			Dir:     "../../github-mcp2", // Путь к MCP серверу относительно back директории
+		},
+		Jobs:    JobsConfig{BuildWorkers: defaultJobWorkers},
+		Preview: PreviewConfig{Port: defaultPreviewPort, TTL: defaultPreviewTTL, Dir: "result"},
+		Deploy:  DefaultDeployConfig(),
+	}
+}
+
+// configField is a leaf setting of Config
+type configField struct {
+	path   string // YAML path and flag name
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// configFields lists the settings of the config in declaration order
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, path, env string)
+	walk = func(v reflect.Value, path, env string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+
+			fieldPath := joinNonEmpty(".", path, name)
+			fieldEnv := joinNonEmpty("_", env, field.Tag.Get("env"))
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), fieldPath, fieldEnv)
+				continue
+			}
+			fields = append(fields, configField{
+				path:   fieldPath,
+				env:    fieldEnv,
+				secret: field.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "", "")
+	return fields
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+// set parses a string value into the field
+func (f configField) set(value string) error {
+	value = strings.TrimSpace(value)
+
+	if f.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 1h, got %q", value)
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		f.value.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		f.value.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// ConfigFlags are the command line flags of the config: --config and one flag per setting
+type ConfigFlags struct {
+	file   *string
+	values map[string]string
+}
+
+type configFlag struct {
+	flags *ConfigFlags
+	path  string
+}
+
+func (f configFlag) String() string { return "" }
+
+func (f configFlag) Set(value string) error {
+	f.flags.values[f.path] = value
+	return nil
+}
+
+// RegisterConfigFlags adds the config flags to the flag set. The values are applied
+// by Load on top of the file and env variables.
+func RegisterConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	flags := &ConfigFlags{
+		file:   fs.String("config", "", "YAML config file (default $CONFIG_FILE)"),
+		values: make(map[string]string),
+	}
+	for _, field := range configFields(DefaultConfig()) {
+		usage := "see config file key " + field.path
+		if field.env != "" {
+			usage = "overrides $" + field.env
+		}
+		fs.Var(configFlag{flags: flags, path: field.path}, field.path, usage)
+	}
+	return flags
+}
+
+// Load builds the config from the defaults, the config file, env variables and the
+// flags. Every invalid setting is reported in the returned error, the config is
+// returned anyway so that it can be printed.
+func (f *ConfigFlags) Load() (*Config, error) {
+	file := os.Getenv("CONFIG_FILE")
+	if f != nil && *f.file != "" {
+		file = *f.file
+	}
+	var flagValues map[string]string
+	if f != nil {
+		flagValues = f.values
+	}
+	return LoadConfig(file, flagValues)
+}
+
+// LoadConfig builds and validates the config; values maps flag names to their values
+func LoadConfig(file string, values map[string]string) (*Config, error) {
+	cfg := DefaultConfig()
+	var errs []error
+
+	if file != "" {
+		if err := cfg.decodeFile(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, field := range configFields(cfg) {
+		if field.env == "" {
+			continue
+		}
+		if value := os.Getenv(field.env); value != "" {
+			if err := field.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.env, err))
+			}
+		}
+	}
+
+	for _, field := range configFields(cfg) {
+		if value, ok := values[field.path]; ok {
+			if err := field.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("--%s: %w", field.path, err))
+			}
+		}
+	}
+
+	errs = append(errs, cfg.Validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+func (c *Config) decodeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and returns all problems
+func (c *Config) Validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.Server.Port), "server.port (PORT) must be between 1 and 65535")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+	check(c.Database.DSN != "", "database.dsn (DATABASE_URL) is not set")
+	check(validPort(c.Preview.Port), "preview.port (PREVIEW_PORT) must be between 1 and 65535")
+	check(c.Preview.Port != c.Server.Port, "preview.port (PREVIEW_PORT) must differ from server.port, previews need their own origin")
+	check(c.Preview.TTL > 0, "preview.ttl (PREVIEW_TTL) must be positive")
+	check(c.Preview.Secret == "" || len(c.Preview.Secret) >= 32, "preview.secret (PREVIEW_SECRET) must be at least 32 characters")
+	check(c.Deploy.Timeout > 0, "deploy.timeout (DEPLOY_TIMEOUT) must be positive")
+	check(c.Deploy.Verify.Attempts > 0, "deploy.verify.attempts (DEPLOY_VERIFY_ATTEMPTS) must be a positive number")
+	check(c.Deploy.Verify.Interval >= 0, "deploy.verify.interval (DEPLOY_VERIFY_INTERVAL) must not be negative")
+	check(c.Deploy.Verify.Timeout > 0, "deploy.verify.timeout (DEPLOY_VERIFY_TIMEOUT) must be positive")
+	check(c.Deploy.Verify.MinCertValidity >= 0, "deploy.verify.min_cert_validity (DEPLOY_VERIFY_MIN_CERT_VALIDITY) must not be negative")
+	if err := c.Deploy.Validate(c.Deploy.Target); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Jobs.BuildWorkers > 0, "jobs.build_workers (BUILD_WORKERS) must be a positive number")
+	check(c.GitHubMCP.Command != "", "github_mcp.command (GITHUB_MCP_COMMAND) is not set")
+	check(c.GitHubMCP.Timeout >= 0, "github_mcp.timeout (GITHUB_MCP_TIMEOUT) must not be negative")
+	check(c.Auth.AnonymousRole == "" || ValidUserRole(c.Auth.AnonymousRole), "auth.anonymous_role (AUTH_ANONYMOUS_ROLE): invalid role %q", c.Auth.AnonymousRole)
+
+	for _, file := range []struct{ path, name string }{
+		{c.Auth.JWTPublicKeyFile, "auth.jwt_public_key_file (AUTH_JWT_PUBLIC_KEY_FILE)"},
+		{c.LLM.GigaChat.CAFile, "llm.gigachat.ca_file (GIGACHAT_LLM_CA_FILE)"},
+		{c.Files.RequirementsSchema, "files.requirements_schema (REQUIREMENTS_SCHEMA_FILE)"},
+		{c.Files.Pipelines, "files.pipelines (PIPELINES_FILE)"},
+		{c.Files.Quotas, "files.quotas (QUOTAS_FILE)"},
+		{c.Files.Guard, "files.guard (GUARD_FILE)"},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.name, err))
+		}
+	}
+
+	// The guard classifier is only used when the guard config enables it
+	for _, role := range []string{RoleGathering, RoleBuilder, RolePromptImprover} {
+		if _, err := c.Provider(role); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.LLM.GuardClassifier != (LLMRoleConfig{}) {
+		if _, err := c.Provider(RoleGuardClassifier); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// WriteRedacted writes the config as YAML with the secrets replaced
+func (c *Config) WriteRedacted(w io.Writer) error {
+	redacted := *c
+	for _, field := range configFields(&redacted) {
+		if field.secret && field.value.String() != "" {
+			field.value.SetString("[redacted]")
+		}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redacted); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigLayers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte(`
+server:
+  port: 9000
+llm:
+  gathering: {url: http://file, model: file-model, timeout: 60, max_tokens: 500}
+  builder: {url: http://file, model: file-model, timeout: 60, max_tokens: 500}
+preview:
+  ttl: 30m
+`), 0644)
+
+	// Env overrides the file, flags override both
+	t.Setenv("BUILDER_LLM_MODEL", "env-model")
+	t.Setenv("PORT", "9100")
+	cfg, err := LoadConfig(file, map[string]string{"server.port": "9200", "jobs.build_workers": "4"})
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Server.Port != 9200 || cfg.Jobs.BuildWorkers != 4 || cfg.Preview.TTL != 30*time.Minute {
+		t.Errorf("config = %+v", cfg)
+	}
+	builder, err := cfg.Provider(RoleBuilder)
+	if err != nil || builder.Model != "env-model" || builder.URL != "http://file" || builder.Timeout != time.Minute || builder.Provider != ProviderOllama {
+		t.Errorf("builder = %+v, %v", builder, err)
+	}
+	if improver, err := cfg.Provider(RolePromptImprover); err != nil || improver.Model != "gemma3:12b" {
+		t.Errorf("prompt improver defaults = %+v, %v", improver, err)
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte("server:\n  prot: 9000\n"), 0644)
+
+	t.Setenv("BUILD_WORKERS", "many")
+	t.Setenv("GATHERING_REQUIREMENTS_LLM_PROVIDER", "gigachat")
+	_, err := LoadConfig(file, map[string]string{"preview.ttl": "soon"})
+	if err == nil {
+		t.Fatalf("LoadConfig accepted an invalid config")
+	}
+
+	for _, want := range []string{
+		"field prot not found",
+		"BUILD_WORKERS: expected an integer",
+		"--preview.ttl: expected a duration",
+		"GIGACHAT_LLM_SCOPE) is not set",
+		"BUILDER_LLM_URL) is not set",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestWriteRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.LLM.Builder.APIKey = "sk-builder"
+	cfg.LLM.Builder.Model = "codestral:22b"
+
+	var buf bytes.Buffer
+	if err := cfg.WriteRedacted(&buf); err != nil {
+		t.Fatalf("WriteRedacted failed: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "jwt-secret") || strings.Contains(out, "sk-builder") {
+		t.Errorf("secrets are printed:\n%s", out)
+	}
+	if !strings.Contains(out, "codestral:22b") || !strings.Contains(out, "[redacted]") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if cfg.Auth.JWTSecret != "jwt-secret" {
+		t.Errorf("WriteRedacted changed the config")
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// VerifyConfig is the check of the published site after every deployment
+type VerifyConfig struct {
+	Enabled         bool          `yaml:"enabled" env:"ENABLED"`
+	Attempts        int           `yaml:"attempts" env:"ATTEMPTS"`                   // the site may take a while to appear, e.g. behind a CDN
+	Interval        time.Duration `yaml:"interval" env:"INTERVAL"`                   // wait before the second attempt, doubled after every attempt
+	Timeout         time.Duration `yaml:"timeout" env:"TIMEOUT"`                     // time given to a single request
+	RequireHTTPS    bool          `yaml:"require_https" env:"REQUIRE_HTTPS"`         // fail sites not served over HTTPS
+	MinCertValidity time.Duration `yaml:"min_cert_validity" env:"MIN_CERT_VALIDITY"` // fail certificates expiring sooner, 0 disables
+	CAFile          string        `yaml:"ca_file" env:"CA_FILE"`                     // CA certificates trusted besides the system ones
+	AutoRollback    bool          `yaml:"auto_rollback" env:"AUTO_ROLLBACK"`         // redeploy the previous live revision when the check fails
+}
+
+// Verification is the check of a published site, stored JSON-encoded with the deployment
+type Verification struct {
+	URL           string     `json:"url"`
+	Verified      bool       `json:"verified"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"status_code,omitempty"`
+	Hash          string     `json:"hash,omitempty"` // SHA-256 of the content served at the last attempt
+	TLSVersion    string     `json:"tls_version,omitempty"`
+	CertExpiresAt *time.Time `json:"cert_expires_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CheckedAt     time.Time  `json:"checked_at"`
+}
+
+// verifyBodySlack is how much more than the deployed file a verified URL may send before the
+// download is cut off; content of another size does not match the hash anyway
+const verifyBodySlack = 64 << 10
+
+// errVerifyPermanent marks failures that another attempt cannot fix
+var errVerifyPermanent = errors.New("permanent")
+
+// DeployVerifier fetches published sites and compares them with the deployed revision
+type DeployVerifier struct {
+	cfg    VerifyConfig
+	Client *http.Client
+	now    func() time.Time
+}
+
+// NewDeployVerifier creates a verifier; certificates are checked against the system roots and the CA file
+func NewDeployVerifier(cfg VerifyConfig) (*DeployVerifier, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deploy verification CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in deploy verification CA file %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &DeployVerifier{
+		cfg:    cfg,
+		Client: &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		now:    time.Now,
+	}, nil
+}
+
+// Verify fetches the URL until it serves content with the expected hash and size, at most
+// cfg.Attempts times. file:// URLs of the local target are read from the disk.
+func (v *DeployVerifier) Verify(ctx context.Context, rawURL, expectedHash string, size int64) *Verification {
+	result := &Verification{URL: rawURL}
+	wait := v.cfg.Interval
+
+	for attempt := 1; attempt <= max(v.cfg.Attempts, 1); attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				result.Error = ctx.Err().Error()
+				result.CheckedAt = v.now()
+				return result
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		result.Attempts = attempt
+		err := v.check(ctx, rawURL, expectedHash, size, result)
+		result.CheckedAt = v.now()
+		if err == nil {
+			result.Verified = true
+			result.Error = ""
+			return result
+		}
+		result.Error = err.Error()
+		if errors.Is(err, errVerifyPermanent) || ctx.Err() != nil {
+			return result
+		}
+	}
+	return result
+}
+
+func (v *DeployVerifier) check(ctx context.Context, rawURL, expectedHash string, size int64, result *Verification) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", errVerifyPermanent)
+	}
+	if v.cfg.RequireHTTPS && u.Scheme != "https" {
+		return fmt.Errorf("%s is not served over HTTPS: %w", rawURL, errVerifyPermanent)
+	}
+
+	var content []byte
+	switch u.Scheme {
+	case "file":
+		content, err = os.ReadFile(u.Path)
+		if err != nil {
+			return err
+		}
+	case "http", "https":
+		content, err = v.fetch(ctx, rawURL, size+verifyBodySlack, result)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot check %s URLs: %w", u.Scheme, errVerifyPermanent)
+	}
+
+	result.Hash = contentHash(content)
+	if result.Hash != expectedHash {
+		return fmt.Errorf("content hash %s does not match the deployed revision %s", result.Hash, expectedHash)
+	}
+	return nil
+}
+
+// fetch downloads at most limit bytes of the URL; a longer answer is not the deployed content
+func (v *DeployVerifier) fetch(ctx context.Context, rawURL string, limit int64, result *Verification) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Caches in front of the site could answer with the previous revision
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return nil, fmt.Errorf("TLS certificate of %s is not trusted: %v: %w", rawURL, certErr.Err, errVerifyPermanent)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.TLS != nil {
+		result.TLSVersion = tls.VersionName(resp.TLS.Version)
+		if len(resp.TLS.PeerCertificates) > 0 {
+			expiresAt := resp.TLS.PeerCertificates[0].NotAfter
+			result.CertExpiresAt = &expiresAt
+			if v.cfg.MinCertValidity > 0 && expiresAt.Sub(v.now()) < v.cfg.MinCertValidity {
+				return nil, fmt.Errorf("TLS certificate of %s expires at %s: %w", rawURL, expiresAt.Format(time.RFC3339), errVerifyPermanent)
+			}
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", rawURL, resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("content of %s is larger than %d bytes and does not match the deployed revision", rawURL, limit)
+	}
+	return content, nil
+}
@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// Deploy targets
+const (
+	DeployTargetLocal     = "local"
+	DeployTargetSFTP      = "sftp"
+	DeployTargetYCloudMCP = "ycloud-mcp"
+	DeployTargetS3        = "s3"
+)
+
+const defaultDeployTimeout = 2 * time.Minute
+
+// DeployConfig selects the target /publish uploads sites to and holds the settings of every target
+type DeployConfig struct {
+	Target    string                `yaml:"target" env:"DEPLOY_TARGET"`   // local, sftp, ycloud-mcp or s3
+	Timeout   time.Duration         `yaml:"timeout" env:"DEPLOY_TIMEOUT"` // time given to a single upload
+	Local     LocalDeployConfig     `yaml:"local" env:"DEPLOY_LOCAL"`
+	SFTP      SFTPDeployConfig      `yaml:"sftp" env:"YCLOUD_DEPLOY"`
+	YCloudMCP YCloudMCPDeployConfig `yaml:"ycloud_mcp" env:"YCLOUD_MCP"`
+	S3        S3DeployConfig        `yaml:"s3" env:"DEPLOY_S3"`
+	Verify    VerifyConfig          `yaml:"verify" env:"DEPLOY_VERIFY"`
+}
+
+// LocalDeployConfig copies sites into a directory, e.g. the root of a web server on the same host
+type LocalDeployConfig struct {
+	Dir     string `yaml:"dir" env:"DIR"`
+	BaseURL string `yaml:"base_url" env:"BASE_URL"` // public URL of the directory, file:// URLs by default
+}
+
+// SFTPDeployConfig uploads sites to a web server over SFTP
+type SFTPDeployConfig struct {
+	Host       string `yaml:"host" env:"IP"`
+	Port       int    `yaml:"port" env:"PORT"`
+	Username   string `yaml:"username" env:"USERNAME"`
+	PrivateKey string `yaml:"private_key" env:"PRIVATE_KEY"` // path to the private key file
+	KnownHosts string `yaml:"known_hosts" env:"KNOWN_HOSTS"` // host keys to trust, ~/.ssh/known_hosts by default
+	Dir        string `yaml:"dir" env:"DIR"`
+	BaseURL    string `yaml:"base_url" env:"BASE_URL"` // public URL of the directory, http://{host}/ by default
+}
+
+// YCloudMCPDeployConfig uploads sites through the HTTP API of the ycloud-mcp server
+type YCloudMCPDeployConfig struct {
+	URL     string `yaml:"url" env:"URL"`
+	BaseURL string `yaml:"base_url" env:"BASE_URL"` // public URL of the html directory, http://{VM public IP}/ by default
+}
+
+// S3DeployConfig uploads sites to an S3-compatible bucket, e.g. Yandex Object Storage
+type S3DeployConfig struct {
+	Endpoint        string `yaml:"endpoint" env:"ENDPOINT"`
+	Region          string `yaml:"region" env:"REGION"`
+	Bucket          string `yaml:"bucket" env:"BUCKET"`
+	Prefix          string `yaml:"prefix" env:"PREFIX"` // key prefix of the uploaded files
+	AccessKeyID     string `yaml:"access_key_id" env:"ACCESS_KEY_ID" secret:"true"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"SECRET_ACCESS_KEY" secret:"true"`
+	BaseURL         string `yaml:"base_url" env:"BASE_URL"` // public URL of the bucket, {endpoint}/{bucket}/ by default
+}
+
+// DefaultDeployConfig returns the deploy settings used when nothing overrides them
+func DefaultDeployConfig() DeployConfig {
+	return DeployConfig{
+		Target:    DeployTargetLocal,
+		Timeout:   defaultDeployTimeout,
+		Local:     LocalDeployConfig{Dir: "published"},
+		SFTP:      SFTPDeployConfig{Port: 22, Dir: "/var/www/html"},
+		YCloudMCP: YCloudMCPDeployConfig{URL: "http://localhost:3004"},
+		S3:        S3DeployConfig{Endpoint: "https://storage.yandexcloud.net", Region: "ru-central1"},
+		Verify:    VerifyConfig{Enabled: true, Attempts: 5, Interval: 2 * time.Second, Timeout: 10 * time.Second},
+	}
+}
+
+// DeployResult tells where a deployer published a file
+type DeployResult struct {
+	URL        string // public URL, empty when unknown
+	RemotePath string // location on the target
+}
+
+// Deployer is implemented by every deploy target
+type Deployer interface {
+	// Name returns the target name, e.g. "sftp"
+	Name() string
+	// Deploy uploads the file under the given name, replacing a previous upload of the same name
+	Deploy(ctx context.Context, name string, content []byte) (DeployResult, error)
+}
+
+// SiteDeployer is implemented by targets that can replace a whole site at once: the files are
+// uploaded in one session next to name/ and switched in at the end, so the target never serves
+// a mix of two revisions. The result is that of the index.html of the site.
+type SiteDeployer interface {
+	DeploySite(ctx context.Context, name string, files map[string][]byte) (DeployResult, error)
+}
+
+// Validate checks the settings of the target
+func (c DeployConfig) Validate(target string) error {
+	var errs []error
+	notSet := func(value, setting string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is not set", setting))
+		}
+	}
+
+	switch target {
+	case DeployTargetLocal:
+		notSet(c.Local.Dir, "deploy.local.dir (DEPLOY_LOCAL_DIR)")
+	case DeployTargetSFTP:
+		notSet(c.SFTP.Host, "deploy.sftp.host (YCLOUD_DEPLOY_IP)")
+		notSet(c.SFTP.Username, "deploy.sftp.username (YCLOUD_DEPLOY_USERNAME)")
+		notSet(c.SFTP.PrivateKey, "deploy.sftp.private_key (YCLOUD_DEPLOY_PRIVATE_KEY)")
+		notSet(c.SFTP.Dir, "deploy.sftp.dir (YCLOUD_DEPLOY_DIR)")
+		if !validPort(c.SFTP.Port) {
+			errs = append(errs, fmt.Errorf("deploy.sftp.port (YCLOUD_DEPLOY_PORT) must be between 1 and 65535"))
+		}
+	case DeployTargetYCloudMCP:
+		notSet(c.YCloudMCP.URL, "deploy.ycloud_mcp.url (YCLOUD_MCP_URL)")
+	case DeployTargetS3:
+		notSet(c.S3.Endpoint, "deploy.s3.endpoint (DEPLOY_S3_ENDPOINT)")
+		notSet(c.S3.Region, "deploy.s3.region (DEPLOY_S3_REGION)")
+		notSet(c.S3.Bucket, "deploy.s3.bucket (DEPLOY_S3_BUCKET)")
+		notSet(c.S3.AccessKeyID, "deploy.s3.access_key_id (DEPLOY_S3_ACCESS_KEY_ID)")
+		notSet(c.S3.SecretAccessKey, "deploy.s3.secret_access_key (DEPLOY_S3_SECRET_ACCESS_KEY)")
+	default:
+		errs = append(errs, fmt.Errorf("unknown deploy target %q, expected local, sftp, ycloud-mcp or s3", target))
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewDeployer creates the deployer of the target
+func NewDeployer(cfg DeployConfig, target string) (Deployer, error) {
+	if err := cfg.Validate(target); err != nil {
+		return nil, err
+	}
+
+	switch target {
+	case DeployTargetLocal:
+		return NewLocalDeployer(cfg.Local), nil
+	case DeployTargetSFTP:
+		return NewSFTPDeployer(cfg.SFTP), nil
+	case DeployTargetYCloudMCP:
+		return NewYCloudMCPDeployer(cfg.YCloudMCP), nil
+	default:
+		return NewS3Deployer(cfg.S3), nil
+	}
+}
+
+// publicURL joins the public base URL of a target and the name of a published file
+func publicURL(baseURL, name string) string {
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path.Clean("/"+name), "/")
+}
+
+// deployedEntry is the file served at the URL of a deployment: the file itself or the index.html of a site
+type deployedEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// deployFile uploads the file to the target, the configured one when empty, under the given name
+// and records the deployment with the hash of the content. A site directory is uploaded under
+// name/ as one deployment, whose URL is that of its index.html: switched in at once by targets
+// that are a SiteDeployer, file by file by the others. projectID is 0 for files that do not
+// belong to a project. Invalid target settings are returned without a recorded deployment.
+//
+// An uploaded deployment with a public URL is left verifying: the check takes up to a minute,
+// so it is not done here but by verifyDeployment with the returned entry.
+func (a *App) deployFile(ctx context.Context, projectID int64, name, filePath, target string) (deployment *repo.Deployment, entry deployedEntry, err error) {
+	cfg := a.Config.Deploy
+	if target == "" {
+		target = cfg.Target
+	}
+	deployer, err := NewDeployer(cfg, target)
+	if err != nil {
+		return nil, entry, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, entry, err
+	}
+	var files map[string][]byte
+	var revision, entryFile string
+	if info.IsDir() {
+		files, err = readSiteFiles(filePath)
+		if err != nil {
+			return nil, entry, err
+		}
+		revision, entryFile = treeHash(files), siteIndex
+	} else {
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, entry, err
+		}
+		files = map[string][]byte{"": content}
+		revision = contentHash(content)
+	}
+
+	deployment = &repo.Deployment{
+		Target:   deployer.Name(),
+		Status:   repo.DeploymentStatusRunning,
+		Revision: revision,
+		File:     name,
+	}
+	if projectID != 0 {
+		deployment.ProjectID = &projectID
+	}
+	if err := a.Repository.CreateDeployment(ctx, deployment); err != nil {
+		return nil, entry, fmt.Errorf("failed to record deployment: %w", err)
+	}
+
+	event := &repo.AuditEvent{Action: "deploy", Target: deployment.Target + ":" + deployment.File, ProjectID: deployment.ProjectID, StartedAt: deployment.StartedAt}
+	defer func() {
+		event.Outcome = auditOutcome(err)
+		if err != nil {
+			event.Error = err.Error()
+		}
+		writeAuditEvent(ctx, a.Repository, event)
+	}()
+
+	uploadCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	var result DeployResult
+	var deployErr error
+	if siteDeployer, ok := deployer.(SiteDeployer); ok && info.IsDir() {
+		result, deployErr = siteDeployer.DeploySite(uploadCtx, name, files)
+	} else {
+		result, deployErr = a.deployFiles(uploadCtx, deployer, projectID, name, files)
+	}
+	cancel()
+
+	finishedAt := time.Now()
+	deployment.FinishedAt = &finishedAt
+	deployment.URL = result.URL
+	deployment.RemotePath = result.RemotePath
+	deployment.Status = repo.DeploymentStatusSucceeded
+	if deployErr != nil {
+		deployment.Status = repo.DeploymentStatusFailed
+		deployment.Error = deployErr.Error()
+	} else if cfg.Verify.Enabled && deployment.URL != "" {
+		deployment.Status = repo.DeploymentStatusVerifying
+		deployment.FinishedAt = nil
+	}
+	// The outcome is recorded even when the request was cancelled meanwhile
+	if err := a.Repository.UpdateDeployment(context.WithoutCancel(ctx), deployment); err != nil {
+		return deployment, entry, fmt.Errorf("failed to record deployment: %w", err)
+	}
+
+	if deployErr != nil {
+		return deployment, entry, fmt.Errorf("%s deploy failed: %w", deployment.Target, deployErr)
+	}
+	return deployment, deployedEntry{Hash: contentHash(files[entryFile]), Size: int64(len(files[entryFile]))}, nil
+}
+
+// deployFiles uploads the files one by one under name/, index.html last. When an upload fails,
+// the files uploaded before are put back as they were in the revision last live on the target,
+// so it does not serve a mix of two revisions; new files the old pages do not link to stay.
+func (a *App) deployFiles(ctx context.Context, deployer Deployer, projectID int64, name string, files map[string][]byte) (DeployResult, error) {
+	var result DeployResult
+	var uploaded []string
+	for _, file := range siteFileOrder(files) {
+		fileResult, err := deployer.Deploy(ctx, path.Join(name, file), files[file])
+		if err != nil {
+			if len(uploaded) > 0 {
+				a.restoreSiteFiles(ctx, deployer, projectID, name, uploaded)
+			}
+			return DeployResult{}, err
+		}
+		result = fileResult
+		uploaded = append(uploaded, file)
+	}
+	return result, nil
+}
+
+// restoreSiteFiles uploads the files of the site as they were in the revision last live on the
+// target. Failures are only logged: the deployment has failed already.
+func (a *App) restoreSiteFiles(ctx context.Context, deployer Deployer, projectID int64, name string, uploaded []string) {
+	if projectID == 0 {
+		return
+	}
+	// The upload may have failed because its time ran out, the restore gets its own
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.Config.Deploy.Timeout)
+	defer cancel()
+
+	deployments, err := a.Repository.GetDeployments(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to restore %s on %s: %v", name, deployer.Name(), err)
+		return
+	}
+	var previous *repo.Deployment
+	for _, deployment := range deployments {
+		if deployment.Target == deployer.Name() && deployment.File == name && deploymentLive(deployment) {
+			previous = deployment
+			break
+		}
+	}
+	if previous == nil {
+		return
+	}
+
+	revisions, err := a.Repository.GetProjectRevisions(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to restore %s on %s: %v", name, deployer.Name(), err)
+		return
+	}
+	rev, err := findRevision(revisions, previous.Revision)
+	if err != nil {
+		log.Printf("Failed to restore %s on %s to %s: %v", name, deployer.Name(), previous.Revision, err)
+		return
+	}
+	files, err := readSiteFiles(rev.FilePath)
+	if err != nil {
+		log.Printf("Failed to restore %s on %s to %s: %v", name, deployer.Name(), previous.Revision, err)
+		return
+	}
+
+	for _, file := range uploaded {
+		content, ok := files[file]
+		if !ok {
+			continue
+		}
+		if _, err := deployer.Deploy(ctx, path.Join(name, file), content); err != nil {
+			log.Printf("Failed to restore %s/%s on %s: %v", name, file, deployer.Name(), err)
+		}
+	}
+}
+
+// verifyDeployment checks that the URL of the deployment serves the deployed entry, its revision
+// or the index.html of a site, and marks the deployment verified or failed; the returned error
+// is the reason of the failure
+func (a *App) verifyDeployment(ctx context.Context, deployment *repo.Deployment, entry deployedEntry) error {
+	verifier, err := NewDeployVerifier(a.Config.Deploy.Verify)
+	if err != nil {
+		deployment.Status = repo.DeploymentStatusFailed
+		deployment.Error = err.Error()
+		return err
+	}
+
+	verification := verifier.Verify(ctx, deployment.URL, entry.Hash, entry.Size)
+	if data, err := json.Marshal(verification); err == nil {
+		deployment.Verification = string(data)
+	}
+	finishedAt := verification.CheckedAt
+	deployment.FinishedAt = &finishedAt
+
+	if !verification.Verified {
+		deployment.Status = repo.DeploymentStatusFailed
+		deployment.Error = "verification failed: " + verification.Error
+		return errors.New(deployment.Error)
+	}
+	deployment.Status = repo.DeploymentStatusVerified
+	return nil
+}
+
+// deploymentLive tells whether the deployment put its revision online: verified, or
+// succeeded when it was not verified
+func deploymentLive(deployment *repo.Deployment) bool {
+	return deployment.Status == repo.DeploymentStatusVerified || deployment.Status == repo.DeploymentStatusSucceeded
+}
@@ -34,7 +34,7 @@ type ExternalGenerateResponse struct {
 	Prompt string `json:"prompt"`
 }
 
-func GenerateImageHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) GenerateImageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
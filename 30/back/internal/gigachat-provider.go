@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGigaChatAuthURL is the Sber OAuth endpoint that exchanges credentials for an access token
+const DefaultGigaChatAuthURL = "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
+
+// gigaChatTokenRefreshMargin is how long before expiry a cached token is refreshed
+const gigaChatTokenRefreshMargin = time.Minute
+
+// GigaChatTokenResponse represents the response of the GigaChat OAuth endpoint
+type GigaChatTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresAt   int64  `json:"expires_at"` // Unix time in milliseconds
+}
+
+// gigaChatToken is a cached access token
+type gigaChatToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// gigaChatAuth exchanges the authorization key for access tokens and caches them.
+// The provider and its token live as long as the App that created them.
+type gigaChatAuth struct {
+	AuthURL string
+	Creds   string
+	Scope   string
+	Client  *http.Client
+
+	mu    sync.Mutex // guards token
+	token *gigaChatToken
+
+	// refreshMu lets one caller request a new token while the others wait for it
+	refreshMu sync.Mutex
+}
+
+// Token returns a cached access token or requests a new one if it is about to expire.
+// The OAuth request is made without holding mu, so callers with a valid token are not blocked.
+func (a *gigaChatAuth) Token(ctx context.Context) (string, error) {
+	if token, ok := a.cached(); ok {
+		return token, nil
+	}
+
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	// Another caller may have refreshed the token while this one waited
+	if token, ok := a.cached(); ok {
+		return token, nil
+	}
+
+	token, err := a.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+	return token.value, nil
+}
+
+// Invalidate drops the cached token so the next call requests a new one
+func (a *gigaChatAuth) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.token = nil
+}
+
+func (a *gigaChatAuth) cached() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == nil || time.Until(a.token.expiresAt) <= gigaChatTokenRefreshMargin {
+		return "", false
+	}
+	return a.token.value, true
+}
+
+func (a *gigaChatAuth) requestToken(ctx context.Context) (*gigaChatToken, error) {
+	form := url.Values{"scope": {a.Scope}}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.AuthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+
+	rqUID, err := newRqUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RqUID: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("RqUID", rqUID)
+	req.Header.Set("Authorization", "Basic "+a.Creds)
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request GigaChat token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GigaChat OAuth returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp GigaChatTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("GigaChat OAuth returned empty access token")
+	}
+
+	return &gigaChatToken{
+		value:     tokenResp.AccessToken,
+		expiresAt: time.UnixMilli(tokenResp.ExpiresAt),
+	}, nil
+}
+
+// NewGigaChatProvider creates a chat completions provider authorized through GigaChat OAuth
+func NewGigaChatProvider(cfg ProviderConfig) (*ChatCompletionsProvider, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("GIGACHAT_LLM_CREDS is not set")
+	}
+
+	httpClient, streamClient, err := newGigaChatHTTPClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL := cfg.AuthURL
+	if authURL == "" {
+		authURL = DefaultGigaChatAuthURL
+	}
+
+	provider := NewChatCompletionsProvider(cfg)
+	provider.Client = httpClient
+	provider.StreamClient = streamClient
+	provider.Tokens = &gigaChatAuth{
+		AuthURL: authURL,
+		Creds:   cfg.APIKey,
+		Scope:   cfg.Scope,
+		Client:  httpClient,
+	}
+
+	return provider, nil
+}
+
+// newGigaChatHTTPClients returns the provider clients with the SSL settings applied:
+// a custom CA bundle or disabled verification
+func newGigaChatHTTPClients(cfg ProviderConfig) (client, stream *http.Client, err error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read GigaChat CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in GigaChat CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client, stream = newProviderClients(transport, cfg.Timeout)
+	return client, stream, nil
+}
+
+// newRqUID generates a random UUID v4 for the RqUID header
+func newRqUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
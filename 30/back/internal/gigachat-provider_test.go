@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gigaChatStub imitates both the GigaChat OAuth endpoint and the completions endpoint
+type gigaChatStub struct {
+	tokenRequests int32
+	tokenTTL      time.Duration
+	tokenDelay    time.Duration
+	rejectTokens  map[string]bool
+}
+
+func (s *gigaChatStub) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v2/oauth", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic test-creds" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("RqUID") == "" {
+			t.Errorf("RqUID header is missing")
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("scope") != "GIGACHAT_API_PERS" {
+			t.Errorf("unexpected scope: %q", r.PostForm.Get("scope"))
+		}
+
+		n := atomic.AddInt32(&s.tokenRequests, 1)
+		time.Sleep(s.tokenDelay)
+		json.NewEncoder(w).Encode(GigaChatTokenResponse{
+			AccessToken: fmt.Sprintf("token-%d", n),
+			ExpiresAt:   time.Now().Add(s.tokenTTL).UnixMilli(),
+		})
+	})
+
+	mux.HandleFunc("/api/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if s.rejectTokens[auth] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req HuggingFaceChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode completion request: %v", err)
+		}
+
+		json.NewEncoder(w).Encode(HuggingFaceChatResponse{
+			Model: req.Model,
+			Choices: []HuggingFaceChatChoice{
+				{Message: HuggingFaceChatMessage{Role: "assistant", Content: auth + " " + req.Messages[len(req.Messages)-1].Content}},
+			},
+			Usage: HuggingFaceChatUsage{PromptTokens: 3, CompletionTokens: 5},
+		})
+	})
+
+	return mux
+}
+
+func newTestGigaChatProvider(t *testing.T, serverURL string, mutate func(*ProviderConfig)) *ChatCompletionsProvider {
+	cfg := ProviderConfig{
+		Role:     RoleBuilder,
+		Provider: ProviderGigaChat,
+		URL:      serverURL + "/api/v1/",
+		AuthURL:  serverURL + "/api/v2/oauth",
+		Model:    "GigaChat",
+		APIKey:   "test-creds",
+		Scope:    "GIGACHAT_API_PERS",
+		Timeout:  5 * time.Second,
+	}
+	if mutate != nil {
+		mutate(&cfg)
+	}
+
+	provider, err := NewGigaChatProvider(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return provider
+}
+
+func TestGigaChatProvider(t *testing.T) {
+	t.Run("cachesToken", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Minute}
+		server := httptest.NewServer(stub.handler(t))
+		defer server.Close()
+
+		provider := newTestGigaChatProvider(t, server.URL, nil)
+
+		for i := 0; i < 2; i++ {
+			resp, err := provider.Generate(context.Background(), ProviderRequest{System: "system", Prompt: "hello"})
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if resp.Response != "Bearer token-1 hello" {
+				t.Errorf("Unexpected response: %q", resp.Response)
+			}
+			if resp.PromptEvalCount != 3 || resp.EvalCount != 5 {
+				t.Errorf("Unexpected usage: %+v", resp)
+			}
+		}
+
+		if n := atomic.LoadInt32(&stub.tokenRequests); n != 1 {
+			t.Errorf("Token requested %d times, want 1", n)
+		}
+	})
+
+	t.Run("refreshesBeforeExpiry", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Second}
+		server := httptest.NewServer(stub.handler(t))
+		defer server.Close()
+
+		provider := newTestGigaChatProvider(t, server.URL, nil)
+
+		for i := 0; i < 2; i++ {
+			if _, err := provider.Generate(context.Background(), ProviderRequest{Prompt: "hello"}); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+		}
+
+		if n := atomic.LoadInt32(&stub.tokenRequests); n != 2 {
+			t.Errorf("Token requested %d times, want 2", n)
+		}
+	})
+
+	t.Run("concurrentCallsShareRefresh", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Minute, tokenDelay: 50 * time.Millisecond}
+		server := httptest.NewServer(stub.handler(t))
+		defer server.Close()
+
+		provider := newTestGigaChatProvider(t, server.URL, nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := provider.Tokens.Token(context.Background())
+				if err != nil {
+					t.Errorf("Token failed: %v", err)
+				} else if token != "token-1" {
+					t.Errorf("Unexpected token: %q", token)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&stub.tokenRequests); n != 1 {
+			t.Errorf("Token requested %d times, want 1", n)
+		}
+	})
+
+	t.Run("tokensPerProvider", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Minute}
+		server := httptest.NewServer(stub.handler(t))
+		defer server.Close()
+
+		first := newTestGigaChatProvider(t, server.URL, nil)
+		second := newTestGigaChatProvider(t, server.URL, nil)
+
+		if _, err := first.Tokens.Token(context.Background()); err != nil {
+			t.Fatalf("Token failed: %v", err)
+		}
+		second.Tokens.Invalidate()
+		if token, err := first.Tokens.Token(context.Background()); err != nil || token != "token-1" {
+			t.Errorf("Invalidate of another provider dropped the token: %q, %v", token, err)
+		}
+		if token, err := second.Tokens.Token(context.Background()); err != nil || token != "token-2" {
+			t.Errorf("Second provider got %q, %v, want its own token", token, err)
+		}
+	})
+
+	t.Run("retriesRejectedToken", func(t *testing.T) {
+		stub := &gigaChatStub{
+			tokenTTL:     30 * time.Minute,
+			rejectTokens: map[string]bool{"Bearer token-1": true},
+		}
+		server := httptest.NewServer(stub.handler(t))
+		defer server.Close()
+
+		provider := newTestGigaChatProvider(t, server.URL, nil)
+
+		resp, err := provider.Generate(context.Background(), ProviderRequest{Prompt: "hello"})
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if resp.Response != "Bearer token-2 hello" {
+			t.Errorf("Unexpected response: %q", resp.Response)
+		}
+	})
+
+	t.Run("customCA", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Minute}
+		server := httptest.NewTLSServer(stub.handler(t))
+		defer server.Close()
+
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+			t.Fatalf("Failed to write CA file: %v", err)
+		}
+
+		provider := newTestGigaChatProvider(t, server.URL, func(cfg *ProviderConfig) {
+			cfg.CAFile = caFile
+		})
+
+		if _, err := provider.Generate(context.Background(), ProviderRequest{Prompt: "hello"}); err != nil {
+			t.Fatalf("Generate with custom CA failed: %v", err)
+		}
+	})
+
+	t.Run("untrustedCertificate", func(t *testing.T) {
+		stub := &gigaChatStub{tokenTTL: 30 * time.Minute}
+		server := httptest.NewTLSServer(stub.handler(t))
+		defer server.Close()
+
+		provider := newTestGigaChatProvider(t, server.URL, nil)
+		if _, err := provider.Generate(context.Background(), ProviderRequest{Prompt: "hello"}); err == nil {
+			t.Fatalf("Expected TLS verification error")
+		}
+
+		insecure := newTestGigaChatProvider(t, server.URL, func(cfg *ProviderConfig) {
+			cfg.InsecureSkipVerify = true
+		})
+		if _, err := insecure.Generate(context.Background(), ProviderRequest{Prompt: "hello"}); err != nil {
+			t.Fatalf("Generate with disabled verification failed: %v", err)
+		}
+	})
+}
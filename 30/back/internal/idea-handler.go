@@ -8,38 +8,8 @@ import (
 	"net/http"
 )
 
-func IdeaHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading request body: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	// Parse JSON request - use the same format as other endpoints
-	var req struct {
-		Message string `json:"message"`
-		UserID  string `json:"user_id,omitempty"`
-	}
-	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-		return
-	}
-
-	// Log the incoming request
-	log.Printf("=== Incoming /idea request ===")
-	log.Printf("Message: %s", req.Message)
-	log.Printf("User ID: %s", req.UserID)
-	log.Printf("==============================")
-
-	// Create system prompt for idea expansion
-	systemPrompt := `Ты - эксперт по веб-разработке и UX/UI дизайну. Твоя задача - взять базовую идею типа сайта и расширить её до детального технического задания.
+// ideaSystemPrompt expands a basic site type into a detailed specification
+const ideaSystemPrompt = `Ты - эксперт по веб-разработке и UX/UI дизайну. Твоя задача - взять базовую идею типа сайта и расширить её до детального технического задания.
 
 Когда пользователь говорит тип сайта (например "лендинг", "интернет-магазин", "блог"), ты должен:
 
@@ -74,9 +44,50 @@ func IdeaHandler(w http.ResponseWriter, r *http.Request) {
 ТЕХНИЧЕСКИЕ ТРЕБОВАНИЯ:
 - [особенности реализации]`
 
+func (a *App) IdeaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Read request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Parse JSON request - use the same format as other endpoints
+	var req struct {
+		Message string `json:"message"`
+		UserID  string `json:"user_id,omitempty"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	// Log the incoming request
+	log.Printf("=== Incoming /idea request ===")
+	log.Printf("Message: %s", req.Message)
+	log.Printf("User ID: %s", resolveUserID(r.Context(), req.UserID))
+	log.Printf("==============================")
+
+	if _, err := a.screenInput(r.Context(), "message", req.Message); writeGuardRejection(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Guard error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Create LLM client and get response
-	llmClient := NewLLMClient()
-	llmResponse, err := llmClient.GetLLMResponse(req.Message, systemPrompt)
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	llmResponse, err := llmClient.GetLLMResponse(r.Context(), req.Message, ideaSystemPrompt)
 
 	var response IdeaResponse
 	if err != nil {
@@ -99,3 +110,51 @@ func IdeaHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// IdeaStreamHandler is the Server-Sent Events variant of IdeaHandler.
+// It sends "token" events while the LLM generates and a final "done" event with IdeaResponse.
+func (a *App) IdeaStreamHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		UserID  string `json:"user_id,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("=== Incoming /idea/stream request ===")
+	log.Printf("Message: %s", req.Message)
+	log.Printf("User ID: %s", resolveUserID(r.Context(), req.UserID))
+
+	if _, err := a.screenInput(r.Context(), "message", req.Message); writeGuardRejection(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Guard error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		sse.Error(err)
+		return
+	}
+	llmResponse, err := llmClient.StreamLLMResponse(r.Context(), req.Message, ideaSystemPrompt, sse.Token)
+	if err != nil {
+		log.Printf("Error streaming LLM response: %v", err)
+		sse.Error(fmt.Errorf("Ошибка обработки запроса: %v", err))
+		return
+	}
+
+	sse.Send(SSEEventDone, IdeaResponse{
+		Status:         "success",
+		ExpandedPrompt: llmResponse,
+	})
+}
@@ -1,38 +1,23 @@
 package internal
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 )
 
 type ImprovePromptRequest struct {
-	Prompt string `json:"prompt"`
+	Prompt string `json:"prompt" description:"Промпт для генерации изображения"`
 }
 
 type ImprovePromptResponse struct {
 	Prompt string `json:"prompt"`
 }
 
-type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	System string `json:"system"`
-	Stream bool   `json:"stream"`
-}
-
-type OllamaResponse struct {
-	Model     string `json:"model"`
-	CreatedAt string `json:"created_at"`
-	Response  string `json:"response"`
-	Done      bool   `json:"done"`
-}
-
-func ImprovePromptHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) ImprovePromptHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -49,11 +34,29 @@ func ImprovePromptHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read personal settings from environment variables
-	profession := os.Getenv("PERSONAL_PROFESSION")
-	habit := os.Getenv("PERSONAL_HABBIT")
-	lang := os.Getenv("PERSONAL_LANG")
-	style := os.Getenv("PERSONAL_STYLE")
+	improvedPrompt, err := a.improvePrompt(r.Context(), improvePromptReq.Prompt)
+	if err != nil {
+		log.Printf("Error improving prompt: %v", err)
+		http.Error(w, "Failed to improve prompt", http.StatusInternalServerError)
+		return
+	}
+
+	response := ImprovePromptResponse{
+		Prompt: improvedPrompt,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// improvePrompt rewrites an image generation prompt with the prompt improver LLM,
+// taking the personal settings into account
+func (a *App) improvePrompt(ctx context.Context, prompt string) (string, error) {
+	personal := a.Config.Personal
+	profession := personal.Profession
+	habit := personal.Habit
+	lang := personal.Lang
+	style := personal.Style
 
 	// Build system prompt with personal settings
 	systemPrompt := "You are a prompt improvement assistant. Take the user's image generation prompt and improve it for better image generation results. Return ONLY the improved prompt in English, without any explanations, thinking, quotes, or additional text. Do not use <think> tags or any other formatting."
@@ -74,46 +77,22 @@ func ImprovePromptHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	ollamaReq := OllamaRequest{
-		Model:  "gemma3:12b",
-		Prompt: improvePromptReq.Prompt,
-		System: systemPrompt,
-		Stream: false,
-	}
-
-	ollamaReqJSON, err := json.Marshal(ollamaReq)
+	provider, cfg, err := a.Provider(RolePromptImprover)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("failed to configure prompt improver: %w", err)
 	}
 
-	ollamaURL := "http://localhost:11434/api/generate"
-	resp, err := http.Post(ollamaURL, "application/json", bytes.NewBuffer(ollamaReqJSON))
+	llmResp, err := provider.Generate(ctx, ProviderRequest{
+		System:      systemPrompt,
+		Prompt:      prompt,
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+	})
 	if err != nil {
-		http.Error(w, "Failed to improve prompt", http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("%s: %w", provider.Name(), err)
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read Ollama response", http.StatusInternalServerError)
-		return
-	}
+	log.Printf("Prompt improver response: %+v", llmResp)
 
-	var ollamaResp OllamaResponse
-	if err := json.Unmarshal(respBody, &ollamaResp); err != nil {
-		log.Printf("Error parsing Ollama response: %v, body: %s", err, string(respBody))
-		http.Error(w, "Invalid Ollama response format", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Ollama response: %+v", ollamaResp)
-
-	response := ImprovePromptResponse{
-		Prompt: ollamaResp.Response,
-	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	return llmResp.Response, nil
 }
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// JobResponse is the public representation of a job
+type JobResponse struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Status     string          `json:"status"`
+	Progress   string          `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// NewJobResponse converts a stored job to the API response
+func NewJobResponse(job *repo.Job) JobResponse {
+	response := JobResponse{
+		ID:         job.ID,
+		Type:       job.Type,
+		Status:     job.Status,
+		Progress:   job.Progress,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		UpdatedAt:  job.UpdatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Result != "" {
+		response.Result = json.RawMessage(job.Result)
+	}
+	return response
+}
+
+// GetJobHandler returns the job state and its result once finished
+func (a *App) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, err := a.Jobs.Get(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownedByCaller(r.Context(), job.UserID)) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(NewJobResponse(job))
+}
+
+// CancelJobHandler cancels a queued or running job
+func (a *App) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id := mux.Vars(r)["id"]
+
+	// Чужие задачи не видны, как и несуществующие
+	job, err := a.Jobs.Get(r.Context(), id)
+	if err == nil && !ownedByCaller(r.Context(), job.UserID) {
+		err = sql.ErrNoRows
+	}
+	if err == nil {
+		job, err = a.Jobs.Cancel(r.Context(), id)
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrJobFinished):
+		w.WriteHeader(http.StatusConflict)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to cancel job: %v", err), http.StatusInternalServerError)
+		return
+	case job.Status == repo.JobStatusRunning:
+		// Отмена запрошена, задача остановится после текущего шага
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	json.NewEncoder(w).Encode(NewJobResponse(job))
+}
+
+// JobEventsHandler streams job state changes as Server-Sent Events.
+// Every change is sent as a "progress" event; the final state is sent as "done".
+func (a *App) JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// Подписываемся до чтения задачи, чтобы не пропустить завершение между ними
+	updates, unsubscribe := a.Jobs.Subscribe(id)
+	defer unsubscribe()
+
+	job, err := a.Jobs.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownedByCaller(r.Context(), job.UserID)) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for !IsJobFinished(job) {
+		if err := sse.Send(SSEEventProgress, NewJobResponse(job)); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case update, ok := <-updates:
+			if ok {
+				job = update
+				continue
+			}
+			// Канал закрыт после завершения задачи - перечитываем итоговое состояние
+			job, err = a.Jobs.Get(r.Context(), id)
+			if err != nil {
+				sse.Error(fmt.Errorf("failed to get job: %w", err))
+				return
+			}
+			if !IsJobFinished(job) {
+				sse.Error(fmt.Errorf("job %s is no longer tracked", id))
+				return
+			}
+		}
+	}
+
+	sse.Send(SSEEventDone, NewJobResponse(job))
+}
@@ -0,0 +1,483 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// Job types handled by the queue
+const (
+	JobTypeBuild    = "build"
+	JobTypePipeline = "pipeline"
+	JobTypeRevise   = "revise"
+	JobTypeVerify   = "verify"
+)
+
+// defaultJobWorkers limits how many jobs use the LLM at the same time
+const defaultJobWorkers = 2
+
+// jobQueueSize is the number of queued jobs kept in memory. New jobs beyond it are rejected,
+// jobs resumed on start beyond it wait in the repository until the workers refill the queue.
+const jobQueueSize = 100
+
+var (
+	// ErrJobQueueFull is returned when no more jobs can be queued
+	ErrJobQueueFull = errors.New("job queue is full")
+	// ErrJobFinished is returned when cancelling a job that has already finished
+	ErrJobFinished = errors.New("job has already finished")
+	// ErrJobQueueStopped is returned when the queue is not running
+	ErrJobQueueStopped = errors.New("job queue is not running")
+)
+
+// JobHandler executes a job. It reports stages through progress and returns the job result.
+type JobHandler func(ctx context.Context, repository repo.Repository, job *repo.Job, progress func(stage string)) (interface{}, error)
+
+// JobQueue runs persisted jobs on a bounded pool of workers
+type JobQueue struct {
+	repository repo.Repository
+	handlers   map[string]JobHandler
+	queue      chan string
+	workers    int
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	draining  chan struct{}
+	drainOnce sync.Once
+
+	// backlog is set when queued jobs of the repository did not fit into the channel
+	backlogMu sync.Mutex
+	backlog   bool
+
+	mu          sync.Mutex
+	running     map[string]context.CancelFunc
+	subscribers map[string]map[chan *repo.Job]struct{}
+}
+
+// NewJobQueue creates a queue that stores jobs in the repository
+func NewJobQueue(repository repo.Repository, workers int) *JobQueue {
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+
+	return &JobQueue{
+		repository:  repository,
+		handlers:    make(map[string]JobHandler),
+		queue:       make(chan string, jobQueueSize),
+		workers:     workers,
+		draining:    make(chan struct{}),
+		running:     make(map[string]context.CancelFunc),
+		subscribers: make(map[string]map[chan *repo.Job]struct{}),
+	}
+}
+
+// Handle registers the handler for a job type. It must be called before Start.
+func (q *JobQueue) Handle(jobType string, handler JobHandler) {
+	q.handlers[jobType] = handler
+}
+
+// Start requeues jobs left queued or running by a previous process and starts the workers.
+// ctx only bounds the loading of those jobs: the workers run until Stop, so that a cancelled
+// server context, e.g. on SIGTERM, lets Drain finish the running jobs instead of interrupting them.
+func (q *JobQueue) Start(ctx context.Context) error {
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	pending, err := q.repository.GetJobsByStatus(ctx, repo.JobStatusQueued, repo.JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to load pending jobs: %w", err)
+	}
+
+	for _, job := range pending {
+		if job.Status == repo.JobStatusRunning {
+			job.Status = repo.JobStatusQueued
+			job.Progress = ""
+			job.StartedAt = nil
+			if err := q.repository.UpdateJob(ctx, job); err != nil {
+				return fmt.Errorf("failed to requeue job %s: %w", job.ID, err)
+			}
+		}
+
+		// Interrupted jobs past a full channel are still returned to the queued state above
+		if q.backlog || !q.push(job) {
+			continue
+		}
+		log.Printf("Resuming job %s (%s)", job.ID, job.Type)
+	}
+	if q.backlog {
+		log.Printf("Job queue is full, the remaining jobs are resumed as the workers free it")
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	log.Printf("Job queue started with %d workers", q.workers)
+	return nil
+}
+
+// Drain stops taking jobs from the queue and waits for the running ones to
+// finish. When ctx is done first, the remaining jobs are cancelled and returned
+// to the queue like on Stop. Jobs left in the queue are resumed on the next start.
+func (q *JobQueue) Drain(ctx context.Context) {
+	q.drainOnce.Do(func() { close(q.draining) })
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Job queue did not drain in time, interrupting running jobs")
+		q.Stop()
+	}
+}
+
+// Stop cancels running jobs and waits for the workers. Interrupted jobs are
+// returned to the queue and resumed on the next start. The repository stays open.
+func (q *JobQueue) Stop() {
+	if q.cancel == nil {
+		return
+	}
+	q.cancel()
+	q.wg.Wait()
+}
+
+// Enqueue stores a new job and schedules it for execution
+func (q *JobQueue) Enqueue(ctx context.Context, jobType, userID string, payload interface{}) (*repo.Job, error) {
+	if _, ok := q.handlers[jobType]; !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	// A draining queue is shutting down and takes no new jobs
+	select {
+	case <-q.draining:
+		return nil, ErrJobQueueStopped
+	default:
+	}
+	if len(q.queue) == cap(q.queue) {
+		return nil, ErrJobQueueFull
+	}
+
+	job := &repo.Job{
+		ID:      newJobID(),
+		Type:    jobType,
+		UserID:  userID,
+		Status:  repo.JobStatusQueued,
+		Payload: string(payloadJSON),
+	}
+	if err := q.repository.CreateJob(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to store job: %w", err)
+	}
+
+	select {
+	case q.queue <- job.ID:
+	default:
+		job.Status = repo.JobStatusFailed
+		job.Error = ErrJobQueueFull.Error()
+		q.finish(job)
+		return nil, ErrJobQueueFull
+	}
+
+	return job, nil
+}
+
+// Get returns the stored job
+func (q *JobQueue) Get(ctx context.Context, id string) (*repo.Job, error) {
+	return q.repository.GetJob(ctx, id)
+}
+
+// Cancel stops a running job or removes a queued one from the queue
+func (q *JobQueue) Cancel(ctx context.Context, id string) (*repo.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.repository.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.Status {
+	case repo.JobStatusRunning:
+		// Воркер сам отметит задачу как отмененную после остановки обработчика
+		if cancel, ok := q.running[id]; ok {
+			cancel()
+		}
+		return job, nil
+	case repo.JobStatusQueued:
+		job.Status = repo.JobStatusCancelled
+		if err := q.finishLocked(ctx, job); err != nil {
+			return nil, err
+		}
+		return job, nil
+	default:
+		return job, ErrJobFinished
+	}
+}
+
+// Subscribe returns a channel with job state updates and a function that unsubscribes
+func (q *JobQueue) Subscribe(id string) (<-chan *repo.Job, func()) {
+	updates := make(chan *repo.Job, 16)
+
+	q.mu.Lock()
+	if q.subscribers[id] == nil {
+		q.subscribers[id] = make(map[chan *repo.Job]struct{})
+	}
+	q.subscribers[id][updates] = struct{}{}
+	q.mu.Unlock()
+
+	return updates, func() {
+		q.mu.Lock()
+		delete(q.subscribers[id], updates)
+		if len(q.subscribers[id]) == 0 {
+			delete(q.subscribers, id)
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		// A draining queue finishes the running job but starts no new one
+		select {
+		case <-q.draining:
+			return
+		default:
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.draining:
+			return
+		case id := <-q.queue:
+			q.run(id)
+			q.refill()
+		}
+	}
+}
+
+// push sends a queued job to the channel. When the channel is full, it marks the backlog
+// for refill and reports false.
+func (q *JobQueue) push(job *repo.Job) bool {
+	select {
+	case q.queue <- job.ID:
+		return true
+	default:
+		q.backlog = true
+		return false
+	}
+}
+
+// refill moves jobs left in the repository by a full channel into it. It waits until the
+// channel is empty, so the jobs still in it are not queued twice; a job taken by another
+// worker but not started yet may be, and is skipped by run.
+func (q *JobQueue) refill() {
+	q.backlogMu.Lock()
+	defer q.backlogMu.Unlock()
+
+	if !q.backlog || len(q.queue) > 0 || q.ctx.Err() != nil {
+		return
+	}
+
+	jobs, err := q.repository.GetJobsByStatus(q.ctx, repo.JobStatusQueued)
+	if err != nil {
+		log.Printf("Failed to load queued jobs: %v", err)
+		return
+	}
+
+	q.backlog = false
+	for _, job := range jobs {
+		if !q.push(job) {
+			return
+		}
+	}
+}
+
+// run executes a single job and stores its final state
+func (q *JobQueue) run(id string) {
+	q.mu.Lock()
+	job, err := q.repository.GetJob(q.ctx, id)
+	if err != nil {
+		q.mu.Unlock()
+		log.Printf("Failed to load job %s: %v", id, err)
+		return
+	}
+	if job.Status != repo.JobStatusQueued {
+		// Задача отменена, пока ждала в очереди
+		q.mu.Unlock()
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	q.running[id] = cancel
+
+	now := time.Now()
+	job.Status = repo.JobStatusRunning
+	job.StartedAt = &now
+	if err := q.repository.UpdateJob(q.ctx, job); err != nil {
+		delete(q.running, id)
+		q.mu.Unlock()
+		log.Printf("Failed to start job %s: %v", id, err)
+		return
+	}
+	q.publishLocked(job)
+	q.mu.Unlock()
+
+	progress := func(stage string) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		job.Progress = stage
+		if err := q.repository.UpdateJob(q.ctx, job); err != nil {
+			log.Printf("Failed to update job %s progress: %v", id, err)
+		}
+		q.publishLocked(job)
+	}
+
+	audit := &repo.AuditEvent{Actor: job.UserID, Action: "job." + job.Type, Target: "job:" + job.ID, StartedAt: now}
+	result, err := q.execute(withAuditEvent(jobCtx, audit), job, progress)
+
+	q.mu.Lock()
+	delete(q.running, id)
+	q.mu.Unlock()
+
+	switch {
+	case q.ctx.Err() != nil && err != nil:
+		// Сервер останавливается - прерванная задача будет выполнена заново после перезапуска,
+		// а результат задачи, успевшей завершиться, сохраняется
+		job.Status = repo.JobStatusQueued
+		job.Progress = ""
+		job.StartedAt = nil
+		if err := q.repository.UpdateJob(context.Background(), job); err != nil {
+			log.Printf("Failed to requeue job %s: %v", id, err)
+		}
+		return
+	case jobCtx.Err() != nil && q.ctx.Err() == nil:
+		job.Status = repo.JobStatusCancelled
+	case err != nil:
+		job.Status = repo.JobStatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = repo.JobStatusSucceeded
+		if result != nil {
+			resultJSON, marshalErr := json.Marshal(result)
+			if marshalErr != nil {
+				job.Status = repo.JobStatusFailed
+				job.Error = fmt.Sprintf("failed to encode job result: %v", marshalErr)
+			} else {
+				job.Result = string(resultJSON)
+			}
+		}
+	}
+
+	switch job.Status {
+	case repo.JobStatusSucceeded:
+		audit.Outcome = repo.AuditOutcomeSuccess
+	case repo.JobStatusCancelled:
+		audit.Outcome = repo.AuditOutcomeFailed
+		audit.Error = "cancelled"
+	default:
+		audit.Outcome = repo.AuditOutcomeFailed
+		audit.Error = job.Error
+	}
+	writeAuditEvent(context.Background(), q.repository, audit)
+
+	q.finish(job)
+}
+
+// execute calls the job handler, turning a panic into a job error
+func (q *JobQueue) execute(ctx context.Context, job *repo.Job, progress func(stage string)) (result interface{}, err error) {
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	// LLM tokens of the job are charged to its owner
+	return handler(withQuotaSubject(ctx, job.UserID, job.Type), q.repository, job, progress)
+}
+
+// finish stores the terminal state of the job and notifies the subscribers
+func (q *JobQueue) finish(job *repo.Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.finishLocked(context.Background(), job); err != nil {
+		log.Printf("Failed to store job %s result: %v", job.ID, err)
+	}
+}
+
+// finishLocked stores the terminal state, publishes it and closes the subscriber
+// channels, so subscribers that missed the last update can reload the job; q.mu must be held.
+func (q *JobQueue) finishLocked(ctx context.Context, job *repo.Job) error {
+	now := time.Now()
+	job.FinishedAt = &now
+
+	if err := q.repository.UpdateJob(ctx, job); err != nil {
+		return err
+	}
+
+	q.publishLocked(job)
+	for updates := range q.subscribers[job.ID] {
+		close(updates)
+	}
+	delete(q.subscribers, job.ID)
+	return nil
+}
+
+// publishLocked sends a copy of the job to its subscribers; q.mu must be held.
+// Slow subscribers miss intermediate updates instead of blocking the worker.
+func (q *JobQueue) publishLocked(job *repo.Job) {
+	for updates := range q.subscribers[job.ID] {
+		snapshot := *job
+		select {
+		case updates <- &snapshot:
+		default:
+		}
+	}
+}
+
+// IsJobFinished reports whether the job reached a terminal status
+func IsJobFinished(job *repo.Job) bool {
+	switch job.Status {
+	case repo.JobStatusSucceeded, repo.JobStatusFailed, repo.JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// newJobID returns a random job identifier
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
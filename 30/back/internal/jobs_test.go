@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// newTestJobRepository opens a SQLite repository in a temporary working directory
+func newTestJobRepository(t *testing.T) repo.Repository {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	repository, err := repo.NewSQLiteRepository(repo.DefaultSQLiteFile)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	return repository
+}
+
+// waitForJob polls the job until it reaches the wanted status
+func waitForJob(t *testing.T, queue *JobQueue, id, status string) *repo.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := queue.Get(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach status %s", id, status)
+	return nil
+}
+
+func TestJobQueue(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	release := make(chan struct{})
+	queue := NewJobQueue(repository, 1)
+	queue.Handle("echo", func(ctx context.Context, _ repo.Repository, job *repo.Job, progress func(string)) (interface{}, error) {
+		progress("working")
+		select {
+		case <-release:
+			return map[string]string{"payload": job.Payload}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	blocked := make(chan struct{})
+	queue.Handle("block", func(ctx context.Context, _ repo.Repository, _ *repo.Job, _ func(string)) (interface{}, error) {
+		close(blocked)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err := queue.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer queue.Stop()
+
+	first, err := queue.Enqueue(context.Background(), "echo", "user", "first")
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	second, err := queue.Enqueue(context.Background(), "echo", "user", "second")
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	t.Run("boundedWorkers", func(t *testing.T) {
+		job := waitForJob(t, queue, first.ID, repo.JobStatusRunning)
+		if job.StartedAt == nil {
+			t.Errorf("running job has no started_at")
+		}
+
+		waiting, _ := queue.Get(context.Background(), second.ID)
+		if waiting.Status != repo.JobStatusQueued {
+			t.Errorf("second job status = %s, want queued while the only worker is busy", waiting.Status)
+		}
+	})
+
+	t.Run("cancelQueued", func(t *testing.T) {
+		job, err := queue.Cancel(context.Background(), second.ID)
+		if err != nil {
+			t.Fatalf("Failed to cancel: %v", err)
+		}
+		if job.Status != repo.JobStatusCancelled {
+			t.Errorf("status = %s, want cancelled", job.Status)
+		}
+	})
+
+	t.Run("succeeds", func(t *testing.T) {
+		updates, unsubscribe := queue.Subscribe(first.ID)
+		defer unsubscribe()
+
+		close(release)
+		job := waitForJob(t, queue, first.ID, repo.JobStatusSucceeded)
+		if job.Result != `{"payload":"\"first\""}` {
+			t.Errorf("result = %s", job.Result)
+		}
+
+		var last *repo.Job
+		for update := range updates {
+			last = update
+		}
+		if last == nil || last.Status != repo.JobStatusSucceeded {
+			t.Errorf("subscriber did not receive the final state: %+v", last)
+		}
+
+		if _, err := queue.Cancel(context.Background(), first.ID); err != ErrJobFinished {
+			t.Errorf("cancel finished job err = %v, want ErrJobFinished", err)
+		}
+	})
+
+	t.Run("cancelRunning", func(t *testing.T) {
+		job, err := queue.Enqueue(context.Background(), "block", "user", nil)
+		if err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+		<-blocked
+
+		if _, err := queue.Cancel(context.Background(), job.ID); err != nil {
+			t.Fatalf("Failed to cancel: %v", err)
+		}
+		waitForJob(t, queue, job.ID, repo.JobStatusCancelled)
+	})
+}
+
+func TestJobQueueResumesAfterRestart(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	interrupted := &repo.Job{ID: "interrupted", Type: "echo", Status: repo.JobStatusRunning, Payload: "{}"}
+	if err := repository.CreateJob(ctx, interrupted); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	queued := &repo.Job{ID: "queued", Type: "echo", Status: repo.JobStatusQueued, Payload: "{}"}
+	if err := repository.CreateJob(ctx, queued); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	queue := NewJobQueue(repository, 2)
+	queue.Handle("echo", func(context.Context, repo.Repository, *repo.Job, func(string)) (interface{}, error) {
+		return nil, nil
+	})
+	if err := queue.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer queue.Stop()
+
+	waitForJob(t, queue, interrupted.ID, repo.JobStatusSucceeded)
+	waitForJob(t, queue, queued.ID, repo.JobStatusSucceeded)
+}
+
+func TestJobQueueDrain(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	queue := NewJobQueue(repository, 1)
+	queue.Handle("echo", func(ctx context.Context, _ repo.Repository, _ *repo.Job, _ func(string)) (interface{}, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	if err := queue.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+
+	running, err := queue.Enqueue(ctx, "echo", "user", nil)
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	<-started
+	waiting, err := queue.Enqueue(ctx, "echo", "user", nil)
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		queue.Drain(ctx)
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		t.Fatalf("Drain returned while a job was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-drained
+
+	// The running job finished, the queued one waits for the next start
+	if job, _ := queue.Get(ctx, running.ID); job.Status != repo.JobStatusSucceeded {
+		t.Errorf("running job status = %s, want succeeded", job.Status)
+	}
+	if job, _ := queue.Get(ctx, waiting.ID); job.Status != repo.JobStatusQueued {
+		t.Errorf("queued job status = %s, want queued", job.Status)
+	}
+	queue.Stop()
+
+	// Past the deadline running jobs are interrupted and requeued
+	queue = NewJobQueue(repository, 1)
+	queue.Handle("echo", func(ctx context.Context, _ repo.Repository, _ *repo.Job, _ func(string)) (interface{}, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err := queue.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	<-started
+
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	queue.Drain(deadline)
+
+	if job, _ := queue.Get(ctx, waiting.ID); job.Status != repo.JobStatusQueued || job.StartedAt != nil {
+		t.Errorf("interrupted job = %+v, want it requeued", job)
+	}
+}
+
+func TestJobQueueOutlivesStartContext(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	queue := NewJobQueue(repository, 1)
+	queue.Handle("echo", func(ctx context.Context, _ repo.Repository, _ *repo.Job, _ func(string)) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return "done", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+
+	// The server context is cancelled on SIGTERM, before the queue is drained
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	if err := queue.Start(serverCtx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer queue.Stop()
+
+	job, err := queue.Enqueue(context.Background(), "echo", "user", nil)
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	<-started
+	cancelServer()
+
+	drained := make(chan struct{})
+	go func() {
+		queue.Drain(context.Background())
+		close(drained)
+	}()
+	close(release)
+	<-drained
+
+	if job = waitForJob(t, queue, job.ID, repo.JobStatusSucceeded); job.Result != `"done"` {
+		t.Errorf("result = %q", job.Result)
+	}
+}
+
+func TestJobQueueResumesBacklog(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	// More jobs than the channel holds are left by a previous process
+	var ids []string
+	for i := 0; i < jobQueueSize+20; i++ {
+		status := repo.JobStatusQueued
+		if i == jobQueueSize+10 {
+			status = repo.JobStatusRunning
+		}
+		job := &repo.Job{ID: fmt.Sprintf("pending-%03d", i), Type: "echo", Status: status, Payload: "{}"}
+		if err := repository.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	queue := NewJobQueue(repository, 2)
+	queue.Handle("echo", func(context.Context, repo.Repository, *repo.Job, func(string)) (interface{}, error) {
+		return nil, nil
+	})
+	if err := queue.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer queue.Stop()
+
+	for _, id := range ids {
+		waitForJob(t, queue, id, repo.JobStatusSucceeded)
+	}
+}
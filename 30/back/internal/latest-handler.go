@@ -2,7 +2,9 @@ package internal
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -10,49 +12,33 @@ import (
 )
 
 type LatestResponse struct {
-	Status   string `json:"status"`
-	Message  string `json:"message"`
-	File     string `json:"file,omitempty"`
-	FilePath string `json:"file_path,omitempty"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	File       string `json:"file,omitempty"`
+	FilePath   string `json:"file_path,omitempty"`
+	ProjectID  int64  `json:"project_id,omitempty"`
+	PreviewURL string `json:"preview_url,omitempty"`
 }
 
 // LatestHandler returns the latest generated file from the repository
-func LatestHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) LatestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Initialize repository
-	repository, err := repo.NewRepository()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer repository.Close()
-
 	ctx := context.Background()
 
-	// Get all chats to find the latest project
-	chats, err := repository.GetChats(ctx, 100, 0) // Get up to 100 recent chats
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get chats: %v", err), http.StatusInternalServerError)
+	latestProject, err := a.Repository.GetLatestProject(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Failed to get latest project: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	var latestProject *repo.Project
 	var latestChat *repo.Chat
-
-	// Find the latest project across all chats
-	for _, chat := range chats {
-		projects, err := repository.GetProjectsByChat(ctx, chat.ID)
+	if latestProject != nil {
+		latestChat, err = a.Repository.GetChat(ctx, latestProject.ChatID)
 		if err != nil {
-			continue // Skip this chat if error
-		}
-
-		for _, project := range projects {
-			if latestProject == nil || project.CreatedAt.After(latestProject.CreatedAt) {
-				latestProject = project
-				latestChat = chat
-			}
+			http.Error(w, fmt.Sprintf("Failed to get chat: %v", err), http.StatusInternalServerError)
+			return
 		}
 	}
 
@@ -68,10 +54,12 @@ func LatestHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Return the latest project info
 		response = LatestResponse{
-			Status:   "success",
-			Message:  fmt.Sprintf("Latest generated file from chat: %s", latestChat.Title),
-			File:     latestProject.Name,
-			FilePath: latestProject.FilePath,
+			Status:     "success",
+			Message:    fmt.Sprintf("Latest generated file from chat: %s", latestChat.Title),
+			File:       latestProject.Name,
+			FilePath:   latestProject.FilePath,
+			ProjectID:  latestProject.ID,
+			PreviewURL: a.previewURL(latestProject.ID),
 		}
 	}
 
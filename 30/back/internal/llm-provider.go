@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLM roles. Each role is configured independently in the llm config section
+// (or env variables with its own prefix), so every role can use a different backend and model.
+const (
+	RoleGathering       = "gathering"
+	RoleBuilder         = "builder"
+	RolePromptImprover  = "prompt-improver"
+	RoleGuardClassifier = "guard-classifier"
+)
+
+// Supported provider backends
+const (
+	ProviderOllama      = "ollama"
+	ProviderHuggingFace = "huggingface"
+	ProviderOpenAI      = "openai"
+	ProviderGigaChat    = "gigachat"
+)
+
+// roleConfigKeys maps a role to its key in the llm config section and the prefix of its env variables
+var roleConfigKeys = map[string]struct{ key, env string }{
+	RoleGathering:       {"gathering", "GATHERING_REQUIREMENTS_LLM"},
+	RoleBuilder:         {"builder", "BUILDER_LLM"},
+	RolePromptImprover:  {"prompt_improver", "PROMPT_IMPROVER_LLM"},
+	RoleGuardClassifier: {"guard_classifier", "GUARD_CLASSIFIER_LLM"},
+}
+
+// ProviderRequest is a backend-agnostic completion request.
+// Generate uses System and Prompt, Chat and Stream use Messages when they are set.
+type ProviderRequest struct {
+	System      string
+	Prompt      string
+	Messages    []Message
+	Temperature float64
+	MaxTokens   int
+}
+
+// LLMProvider is implemented by every LLM backend
+type LLMProvider interface {
+	// Name returns the backend name, e.g. "ollama"
+	Name() string
+	// Model returns the model the provider sends requests to
+	Model() string
+	// Generate performs a single-prompt completion
+	Generate(ctx context.Context, req ProviderRequest) (*LLMResponse, error)
+	// Chat performs a completion over a list of messages
+	Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error)
+	// Stream performs a completion and calls onToken for every received chunk.
+	// The returned response contains the whole concatenated text.
+	Stream(ctx context.Context, req ProviderRequest, onToken func(token string) error) (*LLMResponse, error)
+}
+
+// ProviderConfig holds the settings of a single role
+type ProviderConfig struct {
+	Role        string
+	Provider    string
+	URL         string
+	Model       string
+	APIKey      string
+	Timeout     time.Duration
+	Temperature float64
+	MaxTokens   int
+	Stream      bool
+
+	// GigaChat OAuth and TLS settings
+	Scope              string
+	AuthURL            string
+	InsecureSkipVerify bool
+	CAFile             string
+}
+
+// Provider returns the validated settings of a role, with the fallbacks
+// to the shared Hugging Face and GigaChat settings applied
+func (c *Config) Provider(role string) (ProviderConfig, error) {
+	keys, ok := roleConfigKeys[role]
+	if !ok {
+		return ProviderConfig{}, fmt.Errorf("unknown LLM role %q", role)
+	}
+
+	var roleCfg LLMRoleConfig
+	switch role {
+	case RoleGathering:
+		roleCfg = c.LLM.Gathering
+	case RoleBuilder:
+		roleCfg = c.LLM.Builder
+	case RolePromptImprover:
+		roleCfg = c.LLM.PromptImprover
+	case RoleGuardClassifier:
+		roleCfg = c.LLM.GuardClassifier
+	}
+
+	cfg := ProviderConfig{
+		Role:        role,
+		Provider:    strings.ToLower(roleCfg.Provider),
+		URL:         roleCfg.URL,
+		Model:       roleCfg.Model,
+		APIKey:      roleCfg.APIKey,
+		Timeout:     time.Duration(roleCfg.Timeout) * time.Second,
+		Temperature: roleCfg.Temperature,
+		MaxTokens:   roleCfg.MaxTokens,
+		Stream:      roleCfg.Stream,
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderOllama
+	}
+
+	var errs []error
+	setting := func(name string) string {
+		return fmt.Sprintf("llm.%s.%s (%s_%s)", keys.key, name, keys.env, strings.ToUpper(name))
+	}
+
+	switch cfg.Provider {
+	case ProviderOllama, ProviderOpenAI:
+	case ProviderHuggingFace:
+		// Hugging Face roles fall back to the shared HUGGINGFACE_* settings
+		if cfg.URL == "" {
+			cfg.URL = c.LLM.HuggingFace.ChatURL
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = c.LLM.HuggingFace.APIKey
+		}
+	case ProviderGigaChat:
+		// GigaChat roles fall back to the shared GIGACHAT_LLM_* settings
+		gigaChat := c.LLM.GigaChat
+		if cfg.URL == "" {
+			cfg.URL = gigaChat.URL
+		}
+		if cfg.Model == "" {
+			cfg.Model = gigaChat.Model
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = gigaChat.Creds
+		}
+		cfg.Scope = gigaChat.Scope
+		cfg.AuthURL = gigaChat.AuthURL
+		cfg.CAFile = gigaChat.CAFile
+		cfg.InsecureSkipVerify = !gigaChat.SSL
+		if cfg.Scope == "" {
+			errs = append(errs, fmt.Errorf("llm.gigachat.scope (GIGACHAT_LLM_SCOPE) is not set"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown LLM provider %q", setting("provider"), cfg.Provider))
+	}
+
+	if cfg.URL == "" {
+		errs = append(errs, fmt.Errorf("%s is not set", setting("url")))
+	}
+	if cfg.Model == "" {
+		errs = append(errs, fmt.Errorf("%s is not set", setting("model")))
+	}
+	if cfg.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s must be a positive number of seconds", setting("timeout")))
+	}
+	if cfg.MaxTokens <= 0 {
+		errs = append(errs, fmt.Errorf("%s must be a positive number", setting("max_tokens")))
+	}
+	if cfg.Temperature < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative", setting("temperature")))
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// NewProvider creates a provider for the given configuration
+func NewProvider(cfg ProviderConfig) (LLMProvider, error) {
+	switch cfg.Provider {
+	case ProviderOllama:
+		return NewOllamaProvider(cfg), nil
+	case ProviderHuggingFace, ProviderOpenAI:
+		return NewChatCompletionsProvider(cfg), nil
+	case ProviderGigaChat:
+		provider, err := NewGigaChatProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q for role %q", cfg.Provider, cfg.Role)
+	}
+}
+
+// ProviderFactory returns the provider of a role and the settings of the role
+type ProviderFactory func(role string) (LLMProvider, ProviderConfig, error)
+
+// complete runs a request through the provider. Roles with streaming enabled
+// read the response as a stream, so a long generation is not cut by a single read.
+func complete(ctx context.Context, provider LLMProvider, cfg ProviderConfig, req ProviderRequest) (*LLMResponse, error) {
+	if cfg.Stream {
+		return provider.Stream(ctx, req, nil)
+	}
+	return provider.Generate(ctx, req)
+}
+
+// requestMessages returns the chat messages of a request,
+// prepending the system prompt and falling back to the single prompt
+func requestMessages(req ProviderRequest) []Message {
+	var messages []Message
+	if req.System != "" {
+		messages = append(messages, Message{Role: "system", Content: req.System})
+	}
+	if len(req.Messages) > 0 {
+		return append(messages, req.Messages...)
+	}
+	return append(messages, Message{Role: "user", Content: req.Prompt})
+}
+
+// newProviderClients returns the HTTP clients of a provider: one bounded by the timeout
+// and one for streams without the overall timeout. A long generation may legitimately
+// stream for minutes, so only the wait for the response headers is limited; cancellation
+// goes through the context. Both share the transport and so one connection pool.
+func newProviderClients(transport *http.Transport, timeout time.Duration) (client, stream *http.Client) {
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Timeout: timeout, Transport: transport}, &http.Client{Transport: transport}
+}
+
+// drainStream reads what is left of a finished stream, usually just its end,
+// so the connection returns to the pool of the provider
+func drainStream(body io.Reader) {
+	io.Copy(io.Discard, io.LimitReader(body, 64*1024))
+}
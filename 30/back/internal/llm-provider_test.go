@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigProvider(t *testing.T) {
+	role := LLMRoleConfig{Model: "role-model", Timeout: 30, MaxTokens: 1000}
+
+	t.Run("defaultsToOllama", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.LLM.Builder = role
+		cfg.LLM.Builder.URL = "http://ollama:11434"
+
+		provider, err := cfg.Provider(RoleBuilder)
+		if err != nil {
+			t.Fatalf("Provider failed: %v", err)
+		}
+		if provider.Provider != ProviderOllama || provider.Role != RoleBuilder || provider.URL != "http://ollama:11434" ||
+			provider.Model != "role-model" || provider.Timeout != 30*time.Second || provider.MaxTokens != 1000 {
+			t.Errorf("provider = %+v", provider)
+		}
+	})
+
+	t.Run("rolesAreIndependent", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.LLM.Gathering = role
+		cfg.LLM.Gathering.URL = "http://ollama:11434"
+		cfg.LLM.Builder = role
+		cfg.LLM.Builder.Provider = "OpenAI"
+		cfg.LLM.Builder.URL = "https://api.openai.com/v1"
+		cfg.LLM.Builder.Model = "gpt-4o"
+
+		gathering, err := cfg.Provider(RoleGathering)
+		if err != nil || gathering.Provider != ProviderOllama || gathering.Model != "role-model" {
+			t.Errorf("gathering = %+v, %v", gathering, err)
+		}
+		builder, err := cfg.Provider(RoleBuilder)
+		if err != nil || builder.Provider != ProviderOpenAI || builder.Model != "gpt-4o" {
+			t.Errorf("builder = %+v, %v", builder, err)
+		}
+	})
+
+	t.Run("huggingFaceFallsBackToSharedSettings", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.LLM.HuggingFace = HuggingFaceConfig{ChatURL: "https://router.huggingface.co/v1", APIKey: "hf-shared"}
+		cfg.LLM.Builder = role
+		cfg.LLM.Builder.Provider = ProviderHuggingFace
+		cfg.LLM.Gathering = role
+		cfg.LLM.Gathering.Provider = ProviderHuggingFace
+		cfg.LLM.Gathering.URL = "https://endpoint.huggingface.cloud/v1"
+		cfg.LLM.Gathering.APIKey = "hf-own"
+
+		builder, err := cfg.Provider(RoleBuilder)
+		if err != nil || builder.URL != "https://router.huggingface.co/v1" || builder.APIKey != "hf-shared" {
+			t.Errorf("builder = %+v, %v", builder, err)
+		}
+		gathering, err := cfg.Provider(RoleGathering)
+		if err != nil || gathering.URL != "https://endpoint.huggingface.cloud/v1" || gathering.APIKey != "hf-own" {
+			t.Errorf("gathering = %+v, %v", gathering, err)
+		}
+	})
+
+	t.Run("unknownProvider", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.LLM.Builder = role
+		cfg.LLM.Builder.Provider = "claude"
+		cfg.LLM.Builder.URL = "http://llm"
+
+		_, err := cfg.Provider(RoleBuilder)
+		if err == nil || !strings.Contains(err.Error(), `llm.builder.provider (BUILDER_LLM_PROVIDER): unknown LLM provider "claude"`) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("missingSettings", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.LLM.PromptImprover.Temperature = -1
+
+		_, err := cfg.Provider(RolePromptImprover)
+		if err == nil {
+			t.Fatalf("Provider accepted an empty role")
+		}
+		for _, want := range []string{
+			"llm.prompt_improver.url (PROMPT_IMPROVER_LLM_URL) is not set",
+			"llm.prompt_improver.model (PROMPT_IMPROVER_LLM_MODEL) is not set",
+			"PROMPT_IMPROVER_LLM_TIMEOUT) must be a positive number of seconds",
+			"PROMPT_IMPROVER_LLM_MAX_TOKENS) must be a positive number",
+			"PROMPT_IMPROVER_LLM_TEMPERATURE) must not be negative",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error lacks %q:\n%v", want, err)
+			}
+		}
+	})
+
+	t.Run("unknownRole", func(t *testing.T) {
+		if _, err := (&Config{}).Provider("painter"); err == nil || !strings.Contains(err.Error(), `unknown LLM role "painter"`) {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestNewProvider(t *testing.T) {
+	base := ProviderConfig{Role: RoleBuilder, URL: "http://llm/v1/", Model: "model", Timeout: time.Second, MaxTokens: 100}
+
+	for _, tc := range []struct {
+		provider string
+		name     string
+	}{
+		{ProviderOllama, ProviderOllama},
+		{ProviderHuggingFace, ProviderHuggingFace},
+		{ProviderOpenAI, ProviderOpenAI},
+	} {
+		cfg := base
+		cfg.Provider = tc.provider
+		provider, err := NewProvider(cfg)
+		if err != nil {
+			t.Fatalf("NewProvider(%s) failed: %v", tc.provider, err)
+		}
+		if provider.Name() != tc.name || provider.Model() != "model" {
+			t.Errorf("NewProvider(%s) = %s/%s", tc.provider, provider.Name(), provider.Model())
+		}
+	}
+
+	if provider, _ := NewProvider(ProviderConfig{Provider: ProviderOpenAI, URL: "http://llm/v1/"}); provider.(*ChatCompletionsProvider).URL != "http://llm/v1/chat/completions" {
+		t.Errorf("chat completions URL = %q", provider.(*ChatCompletionsProvider).URL)
+	}
+
+	cfg := base
+	cfg.Provider = "claude"
+	if _, err := NewProvider(cfg); err == nil {
+		t.Errorf("NewProvider accepted an unknown provider")
+	}
+}
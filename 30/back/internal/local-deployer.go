@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// LocalDeployer copies sites into a local directory
+type LocalDeployer struct {
+	cfg LocalDeployConfig
+}
+
+func NewLocalDeployer(cfg LocalDeployConfig) *LocalDeployer {
+	return &LocalDeployer{cfg: cfg}
+}
+
+func (d *LocalDeployer) Name() string {
+	return DeployTargetLocal
+}
+
+func (d *LocalDeployer) Deploy(ctx context.Context, name string, content []byte) (DeployResult, error) {
+	target := filepath.Join(d.cfg.Dir, filepath.FromSlash(path.Clean("/"+name)))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return DeployResult{}, fmt.Errorf("failed to create deploy directory: %w", err)
+	}
+
+	// The file is replaced in one step, so the web server never serves a partial page
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".deploy-*")
+	if err != nil {
+		return DeployResult{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return DeployResult{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return DeployResult{}, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return DeployResult{}, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return DeployResult{}, err
+	}
+	return d.result(name, target)
+}
+
+// DeploySite writes the site next to name/ and swaps the directories
+func (d *LocalDeployer) DeploySite(ctx context.Context, name string, files map[string][]byte) (DeployResult, error) {
+	target := filepath.Join(d.cfg.Dir, filepath.FromSlash(path.Clean("/"+name)))
+	if err := replaceSiteFiles(target, files); err != nil {
+		return DeployResult{}, fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return d.result(path.Join(name, siteIndex), filepath.Join(target, siteIndex))
+}
+
+// result returns the public URL of the published file, its file:// URL without a base URL
+func (d *LocalDeployer) result(name, target string) (DeployResult, error) {
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return DeployResult{}, err
+	}
+	result := DeployResult{URL: publicURL(d.cfg.BaseURL, name), RemotePath: absTarget}
+	if result.URL == "" {
+		result.URL = (&url.URL{Scheme: "file", Path: filepath.ToSlash(absTarget)}).String()
+	}
+	return result, nil
+}
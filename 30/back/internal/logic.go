@@ -1,50 +1,137 @@
 package internal
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
-)
 
-// In-memory storage for dialog sessions
-var (
-	sessions      = make(map[string]*DialogSession)
-	sessionsMutex = &sync.RWMutex{}
+	"chat-web-service-backend/repo"
 )
 
-// GetOrCreateSession retrieves or creates a dialog session for a user
-func GetOrCreateSession(userID string) *DialogSession {
-	sessionsMutex.Lock()
-	defer sessionsMutex.Unlock()
+// maxSessionMessages limits how much dialog history is loaded into a session
+const maxSessionMessages = 1000
 
+// GetOrCreateSession loads the user's dialog session from the repository,
+// creating the session and its chat on the first request
+func GetOrCreateSession(ctx context.Context, repository repo.Repository, userID string) (*DialogSession, error) {
 	if userID == "" {
 		userID = "default"
 	}
 
-	session, exists := sessions[userID]
-	if !exists {
-		session = &DialogSession{
-			UserID:       userID,
-			History:      []Message{},
-			Requirements: Requirements{},
-			IsComplete:   false,
+	stored, err := repository.GetDialogSession(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		chat, chatErr := repository.CreateChat(ctx, fmt.Sprintf("Requirements dialog - %s", userID), userID)
+		if chatErr != nil {
+			return nil, fmt.Errorf("failed to create dialog chat: %w", chatErr)
 		}
-		sessions[userID] = session
+
+		stored, err = repository.CreateDialogSession(ctx, userID, chat.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dialog session: %w", err)
+	}
+
+	session := &DialogSession{
+		UserID:          stored.UserID,
+		ChatID:          stored.ChatID,
+		History:         []Message{},
+		CurrentQuestion: stored.CurrentQuestion,
+		IsComplete:      stored.IsComplete,
 	}
 
-	return session
+	if err := json.Unmarshal([]byte(stored.Requirements), &session.Requirements); err != nil {
+		return nil, fmt.Errorf("failed to decode stored requirements: %w", err)
+	}
+	if session.Requirements == nil {
+		session.Requirements = Requirements{}
+	}
+
+	// The newest messages are the ones the next turn continues from
+	messages, err := repository.GetRecentMessages(ctx, stored.ChatID, maxSessionMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dialog history: %w", err)
+	}
+	for _, msg := range messages {
+		session.History = append(session.History, Message{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+
+	return session, nil
+}
+
+// sessionLock serialises the turns of one user's dialog session
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
-// AddMessageToSession adds a message to the session history
-func AddMessageToSession(session *DialogSession, role, content string) {
-	sessionsMutex.Lock()
-	defer sessionsMutex.Unlock()
+// lockSession locks the dialog session of the user until the returned function is called.
+// Without it two requests of the same user both create the session, or one turn
+// overwrites the requirements the other has just extracted.
+func (a *App) lockSession(userID string) (unlock func()) {
+	if userID == "" {
+		userID = "default"
+	}
+
+	a.sessionsMu.Lock()
+	if a.sessions == nil {
+		a.sessions = make(map[string]*sessionLock)
+	}
+	lock, ok := a.sessions[userID]
+	if !ok {
+		lock = &sessionLock{}
+		a.sessions[userID] = lock
+	}
+	lock.refs++
+	a.sessionsMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		a.sessionsMu.Lock()
+		defer a.sessionsMu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(a.sessions, userID)
+		}
+	}
+}
+
+// AddMessageToSession stores a message in the session chat and appends it to the history
+func AddMessageToSession(ctx context.Context, repository repo.Repository, session *DialogSession, role, content string) error {
+	if _, err := repository.CreateMessage(ctx, session.ChatID, role, content); err != nil {
+		return fmt.Errorf("failed to store message: %w", err)
+	}
 
 	message := Message{
 		Role:    role,
 		Content: content,
 	}
 	session.History = append(session.History, message)
+	return nil
+}
+
+// SaveSession persists requirements, current question and completion state of the session
+func SaveSession(ctx context.Context, repository repo.Repository, session *DialogSession) error {
+	requirements, err := json.Marshal(session.Requirements)
+	if err != nil {
+		return fmt.Errorf("failed to encode requirements: %w", err)
+	}
+
+	return repository.UpdateDialogSession(ctx, &repo.DialogSession{
+		UserID:          session.UserID,
+		ChatID:          session.ChatID,
+		Requirements:    string(requirements),
+		CurrentQuestion: session.CurrentQuestion,
+		IsComplete:      session.IsComplete,
+	})
 }
 
 // GetHistoryAsString converts session history to a formatted string
@@ -62,35 +149,114 @@ func GetHistoryAsString(session *DialogSession) string {
 	return history.String()
 }
 
-// UpdateRequirementsFromResponse analyzes the LLM response and user message to extract requirements
-func UpdateRequirementsFromResponse(session *DialogSession, userMessage, llmResponse string) {
-	sessionsMutex.Lock()
-	defer sessionsMutex.Unlock()
+// RequirementsExtractor asks an LLM to extract requirement slots; LLMClient implements it
+type RequirementsExtractor interface {
+	GetLLMResponse(ctx context.Context, userInput string, systemPrompt string) (string, error)
+}
 
-	// Если это первый ответ пользователя, определяем что это ответ на вопрос о типе сайта
-	if session.Requirements.SiteType == "" && session.CurrentQuestion == "" {
-		session.Requirements.SiteType = userMessage
-		session.CurrentQuestion = "target_audience"
-		return
+// UpdateRequirementsFromResponse extracts slot values from the user's answer with the LLM,
+// validates them against the schema and persists the updated session state
+func UpdateRequirementsFromResponse(ctx context.Context, repository repo.Repository, extractor RequirementsExtractor, schema *RequirementsSchema, session *DialogSession, userMessage string) error {
+	values, err := ExtractRequirements(ctx, extractor, schema, session, userMessage)
+	if err != nil {
+		log.Printf("Requirements extraction failed, using the answer as is: %v", err)
+		values = fallbackRequirements(schema, session, userMessage)
 	}
 
-	// Если это ответ на вопрос о целевой аудитории
-	if session.Requirements.TargetAudience == "" && session.CurrentQuestion == "target_audience" {
-		session.Requirements.TargetAudience = userMessage
+	if rejected := schema.Apply(session.Requirements, values); len(rejected) > 0 {
+		log.Printf("Rejected requirement values: %s", strings.Join(rejected, "; "))
+	}
+
+	refreshSessionState(schema, session)
+	return SaveSession(ctx, repository, session)
+}
+
+// ExtractRequirements asks the LLM for the slot values mentioned in the user's answer
+func ExtractRequirements(ctx context.Context, extractor RequirementsExtractor, schema *RequirementsSchema, session *DialogSession, userMessage string) (map[string]string, error) {
+	systemPrompt := GetRequirementsExtractionPrompt(schema, session.Requirements, lastAssistantMessage(session))
+
+	response, err := extractor.GetLLMResponse(ctx, userMessage, systemPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseExtractedRequirements(response)
+}
+
+// parseExtractedRequirements reads the JSON object from the LLM answer,
+// tolerating surrounding text and non-string values
+func parseExtractedRequirements(response string) (map[string]string, error) {
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("no JSON object in extraction response: %s", response)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse extraction response: %w", err)
+	}
+
+	values := make(map[string]string)
+	for name, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			values[name] = v
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ", ")
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// fallbackRequirements treats the whole answer as the value of the slot the user was asked about
+func fallbackRequirements(schema *RequirementsSchema, session *DialogSession, userMessage string) map[string]string {
+	if slot, ok := schema.Slot(session.CurrentQuestion); ok {
+		return map[string]string{slot.Name: userMessage}
+	}
+
+	if missing := schema.MissingSlots(session.Requirements); len(missing) > 0 {
+		return map[string]string{missing[0].Name: userMessage}
+	}
+
+	// Если все вопросы заданы, но пользователь продолжает отвечать - добавляем как дополнительную информацию
+	for _, slot := range schema.Slots {
+		if slot.Multiple {
+			return map[string]string{slot.Name: userMessage}
+		}
+	}
+
+	return nil
+}
+
+// refreshSessionState points CurrentQuestion at the next missing slot and updates IsComplete
+func refreshSessionState(schema *RequirementsSchema, session *DialogSession) {
+	missing := schema.MissingSlots(session.Requirements)
+	if len(missing) == 0 {
 		session.CurrentQuestion = "complete"
 		session.IsComplete = true
 		return
 	}
 
-	// Если все вопросы заданы, но пользователь продолжает отвечать - добавляем как дополнительную информацию
-	if session.IsComplete {
-		if session.Requirements.Note == "" {
-			session.Requirements.Note = userMessage
-		} else {
-			session.Requirements.Note += "; " + userMessage
+	session.CurrentQuestion = missing[0].Name
+	session.IsComplete = false
+}
+
+// lastAssistantMessage returns the latest question asked by the assistant
+func lastAssistantMessage(session *DialogSession) string {
+	for i := len(session.History) - 1; i >= 0; i-- {
+		if session.History[i].Role == "assistant" {
+			return session.History[i].Content
 		}
 	}
+	return ""
 }
-
-// This file contains business logic functions
-// Currently empty - will be populated as needed
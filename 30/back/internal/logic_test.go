@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetOrCreateSessionHistory(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	session, err := GetOrCreateSession(ctx, repository, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	for i := 0; i < maxSessionMessages+2; i++ {
+		if err := AddMessageToSession(ctx, repository, session, "user", fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatalf("AddMessageToSession failed: %v", err)
+		}
+	}
+
+	// A long dialog continues from its newest messages, in the order they were sent
+	session, err = GetOrCreateSession(ctx, repository, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	if len(session.History) != maxSessionMessages || session.History[0].Content != "message 2" ||
+		session.History[len(session.History)-1].Content != fmt.Sprintf("message %d", maxSessionMessages+1) {
+		t.Errorf("history has %d messages from %+v to %+v", len(session.History), session.History[0], session.History[len(session.History)-1])
+	}
+}
+
+func TestLockSession(t *testing.T) {
+	app := &App{}
+
+	unlock := app.lockSession("alice")
+	locked, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		unlock := app.lockSession("alice")
+		close(locked)
+		unlock()
+		close(done)
+	}()
+
+	// Other users are not held up by alice's turn
+	app.lockSession("bob")()
+	select {
+	case <-locked:
+		t.Fatal("second turn of alice ran while the first held the session")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	<-done
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.lockSession("")()
+		}()
+	}
+	wg.Wait()
+	app.sessionsMu.Lock()
+	defer app.sessionsMu.Unlock()
+	if len(app.sessions) != 0 {
+		t.Errorf("%d session locks left after the turns", len(app.sessions))
+	}
+}
+
+func TestAskTurnStoresAnsweredMessages(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	cfg := DefaultConfig()
+	cfg.LLM.Gathering = LLMRoleConfig{URL: "http://llm.invalid", Model: "scripted-model", Timeout: 60, MaxTokens: 1000}
+	schema, err := LoadRequirementsSchema("")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	provider := &scriptedProvider{responses: []string{"{}"}}
+	app := &App{Config: cfg, Repository: repository, Guard: newTestGuard(t), Schema: schema, providers: map[string]LLMProvider{RoleGathering: provider}}
+
+	ask := func(message string) AskResponse {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		app.AskHandler(recorder, httptest.NewRequest("POST", "/ask", strings.NewReader(`{"message":"`+message+`","user_id":"alice"}`)))
+		var resp AskResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	// The answer fails after the extraction: the question is not stored without it
+	if resp := ask("кофейня"); resp.Status != "error" {
+		t.Fatalf("response = %+v, want error", resp)
+	}
+	session, err := GetOrCreateSession(context.Background(), repository, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	if len(session.History) != 0 {
+		t.Errorf("failed turn left history %+v", session.History)
+	}
+
+	provider.responses = []string{"{}", "Как называется кофейня?"}
+	if resp := ask("сайт кофейни"); resp.Status != "success" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if prompt := provider.requests[len(provider.requests)-1].System; strings.Count(prompt, "Пользователь:") != 1 {
+		t.Errorf("prompt has the failed question:\n%s", prompt)
+	}
+
+	session, err = GetOrCreateSession(context.Background(), repository, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	if len(session.History) != 2 || session.History[0] != (Message{Role: "user", Content: "сайт кофейни"}) ||
+		session.History[1] != (Message{Role: "assistant", Content: "Как называется кофейня?"}) {
+		t.Errorf("history = %+v", session.History)
+	}
+}
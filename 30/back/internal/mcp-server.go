@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"chat-web-service-backend/mcp"
+)
+
+// mcpServerInstructions is sent to MCP clients on initialize
+const mcpServerInstructions = `Сервис генерации сайтов. Типичный сценарий: ask (сбор требований, пока get_requirements не вернёт is_complete), ` +
+	`затем build и опрос get_job до статуса succeeded. Для быстрого прототипа: idea -> builder22 -> clear.`
+
+// RequirementsToolRequest is the argument of the get_requirements tool
+type RequirementsToolRequest struct {
+	UserID string `json:"user_id,omitempty" description:"Идентификатор пользователя, по умолчанию \"default\""`
+}
+
+// JobToolRequest is the argument of the get_job tool
+type JobToolRequest struct {
+	ID string `json:"id" description:"Идентификатор задачи, который вернул build"`
+}
+
+// AnalyzeProjectToolRequest is the argument of the analyze_project tool
+type AnalyzeProjectToolRequest struct{}
+
+// NewMCPServer exposes the backend operations as MCP tools. The same server
+// is served over stdio (--mcp-stdio) and streamable HTTP (/mcp). Every tool
+// call is recorded in the audit log.
+func (a *App) NewMCPServer() *mcp.Server {
+	server := mcp.NewServer(mcp.Implementation{Name: "chat-web-service-backend", Version: "1.0.0"}, mcpServerInstructions)
+
+	mcp.AddTypedTool(server, "ask", "Очередной шаг диалога сбора требований к сайту, как POST /ask", auditedTool(a.Repository, "ask", a.askTool))
+	mcp.AddTypedTool(server, "get_requirements", "Текущие требования, недостающие слоты и история диалога, как GET /requirements", auditedTool(a.Repository, "get_requirements", a.requirementsTool))
+	mcp.AddTypedTool(server, "build", "Ставит в очередь сборку сайта, как POST /build. Результат - через get_job", auditedTool(a.Repository, "build", a.buildTool))
+	mcp.AddTypedTool(server, "get_job", "Статус, этап и результат задачи сборки, как GET /jobs/{id}", auditedTool(a.Repository, "get_job", a.jobTool))
+	mcp.AddTypedTool(server, "idea", "Расширяет тип сайта до технического задания, как POST /idea", auditedTool(a.Repository, "idea", a.ideaTool))
+	mcp.AddTypedTool(server, "builder22", "Генерирует HTML по техническому заданию, как POST /builder22", auditedTool(a.Repository, "builder22", a.builder22Tool))
+	mcp.AddTypedTool(server, "clear", "Удаляет артефакты markdown из HTML, как POST /clear", auditedTool(a.Repository, "clear", clearTool))
+	mcp.AddTypedTool(server, "improve_prompt", "Улучшает промпт для генерации изображения, как POST /improve-prompt", auditedTool(a.Repository, "improve_prompt", a.improvePromptTool))
+	mcp.AddTypedTool(server, "analyze_project", "Запускает code-analyzer по проекту, как GET /analyze-project", auditedTool(a.Repository, "analyze_project", analyzeProjectTool))
+
+	return server
+}
+
+func (a *App) askTool(ctx context.Context, askReq AskRequest) (AskResponse, error) {
+	if askReq.Message == "" {
+		return AskResponse{}, fmt.Errorf("message cannot be empty")
+	}
+	askReq.UserID = resolveUserID(ctx, askReq.UserID)
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		return AskResponse{}, err
+	}
+
+	// The turn holds the session until the answer is stored
+	defer a.lockSession(askReq.UserID)()
+	session, systemPrompt, err := a.startAskTurn(ctx, llmClient, askReq)
+	if err != nil {
+		return AskResponse{}, fmt.Errorf("session error: %w", err)
+	}
+
+	llmResponse, err := llmClient.GetLLMResponse(ctx, askReq.Message, systemPrompt)
+	if err != nil {
+		return AskResponse{}, fmt.Errorf("Ошибка обработки запроса: %w", err)
+	}
+	if err := finishAskTurn(ctx, a.Repository, session, askReq.Message, llmResponse); err != nil {
+		return AskResponse{}, fmt.Errorf("Ошибка сохранения диалога: %w", err)
+	}
+
+	return AskResponse{
+		Status:  "success",
+		Message: llmResponse,
+	}, nil
+}
+
+func (a *App) requirementsTool(ctx context.Context, req RequirementsToolRequest) (RequirementsResponse, error) {
+	userID := resolveUserID(ctx, req.UserID)
+	if userID == "" {
+		userID = "default"
+	}
+
+	defer a.lockSession(userID)()
+	session, err := GetOrCreateSession(ctx, a.Repository, userID)
+	if err != nil {
+		return RequirementsResponse{}, fmt.Errorf("session error: %w", err)
+	}
+
+	return RequirementsResponse{
+		Status:       "success",
+		Requirements: session.Requirements,
+		MissingSlots: a.Schema.MissingSlots(session.Requirements),
+		IsComplete:   session.IsComplete,
+		History:      session.History,
+	}, nil
+}
+
+func (a *App) buildTool(ctx context.Context, buildReq BuildRequest) (JobResponse, error) {
+	if buildReq.Message == "" {
+		return JobResponse{}, fmt.Errorf("message cannot be empty")
+	}
+
+	userID := resolveUserID(ctx, buildReq.UserID)
+	if userID == "" {
+		userID = "mcp"
+	}
+	buildReq.UserID = userID
+
+	// The /mcp route is limited as a whole, builds count against the build quota too
+	if a.Quotas != nil {
+		if _, err := a.Quotas.Consume(ctx, a.Repository, "build"); err != nil {
+			return JobResponse{}, err
+		}
+	}
+
+	job, err := a.Jobs.Enqueue(ctx, JobTypeBuild, userID, buildReq)
+	if err != nil {
+		return JobResponse{}, err
+	}
+	setAuditTarget(ctx, "job:"+job.ID)
+	return NewJobResponse(job), nil
+}
+
+func (a *App) jobTool(ctx context.Context, req JobToolRequest) (JobResponse, error) {
+	job, err := a.Jobs.Get(ctx, req.ID)
+	if err == nil && !ownedByCaller(ctx, job.UserID) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return JobResponse{}, fmt.Errorf("job %s: %w", req.ID, err)
+	}
+	return NewJobResponse(job), nil
+}
+
+func (a *App) ideaTool(ctx context.Context, req IdeaRequest) (IdeaResponse, error) {
+	return a.ideaAction(ctx, PipelineMessageInput{Message: req.SiteType, UserID: req.UserID})
+}
+
+func (a *App) builder22Tool(ctx context.Context, req Builder22Request) (Builder22Response, error) {
+	return a.builder22Action(ctx, PipelineMessageInput{Message: req.DetailedPrompt, UserID: req.UserID})
+}
+
+func clearTool(ctx context.Context, req ClearRequest) (ClearResponse, error) {
+	return clearAction(ctx, req)
+}
+
+func (a *App) improvePromptTool(ctx context.Context, req ImprovePromptRequest) (ImprovePromptResponse, error) {
+	if req.Prompt == "" {
+		return ImprovePromptResponse{}, fmt.Errorf("prompt cannot be empty")
+	}
+
+	improvedPrompt, err := a.improvePrompt(ctx, req.Prompt)
+	if err != nil {
+		return ImprovePromptResponse{}, err
+	}
+	return ImprovePromptResponse{Prompt: improvedPrompt}, nil
+}
+
+func analyzeProjectTool(ctx context.Context, _ AnalyzeProjectToolRequest) (AnalyzeProjectResponse, error) {
+	if err := requireRole(ctx, UserRoleAdmin); err != nil {
+		return AnalyzeProjectResponse{}, err
+	}
+
+	output, err := runProjectAnalyzer(ctx)
+	if err != nil {
+		return AnalyzeProjectResponse{}, fmt.Errorf("%w\n%s", err, output)
+	}
+	return AnalyzeProjectResponse{Success: true, Output: output}, nil
+}
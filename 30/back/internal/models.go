@@ -1,7 +1,5 @@
 package internal
 
-import "net/http"
-
 type HealthResponse struct {
 	Status  string `json:"status" example:"ok"`
 	Service string `json:"service" example:"chat-web-service-backend"`
@@ -18,9 +16,12 @@ type LLMRequest struct {
 }
 
 type LLMResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-	Error    string `json:"error,omitempty"`
+	Model           string `json:"model,omitempty"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	Error           string `json:"error,omitempty"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
 }
 
 type UserRequest struct {
@@ -28,26 +29,24 @@ type UserRequest struct {
 	System  string `json:"system,omitempty"`
 }
 
+// LLMClient represents a client for the requirements gathering role
 type LLMClient struct {
-	BaseURL string
-	Model   string
-	Client  *http.Client
+	Provider LLMProvider
+	Config   ProviderConfig
 }
 
-// Requirements represents the gathered requirements for website creation
-type Requirements struct {
-	SiteType       string `json:"site_type"`
-	TargetAudience string `json:"target_audience"`
-	Note           string `json:"note"`
-}
+// Requirements represents the gathered requirements for website creation,
+// keyed by the slot names of the RequirementsSchema
+type Requirements map[string]string
 
 // DialogSession represents a user's dialog session
 type DialogSession struct {
 	UserID          string       `json:"user_id"`
+	ChatID          int64        `json:"chat_id"`
 	History         []Message    `json:"history"`
 	Requirements    Requirements `json:"requirements"`
 	IsComplete      bool         `json:"is_complete"`
-	CurrentQuestion string       `json:"current_question"` // Текущий вопрос, на который отвечает пользователь
+	CurrentQuestion string       `json:"current_question"` // Слот, о котором сейчас спрашивают пользователя
 }
 
 // Message represents a single message in the dialog
@@ -58,15 +57,15 @@ type Message struct {
 
 // WebsiteBuilderClient represents a client for website generation
 type WebsiteBuilderClient struct {
-	BaseURL string
-	Model   string
-	Client  *http.Client
+	Provider LLMProvider
+	Config   ProviderConfig
+	Progress func(stage string) // optional, called before every generation step
 }
 
+// WebsiteBuilderClient2 represents a single-step website builder client
 type WebsiteBuilderClient2 struct {
-	BaseURL string
-	Model   string
-	Client  *http.Client
+	Provider LLMProvider
+	Config   ProviderConfig
 }
 
 // WebsiteRequest represents a request for website generation
@@ -76,10 +75,10 @@ type WebsiteRequest struct {
 	Requirements Requirements `json:"requirements"`
 }
 
-// PublishRequest represents a request for publishing a file to Yandex Cloud
+// PublishRequest selects the project to publish and optionally a target other than the configured one
 type PublishRequest struct {
-	Filename string `json:"filename"`
-	UserID   string `json:"user_id"`
+	ProjectID int64  `json:"project_id"`
+	Target    string `json:"target,omitempty"` // local, sftp, ycloud-mcp or s3
 }
 
 // HuggingFaceRequest represents a request to Hugging Face Inference API
@@ -109,11 +108,13 @@ type HuggingFaceResponse []struct {
 	GeneratedText string `json:"generated_text"`
 }
 
-// HuggingFaceChatRequest represents a request to Hugging Face Chat Completions API
+// HuggingFaceChatRequest represents a request to Hugging Face (or any OpenAI-compatible) Chat Completions API
 type HuggingFaceChatRequest struct {
-	Model    string                    `json:"model"`
-	Messages []HuggingFaceChatMessage  `json:"messages"`
-	Stream   bool                      `json:"stream"`
+	Model       string                   `json:"model"`
+	Messages    []HuggingFaceChatMessage `json:"messages"`
+	Stream      bool                     `json:"stream"`
+	Temperature float64                  `json:"temperature,omitempty"`
+	MaxTokens   int                      `json:"max_tokens,omitempty"`
 }
 
 // HuggingFaceChatMessage represents a message in the chat format
@@ -148,6 +149,18 @@ type HuggingFaceChatUsage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+// HuggingFaceChatStreamChunk represents a single server-sent event of a streamed chat completion
+type HuggingFaceChatStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int                    `json:"index"`
+		Delta        HuggingFaceChatMessage `json:"delta"`
+		FinishReason string                 `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *HuggingFaceChatUsage `json:"usage,omitempty"`
+}
+
 // AnalyzeProjectResponse represents response from analyze-project endpoint
 type AnalyzeProjectResponse struct {
 	Success bool   `json:"success"`
@@ -157,8 +170,8 @@ type AnalyzeProjectResponse struct {
 
 // IdeaRequest represents request for idea expansion
 type IdeaRequest struct {
-	SiteType string `json:"site_type"`
-	UserID   string `json:"user_id,omitempty"`
+	SiteType string `json:"site_type" description:"Тип сайта, например \"лендинг\" или \"блог\""`
+	UserID   string `json:"user_id,omitempty" description:"Идентификатор пользователя"`
 }
 
 // IdeaResponse represents response with expanded idea
@@ -170,8 +183,8 @@ type IdeaResponse struct {
 
 // Builder22Request represents request for HTML generation from detailed prompt
 type Builder22Request struct {
-	DetailedPrompt string `json:"detailed_prompt"`
-	UserID         string `json:"user_id,omitempty"`
+	DetailedPrompt string `json:"detailed_prompt" description:"Техническое задание, по которому генерируется HTML"`
+	UserID         string `json:"user_id,omitempty" description:"Идентификатор пользователя"`
 }
 
 // Builder22Response represents response with generated HTML
@@ -183,8 +196,8 @@ type Builder22Response struct {
 
 // ClearRequest represents request for HTML cleaning from markdown artifacts
 type ClearRequest struct {
-	RawHTML string `json:"raw_html"`
-	UserID  string `json:"user_id,omitempty"`
+	RawHTML string `json:"raw_html" description:"HTML с артефактами markdown"`
+	UserID  string `json:"user_id,omitempty" description:"Идентификатор пользователя"`
 }
 
 // ClearResponse represents response with cleaned HTML
@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"chat-web-service-backend/repo"
+
+	"golang.org/x/net/html"
+)
+
+// Names of the GenerateSite steps stored in the trace besides the plan
+const (
+	GenerationStepLayout = "layout"
+	GenerationStepPage   = "page"
+)
+
+// siteStylesheet is the shared CSS file every page of a multi-page site links to
+const siteStylesheet = "style.css"
+
+// Limits of the sitemap of a multi-page site
+const (
+	minSitePages = 2
+	maxSitePages = 6
+)
+
+// sitePageFile is the file name of a page in the sitemap: lowercase latin letters, digits and dashes
+var sitePageFile = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*\.html$`)
+
+// SitePage is a page of the sitemap
+type SitePage struct {
+	File        string `json:"file"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Sitemap is the plan of a multi-page site
+type Sitemap struct {
+	Title string     `json:"title"`
+	Style string     `json:"style"`
+	Pages []SitePage `json:"pages"`
+}
+
+// BrokenLink is an internal link to a file that is not part of the site
+type BrokenLink struct {
+	Page string `json:"page"`
+	Href string `json:"href"`
+}
+
+// Site is a generated multi-page site: its sitemap and files, keyed by path
+type Site struct {
+	Sitemap *Sitemap
+	Files   map[string][]byte
+}
+
+// GenerateSite plans the sitemap, writes the shared stylesheet and then every page against the
+// shared layout. The header with the navigation and the footer are rendered from the sitemap,
+// so the pages only differ in their main content. It returns the trace of all steps, which is
+// also returned on failure for debugging.
+func (c *WebsiteBuilderClient) GenerateSite(ctx context.Context, userInput string, requirements Requirements) (*Site, []*repo.GenerationStep, error) {
+	var trace []*repo.GenerationStep
+
+	// Step 1: the plan is the sitemap
+	planReq := &WebsiteRequest{
+		Message: userInput,
+		System: "Ты — аналитик веб-разработки. Спланируй многостраничный сайт по запросу пользователя.\n\n" +
+			"Ответь только JSON-объектом без пояснений:\n" +
+			`{"title": "название сайта", "style": "цветовая схема и стиль", "pages": [{"file": "index.html", "title": "Главная", "description": "разделы и содержимое страницы"}]}` + "\n\n" +
+			"Правила:\n" +
+			fmt.Sprintf("- От %d до %d страниц, первая — index.html\n", minSitePages, maxSitePages) +
+			"- Имена файлов — латинские буквы в нижнем регистре, цифры и дефисы, с расширением .html\n" +
+			"- title страницы — короткое название для меню",
+		Requirements: requirements,
+	}
+
+	c.reportProgress(BuildStagePlanning)
+	planResp, err := c.tracedSend(ctx, &trace, GenerationStepPlan, planReq)
+	if err != nil {
+		return nil, trace, fmt.Errorf("failed to plan sitemap: %w", err)
+	}
+	sitemap, err := parseSitemap(planResp.Response)
+	if err != nil {
+		return nil, trace, err
+	}
+
+	// Step 2: the stylesheet shared by all pages
+	plan, _ := json.MarshalIndent(sitemap, "", "  ")
+	layoutReq := &WebsiteRequest{
+		Message: fmt.Sprintf("Исходный запрос пользователя: %s\n\nКарта сайта:\n%s", userInput, plan),
+		System: "Ты — web-дизайнер. Напиши общий файл стилей для всех страниц многостраничного сайта.\n\n" +
+			"Каждая страница устроена так:\n" + siteLayoutOutline + "\n\n" +
+			"Требования:\n" +
+			"- Опиши стили этих элементов и общие классы для содержимого страниц: .section, .card, .grid, .button\n" +
+			"- Не используй картинки, обозначай блоки цветами\n" +
+			"- Современный адаптивный дизайн по стилю из карты сайта\n" +
+			"- Возвращай только CSS, без markdown-блоков и пояснений",
+		Requirements: requirements,
+	}
+
+	c.reportProgress(BuildStageGenerating)
+	layoutResp, err := c.tracedSend(ctx, &trace, GenerationStepLayout, layoutReq)
+	if err != nil {
+		return nil, trace, fmt.Errorf("failed to generate layout: %w", err)
+	}
+	css := cleanCodeResponse(layoutResp.Response, "css")
+	if css == "" {
+		return nil, trace, fmt.Errorf("received empty layout response")
+	}
+
+	// Step 3: the main content of every page
+	files := map[string][]byte{siteStylesheet: []byte(css + "\n")}
+	var links []string
+	for _, page := range sitemap.Pages {
+		links = append(links, fmt.Sprintf("- %s — %s", page.File, page.Title))
+	}
+	for _, page := range sitemap.Pages {
+		pageReq := &WebsiteRequest{
+			Message: fmt.Sprintf("Исходный запрос пользователя: %s\n\nСтраницы сайта:\n%s\n\nСделай страницу %s «%s»: %s\n\nОбщие стили:\n%s",
+				userInput, strings.Join(links, "\n"), page.File, page.Title, page.Description, css),
+			System: "Ты — web-разработчик. Ты делаешь одну страницу многостраничного сайта. Шапка с навигацией, подвал и общие стили уже готовы.\n\n" +
+				"Правила:\n" +
+				"- Верни только содержимое элемента <main> этой страницы: разделы, заголовки, тексты\n" +
+				"- Используй классы из общих стилей, не добавляй <style>, <script>, <header>, <footer>, <html>, <head> и <body>\n" +
+				"- Ссылайся на другие страницы только по именам файлов из списка страниц\n" +
+				"- Не используй картинки\n" +
+				"- Никаких markdown-блоков и пояснений",
+			Requirements: requirements,
+		}
+
+		pageResp, err := c.tracedSend(ctx, &trace, GenerationStepPage, pageReq)
+		if err != nil {
+			return nil, trace, fmt.Errorf("failed to generate page %s: %w", page.File, err)
+		}
+		content := mainContent(cleanCodeResponse(pageResp.Response, "html"))
+		if content == "" {
+			return nil, trace, fmt.Errorf("received empty response for page %s", page.File)
+		}
+		files[page.File] = []byte(renderSitePage(sitemap, page, content))
+	}
+
+	return &Site{Sitemap: sitemap, Files: files}, trace, nil
+}
+
+// siteLayoutOutline describes the layout rendered by renderSitePage to the builder
+const siteLayoutOutline = `<header class="site-header">
+  <a class="site-title" href="index.html">Название сайта</a>
+  <nav class="site-nav"><a href="index.html" aria-current="page">Главная</a> <a href="about.html">О нас</a></nav>
+</header>
+<main class="site-main">содержимое страницы</main>
+<footer class="site-footer">© Название сайта</footer>`
+
+// renderSitePage puts the main content of the page into the shared layout
+func renderSitePage(sitemap *Sitemap, page SitePage, content string) string {
+	var nav strings.Builder
+	for _, p := range sitemap.Pages {
+		current := ""
+		if p.File == page.File {
+			current = ` aria-current="page"`
+		}
+		fmt.Fprintf(&nav, "\n      <a href=\"%s\"%s>%s</a>", p.File, current, html.EscapeString(p.Title))
+	}
+
+	title := html.EscapeString(sitemap.Title)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>%s — %s</title>
+  <link rel="stylesheet" href="%s">
+</head>
+<body>
+  <header class="site-header">
+    <a class="site-title" href="%s">%s</a>
+    <nav class="site-nav">%s
+    </nav>
+  </header>
+  <main class="site-main">
+%s
+  </main>
+  <footer class="site-footer">© %s</footer>
+</body>
+</html>
+`, html.EscapeString(page.Title), title, siteStylesheet, siteIndex, title, nav.String(), content, title)
+}
+
+// parseSitemap reads the sitemap from the plan, tolerating surrounding text. index.html
+// becomes the first page; a sitemap without it gets its first page renamed.
+func parseSitemap(response string) (*Sitemap, error) {
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("no JSON object in sitemap response: %s", response)
+	}
+
+	var sitemap Sitemap
+	if err := json.Unmarshal([]byte(response[start:end+1]), &sitemap); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap: %w", err)
+	}
+	if len(sitemap.Pages) < minSitePages || len(sitemap.Pages) > maxSitePages {
+		return nil, fmt.Errorf("sitemap has %d pages, expected %d to %d", len(sitemap.Pages), minSitePages, maxSitePages)
+	}
+	if strings.TrimSpace(sitemap.Title) == "" {
+		sitemap.Title = sitemap.Pages[0].Title
+	}
+
+	seen := make(map[string]bool)
+	index := -1
+	for i := range sitemap.Pages {
+		page := &sitemap.Pages[i]
+		page.File = strings.ToLower(strings.TrimSpace(page.File))
+		if !sitePageFile.MatchString(page.File) {
+			return nil, fmt.Errorf("invalid page file name %q in sitemap", page.File)
+		}
+		if seen[page.File] {
+			return nil, fmt.Errorf("page %s appears twice in sitemap", page.File)
+		}
+		seen[page.File] = true
+		if page.Title == "" {
+			page.Title = strings.TrimSuffix(page.File, ".html")
+		}
+		if page.File == siteIndex {
+			index = i
+		}
+	}
+
+	switch index {
+	case -1:
+		sitemap.Pages[0].File = siteIndex
+	case 0:
+	default:
+		page := sitemap.Pages[index]
+		copy(sitemap.Pages[1:index+1], sitemap.Pages[:index])
+		sitemap.Pages[0] = page
+	}
+	return &sitemap, nil
+}
+
+// cleanCodeResponse removes the markdown code block the builder may wrap its answer in
+func cleanCodeResponse(response, language string) string {
+	cleaned := strings.TrimSpace(response)
+	cleaned = strings.TrimPrefix(cleaned, "```"+language)
+	cleaned = strings.TrimPrefix(cleaned, "```")
+	cleaned = strings.TrimSuffix(cleaned, "```")
+	return strings.TrimSpace(cleaned)
+}
+
+// mainContent returns the content of the <main> element when the builder returned more than asked
+func mainContent(content string) string {
+	start := strings.Index(content, "<main")
+	end := strings.LastIndex(content, "</main>")
+	if start == -1 || end == -1 {
+		return content
+	}
+	open := strings.Index(content[start:], ">")
+	if open == -1 || start+open+1 > end {
+		return content
+	}
+	return strings.TrimSpace(content[start+open+1 : end])
+}
+
+// validateSiteLinks checks the internal links of every page of the site. Links to files
+// the site does not have lose their href, so visitors get no 404 pages, and are returned.
+// External links, fragments and mailto:/tel: links are not checked.
+func validateSiteLinks(files map[string][]byte) ([]BrokenLink, error) {
+	var broken []BrokenLink
+	for _, page := range siteFileOrder(files) {
+		if path.Ext(page) != ".html" {
+			continue
+		}
+		pageBroken, err := validatePageLinks(files, page)
+		if err != nil {
+			return nil, err
+		}
+		broken = append(broken, pageBroken...)
+	}
+	return broken, nil
+}
+
+// validatePageLinks checks the internal links of a single page of the site like validateSiteLinks
+func validatePageLinks(files map[string][]byte, page string) ([]BrokenLink, error) {
+	var broken []BrokenLink
+	root, err := html.Parse(bytes.NewReader(files[page]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", page, err)
+	}
+
+	changed := false
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") {
+			attrs := node.Attr[:0]
+			for _, attr := range node.Attr {
+				if attr.Key == "href" && !siteLinkExists(files, page, attr.Val) {
+					broken = append(broken, BrokenLink{Page: page, Href: attr.Val})
+					changed = true
+					continue
+				}
+				attrs = append(attrs, attr)
+			}
+			node.Attr = attrs
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	if changed {
+		var buf bytes.Buffer
+		if err := html.Render(&buf, root); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", page, err)
+		}
+		files[page] = buf.Bytes()
+	}
+	return broken, nil
+}
+
+// siteLinkExists reports whether the href of a page points to a file of the site or outside of it
+func siteLinkExists(files map[string][]byte, page, href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return true
+	}
+
+	target := path.Clean(u.Path)
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(path.Dir(page), target)
+	}
+	target = strings.TrimPrefix(target, "/")
+	if target == "." {
+		target = ""
+	}
+	// Links to directories, e.g. "blog/", "." or "..", open their index.html
+	base := path.Base(u.Path)
+	if target == "" || strings.HasSuffix(u.Path, "/") || base == "." || base == ".." {
+		target = path.Join(target, siteIndex)
+	}
+	_, ok := files[target]
+	return ok
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseSitemap(t *testing.T) {
+	sitemap, err := parseSitemap("Вот карта сайта:\n" + `{"title": "Кофейня", "pages": [
+		{"file": "Menu.html", "title": "Меню"},
+		{"file": "index.html", "title": "Главная"},
+		{"file": "contacts.html", "title": "Контакты"}]}`)
+	if err != nil {
+		t.Fatalf("parseSitemap failed: %v", err)
+	}
+	var files []string
+	for _, page := range sitemap.Pages {
+		files = append(files, page.File)
+	}
+	if strings.Join(files, " ") != "index.html menu.html contacts.html" {
+		t.Errorf("pages = %v", files)
+	}
+
+	// Without index.html the first page becomes the entry page
+	sitemap, err = parseSitemap(`{"pages": [{"file": "home.html", "title": "Главная"}, {"file": "about.html"}]}`)
+	if err != nil || sitemap.Pages[0].File != siteIndex || sitemap.Title != "Главная" || sitemap.Pages[1].Title != "about" {
+		t.Errorf("sitemap = %+v, %v", sitemap, err)
+	}
+
+	for _, response := range []string{
+		"нет карты",
+		`{"pages": [{"file": "index.html"}]}`,
+		`{"pages": [{"file": "index.html"}, {"file": "../admin.html"}]}`,
+		`{"pages": [{"file": "index.html"}, {"file": "menu.html"}, {"file": "MENU.html"}]}`,
+	} {
+		if _, err := parseSitemap(response); err == nil {
+			t.Errorf("parseSitemap(%s) succeeded", response)
+		}
+	}
+}
+
+func TestGenerateSite(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{
+		`{"title": "Кофейня", "style": "тёплые тона", "pages": [{"file": "index.html", "title": "Главная"}, {"file": "menu.html", "title": "Меню"}]}`,
+		"```css\nbody { color: brown; }\n```",
+		`<section class="section"><h1>Кофейня</h1><a href="menu.html">Меню</a></section>`,
+		"<html><body><main><h1>Меню</h1><a href=\"prices.html\">Цены</a></main></body></html>",
+	}}
+	client := &WebsiteBuilderClient{Provider: provider}
+
+	site, trace, err := client.GenerateSite(context.Background(), "сайт кофейни", nil)
+	if err != nil {
+		t.Fatalf("GenerateSite failed: %v", err)
+	}
+	names := []string{GenerationStepPlan, GenerationStepLayout, GenerationStepPage, GenerationStepPage}
+	if len(trace) != len(names) {
+		t.Fatalf("trace has %d steps, want %d", len(trace), len(names))
+	}
+	for i, step := range trace {
+		if step.Step != i+1 || step.Name != names[i] {
+			t.Errorf("step %d = %d %s", i, step.Step, step.Name)
+		}
+	}
+	if string(site.Files[siteStylesheet]) != "body { color: brown; }\n" {
+		t.Errorf("style.css = %q", site.Files[siteStylesheet])
+	}
+
+	// Every page has the shared layout and marks itself in the navigation
+	menu := string(site.Files["menu.html"])
+	for _, want := range []string{`<link rel="stylesheet" href="style.css">`, `<a href="index.html">Главная</a>`,
+		`<a href="menu.html" aria-current="page">Меню</a>`, "<main class=\"site-main\">\n<h1>Меню</h1>", "<title>Меню — Кофейня</title>"} {
+		if !strings.Contains(menu, want) {
+			t.Errorf("menu.html lacks %q:\n%s", want, menu)
+		}
+	}
+	if strings.Count(menu, "<body>") != 1 || !strings.Contains(provider.requests[3].Prompt, "body { color: brown; }") {
+		t.Errorf("menu.html is not a single page built against the stylesheet:\n%s", menu)
+	}
+
+	broken, err := validateSiteLinks(site.Files)
+	if err != nil || len(broken) != 1 || broken[0] != (BrokenLink{Page: "menu.html", Href: "prices.html"}) {
+		t.Fatalf("broken links = %+v, %v", broken, err)
+	}
+	if menu = string(site.Files["menu.html"]); strings.Contains(menu, "prices.html") || !strings.Contains(menu, "<a>Цены</a>") {
+		t.Errorf("broken link kept:\n%s", menu)
+	}
+}
+
+func TestValidateSiteLinks(t *testing.T) {
+	files := map[string][]byte{
+		"index.html":      []byte(`<a href="https://example.com/x.html">x</a><a href="mailto:a@b.c">m</a><a href="#top">t</a><a href="blog/">b</a><a href="missing.html">m</a>`),
+		"blog/index.html": []byte(`<link rel="stylesheet" href="../style.css"><a href="../">home</a><a href="post.html?ref=1#c">p</a><a href="/gone.css">g</a>`),
+		"blog/post.html":  []byte(`<a href=".">blog</a><a href="/index.html">home</a>`),
+		"style.css":       []byte("body {}"),
+	}
+	untouched := string(files["blog/post.html"])
+
+	broken, err := validateSiteLinks(files)
+	if err != nil {
+		t.Fatalf("validateSiteLinks failed: %v", err)
+	}
+	want := []BrokenLink{{Page: "blog/index.html", Href: "/gone.css"}, {Page: "index.html", Href: "missing.html"}}
+	if len(broken) != len(want) || broken[0] != want[0] || broken[1] != want[1] {
+		t.Errorf("broken links = %+v, want %+v", broken, want)
+	}
+	if string(files["blog/post.html"]) != untouched || !strings.Contains(string(files["index.html"]), `href="#top"`) {
+		t.Errorf("valid links changed: %s", files["index.html"])
+	}
+}
+
+func TestSiteArtifact(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	v1 := map[string][]byte{
+		"index.html": []byte(`<html><head><link rel="stylesheet" href="style.css"></head><body><a href="menu.html">Меню</a></body></html>`),
+		"menu.html":  []byte(`<html><body><h1>Меню</h1></body></html>`),
+		"style.css":  []byte("body {}"),
+	}
+	artifact := filepath.Join("result", "2025-01-01_10-00-00")
+	if err := writeSiteFiles(artifact, v1, 0644); err != nil {
+		t.Fatalf("writeSiteFiles failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(artifact, ".deploy-123"), []byte("partial"), 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "")
+	id := strconv.FormatInt(project.ID, 10)
+
+	if files, err := readSiteFiles(artifact); err != nil || len(files) != 3 || treeHash(files) != treeHash(v1) {
+		t.Fatalf("readSiteFiles = %d files, %v", len(files), err)
+	}
+	if order := siteFileOrder(v1); strings.Join(order, " ") != "menu.html style.css index.html" {
+		t.Errorf("upload order = %v", order)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir("www")))
+	defer server.Close()
+	cfg := DefaultConfig()
+	cfg.Deploy.Local = LocalDeployConfig{Dir: "www", BaseURL: server.URL}
+	cfg.Deploy.Verify.Attempts = 1
+	cfg.Deploy.Verify.Interval = time.Millisecond
+	app := &App{Config: cfg, Repository: repository}
+	app.Jobs = NewJobQueue(repository, 1)
+	app.Jobs.Handle(JobTypeVerify, app.runVerifyJob)
+	if err := app.Jobs.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer app.Jobs.Stop()
+
+	call := func(handler http.HandlerFunc, method, target, body string) (int, []byte) {
+		req := mux.SetURLVars(httptest.NewRequest(method, target, strings.NewReader(body)), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+
+	// The site is published as a unit under its name, verified by its index.html
+	code, body := call(app.PublishHandler, http.MethodPost, "/publish", `{"project_id": `+id+`}`)
+	var response PublishResponse
+	json.Unmarshal(body, &response)
+	if code != http.StatusOK || response.VerifyJob == "" || response.Deployment.Revision != treeHash(v1) ||
+		response.Deployment.URL != server.URL+"/2025-01-01_10-00-00/index.html" {
+		t.Fatalf("publish = %d %s", code, body)
+	}
+	job := waitForJob(t, app.Jobs, response.VerifyJob, repo.JobStatusSucceeded)
+	var deployment repo.Deployment
+	if json.Unmarshal([]byte(job.Result), &deployment); deployment.Status != repo.DeploymentStatusVerified {
+		t.Fatalf("verification = %s", job.Result)
+	}
+	for name, content := range v1 {
+		if data, _ := os.ReadFile(filepath.Join("www", "2025-01-01_10-00-00", name)); string(data) != string(content) {
+			t.Errorf("published %s = %q", name, data)
+		}
+	}
+
+	// The revision is a read-only copy of the directory, so the artifact may change afterwards
+	revisions, _ := repository.GetProjectRevisions(ctx, project.ID)
+	if len(revisions) != 1 || revisions[0].Hash != treeHash(v1) || revisions[0].FilePath != filepath.Join(revisionsDir, treeHash(v1)) {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+	os.WriteFile(filepath.Join(artifact, "menu.html"), []byte(`<html><body><h1>Новое меню</h1></body></html>`), 0644)
+	if files, err := readSiteFiles(revisions[0].FilePath); err != nil || treeHash(files) != treeHash(v1) {
+		t.Errorf("revision changed with the artifact: %v", err)
+	}
+
+	code, body = call(app.PublishHandler, http.MethodPost, "/publish", `{"project_id": `+id+`}`)
+	if code != http.StatusOK {
+		t.Fatalf("second publish = %d %s", code, body)
+	}
+	code, body = call(app.RollbackHandler, http.MethodPost, "/projects/"+id+"/rollback?to="+treeHash(v1)[:10], "")
+	if data, _ := os.ReadFile(filepath.Join("www", "2025-01-01_10-00-00", "menu.html")); code != http.StatusOK || string(data) != string(v1["menu.html"]) {
+		t.Errorf("rollback = %d %s, menu.html %q", code, body, data)
+	}
+
+	for _, page := range []string{"missing.html", "style.css"} {
+		if code, body = call(app.ReviseHandler, http.MethodPost, "/projects/"+id+"/revise", `{"instruction": "сделай шапку зелёной", "page": "`+page+`"}`); code != http.StatusBadRequest {
+			t.Errorf("revise of %s = %d %s", page, code, body)
+		}
+	}
+}
+
+func TestReviseSitePage(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	v1 := map[string][]byte{
+		"index.html": []byte(`<html><body><a href="menu.html">Меню</a></body></html>`),
+		"menu.html":  []byte(`<html><body><h1>Меню</h1></body></html>`),
+		"style.css":  []byte("body {}"),
+	}
+	artifact := filepath.Join("result", "2025-01-01_10-00-00")
+	writeSiteFiles(artifact, v1, 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "")
+	id := strconv.FormatInt(project.ID, 10)
+
+	provider := &scriptedProvider{responses: []string{`<html><body><h1 style="color: green">Меню</h1><a href="prices.html">Цены</a></body></html>`}}
+	cfg := DefaultConfig()
+	cfg.LLM.Builder = LLMRoleConfig{URL: "http://llm.invalid", Model: "scripted-model", Timeout: 60, MaxTokens: 4096}
+	app := &App{Config: cfg, Repository: repository, Guard: newTestGuard(t), providers: map[string]LLMProvider{RoleBuilder: provider}}
+	app.Jobs = NewJobQueue(repository, 1)
+	app.Jobs.Handle(JobTypeRevise, app.runReviseJob)
+	if err := app.Jobs.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer app.Jobs.Stop()
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/projects/"+id+"/revise",
+		strings.NewReader(`{"instruction": "сделай заголовок зелёным", "page": "menu.html"}`)), map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	app.ReviseHandler(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("revise = %d %s", rec.Code, rec.Body.String())
+	}
+	var queued JobResponse
+	json.Unmarshal(rec.Body.Bytes(), &queued)
+	job := waitForJob(t, app.Jobs, queued.ID, repo.JobStatusSucceeded)
+	var response ReviseResponse
+	json.Unmarshal([]byte(job.Result), &response)
+
+	// Only the page is sent to the builder and changed, the revision is the whole site
+	if len(provider.requests) != 1 || !strings.Contains(provider.requests[0].Prompt, "<h1>Меню</h1>") || strings.Contains(provider.requests[0].Prompt, "body {}") {
+		t.Errorf("builder prompt = %+v", provider.requests)
+	}
+	files, err := readSiteFiles(artifact)
+	if err != nil || len(files) != 3 || string(files["index.html"]) != string(v1["index.html"]) || string(files["style.css"]) != "body {}" {
+		t.Fatalf("site after revise = %d files, %v", len(files), err)
+	}
+	if menu := string(files["menu.html"]); !strings.Contains(menu, "color: green") || strings.Contains(menu, "prices.html") {
+		t.Errorf("menu.html = %s", menu)
+	}
+	if response.Page != "menu.html" || len(response.BrokenLinks) != 1 || response.BrokenLinks[0].Href != "prices.html" {
+		t.Errorf("response = %+v", response)
+	}
+	if response.Revision == nil || response.Revision.Hash != treeHash(files) || response.Revision.Parent != treeHash(v1) {
+		t.Errorf("revision = %+v", response.Revision)
+	}
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaProvider talks to the Ollama /api/generate and /api/chat endpoints
+type OllamaProvider struct {
+	BaseURL string
+	model   string
+	Client  *http.Client
+	// StreamClient is used for streams; it shares the connection pool of Client
+	StreamClient *http.Client
+}
+
+// OllamaChatRequest represents a request to the Ollama /api/chat endpoint
+type OllamaChatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+	Stream      bool      `json:"stream"`
+}
+
+// OllamaChatResponse represents a response (or a stream chunk) from /api/chat
+type OllamaChatResponse struct {
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	Error           string  `json:"error,omitempty"`
+	PromptEvalCount int     `json:"prompt_eval_count,omitempty"`
+	EvalCount       int     `json:"eval_count,omitempty"`
+}
+
+// ollamaStreamChunk is a single line of a generate or chat stream
+type ollamaStreamChunk struct {
+	Model           string  `json:"model"`
+	Response        string  `json:"response"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	Error           string  `json:"error,omitempty"`
+	PromptEvalCount int     `json:"prompt_eval_count,omitempty"`
+	EvalCount       int     `json:"eval_count,omitempty"`
+}
+
+func NewOllamaProvider(cfg ProviderConfig) *OllamaProvider {
+	client, streamClient := newProviderClients(http.DefaultTransport.(*http.Transport).Clone(), cfg.Timeout)
+
+	return &OllamaProvider{
+		BaseURL:      strings.TrimSuffix(cfg.URL, "/"),
+		model:        cfg.Model,
+		Client:       client,
+		StreamClient: streamClient,
+	}
+}
+
+func (p *OllamaProvider) Name() string {
+	return ProviderOllama
+}
+
+func (p *OllamaProvider) Model() string {
+	return p.model
+}
+
+func (p *OllamaProvider) Generate(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	resp, err := p.post(ctx, "/api/generate", p.generateRequest(req, false), false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var llmResp LLMResponse
+	if err := json.NewDecoder(resp.Body).Decode(&llmResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if llmResp.Error != "" {
+		return nil, fmt.Errorf("LLM API error: %s", llmResp.Error)
+	}
+
+	return &llmResp, nil
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	resp, err := p.post(ctx, "/api/chat", p.chatRequest(req, false), false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp OllamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("LLM API error: %s", chatResp.Error)
+	}
+
+	return &LLMResponse{
+		Model:           chatResp.Model,
+		Response:        chatResp.Message.Content,
+		Done:            chatResp.Done,
+		PromptEvalCount: chatResp.PromptEvalCount,
+		EvalCount:       chatResp.EvalCount,
+	}, nil
+}
+
+// Stream reads Ollama's line-delimited JSON stream. Requests with messages go
+// to /api/chat, single prompts go to /api/generate.
+func (p *OllamaProvider) Stream(ctx context.Context, req ProviderRequest, onToken func(token string) error) (*LLMResponse, error) {
+	var payload interface{}
+	path := "/api/generate"
+	if len(req.Messages) > 0 {
+		payload = p.chatRequest(req, true)
+		path = "/api/chat"
+	} else {
+		payload = p.generateRequest(req, true)
+	}
+
+	resp, err := p.post(ctx, path, payload, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &LLMResponse{Model: p.model}
+	var text strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaStreamChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		// /api/generate sends the token in "response", /api/chat in "message"
+		token := chunk.Response + chunk.Message.Content
+
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("LLM API error: %s", chunk.Error)
+		}
+
+		if token != "" {
+			text.WriteString(token)
+			if onToken != nil {
+				if err := onToken(token); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if chunk.Done {
+			result.Done = true
+			result.PromptEvalCount = chunk.PromptEvalCount
+			result.EvalCount = chunk.EvalCount
+			if chunk.Model != "" {
+				result.Model = chunk.Model
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+	if !result.Done {
+		return nil, fmt.Errorf("LLM stream ended before the final chunk")
+	}
+	drainStream(resp.Body)
+
+	result.Response = text.String()
+	return result, nil
+}
+
+func (p *OllamaProvider) generateRequest(req ProviderRequest, stream bool) LLMRequest {
+	return LLMRequest{
+		Model:       p.model,
+		System:      req.System,
+		Prompt:      req.Prompt,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Stream:      stream,
+	}
+}
+
+func (p *OllamaProvider) chatRequest(req ProviderRequest, stream bool) OllamaChatRequest {
+	return OllamaChatRequest{
+		Model:       p.model,
+		Messages:    requestMessages(req),
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Stream:      stream,
+	}
+}
+
+// post sends a JSON payload and returns the response if its status is 200
+func (p *OllamaProvider) post(ctx context.Context, path string, payload interface{}, stream bool) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if stream {
+		client = p.StreamClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to LLM: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("LLM API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestOllamaProvider(serverURL string) *OllamaProvider {
+	return NewOllamaProvider(ProviderConfig{Provider: ProviderOllama, URL: serverURL + "/", Model: "llama3", Timeout: 5 * time.Second})
+}
+
+func TestOllamaProvider(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/api/generate" {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+			var req LLMRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+			}
+			if req.Model != "llama3" || req.System != "system" || req.Prompt != "hello" || req.Temperature != 0.3 || req.MaxTokens != 200 || req.Stream {
+				t.Errorf("request = %+v", req)
+			}
+
+			json.NewEncoder(w).Encode(LLMResponse{Model: "llama3", Response: "hi", Done: true, PromptEvalCount: 4, EvalCount: 2})
+		}))
+		defer server.Close()
+
+		resp, err := newTestOllamaProvider(server.URL).Generate(context.Background(), ProviderRequest{System: "system", Prompt: "hello", Temperature: 0.3, MaxTokens: 200})
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if resp.Response != "hi" || !resp.Done || resp.PromptEvalCount != 4 || resp.EvalCount != 2 {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/chat" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			var req OllamaChatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+			}
+			want := []Message{{Role: "system", Content: "system"}, {Role: "user", Content: "hello"}, {Role: "assistant", Content: "hi"}, {Role: "user", Content: "bye"}}
+			if req.Model != "llama3" || len(req.Messages) != len(want) {
+				t.Fatalf("request = %+v", req)
+			}
+			for i := range want {
+				if req.Messages[i] != want[i] {
+					t.Errorf("message %d = %+v, want %+v", i, req.Messages[i], want[i])
+				}
+			}
+
+			json.NewEncoder(w).Encode(OllamaChatResponse{Model: "llama3", Message: Message{Role: "assistant", Content: "bye"}, Done: true, EvalCount: 1})
+		}))
+		defer server.Close()
+
+		resp, err := newTestOllamaProvider(server.URL).Chat(context.Background(), ProviderRequest{
+			System:   "system",
+			Messages: []Message{{Role: "user", Content: "hello"}, {Role: "assistant", Content: "hi"}, {Role: "user", Content: "bye"}},
+		})
+		if err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		if resp.Response != "bye" || resp.Model != "llama3" || !resp.Done || resp.EvalCount != 1 {
+			t.Errorf("response = %+v", resp)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"model not found"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(LLMResponse{Error: "out of memory"})
+		}))
+		defer server.Close()
+
+		provider := newTestOllamaProvider(server.URL)
+		if _, err := provider.Generate(context.Background(), ProviderRequest{Prompt: "hello"}); err == nil || !strings.Contains(err.Error(), "LLM API error: out of memory") {
+			t.Errorf("Generate err = %v", err)
+		}
+		if _, err := provider.Chat(context.Background(), ProviderRequest{Prompt: "hello"}); err == nil || !strings.Contains(err.Error(), "status 404") {
+			t.Errorf("Chat err = %v", err)
+		}
+	})
+}
+
+// writeStream writes the body in pieces split at the given offsets, flushing after each,
+// so the client receives lines cut in the middle
+func writeStream(w http.ResponseWriter, body string, splits ...int) {
+	flusher := w.(http.Flusher)
+	start := 0
+	for _, split := range append(splits, len(body)) {
+		w.Write([]byte(body[start:split]))
+		flusher.Flush()
+		time.Sleep(5 * time.Millisecond)
+		start = split
+	}
+}
+
+// newStreamServer serves handler and counts the client connections
+func newStreamServer(handler http.HandlerFunc) (*httptest.Server, *atomic.Int64) {
+	connections := &atomic.Int64{}
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	return server, connections
+}
+
+func TestOllamaProviderStream(t *testing.T) {
+	const body = `{"model":"llama3","response":"При","done":false}
+{"model":"llama3","response":"вет","done":false}
+
+{"model":"llama3","response":"!","done":true,"prompt_eval_count":5,"eval_count":3}
+{"model":"llama3","response":"after done","done":false}
+`
+
+	t.Run("tokensSplitAcrossReads", func(t *testing.T) {
+		server, connections := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			var req LLMRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.URL.Path != "/api/generate" || !req.Stream || req.Prompt != "hello" {
+				t.Errorf("unexpected request %s %+v", r.URL.Path, req)
+			}
+			// Cut inside a JSON object and inside a multi-byte rune
+			writeStream(w, body, 20, 36, len(`{"model":"llama3","response":"При","done":false}`)+25)
+		})
+		defer server.Close()
+
+		provider := newTestOllamaProvider(server.URL)
+		for i := 0; i < 2; i++ {
+			var tokens []string
+			resp, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, func(token string) error {
+				tokens = append(tokens, token)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			if strings.Join(tokens, "|") != "При|вет|!" {
+				t.Errorf("tokens = %q", tokens)
+			}
+			if resp.Response != "Привет!" || !resp.Done || resp.PromptEvalCount != 5 || resp.EvalCount != 3 {
+				t.Errorf("response = %+v", resp)
+			}
+		}
+
+		// The streaming client is built once, so both streams share a connection
+		if n := connections.Load(); n != 1 {
+			t.Errorf("streams opened %d connections, want 1", n)
+		}
+	})
+
+	t.Run("chatMessages", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			var req OllamaChatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.URL.Path != "/api/chat" || !req.Stream || len(req.Messages) != 1 {
+				t.Errorf("unexpected request %s %+v", r.URL.Path, req)
+			}
+			writeStream(w, `{"message":{"role":"assistant","content":"hi"},"done":false}`+"\n"+`{"message":{"role":"assistant","content":""},"done":true}`+"\n")
+		})
+		defer server.Close()
+
+		resp, err := newTestOllamaProvider(server.URL).Stream(context.Background(), ProviderRequest{Messages: []Message{{Role: "user", Content: "hello"}}}, nil)
+		if err != nil || resp.Response != "hi" || resp.Model != "llama3" {
+			t.Errorf("Stream = %+v, %v", resp, err)
+		}
+	})
+
+	t.Run("malformedLine", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, `{"response":"a","done":false}`+"\n"+`{"response":`+"\n")
+		})
+		defer server.Close()
+
+		_, err := newTestOllamaProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "failed to unmarshal stream chunk") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("errorChunk", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, `{"error":"model is loading"}`+"\n")
+		})
+		defer server.Close()
+
+		_, err := newTestOllamaProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "LLM API error: model is loading") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("closedMidStream", func(t *testing.T) {
+		var abort atomic.Bool
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			if abort.Load() {
+				writeStream(w, `{"response":"a","done":false}`+"\n")
+				panic(http.ErrAbortHandler)
+			}
+			writeStream(w, `{"response":"a","done":false}`+"\n"+`{"response":"b",`)
+		})
+		defer server.Close()
+
+		// The response ends cleanly in the middle of a line
+		provider := newTestOllamaProvider(server.URL)
+		if _, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil); err == nil || !strings.Contains(err.Error(), "failed to unmarshal stream chunk") {
+			t.Errorf("err = %v", err)
+		}
+
+		// The connection is dropped
+		abort.Store(true)
+		if _, err := provider.Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil); err == nil || !strings.Contains(err.Error(), "failed to read stream") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("endedWithoutDone", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, `{"response":"a","done":false}`+"\n")
+		})
+		defer server.Close()
+
+		if _, err := newTestOllamaProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, nil); err == nil || !strings.Contains(err.Error(), "ended before the final chunk") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("onTokenError", func(t *testing.T) {
+		server, _ := newStreamServer(func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, body)
+		})
+		defer server.Close()
+
+		stop := errors.New("client went away")
+		_, err := newTestOllamaProvider(server.URL).Stream(context.Background(), ProviderRequest{Prompt: "hello"}, func(string) error { return stop })
+		if !errors.Is(err, stop) {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
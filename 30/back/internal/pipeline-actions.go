@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+// PipelineMessageInput is the input of the LLM stages, same as the /idea and /builder22 request
+type PipelineMessageInput struct {
+	Message string `json:"message"`
+	UserID  string `json:"user_id,omitempty"`
+}
+
+// PublishStageInput is the input of the publish stage
+type PublishStageInput struct {
+	HTML     string `json:"html"`
+	Filename string `json:"filename,omitempty"` // generated from the current time when empty
+}
+
+// PublishStageOutput is the output of the publish stage
+type PublishStageOutput struct {
+	File         string `json:"file"`
+	ProjectID    int64  `json:"project_id"`
+	DeploymentID int64  `json:"deployment_id"`
+	URL          string `json:"url,omitempty"`
+	RemotePath   string `json:"remote_path"`
+	VerifyJob    string `json:"verify_job,omitempty"` // the job checking the URL, see PublishResponse
+}
+
+// pipelineActions are the actions available to pipeline stages
+func (a *App) pipelineActions() map[string]PipelineAction {
+	return map[string]PipelineAction{
+		"idea":      TypedAction[PipelineMessageInput, IdeaResponse](a.ideaAction),
+		"builder22": TypedAction[PipelineMessageInput, Builder22Response](a.builder22Action),
+		"clear":     TypedAction[ClearRequest, ClearResponse](clearAction),
+		"publish":   TypedAction[PublishStageInput, PublishStageOutput](a.publishAction),
+	}
+}
+
+// ideaAction expands the site idea into a specification, like /idea
+func (a *App) ideaAction(ctx context.Context, input PipelineMessageInput) (IdeaResponse, error) {
+	if input.Message == "" {
+		return IdeaResponse{}, fmt.Errorf("message cannot be empty")
+	}
+	if _, err := a.screenInput(ctx, "message", input.Message); err != nil {
+		return IdeaResponse{}, err
+	}
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		return IdeaResponse{}, err
+	}
+	llmResponse, err := llmClient.GetLLMResponse(ctx, input.Message, ideaSystemPrompt)
+	if err != nil {
+		return IdeaResponse{}, err
+	}
+
+	return IdeaResponse{
+		Status:         "success",
+		ExpandedPrompt: llmResponse,
+	}, nil
+}
+
+// builder22Action generates HTML from the specification, like /builder22
+func (a *App) builder22Action(ctx context.Context, input PipelineMessageInput) (Builder22Response, error) {
+	if input.Message == "" {
+		return Builder22Response{}, fmt.Errorf("message cannot be empty")
+	}
+	if _, err := a.screenInput(ctx, "message", input.Message); err != nil {
+		return Builder22Response{}, err
+	}
+
+	llmClient, err := a.NewLLMClient()
+	if err != nil {
+		return Builder22Response{}, err
+	}
+	llmResponse, err := llmClient.GetLLMResponse(ctx, input.Message, builder22SystemPrompt)
+	if err != nil {
+		return Builder22Response{}, err
+	}
+
+	cleanHTML, _, err := a.sanitizeHTML(cleanHTMLResponse(llmResponse))
+	if err != nil {
+		return Builder22Response{}, err
+	}
+
+	return Builder22Response{
+		Status: "success",
+		HTML:   cleanHTML,
+	}, nil
+}
+
+// clearAction removes markdown artifacts from the HTML, like /clear
+func clearAction(_ context.Context, input ClearRequest) (ClearResponse, error) {
+	if input.RawHTML == "" {
+		return ClearResponse{}, fmt.Errorf("raw_html cannot be empty")
+	}
+
+	return ClearResponse{
+		Status:    "success",
+		CleanHTML: cleanMarkdownArtifacts(input.RawHTML),
+	}, nil
+}
+
+// publishAction sanitises the HTML, saves it as a new project of the run's owner and deploys it
+// to the configured target like /publish, so the deployment can be listed and rolled back
+func (a *App) publishAction(ctx context.Context, input PublishStageInput) (PublishStageOutput, error) {
+	if input.HTML == "" {
+		return PublishStageOutput{}, fmt.Errorf("html cannot be empty")
+	}
+
+	// Earlier stages may pass HTML that did not come from builder22
+	sanitized, _, err := a.sanitizeHTML(input.HTML)
+	if err != nil {
+		return PublishStageOutput{}, err
+	}
+
+	filename := input.Filename
+	if filename == "" {
+		filename = time.Now().Format("2006-01-02_15-04-05") + ".html"
+	}
+	filename = filepath.Base(filename)
+	filePath := filepath.Join("result", filename)
+
+	if err := os.MkdirAll("result", 0755); err != nil {
+		return PublishStageOutput{}, fmt.Errorf("failed to create result directory: %w", err)
+	}
+	if err := os.WriteFile(filePath, []byte(sanitized), 0644); err != nil {
+		return PublishStageOutput{}, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	userID := subjectUserID(ctx)
+	chat, err := a.Repository.CreateChat(ctx, fmt.Sprintf("Pipeline Website - %s", filename), userID)
+	if err != nil {
+		return PublishStageOutput{}, fmt.Errorf("failed to create chat: %w", err)
+	}
+	project, err := a.Repository.CreateProject(ctx, chat.ID, fmt.Sprintf("Pipeline_%s", strings.TrimSuffix(filename, filepath.Ext(filename))), "Published by a pipeline", filePath, userID)
+	if err != nil {
+		return PublishStageOutput{}, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	rev, err := saveRevision(ctx, a.Repository, project.ID, filePath, repo.RevisionSourcePublish)
+	if err != nil {
+		return PublishStageOutput{}, fmt.Errorf("failed to save revision: %w", err)
+	}
+
+	response, err := a.publishRevision(ctx, project, rev, "")
+	if err != nil {
+		return PublishStageOutput{}, err
+	}
+	if !response.Success {
+		return PublishStageOutput{}, fmt.Errorf("deployment %d of project %d failed: %s", response.Deployment.ID, project.ID, response.Error)
+	}
+
+	return PublishStageOutput{
+		File:         filename,
+		ProjectID:    project.ID,
+		DeploymentID: response.Deployment.ID,
+		URL:          response.Deployment.URL,
+		RemotePath:   response.Deployment.RemotePath,
+		VerifyJob:    response.VerifyJob,
+	}, nil
+}
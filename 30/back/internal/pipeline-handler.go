@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// PipelineJobPayload is the payload of a pipeline job
+type PipelineJobPayload struct {
+	RunID string `json:"run_id"`
+}
+
+// PipelineArtifactResponse is the public representation of a stage attempt
+type PipelineArtifactResponse struct {
+	Stage      string          `json:"stage"`
+	Attempt    int             `json:"attempt"`
+	Status     string          `json:"status"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// PipelineRunResponse is the public representation of a pipeline run
+type PipelineRunResponse struct {
+	ID         string                     `json:"id"`
+	Pipeline   string                     `json:"pipeline"`
+	JobID      string                     `json:"job_id,omitempty"`
+	Status     string                     `json:"status"`
+	Input      json.RawMessage            `json:"input,omitempty"`
+	Output     json.RawMessage            `json:"output,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+	Artifacts  []PipelineArtifactResponse `json:"artifacts"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	UpdatedAt  time.Time                  `json:"updated_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+// NewPipelineRunResponse converts a stored run and its artifacts to the API response
+func NewPipelineRunResponse(run *repo.PipelineRun, artifacts []*repo.PipelineArtifact) PipelineRunResponse {
+	response := PipelineRunResponse{
+		ID:         run.ID,
+		Pipeline:   run.Pipeline,
+		JobID:      run.JobID,
+		Status:     run.Status,
+		Input:      rawJSON(run.Input),
+		Output:     rawJSON(run.Output),
+		Error:      run.Error,
+		Artifacts:  []PipelineArtifactResponse{},
+		CreatedAt:  run.CreatedAt,
+		UpdatedAt:  run.UpdatedAt,
+		FinishedAt: run.FinishedAt,
+	}
+
+	for _, artifact := range artifacts {
+		response.Artifacts = append(response.Artifacts, PipelineArtifactResponse{
+			Stage:      artifact.Stage,
+			Attempt:    artifact.Attempt,
+			Status:     artifact.Status,
+			Input:      rawJSON(artifact.Input),
+			Output:     rawJSON(artifact.Output),
+			Error:      artifact.Error,
+			StartedAt:  artifact.StartedAt,
+			FinishedAt: artifact.FinishedAt,
+		})
+	}
+
+	return response
+}
+
+// rawJSON embeds stored JSON into a response, skipping empty values
+func rawJSON(value string) json.RawMessage {
+	if value == "" {
+		return nil
+	}
+	return json.RawMessage(value)
+}
+
+// ListPipelinesHandler returns the declared pipelines
+func (a *App) ListPipelinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	config, err := a.LoadPipelines()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Pipeline configuration error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(config)
+}
+
+// CreatePipelineRunHandler validates the run input and queues the pipeline.
+// The request body is the run input object, e.g. {"message": "лендинг"}.
+func (a *App) CreatePipelineRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	config, err := a.LoadPipelines()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Pipeline configuration error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	pipeline, ok := config.Pipeline(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Pipeline %s not found", name), http.StatusNotFound)
+		return
+	}
+
+	// Publishing through a pipeline needs the same role as POST /publish
+	if pipeline.UsesAction("publish") {
+		if err := requireRole(r.Context(), UserRolePublisher); err != nil {
+			http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+			return
+		}
+	}
+
+	var input map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	var missing []string
+	for _, field := range pipeline.RequiredInputs() {
+		if _, ok := input[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		http.Error(w, fmt.Sprintf("Missing input fields: %s", strings.Join(missing, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	if raw, ok := input["user_id"]; ok {
+		json.Unmarshal(raw, &userID)
+	}
+	// The authenticated user replaces the client-supplied user_id, also for the stages
+	userID = resolveUserID(r.Context(), userID)
+	if userID != "" {
+		input["user_id"], _ = json.Marshal(userID)
+	}
+
+	inputJSON, _ := json.Marshal(input)
+	run := &repo.PipelineRun{
+		ID:       newJobID(),
+		Pipeline: pipeline.Name,
+		UserID:   userID,
+		Status:   repo.JobStatusQueued,
+		Input:    string(inputJSON),
+	}
+
+	ctx := r.Context()
+	if err := a.Repository.CreatePipelineRun(ctx, run); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store pipeline run: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := a.Jobs.Enqueue(ctx, JobTypePipeline, userID, PipelineJobPayload{RunID: run.ID})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrJobQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		NewPipelineEngine(a.Repository, config).finishRun(run, err)
+		http.Error(w, fmt.Sprintf("Failed to queue pipeline: %v", err), status)
+		return
+	}
+	// job_id сохраняет воркер при запуске, здесь он нужен только для ответа
+	run.JobID = job.ID
+
+	log.Printf("Queued pipeline %s run %s (job %s)", pipeline.Name, run.ID, job.ID)
+
+	setAuditTarget(ctx, "pipeline_run:"+run.ID)
+	w.Header().Set("Location", fmt.Sprintf("/pipelines/%s/runs/%s", pipeline.Name, run.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(NewPipelineRunResponse(run, nil))
+}
+
+// GetPipelineRunHandler returns the run with all recorded stage artifacts
+func (a *App) GetPipelineRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	vars := mux.Vars(r)
+	ctx := r.Context()
+
+	run, err := a.Repository.GetPipelineRun(ctx, vars["id"])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (run.Pipeline != vars["name"] || !ownedByCaller(ctx, run.UserID))) {
+		http.Error(w, "Pipeline run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get pipeline run: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	artifacts, err := a.Repository.GetPipelineArtifacts(ctx, run.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get pipeline artifacts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(NewPipelineRunResponse(run, artifacts))
+}
+
+// runPipelineJob executes a queued pipeline run
+func (a *App) runPipelineJob(ctx context.Context, repository repo.Repository, job *repo.Job, progress func(stage string)) (interface{}, error) {
+	var payload PipelineJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("invalid pipeline payload: %w", err)
+	}
+
+	run, err := repository.GetPipelineRun(ctx, payload.RunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pipeline run %s: %w", payload.RunID, err)
+	}
+	run.JobID = job.ID
+
+	config, err := a.LoadPipelines()
+	if err != nil {
+		return nil, NewPipelineEngine(repository, nil).finishRun(run, err)
+	}
+
+	return NewPipelineEngine(repository, config).Execute(ctx, run, progress)
+}
@@ -0,0 +1,428 @@
+package internal
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+//go:embed pipelines.json
+var defaultPipelines []byte
+
+// pipelineInputSource is the mapping prefix that refers to the run input
+const pipelineInputSource = "input"
+
+// defaultStageTimeout is used by stages without an explicit timeout
+const defaultStageTimeout = 5 * time.Minute
+
+// Artifact statuses of a single stage attempt
+const (
+	ArtifactStatusRunning   = "running"
+	ArtifactStatusSucceeded = "succeeded"
+	ArtifactStatusFailed    = "failed"
+)
+
+// PipelineAction is a typed pipeline step. Stage inputs and outputs are JSON objects
+// whose fields are described by InputType and OutputType.
+type PipelineAction interface {
+	InputType() reflect.Type
+	OutputType() reflect.Type
+	Run(ctx context.Context, input json.RawMessage) (json.RawMessage, error)
+}
+
+// TypedAction adapts a function with typed input and output to PipelineAction
+type TypedAction[In, Out any] func(ctx context.Context, input In) (Out, error)
+
+// InputType returns the input struct type
+func (a TypedAction[In, Out]) InputType() reflect.Type {
+	return reflect.TypeFor[In]()
+}
+
+// OutputType returns the output struct type
+func (a TypedAction[In, Out]) OutputType() reflect.Type {
+	return reflect.TypeFor[Out]()
+}
+
+// Run decodes the input, calls the function and encodes its output
+func (a TypedAction[In, Out]) Run(ctx context.Context, input json.RawMessage) (json.RawMessage, error) {
+	var in In
+	if err := json.Unmarshal(input, &in); err != nil {
+		return nil, fmt.Errorf("invalid stage input: %w", err)
+	}
+
+	out, err := a(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(out)
+}
+
+// PipelineStage declares one step of a pipeline
+type PipelineStage struct {
+	Name    string            `json:"name"`
+	Action  string            `json:"action"`
+	Input   map[string]string `json:"input"`             // action input field -> "input.<field>" or "<stage>.<field>"
+	Retries int               `json:"retries,omitempty"` // additional attempts after a failure
+	Timeout string            `json:"timeout,omitempty"` // per attempt, e.g. "90s"
+
+	action  PipelineAction
+	timeout time.Duration
+}
+
+// PipelineDefinition is a named chain of stages
+type PipelineDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Stages      []PipelineStage `json:"stages"`
+}
+
+// PipelinesConfig holds all declared pipelines
+type PipelinesConfig struct {
+	Pipelines []PipelineDefinition `json:"pipelines"`
+}
+
+// LoadPipelines reads pipelines from the files.pipelines file or falls back to the built-in ones
+func (a *App) LoadPipelines() (*PipelinesConfig, error) {
+	data := defaultPipelines
+	if path := a.Config.Files.Pipelines; path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pipelines: %w", err)
+		}
+		data = fileData
+	}
+
+	return ParsePipelines(data, a.pipelineActions())
+}
+
+// ParsePipelines decodes pipelines and checks every stage mapping against
+// the input and output types of the actions
+func ParsePipelines(data []byte, actions map[string]PipelineAction) (*PipelinesConfig, error) {
+	var config PipelinesConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse pipelines: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range config.Pipelines {
+		pipeline := &config.Pipelines[i]
+		if pipeline.Name == "" {
+			return nil, fmt.Errorf("pipeline #%d has no name", i+1)
+		}
+		if seen[pipeline.Name] {
+			return nil, fmt.Errorf("pipeline %q is declared twice", pipeline.Name)
+		}
+		seen[pipeline.Name] = true
+
+		if err := pipeline.validate(actions); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %w", pipeline.Name, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// Pipeline returns the pipeline with the given name
+func (c *PipelinesConfig) Pipeline(name string) (*PipelineDefinition, bool) {
+	for i := range c.Pipelines {
+		if c.Pipelines[i].Name == name {
+			return &c.Pipelines[i], true
+		}
+	}
+	return nil, false
+}
+
+func (p *PipelineDefinition) validate(actions map[string]PipelineAction) error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("no stages")
+	}
+
+	// Типы полей выходов предыдущих этапов, доступные для маппинга
+	outputs := make(map[string]map[string]reflect.Type)
+	for i := range p.Stages {
+		stage := &p.Stages[i]
+		if stage.Name == "" {
+			return fmt.Errorf("stage #%d has no name", i+1)
+		}
+		if stage.Name == pipelineInputSource {
+			return fmt.Errorf("stage name %q is reserved", pipelineInputSource)
+		}
+		if _, ok := outputs[stage.Name]; ok {
+			return fmt.Errorf("stage %q is declared twice", stage.Name)
+		}
+
+		action, ok := actions[stage.Action]
+		if !ok {
+			return fmt.Errorf("stage %q: unknown action %q", stage.Name, stage.Action)
+		}
+		stage.action = action
+
+		if stage.Retries < 0 {
+			return fmt.Errorf("stage %q: retries must not be negative", stage.Name)
+		}
+
+		stage.timeout = defaultStageTimeout
+		if stage.Timeout != "" {
+			timeout, err := time.ParseDuration(stage.Timeout)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("stage %q: invalid timeout %q", stage.Name, stage.Timeout)
+			}
+			stage.timeout = timeout
+		}
+
+		inputFields := jsonFields(action.InputType())
+		for field, source := range stage.Input {
+			target, ok := inputFields[field]
+			if !ok {
+				return fmt.Errorf("stage %q: action %q has no input field %q", stage.Name, stage.Action, field)
+			}
+
+			from, name, ok := strings.Cut(source, ".")
+			if !ok || name == "" {
+				return fmt.Errorf("stage %q: mapping %q must look like <stage>.<field>", stage.Name, source)
+			}
+			if from == pipelineInputSource {
+				continue
+			}
+
+			fields, ok := outputs[from]
+			if !ok {
+				return fmt.Errorf("stage %q: mapping %q refers to a stage that does not run before it", stage.Name, source)
+			}
+			sourceType, ok := fields[name]
+			if !ok {
+				return fmt.Errorf("stage %q: stage %q has no output field %q", stage.Name, from, name)
+			}
+			if !sourceType.AssignableTo(target) {
+				return fmt.Errorf("stage %q: cannot map %s (%s) to %s (%s)", stage.Name, source, sourceType, field, target)
+			}
+		}
+
+		outputs[stage.Name] = jsonFields(action.OutputType())
+	}
+
+	return nil
+}
+
+// UsesAction reports whether any stage of the pipeline runs the action
+func (p *PipelineDefinition) UsesAction(action string) bool {
+	for _, stage := range p.Stages {
+		if stage.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredInputs returns the run input fields referenced by the stage mappings
+func (p *PipelineDefinition) RequiredInputs() []string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, stage := range p.Stages {
+		for _, source := range stage.Input {
+			from, name, _ := strings.Cut(source, ".")
+			if from == pipelineInputSource && !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		}
+	}
+	return fields
+}
+
+// jsonFields maps the JSON names of struct fields to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// PipelineEngine executes pipeline runs and records every stage attempt as an artifact
+type PipelineEngine struct {
+	repository repo.Repository
+	config     *PipelinesConfig
+	retryDelay time.Duration
+}
+
+// NewPipelineEngine creates an engine for the declared pipelines
+func NewPipelineEngine(repository repo.Repository, config *PipelinesConfig) *PipelineEngine {
+	return &PipelineEngine{
+		repository: repository,
+		config:     config,
+		retryDelay: 2 * time.Second,
+	}
+}
+
+// Execute runs all stages of the run in order, passing mapped outputs to the next stage.
+// The run status, final output and error are stored in the repository.
+func (e *PipelineEngine) Execute(ctx context.Context, run *repo.PipelineRun, progress func(stage string)) (json.RawMessage, error) {
+	pipeline, ok := e.config.Pipeline(run.Pipeline)
+	if !ok {
+		return nil, e.finishRun(run, fmt.Errorf("unknown pipeline %q", run.Pipeline))
+	}
+
+	run.Status = repo.JobStatusRunning
+	run.Error = ""
+	run.FinishedAt = nil
+	if err := e.repository.UpdatePipelineRun(ctx, run); err != nil {
+		return nil, fmt.Errorf("failed to update pipeline run: %w", err)
+	}
+
+	values := make(map[string]map[string]json.RawMessage)
+	var input map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(run.Input), &input); err != nil {
+		return nil, e.finishRun(run, fmt.Errorf("invalid run input: %w", err))
+	}
+	values[pipelineInputSource] = input
+
+	var output json.RawMessage
+	for i := range pipeline.Stages {
+		stage := &pipeline.Stages[i]
+		if progress != nil {
+			progress(stage.Name)
+		}
+
+		stageInput, err := mapStageInput(stage, values)
+		if err != nil {
+			return nil, e.finishRun(run, err)
+		}
+
+		output, err = e.runStage(ctx, run, stage, stageInput)
+		if err != nil {
+			return nil, e.finishRun(run, fmt.Errorf("stage %q failed: %w", stage.Name, err))
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(output, &fields); err != nil {
+			return nil, e.finishRun(run, fmt.Errorf("stage %q returned invalid output: %w", stage.Name, err))
+		}
+		values[stage.Name] = fields
+	}
+
+	run.Output = string(output)
+	return output, e.finishRun(run, nil)
+}
+
+// runStage runs a stage with its timeout, retrying failed attempts
+func (e *PipelineEngine) runStage(ctx context.Context, run *repo.PipelineRun, stage *PipelineStage, input json.RawMessage) (json.RawMessage, error) {
+	var lastErr error
+	for attempt := 1; attempt <= stage.Retries+1; attempt++ {
+		if attempt > 1 {
+			log.Printf("Retrying pipeline stage %s of run %s (attempt %d): %v", stage.Name, run.ID, attempt, lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(e.retryDelay * time.Duration(attempt-1)):
+			}
+		}
+
+		artifact := &repo.PipelineArtifact{
+			RunID:   run.ID,
+			Stage:   stage.Name,
+			Attempt: attempt,
+			Status:  ArtifactStatusRunning,
+			Input:   string(input),
+		}
+		if err := e.repository.CreatePipelineArtifact(ctx, artifact); err != nil {
+			return nil, fmt.Errorf("failed to store artifact: %w", err)
+		}
+
+		stageCtx, cancel := context.WithTimeout(ctx, stage.timeout)
+		output, err := stage.action.Run(stageCtx, input)
+		cancel()
+
+		now := time.Now()
+		artifact.FinishedAt = &now
+		if err != nil {
+			artifact.Status = ArtifactStatusFailed
+			artifact.Error = err.Error()
+		} else {
+			artifact.Status = ArtifactStatusSucceeded
+			artifact.Output = string(output)
+		}
+		if updateErr := e.repository.UpdatePipelineArtifact(context.Background(), artifact); updateErr != nil {
+			log.Printf("Failed to store artifact of stage %s: %v", stage.Name, updateErr)
+		}
+
+		if err == nil {
+			return output, nil
+		}
+		lastErr = err
+
+		// Отмена всего запуска не повторяется, в отличие от таймаута отдельного этапа
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, lastErr
+}
+
+// finishRun stores the terminal state of the run and returns runErr
+func (e *PipelineEngine) finishRun(run *repo.PipelineRun, runErr error) error {
+	now := time.Now()
+	run.FinishedAt = &now
+
+	switch {
+	case runErr == nil:
+		run.Status = repo.JobStatusSucceeded
+	case errors.Is(runErr, context.Canceled):
+		run.Status = repo.JobStatusCancelled
+		run.Error = runErr.Error()
+	default:
+		run.Status = repo.JobStatusFailed
+		run.Error = runErr.Error()
+	}
+
+	if err := e.repository.UpdatePipelineRun(context.Background(), run); err != nil {
+		log.Printf("Failed to store pipeline run %s: %v", run.ID, err)
+	}
+	return runErr
+}
+
+// mapStageInput builds the stage input object from the run input and previous stage outputs
+func mapStageInput(stage *PipelineStage, values map[string]map[string]json.RawMessage) (json.RawMessage, error) {
+	input := make(map[string]json.RawMessage)
+	for field, source := range stage.Input {
+		from, name, _ := strings.Cut(source, ".")
+		value, ok := values[from][name]
+		if !ok {
+			return nil, fmt.Errorf("stage %q: %s is not available", stage.Name, source)
+		}
+		input[field] = value
+	}
+
+	return json.Marshal(input)
+}
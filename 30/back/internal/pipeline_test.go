@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+type testUpperInput struct {
+	Text string `json:"text"`
+}
+
+type testUpperOutput struct {
+	Upper string `json:"upper"`
+	Size  int    `json:"size"`
+}
+
+func TestParsePipelines(t *testing.T) {
+	if _, err := ParsePipelines(defaultPipelines, (&App{}).pipelineActions()); err != nil {
+		t.Fatalf("Built-in pipelines are invalid: %v", err)
+	}
+
+	actions := map[string]PipelineAction{
+		"upper": TypedAction[testUpperInput, testUpperOutput](func(_ context.Context, in testUpperInput) (testUpperOutput, error) {
+			return testUpperOutput{Upper: strings.ToUpper(in.Text), Size: len(in.Text)}, nil
+		}),
+	}
+
+	cases := map[string]string{
+		"unknownAction": `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "missing"}]}]}`,
+		"unknownInput":  `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "upper", "input": {"message": "input.text"}}]}]}`,
+		"laterStage":    `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "upper", "input": {"text": "b.upper"}}, {"name": "b", "action": "upper"}]}]}`,
+		"unknownOutput": `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "upper", "input": {"text": "input.text"}}, {"name": "b", "action": "upper", "input": {"text": "a.html"}}]}]}`,
+		"typeMismatch":  `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "upper", "input": {"text": "input.text"}}, {"name": "b", "action": "upper", "input": {"text": "a.size"}}]}]}`,
+		"badTimeout":    `{"pipelines": [{"name": "p", "stages": [{"name": "a", "action": "upper", "timeout": "soon"}]}]}`,
+		"noStages":      `{"pipelines": [{"name": "p", "stages": []}]}`,
+	}
+	for name, data := range cases {
+		if _, err := ParsePipelines([]byte(data), actions); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPipelineEngine(t *testing.T) {
+	repository := newTestJobRepository(t)
+	ctx := context.Background()
+
+	failures := 1
+	actions := map[string]PipelineAction{
+		"upper": TypedAction[testUpperInput, testUpperOutput](func(_ context.Context, in testUpperInput) (testUpperOutput, error) {
+			return testUpperOutput{Upper: strings.ToUpper(in.Text), Size: len(in.Text)}, nil
+		}),
+		"flaky": TypedAction[testUpperInput, testUpperOutput](func(_ context.Context, in testUpperInput) (testUpperOutput, error) {
+			if failures > 0 {
+				failures--
+				return testUpperOutput{}, errors.New("temporary failure")
+			}
+			return testUpperOutput{Upper: in.Text + "!"}, nil
+		}),
+		"slow": TypedAction[testUpperInput, testUpperOutput](func(ctx context.Context, _ testUpperInput) (testUpperOutput, error) {
+			<-ctx.Done()
+			return testUpperOutput{}, ctx.Err()
+		}),
+	}
+
+	config, err := ParsePipelines([]byte(`{"pipelines": [
+		{"name": "chain", "stages": [
+			{"name": "first", "action": "upper", "input": {"text": "input.text"}},
+			{"name": "second", "action": "flaky", "input": {"text": "first.upper"}, "retries": 1}
+		]},
+		{"name": "timeout", "stages": [
+			{"name": "wait", "action": "slow", "input": {"text": "input.text"}, "timeout": "20ms", "retries": 1}
+		]}
+	]}`), actions)
+	if err != nil {
+		t.Fatalf("Failed to parse pipelines: %v", err)
+	}
+
+	engine := NewPipelineEngine(repository, config)
+	engine.retryDelay = time.Millisecond
+
+	t.Run("chainWithRetry", func(t *testing.T) {
+		run := &repo.PipelineRun{ID: "chain-run", Pipeline: "chain", Status: repo.JobStatusQueued, Input: `{"text": "hello"}`}
+		if err := repository.CreatePipelineRun(ctx, run); err != nil {
+			t.Fatalf("Failed to create run: %v", err)
+		}
+
+		var stages []string
+		output, err := engine.Execute(ctx, run, func(stage string) { stages = append(stages, stage) })
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+
+		var result testUpperOutput
+		json.Unmarshal(output, &result)
+		if result.Upper != "HELLO!" {
+			t.Errorf("output = %s, want HELLO!", output)
+		}
+		if strings.Join(stages, ",") != "first,second" {
+			t.Errorf("progress = %v", stages)
+		}
+
+		stored, _ := repository.GetPipelineRun(ctx, run.ID)
+		if stored.Status != repo.JobStatusSucceeded || stored.Output != string(output) {
+			t.Errorf("stored run = %+v", stored)
+		}
+
+		artifacts, _ := repository.GetPipelineArtifacts(ctx, run.ID)
+		if len(artifacts) != 3 {
+			t.Fatalf("artifacts = %d, want first + two attempts of second", len(artifacts))
+		}
+		if artifacts[1].Status != ArtifactStatusFailed || artifacts[2].Attempt != 2 || artifacts[2].Input != `{"text":"HELLO"}` {
+			t.Errorf("unexpected artifacts: %+v %+v", artifacts[1], artifacts[2])
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		run := &repo.PipelineRun{ID: "timeout-run", Pipeline: "timeout", Status: repo.JobStatusQueued, Input: `{"text": "x"}`}
+		if err := repository.CreatePipelineRun(ctx, run); err != nil {
+			t.Fatalf("Failed to create run: %v", err)
+		}
+
+		if _, err := engine.Execute(ctx, run, nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want deadline exceeded", err)
+		}
+
+		stored, _ := repository.GetPipelineRun(ctx, run.ID)
+		if stored.Status != repo.JobStatusFailed {
+			t.Errorf("status = %s, want failed", stored.Status)
+		}
+		artifacts, _ := repository.GetPipelineArtifacts(ctx, run.ID)
+		if len(artifacts) != 2 {
+			t.Errorf("artifacts = %d, want 2 attempts", len(artifacts))
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// Preview defaults
+const (
+	defaultPreviewPort = 8081
+	defaultPreviewTTL  = time.Hour
+	previewPathPrefix  = "/p/"
+)
+
+// PreviewConfig is the preview origin serving generated sites apart from the API
+type PreviewConfig struct {
+	Port           int           `yaml:"port" env:"PORT"`
+	BaseURL        string        `yaml:"base_url" env:"BASE_URL"`               // public origin, http://localhost:{port} by default
+	Secret         string        `yaml:"secret" env:"SECRET" secret:"true"`     // HMAC key of the signed preview URLs
+	TTL            time.Duration `yaml:"ttl" env:"TTL"`                         // lifetime of a signed preview URL
+	FrameAncestors string        `yaml:"frame_ancestors" env:"FRAME_ANCESTORS"` // origins allowed to embed previews; empty forbids framing
+	Dir            string        `yaml:"-"`                                     // directory holding the project artifacts
+}
+
+// PreviewServer serves the artifacts of projects behind signed, expiring URLs
+// of the form /p/{project_id}/{expires}/{signature}/
+type PreviewServer struct {
+	cfg           PreviewConfig
+	repository    repo.Repository
+	scriptHosts   []string
+	inlineScripts bool // the guard lets inline scripts through
+	secret        []byte
+	now           func() time.Time
+}
+
+// NewPreviewServer creates a preview server; artifacts are looked up in the repository and
+// may run the scripts the guard allows: inline ones only when it does not block them, and
+// external ones from its allowed hosts. Without a secret a random key is used,
+// so preview URLs stop working after a restart.
+func NewPreviewServer(cfg PreviewConfig, repository repo.Repository, guard *Guard) (*PreviewServer, error) {
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = fmt.Sprintf("http://localhost:%d", cfg.Port)
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate preview secret: %w", err)
+		}
+		log.Printf("Warning: PREVIEW_SECRET is not set, preview URLs expire on restart")
+	}
+
+	p := &PreviewServer{cfg: cfg, repository: repository, secret: secret, now: time.Now}
+	if guard != nil {
+		p.scriptHosts = guard.config.HTML.AllowedScriptHosts
+		p.inlineScripts = !guard.config.HTML.BlockInlineScripts
+	}
+	return p, nil
+}
+
+// BaseURL is the public origin of the preview server
+func (p *PreviewServer) BaseURL() string {
+	return p.cfg.BaseURL
+}
+
+// Addr is the listen address of the preview server
+func (p *PreviewServer) Addr() string {
+	return ":" + strconv.Itoa(p.cfg.Port)
+}
+
+// SignedURL returns a preview URL of the project valid for the configured TTL
+func (p *PreviewServer) SignedURL(projectID int64) (string, time.Time) {
+	expires := p.now().Add(p.cfg.TTL).Truncate(time.Second)
+	return fmt.Sprintf("%s%s%d/%d/%s/", p.cfg.BaseURL, previewPathPrefix, projectID, expires.Unix(),
+		p.signature(projectID, expires.Unix())), expires
+}
+
+func (p *PreviewServer) signature(projectID, expires int64) string {
+	mac := hmac.New(sha256.New, p.secret)
+	fmt.Fprintf(mac, "%d.%d", projectID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify parses /p/{project_id}/{expires}/{signature}/{file} and checks the signature and expiry
+func (p *PreviewServer) verify(urlPath string) (projectID int64, file string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, previewPathPrefix), "/", 4)
+	if !strings.HasPrefix(urlPath, previewPathPrefix) || len(parts) < 3 {
+		return 0, "", errors.New("not a preview URL")
+	}
+
+	projectID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", errors.New("not a preview URL")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", errors.New("not a preview URL")
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(p.signature(projectID, expires))) {
+		return 0, "", errors.New("invalid signature")
+	}
+	if p.now().Unix() > expires {
+		return 0, "", errors.New("preview URL expired")
+	}
+
+	if len(parts) == 4 {
+		file = parts[3]
+	}
+	return projectID, file, nil
+}
+
+// ServeHTTP serves a file of the project artifact with the isolation headers
+func (p *PreviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.setSecurityHeaders(w)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, file, err := p.verify(r.URL.Path)
+	if err != nil {
+		// Missing, forged and expired URLs look the same to the caller
+		http.NotFound(w, r)
+		return
+	}
+	// Relative links of the site resolve against the signed prefix
+	if file == "" && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	project, err := p.repository.GetProject(r.Context(), projectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Preview unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	filePath, ok := p.artifactFile(project.FilePath, file)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// artifactFile resolves a file of the artifact, which is a single HTML file or a
+// directory with index.html. Paths outside the artifact and the preview directory are refused.
+func (p *PreviewServer) artifactFile(artifact, file string) (string, bool) {
+	if artifact == "" || !withinDir(p.cfg.Dir, artifact) {
+		return "", false
+	}
+
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return "", false
+	}
+	if !info.IsDir() {
+		if file != "" && file != filepath.Base(artifact) {
+			return "", false
+		}
+		return artifact, true
+	}
+
+	if file == "" || strings.HasSuffix(file, "/") {
+		file += "index.html"
+	}
+	filePath := filepath.Join(artifact, filepath.FromSlash(path.Clean("/"+file)))
+	return filePath, withinDir(artifact, filePath)
+}
+
+// withinDir reports whether target is dir or inside it
+func withinDir(dir, target string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absTarget)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// setSecurityHeaders isolates the previewed site: it runs in a sandbox with an opaque
+// origin, cannot submit forms, call APIs or be framed by other sites
+func (p *PreviewServer) setSecurityHeaders(w http.ResponseWriter) {
+	scriptSrc := []string{"'self'"}
+	if p.inlineScripts {
+		scriptSrc = append(scriptSrc, "'unsafe-inline'")
+	}
+	for _, host := range p.scriptHosts {
+		scriptSrc = append(scriptSrc, "https://"+host)
+	}
+
+	// Scripts only run in the sandbox when the guard lets some of them through
+	sandbox := "sandbox allow-popups"
+	if p.inlineScripts || len(p.scriptHosts) > 0 {
+		sandbox = "sandbox allow-scripts allow-popups"
+	}
+
+	frameAncestors := "'none'"
+	if p.cfg.FrameAncestors != "" {
+		frameAncestors = p.cfg.FrameAncestors
+	} else {
+		w.Header().Set("X-Frame-Options", "DENY")
+	}
+
+	w.Header().Set("Content-Security-Policy", strings.Join([]string{
+		"default-src 'none'",
+		"script-src " + strings.Join(scriptSrc, " "),
+		"style-src 'self' 'unsafe-inline' https:",
+		"img-src 'self' data: https:",
+		"font-src 'self' data: https:",
+		"media-src 'self' https:",
+		"connect-src 'none'",
+		"form-action 'none'",
+		"base-uri 'none'",
+		"frame-ancestors " + frameAncestors,
+		sandbox,
+	}, "; "))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+	w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Cache-Control", "private, no-store")
+}
+
+// PreviewURLResponse is a freshly signed preview URL of a project
+type PreviewURLResponse struct {
+	ProjectID int64     `json:"project_id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PreviewURLHandler signs a new preview URL of the project (GET /projects/{id}/preview)
+func (p *PreviewServer) PreviewURLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	projectID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid project id", http.StatusBadRequest)
+		return
+	}
+
+	// Projects of other users are not found, like their jobs
+	if project, err := p.repository.GetProject(r.Context(), projectID); errors.Is(err, sql.ErrNoRows) || (err == nil && !ownedByCaller(r.Context(), project.UserID)) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get project: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	url, expires := p.SignedURL(projectID)
+	json.NewEncoder(w).Encode(PreviewURLResponse{ProjectID: projectID, URL: url, ExpiresAt: expires})
+}
+
+// previewURL signs a preview URL of the project, or returns "" without a preview server
+func (a *App) previewURL(projectID int64) string {
+	if a.Preview == nil || projectID == 0 {
+		return ""
+	}
+	url, _ := a.Preview.SignedURL(projectID)
+	return url
+}
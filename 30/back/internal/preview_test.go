@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPreviewServer(t *testing.T) (*PreviewServer, int64) {
+	t.Helper()
+	repository := newTestJobRepository(t)
+	t.Cleanup(func() { repository.Close() })
+	ctx := context.Background()
+
+	if err := os.MkdirAll("result", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("result", "site.html"), []byte("<h1>Кофейня</h1>"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.WriteFile("secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	chat, err := repository.CreateChat(ctx, "preview", "")
+	if err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+	project, err := repository.CreateProject(ctx, chat.ID, "site", "", filepath.Join("result", "site.html"), "")
+	if err != nil {
+		t.Fatalf("CreateProject failed: %v", err)
+	}
+
+	p, err := NewPreviewServer(PreviewConfig{
+		BaseURL: "http://preview.test/",
+		Secret:  "0123456789abcdef0123456789abcdef",
+		TTL:     time.Hour,
+		Dir:     "result",
+	}, repository, newTestGuard(t))
+	if err != nil {
+		t.Fatalf("NewPreviewServer failed: %v", err)
+	}
+	return p, project.ID
+}
+
+func TestPreviewServer(t *testing.T) {
+	p, projectID := newTestPreviewServer(t)
+
+	get := func(rawURL string) *httptest.ResponseRecorder {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatalf("invalid URL %q: %v", rawURL, err)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.Path, nil))
+		return rec
+	}
+
+	signed, expires := p.SignedURL(projectID)
+	if !strings.HasPrefix(signed, "http://preview.test/p/") || !expires.After(time.Now()) {
+		t.Fatalf("SignedURL = %q, %v", signed, expires)
+	}
+
+	rec := get(signed)
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Кофейня</h1>" {
+		t.Fatalf("signed URL: %d %q", rec.Code, rec.Body.String())
+	}
+	csp := rec.Header().Get("Content-Security-Policy")
+	for _, directive := range []string{"default-src 'none'", "script-src 'self';", "form-action 'none'", "frame-ancestors 'none'", "sandbox allow-popups"} {
+		if !strings.Contains(csp, directive) {
+			t.Errorf("CSP %q lacks %q", csp, directive)
+		}
+	}
+
+	// The default guard blocks inline scripts, so the sandbox runs none;
+	// a guard that lets them through gets them run
+	p.inlineScripts = true
+	csp = get(signed).Header().Get("Content-Security-Policy")
+	for _, directive := range []string{"script-src 'self' 'unsafe-inline'", "sandbox allow-scripts allow-popups"} {
+		if !strings.Contains(csp, directive) {
+			t.Errorf("CSP %q lacks %q", csp, directive)
+		}
+	}
+	p.inlineScripts = false
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+
+	if rec := get(strings.TrimSuffix(signed, "/")); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("URL without trailing slash: %d, want a redirect", rec.Code)
+	}
+
+	// The signature covers the project and the expiry
+	parts := strings.Split(strings.TrimPrefix(signed, "http://preview.test/p/"), "/")
+	for name, forged := range map[string]string{
+		"other project": "http://preview.test/p/999/" + parts[1] + "/" + parts[2] + "/",
+		"later expiry":  "http://preview.test/p/" + parts[0] + "/9999999999/" + parts[2] + "/",
+		"no signature":  "http://preview.test/p/" + parts[0] + "/" + parts[1] + "/",
+		"outside file":  signed + "../../../../secret.txt",
+		"other file":    signed + "secret.txt",
+	} {
+		if rec := get(forged); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", name, rec.Code)
+		}
+	}
+
+	p.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if rec := get(signed); rec.Code != http.StatusNotFound {
+		t.Errorf("expired URL: %d, want 404", rec.Code)
+	}
+}
+
+func TestPreviewArtifactOutsideDir(t *testing.T) {
+	p, _ := newTestPreviewServer(t)
+
+	if _, ok := p.artifactFile("secret.txt", ""); ok {
+		t.Errorf("artifact outside the preview directory was served")
+	}
+	if file, ok := p.artifactFile(filepath.Join("result", "site.html"), ""); !ok || file != filepath.Join("result", "site.html") {
+		t.Errorf("artifactFile = %q, %v", file, ok)
+	}
+}
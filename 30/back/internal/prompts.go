@@ -1,16 +1,36 @@
 package internal
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
-func GetRequirementsGatheringPrompt(history string, requirements Requirements) string {
-	siteType := requirements.SiteType
-	if siteType == "" {
-		siteType = "НЕ ИЗВЕСТНО"
+// GetRequirementsGatheringPrompt builds the dialog prompt from the schema,
+// asking only about the required slots that are still missing
+func GetRequirementsGatheringPrompt(history string, schema *RequirementsSchema, requirements Requirements) string {
+	var goals, current strings.Builder
+	for i, slot := range schema.Slots {
+		if slot.Required {
+			goals.WriteString(fmt.Sprintf("%d. %s\n", i+1, slot.Description))
+		}
+
+		value := requirements[slot.Name]
+		if value == "" {
+			value = "НЕ ИЗВЕСТНО"
+		}
+		current.WriteString(fmt.Sprintf("%d. %s: %s\n", i+1, slot.Label, value))
 	}
 
-	targetAudience := requirements.TargetAudience
-	if targetAudience == "" {
-		targetAudience = "НЕ ИЗВЕСТНО"
+	var next string
+	if missing := schema.MissingSlots(requirements); len(missing) == 0 {
+		next = "Все пункты заполнены - создавай итоговый документ."
+	} else {
+		var questions []string
+		for _, slot := range missing {
+			questions = append(questions, fmt.Sprintf("- %s (%s)", slot.Label, slot.Description))
+		}
+		next = "Не хватает данных. Задавай вопрос только по недостающим пунктам:\n" + strings.Join(questions, "\n")
 	}
 
 	return fmt.Sprintf(`Ты — менеджер по сбору требований для создания простого одностраничника веб-сайта.
@@ -18,11 +38,9 @@ func GetRequirementsGatheringPrompt(history string, requirements Requirements) s
 ОБЯЗАТЕЛЬНО: ВСЕГДА ОТВЕЧАЙ ТОЛЬКО НА РУССКОМ ЯЗЫКЕ!
 
 Твоя задача — задать вопросы пользователю, чтобы понять:
-1. Тип сайта (персональная страница, авто-сайт, что-угодно)
-2. Целевую аудиторию
-
-ВАЖНО: 
-- не рассуждай, не пиши много текста. только задавай вопросы 
+%s
+ВАЖНО:
+- не рассуждай, не пиши много текста. только задавай вопросы
 - Будь краток, задавай вопросы сразу, без вводных фраз
 - Если информация по какому-то пункту уже есть - НЕ СПРАШИВАЙ повторно
 - Если у тебя есть информация по ВСЕМ пунктам - сразу создавай итоговый документ
@@ -30,8 +48,38 @@ func GetRequirementsGatheringPrompt(history string, requirements Requirements) s
 История нашего диалога: %s
 
 Текущие данные:
-1. Тип сайта: %s
-2. Целевая аудитория: %s
+%s
+%s`, goals.String(), history, current.String(), next)
+}
+
+// GetRequirementsExtractionPrompt builds the system prompt that asks the LLM
+// to return the slot values mentioned in the user's answer as a JSON object
+func GetRequirementsExtractionPrompt(schema *RequirementsSchema, requirements Requirements, lastQuestion string) string {
+	var slots strings.Builder
+	for _, slot := range schema.Slots {
+		slots.WriteString(fmt.Sprintf("- %s: %s", slot.Name, slot.Description))
+		if len(slot.Options) > 0 {
+			slots.WriteString(fmt.Sprintf(" (допустимые значения: %s)", strings.Join(slot.Options, ", ")))
+		}
+		slots.WriteString("\n")
+	}
+
+	currentJSON, _ := json.Marshal(requirements)
+	if lastQuestion == "" {
+		lastQuestion = "(нет, это первое сообщение)"
+	}
+
+	return fmt.Sprintf(`Ты — извлекаешь параметры будущего сайта из ответа пользователя.
+
+Параметры:
+%s
+Уже известные значения: %s
+
+Последний вопрос ассистента: %s
 
-Если все пункты заполнены - создавай итоговый документ. Если нет - задавай вопрос только по недостающим пунктам.`, history, siteType, targetAudience)
+Правила:
+- Верни ТОЛЬКО JSON-объект вида {"имя_параметра": "значение"}, без markdown и пояснений
+- Включай только параметры, которые пользователь явно указал в своем ответе
+- Ответ на последний вопрос ассистента относится к параметру, о котором спрашивали
+- Если ничего не указано - верни {}`, slots.String(), string(currentJSON), lastQuestion)
 }
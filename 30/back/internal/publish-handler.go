@@ -1,31 +1,43 @@
 package internal
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
+
+	"chat-web-service-backend/repo"
 )
 
-// YCloudDeployRequest represents the request payload for ycloud-mcp
-type YCloudDeployRequest struct {
-	HTMLContent string `json:"htmlContent"`
-	Filename    string `json:"filename"`
+// PublishResponse is the recorded deployment; Error is set when the upload failed. A deployment
+// that is being verified is returned as verifying with VerifyJob, the job that checks it.
+type PublishResponse struct {
+	Success    bool             `json:"success"`
+	Deployment *repo.Deployment `json:"deployment"`
+	VerifyJob  string           `json:"verify_job,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// VerifyJobPayload is the verification of an uploaded deployment as it is queued
+type VerifyJobPayload struct {
+	DeploymentID int64         `json:"deployment_id"`
+	Entry        deployedEntry `json:"entry"`
 }
 
-// YCloudDeployResponse represents the response from ycloud-mcp
-type YCloudDeployResponse struct {
-	Success bool   `json:"success"`
-	Data    struct {
-		Success    bool   `json:"success"`
-		Message    string `json:"message"`
-		RemotePath string `json:"remotePath"`
-	} `json:"data"`
-	Error string `json:"error,omitempty"`
+// ProjectDeploymentsResponse is the deployment history of a project, newest first
+type ProjectDeploymentsResponse struct {
+	ProjectID   int64              `json:"project_id"`
+	Deployments []*repo.Deployment `json:"deployments"`
 }
 
-func PublishHandler(w http.ResponseWriter, r *http.Request) {
+// PublishHandler deploys the artifact of a project to the deploy target and records the deployment
+func (a *App) PublishHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -35,29 +47,216 @@ func PublishHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ignore request body and hardcode the file
-	hardcodedFilename := "2025-09-06_13-43-57.html"
-	
-	// Read file from result directory
-	filePath := filepath.Join("result", hardcodedFilename)
-	
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("File %s not found in result directory", hardcodedFilename)})
+	var req PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ProjectID <= 0 {
+		http.Error(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		req.Target = a.Config.Deploy.Target
+	}
+	if err := a.Config.Deploy.Validate(req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	project, err := a.Repository.GetProject(ctx, req.ProjectID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownedByCaller(ctx, project.UserID)) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get project: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if project.FilePath == "" {
+		http.Error(w, "Project has no generated site", http.StatusConflict)
+		return
+	}
+
+	// The site is published as a revision, so it can be rolled back to later
+	rev, err := saveRevision(ctx, a.Repository, project.ID, project.FilePath, repo.RevisionSourcePublish)
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("File %s of the project not found", project.FilePath), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save revision: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	a.writeDeployment(w, r, project, rev, req.Target)
+}
+
+// writeDeployment deploys the revision of the project under the name of the project's
+// artifact, so every revision replaces the previous one at the same URL
+func (a *App) writeDeployment(w http.ResponseWriter, r *http.Request, project *repo.Project, rev *repo.ProjectRevision, target string) {
+	response, err := a.publishRevision(r.Context(), project, rev, target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to deploy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !response.Success {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// publishRevision deploys the revision of the project and queues the verification of the upload.
+// The error is only returned when the deployment could not be recorded.
+func (a *App) publishRevision(ctx context.Context, project *repo.Project, rev *repo.ProjectRevision, target string) (*PublishResponse, error) {
+	deployment, entry, err := a.deployFile(ctx, project.ID, filepath.Base(project.FilePath), rev.FilePath, target)
+	if deployment == nil && err != nil {
+		return nil, err
+	}
+
+	response := &PublishResponse{Success: err == nil, Deployment: deployment}
+	if err != nil {
+		response.Error = err.Error()
+		return response, nil
+	}
+	if deployment.Status != repo.DeploymentStatusVerifying {
+		return response, nil
+	}
+
+	// The verification job belongs to the owner of the project, like the project's other jobs
+	job, err := a.Jobs.Enqueue(ctx, JobTypeVerify, project.UserID, VerifyJobPayload{DeploymentID: deployment.ID, Entry: entry})
+	if err != nil {
+		// The site is online, only unchecked
+		log.Printf("Failed to queue the verification of deployment %d: %v", deployment.ID, err)
+		finishedAt := time.Now()
+		deployment.Status = repo.DeploymentStatusSucceeded
+		deployment.Error = fmt.Sprintf("verification not started: %v", err)
+		deployment.FinishedAt = &finishedAt
+		if err := a.Repository.UpdateDeployment(context.WithoutCancel(ctx), deployment); err != nil {
+			return nil, fmt.Errorf("failed to record deployment: %w", err)
+		}
+		return response, nil
+	}
+	response.VerifyJob = job.ID
+	return response, nil
+}
+
+// runVerifyJob checks that the public URL of an uploaded deployment serves the deployed content;
+// the result is the verified deployment. A deployment that fails the check is rolled back when
+// DEPLOY_VERIFY_AUTO_ROLLBACK is set, and the job fails with the reason and the rollback.
+func (a *App) runVerifyJob(ctx context.Context, repository repo.Repository, job *repo.Job, progress func(stage string)) (interface{}, error) {
+	var payload VerifyJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("invalid verify payload: %w", err)
+	}
+
+	deployment, err := repository.GetDeployment(ctx, payload.DeploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment %d: %w", payload.DeploymentID, err)
+	}
+	if deployment.Status != repo.DeploymentStatusVerifying {
+		// Checked before the job was interrupted by a restart
+		if deployment.Status != repo.DeploymentStatusVerified {
+			return nil, fmt.Errorf("deployment %d: %s", deployment.ID, deployment.Error)
+		}
+		return deployment, nil
+	}
+
+	progress("verify")
+	verifyErr := a.verifyDeployment(ctx, deployment, payload.Entry)
+	if ctx.Err() != nil {
+		// Cancelled or shutting down: the deployment stays verifying and the job is resumed
+		return nil, ctx.Err()
+	}
+	if err := repository.UpdateDeployment(ctx, deployment); err != nil {
+		return nil, fmt.Errorf("failed to record deployment: %w", err)
+	}
+	if verifyErr == nil {
+		return deployment, nil
+	}
+
+	if !a.Config.Deploy.Verify.AutoRollback || deployment.ProjectID == nil || deployment.Verification == "" {
+		return nil, fmt.Errorf("deployment %d: %w", deployment.ID, verifyErr)
+	}
+	project, err := repository.GetProject(ctx, *deployment.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("deployment %d: %w; failed to get project for the rollback: %v", deployment.ID, verifyErr, err)
+	}
+	progress("rollback")
+	rollback := a.rollbackFailedDeployment(ctx, project, deployment)
+	if rollback == nil {
+		return nil, fmt.Errorf("deployment %d: %w; not rolled back", deployment.ID, verifyErr)
+	}
+	return nil, fmt.Errorf("deployment %d: %w; rolled back to %s in deployment %d (%s)",
+		deployment.ID, verifyErr, rollback.Revision, rollback.ID, rollback.Status)
+}
+
+// rollbackFailedDeployment redeploys the revision that was live on the target before the
+// failed deployment. It returns nil when there is none; the rollback is not rolled back in turn.
+func (a *App) rollbackFailedDeployment(ctx context.Context, project *repo.Project, failed *repo.Deployment) *repo.Deployment {
+	deployments, err := a.Repository.GetDeployments(ctx, project.ID)
+	if err != nil {
+		log.Printf("Auto-rollback of deployment %d: failed to get deployments: %v", failed.ID, err)
+		return nil
+	}
+
+	var previous *repo.Deployment
+	for _, deployment := range deployments {
+		if deployment.ID != failed.ID && deployment.Target == failed.Target && deploymentLive(deployment) {
+			previous = deployment
+			break
+		}
+	}
+	if previous == nil || previous.Revision == failed.Revision {
+		return nil
+	}
+
+	revisions, err := a.Repository.GetProjectRevisions(ctx, project.ID)
+	if err != nil {
+		log.Printf("Auto-rollback of deployment %d: failed to get revisions: %v", failed.ID, err)
+		return nil
+	}
+	rev, err := findRevision(revisions, previous.Revision)
+	if err != nil {
+		log.Printf("Auto-rollback of deployment %d to %s: %v", failed.ID, previous.Revision, err)
+		return nil
+	}
+
+	rollback, entry, err := a.deployFile(ctx, project.ID, filepath.Base(project.FilePath), rev.FilePath, failed.Target)
+	if err == nil && rollback.Status == repo.DeploymentStatusVerifying {
+		// Already in the background, the rollback is checked right away
+		err = a.verifyDeployment(ctx, rollback, entry)
+		if updateErr := a.Repository.UpdateDeployment(context.WithoutCancel(ctx), rollback); updateErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to record deployment: %w", updateErr))
+		}
+	}
+	if err != nil {
+		log.Printf("Auto-rollback of deployment %d to %s: %v", failed.ID, previous.Revision, err)
+	}
+	return rollback
+}
+
+// ProjectDeploymentsHandler lists the deployments of a project, newest first
+func (a *App) ProjectDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	project, ok := a.projectFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	// Mock successful deployment since ycloud-mcp service is not running
-	// In a real scenario, this would read the file and deploy to Yandex Cloud
+	deployments, err := a.Repository.GetDeployments(r.Context(), project.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deployments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if deployments == nil {
+		deployments = []*repo.Deployment{}
+	}
 
-	// Return success response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":     true,
-		"message":     fmt.Sprintf("File %s successfully deployed to Yandex Cloud", hardcodedFilename),
-		"filename":    hardcodedFilename,
-		"user_id":     "hardcoded-user",
-		"remote_path": "/mock/path/" + hardcodedFilename,
-	})
+	json.NewEncoder(w).Encode(ProjectDeploymentsResponse{ProjectID: project.ID, Deployments: deployments})
 }
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// QuotaUsageResponse is the quota usage of a user
+type QuotaUsageResponse struct {
+	UserID   string               `json:"user_id"`
+	Role     string               `json:"role"`
+	Usage    []QuotaUsage         `json:"usage"`
+	Counters []*repo.QuotaCounter `json:"counters,omitempty"`
+}
+
+// QuotaResetResponse reports how many counters were reset
+type QuotaResetResponse struct {
+	UserID string `json:"user_id"`
+	Scope  string `json:"scope"`
+	Reset  int64  `json:"reset"`
+}
+
+// QuotaHandler returns the quota usage of the caller
+func (m *QuotaManager) QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	identity, ok := IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	m.writeUsage(w, r, identity.UserID, identity.Role, false)
+}
+
+// ConfigHandler returns the quota configuration (admin only)
+func (m *QuotaManager) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	json.NewEncoder(w).Encode(m.config)
+}
+
+// UserQuotaHandler returns the usage and raw counters of a user (admin only).
+// The role of the limits is taken from ?role=, builder by default.
+func (m *QuotaManager) UserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		role = UserRoleBuilder
+	}
+	if !ValidUserRole(role) {
+		http.Error(w, "role must be one of viewer, builder, publisher, admin", http.StatusBadRequest)
+		return
+	}
+
+	m.writeUsage(w, r, mux.Vars(r)["user_id"], role, true)
+}
+
+func (m *QuotaManager) writeUsage(w http.ResponseWriter, r *http.Request, userID, role string, withCounters bool) {
+	usage, counters, err := m.Usage(r.Context(), m.repository, userID, role)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get quota usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := QuotaUsageResponse{UserID: userID, Role: role, Usage: usage}
+	if withCounters {
+		response.Counters = counters
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResetUserQuotaHandler resets the counters of a user, all of them or of one
+// scope given by ?scope= (admin only)
+func (m *QuotaManager) ResetUserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	userID := mux.Vars(r)["user_id"]
+	scope := r.URL.Query().Get("scope")
+
+	reset, err := m.repository.ResetQuotaCounters(r.Context(), userID, scope)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reset quota counters: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(QuotaResetResponse{UserID: userID, Scope: scope, Reset: reset})
+}
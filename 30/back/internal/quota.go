@@ -0,0 +1,505 @@
+package internal
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+//go:embed quotas.json
+var defaultQuotas []byte
+
+// quotaScopeAll is the scope of the limits over all endpoints together
+const quotaScopeAll = "*"
+
+// quotaRetention is how long counters of finished periods are kept
+const quotaRetention = 48 * time.Hour
+
+// Quota metrics
+const (
+	QuotaMetricRequests = "requests"
+	QuotaMetricTokens   = "tokens"
+)
+
+// QuotaLimits are the limits of one scope; zero means unlimited
+type QuotaLimits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	RequestsPerDay    int `json:"requests_per_day,omitempty"`
+	TokensPerDay      int `json:"tokens_per_day,omitempty"`
+}
+
+// requests returns the request limit of the period
+func (l QuotaLimits) requests(period string) int {
+	if period == repo.QuotaPeriodMinute {
+		return l.RequestsPerMinute
+	}
+	return l.RequestsPerDay
+}
+
+// merge returns l with the non-zero limits of override applied
+func (l QuotaLimits) merge(override QuotaLimits) QuotaLimits {
+	if override.RequestsPerMinute != 0 {
+		l.RequestsPerMinute = override.RequestsPerMinute
+	}
+	if override.RequestsPerDay != 0 {
+		l.RequestsPerDay = override.RequestsPerDay
+	}
+	if override.TokensPerDay != 0 {
+		l.TokensPerDay = override.TokensPerDay
+	}
+	return l
+}
+
+func (l QuotaLimits) validate() error {
+	if l.RequestsPerMinute < 0 || l.RequestsPerDay < 0 || l.TokensPerDay < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	return nil
+}
+
+// QuotaPolicy holds the limits over all endpoints and per endpoint.
+// Endpoint names are the route names used in main, e.g. "build" or "publish".
+type QuotaPolicy struct {
+	Default   QuotaLimits            `json:"default"`
+	Endpoints map[string]QuotaLimits `json:"endpoints,omitempty"`
+}
+
+// QuotasConfig declares the policy of every role; a user entry overrides
+// single limits of the role policy
+type QuotasConfig struct {
+	Roles map[string]QuotaPolicy `json:"roles"`
+	Users map[string]QuotaPolicy `json:"users,omitempty"`
+}
+
+// LoadQuotas reads quotas from the file or falls back to the built-in ones when path is empty
+func LoadQuotas(path string) (*QuotasConfig, error) {
+	data := defaultQuotas
+	if path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quotas: %w", err)
+		}
+		data = fileData
+	}
+
+	return ParseQuotas(data)
+}
+
+// ParseQuotas decodes and validates the quota configuration
+func ParseQuotas(data []byte) (*QuotasConfig, error) {
+	var config QuotasConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse quotas: %w", err)
+	}
+
+	validatePolicy := func(name string, policy QuotaPolicy) error {
+		if err := policy.Default.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for endpoint, limits := range policy.Endpoints {
+			if endpoint == quotaScopeAll {
+				return fmt.Errorf("%s: use \"default\" instead of the %q endpoint", name, quotaScopeAll)
+			}
+			if err := limits.validate(); err != nil {
+				return fmt.Errorf("%s, endpoint %s: %w", name, endpoint, err)
+			}
+		}
+		return nil
+	}
+
+	for role, policy := range config.Roles {
+		if !ValidUserRole(role) {
+			return nil, fmt.Errorf("quotas: unknown role %q", role)
+		}
+		if err := validatePolicy("role "+role, policy); err != nil {
+			return nil, fmt.Errorf("quotas: %w", err)
+		}
+	}
+	for userID, policy := range config.Users {
+		if err := validatePolicy("user "+userID, policy); err != nil {
+			return nil, fmt.Errorf("quotas: %w", err)
+		}
+	}
+
+	return &config, nil
+}
+
+// Limits returns the effective limits of the user with the role for a scope:
+// an endpoint name or "*" for all endpoints together
+func (c *QuotasConfig) Limits(userID, role, scope string) QuotaLimits {
+	pick := func(policy QuotaPolicy) QuotaLimits {
+		if scope == quotaScopeAll {
+			return policy.Default
+		}
+		return policy.Endpoints[scope]
+	}
+
+	limits := pick(c.Roles[role])
+	if user, ok := c.Users[userID]; ok {
+		limits = limits.merge(pick(user))
+	}
+	return limits
+}
+
+// quotaPeriodStart returns the start of the period containing now; days start at midnight UTC
+func quotaPeriodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == repo.QuotaPeriodMinute {
+		return now.Truncate(time.Minute)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// quotaPeriodLength returns the duration of the period
+func quotaPeriodLength(period string) time.Duration {
+	if period == repo.QuotaPeriodMinute {
+		return time.Minute
+	}
+	return 24 * time.Hour
+}
+
+// QuotaExceededError is returned when a request does not fit into a limit
+type QuotaExceededError struct {
+	Scope      string
+	Period     string
+	Metric     string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	scope := "all endpoints"
+	if e.Scope != quotaScopeAll {
+		scope = e.Scope
+	}
+	return fmt.Sprintf("quota exceeded: %d %s per %s for %s", e.Limit, e.Metric, e.Period, scope)
+}
+
+// QuotaStatus describes the most constrained request limit, reported in the RateLimit headers
+type QuotaStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+	Policies  []string // e.g. "3;w=86400"
+}
+
+func (s *QuotaStatus) add(limit, remaining int, reset time.Duration, period string) {
+	s.Policies = append(s.Policies, fmt.Sprintf("%d;w=%d", limit, int(quotaPeriodLength(period).Seconds())))
+	if len(s.Policies) == 1 || remaining < s.Remaining {
+		s.Limit = limit
+		s.Remaining = remaining
+		s.Reset = reset
+	}
+}
+
+// WriteHeaders sets the RateLimit-* headers
+func (s *QuotaStatus) WriteHeaders(w http.ResponseWriter) {
+	if s == nil || len(s.Policies) == 0 {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(s.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(s.Reset)))
+	w.Header().Set("RateLimit-Policy", strings.Join(s.Policies, ", "))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// QuotaManager enforces the quotas of authenticated callers
+type QuotaManager struct {
+	config     *QuotasConfig
+	repository repo.Repository
+	now        func() time.Time
+	lastPrune  atomic.Int64
+}
+
+// NewQuotaManager creates a manager for the configuration
+func NewQuotaManager(config *QuotasConfig, repository repo.Repository) *QuotaManager {
+	return &QuotaManager{config: config, repository: repository, now: time.Now}
+}
+
+// Config returns the quota configuration
+func (m *QuotaManager) Config() *QuotasConfig {
+	return m.config
+}
+
+// Limit is the middleware applying the quota of the endpoint; it must run after authentication.
+// The LLM tokens used by the handler are charged to the caller.
+func (m *QuotaManager) Limit(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		identity, ok := IdentityFromContext(ctx)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		status, err := m.Consume(ctx, m.repository, endpoint)
+
+		var exceeded *QuotaExceededError
+		if errors.As(err, &exceeded) {
+			retryAfter := strconv.Itoa(ceilSeconds(exceeded.RetryAfter))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Retry-After", retryAfter)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(exceeded.Limit))
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("RateLimit-Reset", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "error",
+				"message": fmt.Sprintf("Превышен лимит: %s. Попробуйте через %s.", exceeded.Error(), exceeded.RetryAfter.Round(time.Second)),
+			})
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Quota check failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		status.WriteHeaders(w)
+		next(w, r.WithContext(withQuotaSubject(ctx, identity.UserID, endpoint)))
+	}
+}
+
+// Consume counts one request of the authenticated caller against the limits
+// over all endpoints and of the endpoint. Token budgets are checked before the
+// request; the tokens themselves are charged after every LLM call. A rejected
+// request is not counted. Unauthenticated calls are not limited.
+func (m *QuotaManager) Consume(ctx context.Context, repository repo.Repository, endpoint string) (*QuotaStatus, error) {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	now := m.now().UTC()
+	m.prune(ctx, repository, now)
+
+	scopes := []string{quotaScopeAll, endpoint}
+
+	for _, scope := range scopes {
+		limits := m.config.Limits(identity.UserID, identity.Role, scope)
+		if limits.TokensPerDay == 0 {
+			continue
+		}
+
+		start := quotaPeriodStart(repo.QuotaPeriodDay, now)
+		counter := &repo.QuotaCounter{UserID: identity.UserID, Scope: scope, Period: repo.QuotaPeriodDay, PeriodStart: start}
+		if err := repository.AddQuotaUsage(ctx, counter); err != nil {
+			return nil, err
+		}
+		if counter.Tokens >= limits.TokensPerDay {
+			return nil, &QuotaExceededError{
+				Scope:      scope,
+				Period:     repo.QuotaPeriodDay,
+				Metric:     QuotaMetricTokens,
+				Limit:      limits.TokensPerDay,
+				RetryAfter: start.Add(quotaPeriodLength(repo.QuotaPeriodDay)).Sub(now),
+			}
+		}
+	}
+
+	var counted []repo.QuotaCounter
+	rollback := func() {
+		for _, counter := range counted {
+			counter.Requests = -1
+			if err := repository.AddQuotaUsage(context.WithoutCancel(ctx), &counter); err != nil {
+				log.Printf("Failed to roll back quota counter of %s: %v", counter.UserID, err)
+			}
+		}
+	}
+
+	status := &QuotaStatus{}
+	for _, scope := range scopes {
+		limits := m.config.Limits(identity.UserID, identity.Role, scope)
+		for _, period := range []string{repo.QuotaPeriodMinute, repo.QuotaPeriodDay} {
+			limit := limits.requests(period)
+			if limit == 0 {
+				continue
+			}
+
+			start := quotaPeriodStart(period, now)
+			counter := repo.QuotaCounter{UserID: identity.UserID, Scope: scope, Period: period, PeriodStart: start, Requests: 1}
+			if err := repository.AddQuotaUsage(ctx, &counter); err != nil {
+				rollback()
+				return nil, err
+			}
+			counted = append(counted, counter)
+
+			reset := start.Add(quotaPeriodLength(period)).Sub(now)
+			if counter.Requests > limit {
+				rollback()
+				return nil, &QuotaExceededError{Scope: scope, Period: period, Metric: QuotaMetricRequests, Limit: limit, RetryAfter: reset}
+			}
+			status.add(limit, limit-counter.Requests, reset, period)
+		}
+	}
+
+	return status, nil
+}
+
+// prune deletes old counters at most once an hour
+func (m *QuotaManager) prune(ctx context.Context, repository repo.Repository, now time.Time) {
+	last := m.lastPrune.Load()
+	if now.Unix()-last < int64(time.Hour.Seconds()) || !m.lastPrune.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+
+	if deleted, err := repository.DeleteQuotaCountersBefore(ctx, now.Add(-quotaRetention)); err != nil {
+		log.Printf("Failed to delete old quota counters: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d old quota counters", deleted)
+	}
+}
+
+// QuotaUsage is the usage of one limit
+type QuotaUsage struct {
+	Scope   string    `json:"scope"`
+	Period  string    `json:"period"`
+	Metric  string    `json:"metric"`
+	Used    int       `json:"used"`
+	Limit   int       `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Usage returns the usage of every configured limit of the user with the role
+// and the raw counters of the current periods
+func (m *QuotaManager) Usage(ctx context.Context, repository repo.Repository, userID, role string) ([]QuotaUsage, []*repo.QuotaCounter, error) {
+	now := m.now().UTC()
+	counters, err := repository.GetQuotaCounters(ctx, userID, quotaPeriodStart(repo.QuotaPeriodDay, now))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := make(map[string]*repo.QuotaCounter)
+	var active []*repo.QuotaCounter
+	for _, counter := range counters {
+		if !counter.PeriodStart.Equal(quotaPeriodStart(counter.Period, now)) {
+			continue
+		}
+		current[counter.Scope+"/"+counter.Period] = counter
+		active = append(active, counter)
+	}
+
+	scopes := []string{quotaScopeAll}
+	seen := map[string]bool{quotaScopeAll: true}
+	addScopes := func(policy QuotaPolicy) {
+		for endpoint := range policy.Endpoints {
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				scopes = append(scopes, endpoint)
+			}
+		}
+	}
+	addScopes(m.config.Roles[role])
+	addScopes(m.config.Users[userID])
+
+	usage := []QuotaUsage{}
+	for _, scope := range scopes {
+		limits := m.config.Limits(userID, role, scope)
+		for _, period := range []string{repo.QuotaPeriodMinute, repo.QuotaPeriodDay} {
+			resetAt := quotaPeriodStart(period, now).Add(quotaPeriodLength(period))
+			counter := current[scope+"/"+period]
+
+			if limit := limits.requests(period); limit > 0 {
+				used := 0
+				if counter != nil {
+					used = counter.Requests
+				}
+				usage = append(usage, QuotaUsage{Scope: scope, Period: period, Metric: QuotaMetricRequests, Used: used, Limit: limit, ResetAt: resetAt})
+			}
+			if period == repo.QuotaPeriodDay && limits.TokensPerDay > 0 {
+				used := 0
+				if counter != nil {
+					used = counter.Tokens
+				}
+				usage = append(usage, QuotaUsage{Scope: scope, Period: period, Metric: QuotaMetricTokens, Used: used, Limit: limits.TokensPerDay, ResetAt: resetAt})
+			}
+		}
+	}
+
+	return usage, active, nil
+}
+
+// quotaSubject is the user and endpoint the LLM tokens of a context are charged to
+type quotaSubject struct {
+	userID   string
+	endpoint string
+}
+
+type quotaSubjectContextKey struct{}
+
+func withQuotaSubject(ctx context.Context, userID, endpoint string) context.Context {
+	return context.WithValue(ctx, quotaSubjectContextKey{}, quotaSubject{userID: userID, endpoint: endpoint})
+}
+
+// subjectUserID returns the user the context acts for: the caller of a request or the owner of a job
+func subjectUserID(ctx context.Context) string {
+	subject, _ := ctx.Value(quotaSubjectContextKey{}).(quotaSubject)
+	return subject.userID
+}
+
+// chargeTokens adds LLM tokens to the daily counters of the user the context belongs to
+func chargeTokens(ctx context.Context, repository repo.Repository, tokens int) {
+	subject, ok := ctx.Value(quotaSubjectContextKey{}).(quotaSubject)
+	if !ok || subject.userID == "" || tokens <= 0 {
+		return
+	}
+
+	// The request may already be cancelled, the tokens are spent anyway
+	ctx = context.WithoutCancel(ctx)
+	start := quotaPeriodStart(repo.QuotaPeriodDay, time.Now())
+	for _, scope := range []string{quotaScopeAll, subject.endpoint} {
+		if scope == "" {
+			continue
+		}
+		counter := &repo.QuotaCounter{UserID: subject.userID, Scope: scope, Period: repo.QuotaPeriodDay, PeriodStart: start, Tokens: tokens}
+		if err := repository.AddQuotaUsage(ctx, counter); err != nil {
+			log.Printf("Failed to charge %d tokens to %s: %v", tokens, subject.userID, err)
+		}
+	}
+}
+
+// meteredProvider charges the tokens of every completion to the caller's quota
+type meteredProvider struct {
+	LLMProvider
+	repository repo.Repository
+}
+
+func (p meteredProvider) Generate(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	resp, err := p.LLMProvider.Generate(ctx, req)
+	p.charge(ctx, resp)
+	return resp, err
+}
+
+func (p meteredProvider) Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	resp, err := p.LLMProvider.Chat(ctx, req)
+	p.charge(ctx, resp)
+	return resp, err
+}
+
+func (p meteredProvider) Stream(ctx context.Context, req ProviderRequest, onToken func(token string) error) (*LLMResponse, error) {
+	resp, err := p.LLMProvider.Stream(ctx, req, onToken)
+	p.charge(ctx, resp)
+	return resp, err
+}
+
+func (p meteredProvider) charge(ctx context.Context, resp *LLMResponse) {
+	if resp != nil {
+		chargeTokens(ctx, p.repository, resp.PromptEvalCount+resp.EvalCount)
+	}
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-web-service-backend/repo"
+)
+
+const testQuotas = `{
+  "roles": {
+    "builder": {
+      "default": {"requests_per_minute": 10, "requests_per_day": 100, "tokens_per_day": 1000},
+      "endpoints": {"build": {"requests_per_minute": 2, "requests_per_day": 3}}
+    }
+  },
+  "users": {
+    "vip": {"endpoints": {"build": {"requests_per_day": 50}}}
+  }
+}`
+
+func newTestQuotaManager(t *testing.T, repository repo.Repository, now *time.Time) *QuotaManager {
+	t.Helper()
+
+	config, err := ParseQuotas([]byte(testQuotas))
+	if err != nil {
+		t.Fatalf("ParseQuotas failed: %v", err)
+	}
+	manager := NewQuotaManager(config, repository)
+	manager.now = func() time.Time { return *now }
+	return manager
+}
+
+func TestParseQuotas(t *testing.T) {
+	if _, err := ParseQuotas(defaultQuotas); err != nil {
+		t.Fatalf("built-in quotas are invalid: %v", err)
+	}
+
+	invalid := map[string]string{
+		"unknownRole":   `{"roles": {"root": {"default": {}}}}`,
+		"negativeLimit": `{"roles": {"viewer": {"default": {"requests_per_day": -1}}}}`,
+		"starEndpoint":  `{"roles": {"viewer": {"endpoints": {"*": {}}}}}`,
+	}
+	for name, data := range invalid {
+		if _, err := ParseQuotas([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestQuotasConfigLimits(t *testing.T) {
+	config, err := ParseQuotas([]byte(testQuotas))
+	if err != nil {
+		t.Fatalf("ParseQuotas failed: %v", err)
+	}
+
+	if got := config.Limits("alice", UserRoleBuilder, "build"); got != (QuotaLimits{RequestsPerMinute: 2, RequestsPerDay: 3}) {
+		t.Errorf("builder build limits = %+v", got)
+	}
+	if got := config.Limits("vip", UserRoleBuilder, "build"); got != (QuotaLimits{RequestsPerMinute: 2, RequestsPerDay: 50}) {
+		t.Errorf("user override = %+v, want only requests_per_day replaced", got)
+	}
+	if got := config.Limits("alice", UserRoleViewer, quotaScopeAll); got != (QuotaLimits{}) {
+		t.Errorf("role without policy = %+v, want unlimited", got)
+	}
+}
+
+func TestQuotaManagerConsume(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	now := time.Date(2026, 3, 10, 12, 0, 30, 0, time.UTC)
+	manager := newTestQuotaManager(t, repository, &now)
+	ctx := WithIdentity(context.Background(), &Identity{UserID: "alice", Role: UserRoleBuilder})
+
+	for i := 0; i < 2; i++ {
+		if _, err := manager.Consume(ctx, repository, "build"); err != nil {
+			t.Fatalf("build %d: %v", i+1, err)
+		}
+	}
+
+	_, err := manager.Consume(ctx, repository, "build")
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) || exceeded.Period != repo.QuotaPeriodMinute || exceeded.RetryAfter != 30*time.Second {
+		t.Fatalf("third build in a minute: %v, want the minute limit with 30s retry", err)
+	}
+
+	// Rejected requests are not counted, so the day limit still allows one more build
+	now = now.Add(time.Minute)
+	if _, err := manager.Consume(ctx, repository, "build"); err != nil {
+		t.Fatalf("build in the next minute: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := manager.Consume(ctx, repository, "build"); !errors.As(err, &exceeded) || exceeded.Period != repo.QuotaPeriodDay {
+		t.Fatalf("fourth build of the day: %v, want the day limit", err)
+	}
+
+	// Other endpoints only count against the default limits
+	status, err := manager.Consume(ctx, repository, "ask")
+	if err != nil {
+		t.Fatalf("ask: %v", err)
+	}
+	if status.Limit != 10 || status.Remaining != 9 {
+		t.Errorf("ask status = %+v, want 9 of 10 per minute left", status)
+	}
+
+	if _, err := repository.ResetQuotaCounters(ctx, "alice", "build"); err != nil {
+		t.Fatalf("ResetQuotaCounters failed: %v", err)
+	}
+	if _, err := manager.Consume(ctx, repository, "build"); err != nil {
+		t.Fatalf("build after reset: %v", err)
+	}
+
+	if status, err := manager.Consume(context.Background(), repository, "build"); status != nil || err != nil {
+		t.Errorf("unauthenticated call = %+v %v, want no limits", status, err)
+	}
+}
+
+func TestQuotaManagerTokens(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	now := time.Now()
+	manager := newTestQuotaManager(t, repository, &now)
+	ctx := WithIdentity(context.Background(), &Identity{UserID: "alice", Role: UserRoleBuilder})
+
+	provider := meteredProvider{&scriptedProvider{responses: []string{"ok"}}, repository}
+	// The scripted provider reports one prompt token per character
+	if _, err := provider.Generate(withQuotaSubject(ctx, "alice", "ask"), ProviderRequest{Prompt: strings.Repeat("x", 1000)}); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	_, err := manager.Consume(ctx, repository, "ask")
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) || exceeded.Metric != QuotaMetricTokens {
+		t.Fatalf("after spending the token budget: %v, want the token limit", err)
+	}
+
+	usage, _, err := manager.Usage(ctx, repository, "alice", UserRoleBuilder)
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	for _, u := range usage {
+		if u.Scope == quotaScopeAll && u.Metric == QuotaMetricTokens && u.Used < 1000 {
+			t.Errorf("token usage = %+v, want at least 1000", u)
+		}
+	}
+}
+
+func TestQuotaManagerLimitMiddleware(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+
+	now := time.Now()
+	manager := newTestQuotaManager(t, repository, &now)
+	handler := manager.Limit("build", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/build", nil)
+		req = req.WithContext(WithIdentity(req.Context(), &Identity{UserID: "bob", Role: UserRoleBuilder}))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := request()
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("first request: %d", rec.Code)
+	}
+	if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != "1" || rec.Header().Get("RateLimit-Policy") == "" {
+		t.Errorf("RateLimit headers = %v", rec.Header())
+	}
+
+	request()
+	rec = request()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: %d, Retry-After %q, want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed requirements-schema.json
+var defaultRequirementsSchema []byte
+
+// RequirementSlot describes a single piece of information gathered from the user
+type RequirementSlot struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Description string   `json:"description"`
+	Required    bool     `json:"required,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`    // regular expression the value must match
+	Options     []string `json:"options,omitempty"`    // allowed values, compared case-insensitively
+	MaxLength   int      `json:"max_length,omitempty"` // in characters, 0 means no limit
+	Multiple    bool     `json:"multiple,omitempty"`   // new values are appended instead of replacing the old one
+
+	pattern *regexp.Regexp
+}
+
+// RequirementsSchema is the declarative list of slots gathered by /ask
+type RequirementsSchema struct {
+	Slots []RequirementSlot `json:"slots"`
+}
+
+// LoadRequirementsSchema reads the schema from the file, files.requirements_schema,
+// or falls back to the built-in schema when path is empty
+func LoadRequirementsSchema(path string) (*RequirementsSchema, error) {
+	data := defaultRequirementsSchema
+	if path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read requirements schema: %w", err)
+		}
+		data = fileData
+	}
+
+	return ParseRequirementsSchema(data)
+}
+
+// ParseRequirementsSchema decodes and validates a JSON schema
+func ParseRequirementsSchema(data []byte) (*RequirementsSchema, error) {
+	var schema RequirementsSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse requirements schema: %w", err)
+	}
+
+	if len(schema.Slots) == 0 {
+		return nil, fmt.Errorf("requirements schema has no slots")
+	}
+
+	seen := make(map[string]bool)
+	for i := range schema.Slots {
+		slot := &schema.Slots[i]
+		if slot.Name == "" {
+			return nil, fmt.Errorf("requirements slot #%d has no name", i+1)
+		}
+		if seen[slot.Name] {
+			return nil, fmt.Errorf("requirements slot %q is declared twice", slot.Name)
+		}
+		seen[slot.Name] = true
+
+		if slot.Label == "" {
+			slot.Label = slot.Name
+		}
+		if slot.Pattern != "" {
+			re, err := regexp.Compile(slot.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("requirements slot %q has invalid pattern: %w", slot.Name, err)
+			}
+			slot.pattern = re
+		}
+	}
+
+	return &schema, nil
+}
+
+// Slot returns the slot with the given name
+func (s *RequirementsSchema) Slot(name string) (*RequirementSlot, bool) {
+	for i := range s.Slots {
+		if s.Slots[i].Name == name {
+			return &s.Slots[i], true
+		}
+	}
+	return nil, false
+}
+
+// MissingSlots returns the required slots that have no value yet
+func (s *RequirementsSchema) MissingSlots(requirements Requirements) []RequirementSlot {
+	var missing []RequirementSlot
+	for _, slot := range s.Slots {
+		if slot.Required && strings.TrimSpace(requirements[slot.Name]) == "" {
+			missing = append(missing, slot)
+		}
+	}
+	return missing
+}
+
+// IsComplete reports whether all required slots are filled
+func (s *RequirementsSchema) IsComplete(requirements Requirements) bool {
+	return len(s.MissingSlots(requirements)) == 0
+}
+
+// Validate normalizes a value and checks it against the slot constraints
+func (slot *RequirementSlot) Validate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("value is empty")
+	}
+
+	if slot.MaxLength > 0 && utf8.RuneCountInString(value) > slot.MaxLength {
+		return "", fmt.Errorf("value is longer than %d characters", slot.MaxLength)
+	}
+
+	if slot.pattern != nil && !slot.pattern.MatchString(value) {
+		return "", fmt.Errorf("value does not match pattern %s", slot.Pattern)
+	}
+
+	if len(slot.Options) > 0 {
+		for _, option := range slot.Options {
+			if strings.EqualFold(option, value) {
+				return option, nil
+			}
+		}
+		return "", fmt.Errorf("value must be one of: %s", strings.Join(slot.Options, ", "))
+	}
+
+	return value, nil
+}
+
+// Apply validates extracted values and merges them into the requirements.
+// It returns the reasons why some values were rejected.
+func (s *RequirementsSchema) Apply(requirements Requirements, values map[string]string) []string {
+	var rejected []string
+	for name, raw := range values {
+		slot, ok := s.Slot(name)
+		if !ok {
+			rejected = append(rejected, fmt.Sprintf("%s: unknown slot", name))
+			continue
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		value, err := slot.Validate(raw)
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		if slot.Multiple && requirements[name] != "" {
+			requirements[name] += "; " + value
+		} else {
+			requirements[name] = value
+		}
+	}
+	return rejected
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRequirementsSchema(t *testing.T) {
+	data, err := os.ReadFile("../requirements-schema.example.json")
+	if err != nil {
+		t.Fatalf("Failed to read example schema: %v", err)
+	}
+
+	schema, err := ParseRequirementsSchema(data)
+	if err != nil {
+		t.Fatalf("Failed to parse example schema: %v", err)
+	}
+
+	t.Run("applyValidates", func(t *testing.T) {
+		requirements := Requirements{}
+		rejected := schema.Apply(requirements, map[string]string{
+			"site_type":     " лендинг ",
+			"language":      "Английский",
+			"contact_email": "not an email",
+			"unknown":       "value",
+		})
+
+		if requirements["site_type"] != "лендинг" {
+			t.Errorf("site_type = %q, want trimmed value", requirements["site_type"])
+		}
+		if requirements["language"] != "английский" {
+			t.Errorf("language = %q, want the canonical option", requirements["language"])
+		}
+		if _, ok := requirements["contact_email"]; ok {
+			t.Errorf("invalid contact_email was accepted")
+		}
+		if len(rejected) != 2 {
+			t.Errorf("rejected = %v, want contact_email and unknown", rejected)
+		}
+	})
+
+	t.Run("multipleAppends", func(t *testing.T) {
+		requirements := Requirements{"sections": "о нас"}
+		schema.Apply(requirements, map[string]string{"sections": "контакты"})
+
+		if requirements["sections"] != "о нас; контакты" {
+			t.Errorf("sections = %q", requirements["sections"])
+		}
+	})
+
+	t.Run("missingSlots", func(t *testing.T) {
+		requirements := Requirements{"site_type": "блог", "language": "русский"}
+
+		missing := schema.MissingSlots(requirements)
+		if len(missing) != 1 || missing[0].Name != "target_audience" {
+			t.Errorf("missing = %+v, want target_audience", missing)
+		}
+		if schema.IsComplete(requirements) {
+			t.Errorf("requirements reported complete")
+		}
+	})
+
+	t.Run("invalidSchema", func(t *testing.T) {
+		cases := map[string]string{
+			"empty":     `{"slots": []}`,
+			"noName":    `{"slots": [{"label": "x"}]}`,
+			"duplicate": `{"slots": [{"name": "a"}, {"name": "a"}]}`,
+			"pattern":   `{"slots": [{"name": "a", "pattern": "("}]}`,
+		}
+		for name, data := range cases {
+			if _, err := ParseRequirementsSchema([]byte(data)); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
+
+func TestParseExtractedRequirements(t *testing.T) {
+	values, err := parseExtractedRequirements("Вот результат:\n```json\n{\"site_type\": \"магазин\", \"sections\": [\"каталог\", \"корзина\"], \"palette\": null}\n```")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if values["site_type"] != "магазин" {
+		t.Errorf("site_type = %q", values["site_type"])
+	}
+	if values["sections"] != "каталог, корзина" {
+		t.Errorf("sections = %q", values["sections"])
+	}
+	if _, ok := values["palette"]; ok {
+		t.Errorf("null palette should be skipped")
+	}
+
+	if _, err := parseExtractedRequirements("не знаю"); err == nil {
+		t.Errorf("expected error for response without JSON")
+	}
+}
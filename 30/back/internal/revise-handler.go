@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+
+	"chat-web-service-backend/repo"
+)
+
+// ReviseRequest asks to change the generated site of a project
+type ReviseRequest struct {
+	Instruction string `json:"instruction" description:"Что изменить на сайте, например: сделай шапку зелёной"`
+	From        string `json:"from,omitempty" description:"Ревизия, от которой идут изменения: хеш или его префикс, по умолчанию текущий сайт проекта"`
+	Page        string `json:"page,omitempty" description:"Страница многостраничного сайта, которую нужно изменить, по умолчанию index.html"`
+	UserID      string `json:"user_id,omitempty" description:"Идентификатор пользователя для квот и истории"`
+}
+
+// ReviseJobPayload is the revise job as it is queued; Parent is the full hash of the revision,
+// empty for the artifact of the project as it is when the job runs, and Page the revised page
+// when the revision is a multi-page site
+type ReviseJobPayload struct {
+	ProjectID   int64  `json:"project_id"`
+	Parent      string `json:"parent"`
+	Instruction string `json:"instruction"`
+	Page        string `json:"page,omitempty"`
+}
+
+// ReviseResponse is the result of a revise job
+type ReviseResponse struct {
+	Status     string                `json:"status"`
+	Message    string                `json:"message"`
+	ProjectID  int64                 `json:"project_id"`
+	Revision   *repo.ProjectRevision `json:"revision"`
+	PreviewURL string                `json:"preview_url,omitempty"`
+
+	// Page is the revised page of a multi-page site, BrokenLinks the internal links
+	// of the revised page to missing pages, removed like on a build
+	Page        string       `json:"page,omitempty"`
+	BrokenLinks []BrokenLink `json:"broken_links,omitempty"`
+
+	// GuardFindings are the guard rules that fired on the instruction and the revised HTML
+	GuardFindings []*repo.GuardFinding `json:"guard_findings,omitempty"`
+}
+
+// ReviseHandler queues a job that changes the site of the project as the instruction asks,
+// starting from ?from= or the current artifact. Multi-page sites are revised one page at a
+// time, index.html unless page names another one. The result is available via GET /jobs/{id}.
+func (a *App) ReviseHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	project, ok := a.projectFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	var req ReviseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Instruction == "" {
+		http.Error(w, "instruction is required", http.StatusBadRequest)
+		return
+	}
+	if project.FilePath == "" {
+		http.Error(w, "Project has no generated site", http.StatusConflict)
+		return
+	}
+
+	var parent *repo.ProjectRevision
+	if req.From == "" {
+		// The current artifact is saved as a revision, so the new one can link to it
+		rev, err := saveRevision(ctx, a.Repository, project.ID, project.FilePath, repo.RevisionSourceBuild)
+		if os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("File %s of the project not found", project.FilePath), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save revision: %v", err), http.StatusInternalServerError)
+			return
+		}
+		parent = rev
+	} else {
+		revisions, err := a.Repository.GetProjectRevisions(ctx, project.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get revisions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		parent, err = findRevision(revisions, req.From)
+		if errors.Is(err, errRevisionNotFound) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := revisePage(parent.FilePath, req.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Without ?from= the job revises the artifact as the jobs queued before have left it
+	payload := ReviseJobPayload{ProjectID: project.ID, Instruction: req.Instruction, Page: page}
+	if req.From != "" {
+		payload.Parent = parent.Hash
+	}
+	job, err := a.Jobs.Enqueue(ctx, JobTypeRevise, resolveUserID(ctx, req.UserID), payload)
+	if errors.Is(err, ErrJobQueueFull) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Очередь сборки переполнена, попробуйте позже", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, ErrJobQueueStopped) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to queue revise: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	setAuditTarget(ctx, "job:"+job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(NewJobResponse(job))
+}
+
+// revisePage checks the requested page against the revision: a page of a multi-page site,
+// index.html by default, or none for a single-file site
+func revisePage(revision, page string) (string, error) {
+	info, err := os.Stat(revision)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		if page != "" {
+			return "", fmt.Errorf("page can only be set for multi-page sites")
+		}
+		return "", nil
+	}
+
+	if page == "" {
+		page = siteIndex
+	}
+	files, err := readSiteFiles(revision)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := files[page]; !ok || path.Ext(page) != ".html" {
+		return "", fmt.Errorf("page %s is not a page of the site", page)
+	}
+	return page, nil
+}
+
+// projectLock serialises the revise jobs of one project
+type projectLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockProject locks the project for a revise job until the returned function is called.
+// Without it two jobs read the same artifact and the one that writes last drops the
+// edit of the other.
+func (a *App) lockProject(projectID int64) (unlock func()) {
+	a.projectsMu.Lock()
+	if a.projects == nil {
+		a.projects = make(map[int64]*projectLock)
+	}
+	lock, ok := a.projects[projectID]
+	if !ok {
+		lock = &projectLock{}
+		a.projects[projectID] = lock
+	}
+	lock.refs++
+	a.projectsMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		a.projectsMu.Lock()
+		defer a.projectsMu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(a.projects, projectID)
+		}
+	}
+}
+
+// runReviseJob screens the instruction, revises the parent revision through the builder,
+// sanitises the result, writes it to the project's artifact and saves it as a revision
+// linked to its parent. Of a multi-page site only the page is revised, the other files
+// are taken from the parent revision as they are. The revise jobs of a project run one
+// after another, each starting from the artifact the previous one has written.
+func (a *App) runReviseJob(ctx context.Context, repository repo.Repository, job *repo.Job, progress func(stage string)) (interface{}, error) {
+	var payload ReviseJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("invalid revise payload: %w", err)
+	}
+	defer a.lockProject(payload.ProjectID)()
+
+	project, err := repository.GetProject(ctx, payload.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project %d: %w", payload.ProjectID, err)
+	}
+	var parent *repo.ProjectRevision
+	if payload.Parent == "" {
+		// The current artifact is saved as a revision, so the new one can link to it
+		parent, err = saveRevision(ctx, repository, project.ID, project.FilePath, repo.RevisionSourceBuild)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save revision: %w", err)
+		}
+	} else {
+		revisions, err := repository.GetProjectRevisions(ctx, project.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get revisions: %w", err)
+		}
+		parent, err = findRevision(revisions, payload.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("revision %s: %w", payload.Parent, err)
+		}
+	}
+	var files map[string][]byte
+	var document []byte
+	if payload.Page != "" {
+		files, err = readSiteFiles(parent.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if document, ok = files[payload.Page]; !ok {
+			return nil, fmt.Errorf("page %s is not a page of revision %s", payload.Page, parent.Hash)
+		}
+	} else {
+		document, err = os.ReadFile(parent.FilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	findings, err := a.screenInput(ctx, "instruction", payload.Instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	builderClient, err := a.NewWebsiteBuilderClient()
+	if err != nil {
+		return nil, err
+	}
+	builderClient.Progress = progress
+	websiteHTML, trace, err := builderClient.ReviseWebsite(ctx, string(document), payload.Instruction)
+	// Шаги сохраняются и при ошибке, чтобы было видно, что ответила модель;
+	// нумерация продолжает шаги сборки и прошлых изменений проекта
+	if previous, stepsErr := repository.GetGenerationSteps(ctx, project.ID); stepsErr == nil {
+		for _, step := range trace {
+			step.Step += len(previous)
+		}
+	}
+	if traceErr := saveGenerationTrace(ctx, repository, project.ID, trace); traceErr != nil {
+		log.Printf("Failed to save generation trace of project %d: %v", project.ID, traceErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка изменения сайта: %w", err)
+	}
+
+	// Отмененное во время генерации изменение не должно попасть в проект
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	websiteHTML, outputFindings, err := a.sanitizeHTML(websiteHTML)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, outputFindings...)
+
+	var brokenLinks []BrokenLink
+	progress(BuildStageSaving)
+	if files != nil {
+		files[payload.Page] = []byte(websiteHTML)
+		brokenLinks, err = validatePageLinks(files, payload.Page)
+		if err != nil {
+			return nil, err
+		}
+		if err := replaceSiteFiles(project.FilePath, files); err != nil {
+			return nil, fmt.Errorf("Ошибка сохранения сайта: %w", err)
+		}
+	} else if err := os.WriteFile(project.FilePath, []byte(websiteHTML), 0644); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения файла: %w", err)
+	}
+	rev, err := storeRevision(ctx, repository, project.FilePath, &repo.ProjectRevision{
+		ProjectID:   project.ID,
+		Source:      repo.RevisionSourceRevise,
+		Parent:      parent.Hash,
+		Instruction: payload.Instruction,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := saveGuardFindings(ctx, repository, project.ID, findings); err != nil {
+		log.Printf("Failed to save guard findings of project %d: %v", project.ID, err)
+	}
+
+	return ReviseResponse{
+		Status:        "success",
+		Message:       "Сайт изменён, новая ревизия сохранена",
+		ProjectID:     project.ID,
+		Revision:      rev,
+		PreviewURL:    a.previewURL(project.ID),
+		Page:          payload.Page,
+		BrokenLinks:   brokenLinks,
+		GuardFindings: findings,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"chat-web-service-backend/repo"
+)
+
+// GenerationStepRevise is the name of the ReviseWebsite steps stored in the trace
+const GenerationStepRevise = "revise"
+
+// Pages up to reviseWholePageChars are sent to the builder whole and come back whole.
+// Larger pages would not fit the context window together with the answer, so they are
+// split into chunks of at most reviseChunkChars and changed by search/replace edits.
+const (
+	reviseWholePageChars = 16000
+	reviseChunkChars     = 8000
+)
+
+// errReviseNoChanges is returned when the builder left the page as it was
+var errReviseNoChanges = errors.New("the builder made no changes to the page")
+
+// reviseEditPattern matches a search/replace edit of the chunked revise
+var reviseEditPattern = regexp.MustCompile(`(?s)<<<<<<< SEARCH\n(.*?)\n?=======\n(.*?)\n?>>>>>>> REPLACE`)
+
+// reviseEdit replaces the only occurrence of Search in a chunk with Replace
+type reviseEdit struct {
+	Search  string
+	Replace string
+}
+
+// ReviseWebsite applies the instruction to an existing page. It returns the revised HTML
+// and the trace of all steps, which is also returned on failure for debugging.
+func (c *WebsiteBuilderClient) ReviseWebsite(ctx context.Context, document, instruction string) (string, []*repo.GenerationStep, error) {
+	var trace []*repo.GenerationStep
+	c.reportProgress(BuildStageGenerating)
+
+	var revised string
+	var err error
+	if len(document) <= reviseWholePageChars {
+		revised, err = c.reviseWholePage(ctx, &trace, document, instruction)
+	} else {
+		revised, err = c.reviseChunks(ctx, &trace, document, instruction)
+	}
+	if err != nil {
+		return "", trace, err
+	}
+
+	if strings.TrimSpace(revised) == strings.TrimSpace(document) {
+		return "", trace, errReviseNoChanges
+	}
+	return revised, trace, nil
+}
+
+// reviseWholePage sends the whole page and takes the whole revised page back
+func (c *WebsiteBuilderClient) reviseWholePage(ctx context.Context, trace *[]*repo.GenerationStep, document, instruction string) (string, error) {
+	req := &WebsiteRequest{
+		Message: fmt.Sprintf("Что нужно изменить: %s\n\nТекущий HTML сайта:\n%s", instruction, document),
+		System: "Ты — web-разработчик. Тебе дают готовый HTML-документ сайта и просьбу пользователя что-то в нём изменить.\n\n" +
+			"Правила:\n" +
+			"- Внеси только те изменения, о которых просит пользователь, остальное оставь как есть\n" +
+			"- Сохрани стиль, структуру и тексты страницы, которых просьба не касается\n" +
+			"- Возвращай только итоговый валидный HTML-документ целиком\n" +
+			"- Никаких markdown-блоков, пояснений или комментариев",
+	}
+
+	resp, err := c.tracedSend(ctx, trace, GenerationStepRevise, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to revise website: %w", err)
+	}
+
+	revised := strings.TrimSpace(extractHTMLFromResponse(strings.TrimSpace(resp.Response)))
+	if revised == "" {
+		return "", fmt.Errorf("received empty revision response")
+	}
+	return revised, nil
+}
+
+// reviseChunks sends the page chunk by chunk and applies the edits the builder returns for
+// every chunk. Edits that do not match their chunk are skipped and recorded in the trace.
+func (c *WebsiteBuilderClient) reviseChunks(ctx context.Context, trace *[]*repo.GenerationStep, document, instruction string) (string, error) {
+	chunks := splitChunks(document, reviseChunkChars)
+	system := "Ты — web-разработчик. Пользователь просит изменить готовый сайт. Страница большая, поэтому ты видишь только один фрагмент её HTML.\n\n" +
+		"Внеси в этот фрагмент изменения, которых требует просьба. Ответ — только блоки правок такого вида:\n" +
+		"<<<<<<< SEARCH\n" +
+		"точный текст из фрагмента\n" +
+		"=======\n" +
+		"новый текст\n" +
+		">>>>>>> REPLACE\n\n" +
+		"Правила:\n" +
+		"- Текст в SEARCH копируй из фрагмента символ в символ, он должен встречаться во фрагменте один раз\n" +
+		"- Делай правки как можно короче, не повторяй неизменные части фрагмента\n" +
+		"- Если этот фрагмент менять не нужно, ответь NO CHANGES\n" +
+		"- Никаких пояснений"
+
+	applied := 0
+	for i, chunk := range chunks {
+		req := &WebsiteRequest{
+			Message: fmt.Sprintf("Что нужно изменить: %s\n\nФрагмент %d из %d:\n%s", instruction, i+1, len(chunks), chunk),
+			System:  system,
+		}
+		resp, err := c.tracedSend(ctx, trace, GenerationStepRevise, req)
+		if err != nil {
+			return "", fmt.Errorf("failed to revise chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+
+		revised, count, skipped := applyEdits(chunk, parseEdits(resp.Response))
+		if skipped > 0 {
+			step := (*trace)[len(*trace)-1]
+			step.Error = fmt.Sprintf("%d of %d edits do not match the chunk and were skipped", skipped, count)
+		}
+		chunks[i] = revised
+		applied += count - skipped
+	}
+
+	if applied == 0 {
+		return "", errReviseNoChanges
+	}
+	return strings.Join(chunks, ""), nil
+}
+
+// splitChunks splits the document into chunks of at most limit bytes that join back into it.
+// Chunks end at a line break where possible, otherwise after a tag, so minified pages split too,
+// and never inside a multi-byte character.
+func splitChunks(document string, limit int) []string {
+	var chunks []string
+	for len(document) > limit {
+		cut := strings.LastIndex(document[:limit], "\n") + 1
+		if cut == 0 {
+			cut = strings.LastIndex(document[:limit], ">") + 1
+		}
+		if cut == 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(document[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				// The limit is smaller than the first character
+				_, cut = utf8.DecodeRuneInString(document)
+			}
+		}
+		chunks = append(chunks, document[:cut])
+		document = document[cut:]
+	}
+	if document != "" {
+		chunks = append(chunks, document)
+	}
+	return chunks
+}
+
+// parseEdits extracts the search/replace edits from a builder response
+func parseEdits(response string) []reviseEdit {
+	response = strings.ReplaceAll(response, "\r\n", "\n")
+
+	var edits []reviseEdit
+	for _, match := range reviseEditPattern.FindAllStringSubmatch(response, -1) {
+		if match[1] == "" {
+			continue
+		}
+		edits = append(edits, reviseEdit{Search: match[1], Replace: match[2]})
+	}
+	return edits
+}
+
+// applyEdits applies the edits whose search text occurs exactly once in the chunk, in order.
+// It returns the chunk, the number of edits and the number of skipped ones.
+func applyEdits(chunk string, edits []reviseEdit) (string, int, int) {
+	skipped := 0
+	for _, edit := range edits {
+		if strings.Count(chunk, edit.Search) != 1 {
+			skipped++
+			continue
+		}
+		chunk = strings.Replace(chunk, edit.Search, edit.Replace, 1)
+	}
+	return chunk, len(edits), skipped
+}
@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+func TestReviseWebsite(t *testing.T) {
+	t.Run("wholePage", func(t *testing.T) {
+		provider := &scriptedProvider{responses: []string{"```html\n<html><header style=\"color: green\">Кофейня</header></html>\n```"}}
+		client := &WebsiteBuilderClient{Provider: provider}
+
+		html, trace, err := client.ReviseWebsite(context.Background(), "<html><header>Кофейня</header></html>", "сделай шапку зелёной")
+		if err != nil || html != `<html><header style="color: green">Кофейня</header></html>` {
+			t.Fatalf("ReviseWebsite = %q, %v", html, err)
+		}
+		if len(trace) != 1 || trace[0].Name != GenerationStepRevise || !strings.Contains(provider.requests[0].Prompt, "<header>Кофейня</header>") {
+			t.Errorf("trace = %+v", trace)
+		}
+	})
+
+	t.Run("chunksWithoutBreaks", func(t *testing.T) {
+		// Cyrillic text without line breaks or tags, 2 bytes a letter, cut at odd limits
+		document := strings.Repeat("Кофейня у моря ", 1000)
+		for _, limit := range []int{reviseChunkChars + 1, 101, 1} {
+			chunks := splitChunks(document, limit)
+			if strings.Join(chunks, "") != document {
+				t.Fatalf("splitChunks(%d) returned chunks that do not join into the text", limit)
+			}
+			for _, chunk := range chunks {
+				if !utf8.ValidString(chunk) || len(chunk) > max(limit, 2) {
+					t.Fatalf("splitChunks(%d) returned a chunk of %d bytes cut inside a letter: ...%q", limit, len(chunk), chunk[max(len(chunk)-8, 0):])
+				}
+			}
+		}
+	})
+
+	t.Run("chunks", func(t *testing.T) {
+		var page strings.Builder
+		page.WriteString("<html><head><style>header { color: black; }</style></head><body>\n")
+		for i := 0; page.Len() < 2*reviseChunkChars; i++ {
+			fmt.Fprintf(&page, "<section id=\"s%d\"><p>Раздел %d</p></section>\n", i, i)
+		}
+		page.WriteString("<footer>Контакты</footer></body></html>\n")
+		document := page.String()
+
+		chunks := splitChunks(document, reviseChunkChars)
+		if len(chunks) < 3 || strings.Join(chunks, "") != document {
+			t.Fatalf("splitChunks returned %d chunks that do not join into the page", len(chunks))
+		}
+		for _, chunk := range chunks {
+			if len(chunk) > reviseChunkChars || !strings.HasSuffix(chunk, "\n") {
+				t.Fatalf("chunk of %d bytes does not end at a line", len(chunk))
+			}
+		}
+
+		responses := []string{
+			"<<<<<<< SEARCH\nheader { color: black; }\n=======\nheader { color: green; }\n>>>>>>> REPLACE\n" +
+				"<<<<<<< SEARCH\nnot in the chunk\n=======\nanything\n>>>>>>> REPLACE",
+		}
+		for range chunks[1 : len(chunks)-1] {
+			responses = append(responses, "NO CHANGES")
+		}
+		responses = append(responses, "<<<<<<< SEARCH\r\n<footer>Контакты</footer>\r\n=======\r\n<footer>Контакты и адрес</footer>\r\n>>>>>>> REPLACE")
+		provider := &scriptedProvider{responses: responses}
+		client := &WebsiteBuilderClient{Provider: provider}
+
+		html, trace, err := client.ReviseWebsite(context.Background(), document, "сделай шапку зелёной и добавь адрес")
+		if err != nil {
+			t.Fatalf("ReviseWebsite failed: %v", err)
+		}
+		want := strings.Replace(strings.Replace(document, "color: black", "color: green", 1), "Контакты<", "Контакты и адрес<", 1)
+		if html != want {
+			t.Errorf("revised page differs from the expected edits")
+		}
+		if len(trace) != len(chunks) || !strings.Contains(trace[0].Error, "1 of 2 edits") || trace[1].Error != "" {
+			t.Errorf("trace = %d steps, first error %q", len(trace), trace[0].Error)
+		}
+		for _, req := range provider.requests {
+			if len(req.Prompt) > reviseChunkChars+500 {
+				t.Errorf("prompt of %d bytes exceeds the chunk", len(req.Prompt))
+			}
+		}
+	})
+
+	t.Run("noChanges", func(t *testing.T) {
+		client := &WebsiteBuilderClient{Provider: &scriptedProvider{responses: []string{"<html>same</html>"}}}
+		if _, _, err := client.ReviseWebsite(context.Background(), "<html>same</html>", "ничего"); !errors.Is(err, errReviseNoChanges) {
+			t.Errorf("err = %v, want errReviseNoChanges", err)
+		}
+	})
+}
+
+func TestReviseHandler(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	artifact := filepath.Join("result", "site.html")
+	os.MkdirAll("result", 0755)
+	os.WriteFile(artifact, []byte("<html><body><h1>Кофейня</h1></body></html>"), 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "")
+	id := strconv.FormatInt(project.ID, 10)
+
+	provider := &scriptedProvider{responses: []string{
+		"<html><body><h1 style=\"color: green\">Кофейня</h1></body></html>",
+		"<html><body><h1>Кофейня у моря</h1></body></html>",
+	}}
+	cfg := DefaultConfig()
+	cfg.LLM.Builder = LLMRoleConfig{URL: "http://llm.invalid", Model: "scripted-model", Timeout: 60, MaxTokens: 4096}
+	app := &App{Config: cfg, Repository: repository, Guard: newTestGuard(t), providers: map[string]LLMProvider{RoleBuilder: provider}}
+	app.Jobs = NewJobQueue(repository, 1)
+	app.Jobs.Handle(JobTypeRevise, app.runReviseJob)
+	if err := app.Jobs.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer app.Jobs.Stop()
+
+	revise := func(body string) (int, ReviseResponse) {
+		t.Helper()
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/projects/"+id+"/revise", strings.NewReader(body)), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		app.ReviseHandler(rec, req)
+		if rec.Code != http.StatusAccepted {
+			return rec.Code, ReviseResponse{}
+		}
+
+		var queued JobResponse
+		json.Unmarshal(rec.Body.Bytes(), &queued)
+		job := waitForJob(t, app.Jobs, queued.ID, repo.JobStatusSucceeded)
+		var response ReviseResponse
+		json.Unmarshal([]byte(job.Result), &response)
+		return rec.Code, response
+	}
+
+	original := contentHash([]byte("<html><body><h1>Кофейня</h1></body></html>"))
+	_, first := revise(`{"instruction": "сделай заголовок зелёным"}`)
+	if first.Revision == nil || first.Revision.Parent != original || first.Revision.Source != repo.RevisionSourceRevise ||
+		first.Revision.Instruction != "сделай заголовок зелёным" {
+		t.Fatalf("first revise = %+v", first.Revision)
+	}
+	if data, _ := os.ReadFile(artifact); !strings.Contains(string(data), "color: green") {
+		t.Errorf("artifact = %q", data)
+	}
+
+	// Branching from the original leaves the green header out
+	_, second := revise(`{"instruction": "добавь «у моря»", "from": "` + original[:8] + `"}`)
+	if second.Revision == nil || second.Revision.Parent != original {
+		t.Fatalf("second revise = %+v", second.Revision)
+	}
+	if data, _ := os.ReadFile(artifact); !strings.Contains(string(data), "<h1>Кофейня у моря</h1>") {
+		t.Errorf("artifact = %q", data)
+	}
+	if !strings.Contains(provider.requests[1].Prompt, "<h1>Кофейня</h1>") {
+		t.Errorf("second prompt does not start from the original: %q", provider.requests[1].Prompt)
+	}
+
+	revisions, _ := repository.GetProjectRevisions(ctx, project.ID)
+	if len(revisions) != 3 || revisions[0].Hash != second.Revision.Hash || revisions[2].Hash != original {
+		t.Errorf("revisions = %+v", revisions)
+	}
+	if steps, _ := repository.GetGenerationSteps(ctx, project.ID); len(steps) != 2 || steps[1].Step != 2 || steps[1].Name != GenerationStepRevise {
+		t.Errorf("trace = %+v", steps)
+	}
+
+	for body, want := range map[string]int{
+		`{}`:                                  http.StatusBadRequest,
+		`{"instruction": "x", "from": "abc"}`: http.StatusBadRequest,
+		`{"instruction": "x", "from": "` + strings.Repeat("0", 64) + `"}`: http.StatusNotFound,
+	} {
+		if code, _ := revise(body); code != want {
+			t.Errorf("%s: %d, want %d", body, code, want)
+		}
+	}
+}
+
+// overlapProvider is a scriptedProvider that can be called concurrently and counts the
+// calls that overlapped another one
+type overlapProvider struct {
+	mu       sync.Mutex
+	scripted scriptedProvider
+	active   int
+	overlaps int
+}
+
+func (p *overlapProvider) Name() string  { return "scripted" }
+func (p *overlapProvider) Model() string { return "scripted-model" }
+
+func (p *overlapProvider) Generate(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	p.mu.Lock()
+	if p.active++; p.active > 1 {
+		p.overlaps++
+	}
+	p.mu.Unlock()
+
+	// Long enough for a concurrent job to get here too
+	time.Sleep(50 * time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	return p.scripted.Generate(ctx, req)
+}
+
+func (p *overlapProvider) Chat(ctx context.Context, req ProviderRequest) (*LLMResponse, error) {
+	return p.Generate(ctx, req)
+}
+
+func (p *overlapProvider) Stream(ctx context.Context, req ProviderRequest, _ func(string) error) (*LLMResponse, error) {
+	return p.Generate(ctx, req)
+}
+
+func TestReviseJobsOfOneProject(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	artifact := filepath.Join("result", "site.html")
+	os.MkdirAll("result", 0755)
+	os.WriteFile(artifact, []byte("<html><body><h1>Кофейня</h1></body></html>"), 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "")
+	id := strconv.FormatInt(project.ID, 10)
+
+	provider := &overlapProvider{scripted: scriptedProvider{responses: []string{
+		"<html><body><h1 style=\"color: green\">Кофейня</h1></body></html>",
+		"<html><body><h1 style=\"color: green\">Кофейня у моря</h1></body></html>",
+	}}}
+	cfg := DefaultConfig()
+	cfg.LLM.Builder = LLMRoleConfig{URL: "http://llm.invalid", Model: "scripted-model", Timeout: 60, MaxTokens: 4096}
+	app := &App{Config: cfg, Repository: repository, Guard: newTestGuard(t), providers: map[string]LLMProvider{RoleBuilder: provider}}
+	// Two workers could run both jobs at once
+	app.Jobs = NewJobQueue(repository, 2)
+	app.Jobs.Handle(JobTypeRevise, app.runReviseJob)
+	if err := app.Jobs.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer app.Jobs.Stop()
+
+	var jobs []string
+	for _, instruction := range []string{"сделай заголовок зелёным", "добавь «у моря»"} {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/projects/"+id+"/revise",
+			strings.NewReader(`{"instruction": "`+instruction+`"}`)), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		app.ReviseHandler(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("revise = %d %s", rec.Code, rec.Body.String())
+		}
+		var queued JobResponse
+		json.Unmarshal(rec.Body.Bytes(), &queued)
+		jobs = append(jobs, queued.ID)
+	}
+
+	var responses []ReviseResponse
+	for _, job := range jobs {
+		var response ReviseResponse
+		json.Unmarshal([]byte(waitForJob(t, app.Jobs, job, repo.JobStatusSucceeded).Result), &response)
+		responses = append(responses, response)
+	}
+
+	// The second job starts from the artifact the first one has written, so both edits are kept
+	if provider.overlaps != 0 {
+		t.Errorf("%d revise jobs of the project ran at the same time", provider.overlaps)
+	}
+	// The workers may pick the jobs up in either order
+	first, second := responses[0].Revision, responses[1].Revision
+	if second != nil && first != nil && second.Hash == first.Parent {
+		first, second = second, first
+	}
+	if first == nil || second == nil || first.Parent != contentHash([]byte("<html><body><h1>Кофейня</h1></body></html>")) || second.Parent != first.Hash {
+		t.Fatalf("revisions = %+v, %+v", first, second)
+	}
+	if !strings.Contains(provider.scripted.requests[1].Prompt, "color: green") {
+		t.Errorf("second prompt does not include the first edit: %q", provider.scripted.requests[1].Prompt)
+	}
+	if data, _ := os.ReadFile(artifact); !strings.Contains(string(data), "color: green") || !strings.Contains(string(data), "у моря") {
+		t.Errorf("artifact = %q", data)
+	}
+	if len(app.projects) != 0 {
+		t.Errorf("project locks left: %v", app.projects)
+	}
+}
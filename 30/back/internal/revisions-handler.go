@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+// ProjectRevisionsResponse lists the revisions of a project, newest first, and tells which one is live
+type ProjectRevisionsResponse struct {
+	ProjectID int64                   `json:"project_id"`
+	Current   string                  `json:"current,omitempty"` // revision of the project's artifact as it is now
+	Live      map[string]string       `json:"live"`              // target -> revision of its latest successful deployment
+	Revisions []*repo.ProjectRevision `json:"revisions"`
+}
+
+// ProjectRevisionsHandler lists the revisions of a project
+func (a *App) ProjectRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	project, ok := a.projectFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	revisions, err := a.Repository.GetProjectRevisions(ctx, project.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get revisions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	deployments, err := a.Repository.GetDeployments(ctx, project.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deployments: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := ProjectRevisionsResponse{
+		ProjectID: project.ID,
+		Live:      liveRevisions(deployments),
+		Revisions: revisions,
+	}
+	if hash, err := artifactHash(project.FilePath); err == nil {
+		response.Current = hash
+	}
+	if response.Revisions == nil {
+		response.Revisions = []*repo.ProjectRevision{}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// RollbackHandler redeploys an earlier revision of the project: ?to= is the hash of the
+// revision or a unique prefix. It goes to ?target=, by default the target of the latest
+// successful deployment of the project, and the project's artifact is left as it is.
+func (a *App) RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	project, ok := a.projectFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	revisions, err := a.Repository.GetProjectRevisions(ctx, project.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get revisions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rev, err := findRevision(revisions, r.URL.Query().Get("to"))
+	if errors.Is(err, errRevisionNotFound) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		deployments, err := a.Repository.GetDeployments(ctx, project.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get deployments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		target = a.Config.Deploy.Target
+		for _, deployment := range deployments {
+			if deploymentLive(deployment) {
+				target = deployment.Target
+				break
+			}
+		}
+	}
+	if err := a.Config.Deploy.Validate(target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.writeDeployment(w, r, project, rev, target)
+}
+
+// projectFromPath loads the project of the {id} path variable and writes the error response when it fails.
+// Projects of other users are not found, like their jobs.
+func (a *App) projectFromPath(w http.ResponseWriter, r *http.Request) (*repo.Project, bool) {
+	projectID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid project id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	project, err := a.Repository.GetProject(r.Context(), projectID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownedByCaller(r.Context(), project.UserID)) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get project: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return project, true
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chat-web-service-backend/repo"
+)
+
+// revisionsDir is the content-addressed store of project revisions: every revision is
+// saved once as {hash}{ext}, or the directory {hash}/ for sites, and never changed,
+// so revisions of all projects share it
+var revisionsDir = filepath.Join("result", "revisions")
+
+// minRevisionPrefix is the shortest hash prefix accepted as a revision reference
+const minRevisionPrefix = 7
+
+var errRevisionNotFound = errors.New("revision not found")
+
+// contentHash returns the hex SHA-256 of the content, which names its revision
+func contentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// saveRevision stores the current content of the artifact as a revision of the project.
+// Saving unchanged content returns the existing revision.
+func saveRevision(ctx context.Context, repository repo.Repository, projectID int64, artifact, source string) (*repo.ProjectRevision, error) {
+	return storeRevision(ctx, repository, artifact, &repo.ProjectRevision{ProjectID: projectID, Source: source})
+}
+
+// storeRevision stores the current content of the artifact, a file or a site directory, as
+// the revision rev of its project, filling in the hash, file and size. Unchanged content is
+// the existing revision.
+func storeRevision(ctx context.Context, repository repo.Repository, artifact string, rev *repo.ProjectRevision) (*repo.ProjectRevision, error) {
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return nil, err
+	}
+
+	var write func(filePath string) error
+	if info.IsDir() {
+		files, err := readSiteFiles(artifact)
+		if err != nil {
+			return nil, err
+		}
+		rev.Hash = treeHash(files)
+		rev.FilePath = filepath.Join(revisionsDir, rev.Hash)
+		rev.Size = 0
+		for _, content := range files {
+			rev.Size += int64(len(content))
+		}
+		write = func(filePath string) error { return writeRevisionDir(filePath, files) }
+	} else {
+		content, err := os.ReadFile(artifact)
+		if err != nil {
+			return nil, err
+		}
+		rev.Hash = contentHash(content)
+		rev.FilePath = filepath.Join(revisionsDir, rev.Hash+filepath.Ext(artifact))
+		rev.Size = int64(len(content))
+		write = func(filePath string) error { return writeRevisionFile(filePath, content) }
+	}
+
+	if _, err := os.Stat(rev.FilePath); errors.Is(err, fs.ErrNotExist) {
+		if err := write(rev.FilePath); err != nil {
+			return nil, fmt.Errorf("failed to store revision: %w", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := repository.CreateProjectRevision(ctx, rev); err != nil {
+		return nil, fmt.Errorf("failed to record revision: %w", err)
+	}
+	return rev, nil
+}
+
+// writeRevisionFile writes a read-only file in one step, so a crash never leaves a partial revision
+func writeRevisionFile(filePath string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".revision-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// writeRevisionDir writes the read-only files of a site into a directory that appears in one step
+func writeRevisionDir(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".revision-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := writeSiteFiles(tmp, files, 0444); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another writer stored the same content first; the revision is the same
+		if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// findRevision resolves a full hash or a unique prefix of at least minRevisionPrefix characters
+func findRevision(revisions []*repo.ProjectRevision, ref string) (*repo.ProjectRevision, error) {
+	ref = strings.ToLower(ref)
+	if len(ref) < minRevisionPrefix {
+		return nil, fmt.Errorf("revision must be at least %d characters of the hash", minRevisionPrefix)
+	}
+
+	var found *repo.ProjectRevision
+	for _, rev := range revisions {
+		if !strings.HasPrefix(rev.Hash, ref) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("revision %s is ambiguous", ref)
+		}
+		found = rev
+	}
+	if found == nil {
+		return nil, errRevisionNotFound
+	}
+	return found, nil
+}
+
+// liveRevisions maps every target to the revision of its latest successful deployment;
+// deployments are newest first
+func liveRevisions(deployments []*repo.Deployment) map[string]string {
+	live := make(map[string]string)
+	for _, deployment := range deployments {
+		if _, ok := live[deployment.Target]; ok || !deploymentLive(deployment) {
+			continue
+		}
+		live[deployment.Target] = deployment.Revision
+	}
+	return live
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-web-service-backend/repo"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRollbackHandler(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	artifact := filepath.Join("result", "site.html")
+	os.MkdirAll("result", 0755)
+	os.WriteFile(artifact, []byte("<h1>v1</h1>"), 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "")
+	id := strconv.FormatInt(project.ID, 10)
+
+	cfg := DefaultConfig()
+	cfg.Deploy.Local.Dir = "www"
+	app := &App{Config: cfg, Repository: repository}
+	app.Jobs = NewJobQueue(repository, 1)
+	app.Jobs.Handle(JobTypeVerify, app.runVerifyJob)
+	if err := app.Jobs.Start(ctx); err != nil {
+		t.Fatalf("Failed to start queue: %v", err)
+	}
+	defer app.Jobs.Stop()
+
+	call := func(handler http.HandlerFunc, method, target, body string) (int, []byte) {
+		req := mux.SetURLVars(httptest.NewRequest(method, target, strings.NewReader(body)), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code, rec.Body.Bytes()
+	}
+	// The file:// URLs of the local target are verified by a job reading the published file
+	verified := func(body []byte) {
+		t.Helper()
+		var response PublishResponse
+		json.Unmarshal(body, &response)
+		waitForJob(t, app.Jobs, response.VerifyJob, repo.JobStatusSucceeded)
+	}
+	publish := func() {
+		t.Helper()
+		code, body := call(app.PublishHandler, http.MethodPost, "/publish", `{"project_id": `+id+`}`)
+		if code != http.StatusOK {
+			t.Fatalf("publish: %d %s", code, body)
+		}
+		verified(body)
+	}
+	published := func() string {
+		data, _ := os.ReadFile(filepath.Join("www", "site.html"))
+		return string(data)
+	}
+
+	v1 := contentHash([]byte("<h1>v1</h1>"))
+	v2 := contentHash([]byte("<h1>v2</h1>"))
+	publish()
+	os.WriteFile(artifact, []byte("<h1>v2</h1>"), 0644)
+	publish()
+	publish() // unchanged content is the same revision
+	if published() != "<h1>v2</h1>" {
+		t.Fatalf("published = %q", published())
+	}
+
+	code, body := call(app.RollbackHandler, http.MethodPost, "/projects/"+id+"/rollback?to="+v1[:10], "")
+	var response PublishResponse
+	json.Unmarshal(body, &response)
+	if code != http.StatusOK || response.Deployment.Revision != v1 || response.Deployment.Target != DeployTargetLocal || published() != "<h1>v1</h1>" {
+		t.Fatalf("rollback = %d %s, published %q", code, body, published())
+	}
+	verified(body)
+
+	code, body = call(app.ProjectRevisionsHandler, http.MethodGet, "/projects/"+id+"/revisions", "")
+	var revisions ProjectRevisionsResponse
+	json.Unmarshal(body, &revisions)
+	if code != http.StatusOK || len(revisions.Revisions) != 2 || revisions.Revisions[0].Hash != v2 || revisions.Current != v2 ||
+		revisions.Live[DeployTargetLocal] != v1 {
+		t.Errorf("revisions = %d %s", code, body)
+	}
+
+	// Revisions are stored once, read-only, and the artifact may change afterwards
+	rev := revisions.Revisions[1]
+	if data, err := os.ReadFile(rev.FilePath); err != nil || string(data) != "<h1>v1</h1>" || rev.Source != repo.RevisionSourcePublish {
+		t.Errorf("revision file = %q, %v, %+v", data, err, rev)
+	}
+	if info, err := os.Stat(rev.FilePath); err != nil || info.Mode().Perm()&0222 != 0 {
+		t.Errorf("revision file is writable: %v %v", info.Mode(), err)
+	}
+
+	for query, want := range map[string]int{
+		"":                               http.StatusBadRequest,
+		"?to=" + v1[:3]:                  http.StatusBadRequest,
+		"?to=" + strings.Repeat("0", 64): http.StatusNotFound,
+		"?to=" + v1 + "&target=ftp":      http.StatusBadRequest,
+	} {
+		if code, body := call(app.RollbackHandler, http.MethodPost, "/projects/"+id+"/rollback"+query, ""); code != want {
+			t.Errorf("rollback%s: %d %s, want %d", query, code, body, want)
+		}
+	}
+}
+
+func TestProjectOwnership(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	artifact := filepath.Join("result", "site.html")
+	os.MkdirAll("result", 0755)
+	os.WriteFile(artifact, []byte("<h1>v1</h1>"), 0644)
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "alice")
+	project, _ := repository.CreateProject(ctx, chat.ID, "Кофейня", "", artifact, "alice")
+	id := strconv.FormatInt(project.ID, 10)
+
+	cfg := DefaultConfig()
+	cfg.Deploy.Local.Dir = "www"
+	cfg.Deploy.Verify.Enabled = false
+	preview, err := NewPreviewServer(PreviewConfig{BaseURL: "http://preview.test/", Secret: "0123456789abcdef0123456789abcdef", TTL: time.Hour, Dir: "result"}, repository, nil)
+	if err != nil {
+		t.Fatalf("NewPreviewServer failed: %v", err)
+	}
+	app := &App{Config: cfg, Repository: repository, Preview: preview}
+	// Revise jobs are only queued, the queue is not started
+	app.Jobs = NewJobQueue(repository, 1)
+	app.Jobs.Handle(JobTypeRevise, app.runReviseJob)
+
+	call := func(identity *Identity, handler http.HandlerFunc, method, target, body string) int {
+		req := mux.SetURLVars(httptest.NewRequest(method, target, strings.NewReader(body)), map[string]string{"id": id})
+		req = req.WithContext(WithIdentity(req.Context(), identity))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	// Other users do not see the project at all; the revise is refused before its job is queued
+	bob := &Identity{UserID: "bob", Role: UserRolePublisher}
+	for name, handler := range map[string]func(*Identity) int{
+		"revise": func(i *Identity) int {
+			return call(i, app.ReviseHandler, http.MethodPost, "/projects/"+id+"/revise", `{"instruction": "x"}`)
+		},
+		"rollback": func(i *Identity) int {
+			return call(i, app.RollbackHandler, http.MethodPost, "/projects/"+id+"/rollback?to=abcdef0", "")
+		},
+		"revisions": func(i *Identity) int {
+			return call(i, app.ProjectRevisionsHandler, http.MethodGet, "/projects/"+id+"/revisions", "")
+		},
+		"deployments": func(i *Identity) int {
+			return call(i, app.ProjectDeploymentsHandler, http.MethodGet, "/projects/"+id+"/deployments", "")
+		},
+		"trace": func(i *Identity) int {
+			return call(i, app.ProjectTraceHandler, http.MethodGet, "/projects/"+id+"/trace", "")
+		},
+		"preview": func(i *Identity) int {
+			return call(i, preview.PreviewURLHandler, http.MethodGet, "/projects/"+id+"/preview", "")
+		},
+		"publish": func(i *Identity) int {
+			return call(i, app.PublishHandler, http.MethodPost, "/publish", `{"project_id": `+id+`}`)
+		},
+	} {
+		if code := handler(bob); code != http.StatusNotFound {
+			t.Errorf("%s by another user: %d, want 404", name, code)
+		}
+		for _, owner := range []*Identity{{UserID: "alice", Role: UserRolePublisher}, {UserID: "root", Role: UserRoleAdmin}} {
+			if code := handler(owner); code == http.StatusNotFound && name != "rollback" {
+				t.Errorf("%s by %s: 404", name, owner.UserID)
+			}
+		}
+	}
+}
+
+func TestWriteRevisionDirExisting(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "revisions", "abc")
+	files := map[string][]byte{"index.html": []byte("<h1>Кофейня</h1>"), "css/site.css": []byte("h1 {}")}
+
+	// The second writer loses the race to store the same content
+	for i := 0; i < 2; i++ {
+		if err := writeRevisionDir(dir, files); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "css", "site.css")); err != nil || string(data) != "h1 {}" {
+		t.Errorf("stored file = %q, %v", data, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(root, "revisions", ".revision-*")); len(leftovers) != 0 {
+		t.Errorf("temporary directories left: %v", leftovers)
+	}
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Deployer uploads sites to an S3-compatible bucket with path-style PUT requests
+// signed with AWS Signature Version 4
+type S3Deployer struct {
+	cfg    S3DeployConfig
+	Client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Deployer(cfg S3DeployConfig) *S3Deployer {
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &S3Deployer{cfg: cfg, Client: http.DefaultClient, now: time.Now}
+}
+
+func (d *S3Deployer) Name() string {
+	return DeployTargetS3
+}
+
+func (d *S3Deployer) Deploy(ctx context.Context, name string, content []byte) (DeployResult, error) {
+	key := strings.TrimLeft(path.Join(d.cfg.Prefix, path.Clean("/"+name)), "/")
+	objectURL, err := url.Parse(d.cfg.Endpoint + "/" + s3Escape(d.cfg.Bucket) + "/" + s3Escape(key))
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("invalid deploy.s3.endpoint: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), bytes.NewReader(content))
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	signS3Request(req, content, d.cfg.AccessKeyID, d.cfg.SecretAccessKey, d.cfg.Region, d.now())
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("failed to upload to %s: %w", d.cfg.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return DeployResult{}, fmt.Errorf("S3 returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	baseURL := d.cfg.BaseURL
+	if baseURL == "" {
+		baseURL = d.cfg.Endpoint + "/" + d.cfg.Bucket
+		if d.cfg.Prefix != "" {
+			baseURL += "/" + strings.Trim(d.cfg.Prefix, "/")
+		}
+	}
+	return DeployResult{URL: publicURL(baseURL, name), RemotePath: "s3://" + d.cfg.Bucket + "/" + key}, nil
+}
+
+// signS3Request adds the AWS Signature Version 4 headers to the request.
+// Every header set on the request is signed, so headers must not change afterwards.
+func signS3Request(req *http.Request, payload []byte, accessKeyID, secretAccessKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, hex.EncodeToString(key)))
+}
+
+// s3Escape encodes an object key the way S3 canonicalises it: everything but
+// unreserved characters is percent-encoded, slashes are kept
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"chat-web-service-backend/repo"
+)
+
+// Search page sizes
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// SearchResponse is a page of search hits, best first.
+// NextCursor is passed as ?cursor= with the same query to get the next page; it is empty on the last page.
+type SearchResponse struct {
+	Query      string            `json:"query"`
+	Hits       []*repo.SearchHit `json:"hits"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// SearchHandler searches messages, project names and descriptions and image prompts.
+// Query: q (required), kind (message, project or image), chat_id; paging: limit and cursor.
+// Callers only find their own chats, admins find everything.
+func (a *App) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.UserID = callerOwnerFilter(r.Context())
+	pageSize := query.Limit
+	// One more hit tells whether there is a next page
+	query.Limit++
+
+	hits, err := a.Repository.Search(r.Context(), query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to search: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := SearchResponse{Query: query.Text, Hits: hits}
+	if len(hits) > pageSize {
+		response.Hits = hits[:pageSize]
+		response.NextCursor = encodeSearchCursor(hits[pageSize-1].Cursor())
+	}
+	if response.Hits == nil {
+		response.Hits = []*repo.SearchHit{}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseSearchQuery(values url.Values) (repo.SearchQuery, error) {
+	query := repo.SearchQuery{
+		Text:  values.Get("q"),
+		Kind:  values.Get("kind"),
+		Limit: defaultSearchPageSize,
+	}
+	if query.Text == "" {
+		return query, fmt.Errorf("q is required")
+	}
+
+	switch query.Kind {
+	case "", repo.SearchKindMessage, repo.SearchKindProject, repo.SearchKindImage:
+	default:
+		return query, fmt.Errorf("invalid kind, expected message, project or image")
+	}
+	if value := values.Get("chat_id"); value != "" {
+		chatID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || chatID <= 0 {
+			return query, fmt.Errorf("invalid chat_id")
+		}
+		query.ChatID = chatID
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit")
+		}
+		query.Limit = min(limit, maxSearchPageSize)
+	}
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeSearchCursor(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor")
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
+
+// The cursor is opaque to clients: the position of the last hit of the page
+func encodeSearchCursor(cursor *repo.SearchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(value string) (*repo.SearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &repo.SearchCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"chat-web-service-backend/repo"
+)
+
+func TestSearchHandler(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	chat, _ := repository.CreateChat(ctx, "Кофейня", "")
+	for _, content := range []string{"сайт кофейни", "меню кофейни", "цвета кофейни"} {
+		if _, err := repository.CreateMessage(ctx, chat.ID, "user", content); err != nil {
+			t.Fatalf("CreateMessage failed: %v", err)
+		}
+	}
+	repository.CreateProject(ctx, chat.ID, "Кофейня", "", "", "")
+
+	app := &App{Repository: repository}
+	search := func(query url.Values) (int, SearchResponse) {
+		rec := httptest.NewRecorder()
+		app.SearchHandler(rec, httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil))
+		var response SearchResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return rec.Code, response
+	}
+
+	code, page := search(url.Values{"q": {"кофейн"}, "limit": {"3"}})
+	if code != http.StatusOK || len(page.Hits) != 3 || page.NextCursor == "" || page.Hits[0].Kind != "project" {
+		t.Fatalf("first page: %d %+v", code, page)
+	}
+	code, next := search(url.Values{"q": {"кофейн"}, "limit": {"3"}, "cursor": {page.NextCursor}})
+	if code != http.StatusOK || len(next.Hits) != 1 || next.NextCursor != "" || next.Hits[0].Snippet == "" {
+		t.Fatalf("last page: %d %+v", code, next)
+	}
+	for _, hit := range page.Hits {
+		if hit.Kind == next.Hits[0].Kind && hit.ID == next.Hits[0].ID {
+			t.Errorf("hit %+v is on both pages", hit)
+		}
+	}
+
+	if _, response := search(url.Values{"q": {"автосервис"}}); response.Hits == nil || len(response.Hits) != 0 {
+		t.Errorf("no hits = %+v, want an empty list", response)
+	}
+	for _, query := range []url.Values{
+		{},
+		{"q": {"кофейн"}, "kind": {"chat"}},
+		{"q": {"кофейн"}, "cursor": {"not a cursor"}},
+		{"q": {"кофейн"}, "limit": {"-1"}},
+	} {
+		if code, _ := search(query); code != http.StatusBadRequest {
+			t.Errorf("%v: %d, want 400", query, code)
+		}
+	}
+}
+
+func TestSearchHandlerOwners(t *testing.T) {
+	repository := newTestJobRepository(t)
+	defer repository.Close()
+	ctx := context.Background()
+
+	alice, _ := repository.CreateChat(ctx, "Requirements dialog - alice", "alice")
+	repository.CreateMessage(ctx, alice.ID, "user", "мой телефон для сайта кофейни")
+	bob, _ := repository.CreateChat(ctx, "Requirements dialog - bob", "bob")
+	repository.CreateMessage(ctx, bob.ID, "user", "сайт автосервиса")
+
+	app := &App{Repository: repository}
+	search := func(identity *Identity, query url.Values) []*repo.SearchHit {
+		req := httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil)
+		req = req.WithContext(WithIdentity(req.Context(), identity))
+		rec := httptest.NewRecorder()
+		app.SearchHandler(rec, req)
+		var response SearchResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response.Hits
+	}
+
+	bobIdentity := &Identity{UserID: "bob", Role: UserRoleViewer}
+	if hits := search(bobIdentity, url.Values{"q": {"телефон"}}); len(hits) != 0 {
+		t.Errorf("bob finds the messages of alice: %+v", hits)
+	}
+	if hits := search(bobIdentity, url.Values{"q": {"сайт"}, "chat_id": {strconv.FormatInt(alice.ID, 10)}}); len(hits) != 0 {
+		t.Errorf("bob finds the messages of alice by chat_id: %+v", hits)
+	}
+	if hits := search(bobIdentity, url.Values{"q": {"сайт"}}); len(hits) != 1 || hits[0].ChatID != bob.ID {
+		t.Errorf("bob's search = %+v", hits)
+	}
+	if hits := search(&Identity{UserID: "root", Role: UserRoleAdmin}, url.Values{"q": {"сайт"}}); len(hits) != 2 {
+		t.Errorf("admin's search = %+v", hits)
+	}
+}
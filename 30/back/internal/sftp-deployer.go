@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPDeployer uploads sites to the html directory of a web server over SFTP
+type SFTPDeployer struct {
+	cfg SFTPDeployConfig
+}
+
+func NewSFTPDeployer(cfg SFTPDeployConfig) *SFTPDeployer {
+	return &SFTPDeployer{cfg: cfg}
+}
+
+func (d *SFTPDeployer) Name() string {
+	return DeployTargetSFTP
+}
+
+func (d *SFTPDeployer) Deploy(ctx context.Context, name string, content []byte) (DeployResult, error) {
+	remotePath := path.Join(d.cfg.Dir, path.Clean("/"+name))
+	err := d.session(ctx, func(sftpClient *sftp.Client) error {
+		if err := sftpClient.MkdirAll(path.Dir(remotePath)); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path.Dir(remotePath), err)
+		}
+
+		// The file is uploaded next to the target and renamed, so the web server never serves a partial page
+		tmpPath := path.Join(path.Dir(remotePath), ".deploy-"+path.Base(remotePath))
+		if err := uploadRemoteFile(sftpClient, tmpPath, content); err != nil {
+			sftpClient.Remove(tmpPath)
+			return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+		}
+		if err := replaceRemoteFile(sftpClient, tmpPath, remotePath); err != nil {
+			sftpClient.Remove(tmpPath)
+			return fmt.Errorf("failed to replace %s: %w", remotePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return DeployResult{}, err
+	}
+	return DeployResult{URL: publicURL(d.baseURL(), name), RemotePath: remotePath}, nil
+}
+
+// DeploySite uploads the site over one connection into a directory next to name/ and swaps
+// the directories, so the web server never serves a mix of two revisions
+func (d *SFTPDeployer) DeploySite(ctx context.Context, name string, files map[string][]byte) (DeployResult, error) {
+	remoteDir := path.Join(d.cfg.Dir, path.Clean("/"+name))
+	err := d.session(ctx, func(sftpClient *sftp.Client) error {
+		if err := sftpClient.MkdirAll(path.Dir(remoteDir)); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path.Dir(remoteDir), err)
+		}
+
+		stagingDir := path.Join(path.Dir(remoteDir), fmt.Sprintf(".deploy-%s-%d", path.Base(remoteDir), time.Now().UnixNano()))
+		for _, file := range siteFileOrder(files) {
+			filePath := path.Join(stagingDir, file)
+			if err := sftpClient.MkdirAll(path.Dir(filePath)); err != nil {
+				sftpClient.RemoveAll(stagingDir)
+				return fmt.Errorf("failed to create %s: %w", path.Dir(filePath), err)
+			}
+			if err := uploadRemoteFile(sftpClient, filePath, files[file]); err != nil {
+				sftpClient.RemoveAll(stagingDir)
+				return fmt.Errorf("failed to upload %s: %w", path.Join(remoteDir, file), err)
+			}
+		}
+		if err := moveAsideAndRename(sftpClient, stagingDir, remoteDir); err != nil {
+			sftpClient.RemoveAll(stagingDir)
+			return fmt.Errorf("failed to replace %s: %w", remoteDir, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return DeployResult{}, err
+	}
+	return DeployResult{URL: publicURL(d.baseURL(), path.Join(name, siteIndex)), RemotePath: path.Join(remoteDir, siteIndex)}, nil
+}
+
+// session connects and runs fn with an SFTP client on the connection
+func (d *SFTPDeployer) session(ctx context.Context, fn func(sftpClient *sftp.Client) error) error {
+	client, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// A cancelled request closes the connection, which fails the running transfer
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %w", err)
+	}
+	defer sftpClient.Close()
+
+	return fn(sftpClient)
+}
+
+func (d *SFTPDeployer) baseURL() string {
+	if d.cfg.BaseURL == "" {
+		return "http://" + d.cfg.Host
+	}
+	return d.cfg.BaseURL
+}
+
+// uploadRemoteFile writes the content to a new file readable by the web server
+func uploadRemoteFile(sftpClient *sftp.Client, filePath string, content []byte) error {
+	f, err := sftpClient.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return sftpClient.Chmod(filePath, 0644)
+}
+
+// replaceRemoteFile renames the uploaded file over the target. Servers without the posix-rename
+// extension refuse to rename over an existing file, so there the old file is moved aside first.
+func replaceRemoteFile(sftpClient *sftp.Client, tmpPath, remotePath string) error {
+	if _, ok := sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		return sftpClient.PosixRename(tmpPath, remotePath)
+	}
+	return moveAsideAndRename(sftpClient, tmpPath, remotePath)
+}
+
+// moveAsideAndRename renames the target to .old, the new file or directory into its place and
+// only then removes the old one, so the target is never missing on failure
+func moveAsideAndRename(sftpClient *sftp.Client, tmpPath, remotePath string) error {
+	oldPath := remotePath + ".old"
+	sftpClient.RemoveAll(oldPath)
+	hadOld := true
+	if err := sftpClient.Rename(remotePath, oldPath); errors.Is(err, os.ErrNotExist) {
+		hadOld = false
+	} else if err != nil {
+		return err
+	}
+	if err := sftpClient.Rename(tmpPath, remotePath); err != nil {
+		if hadOld {
+			sftpClient.Rename(oldPath, remotePath)
+		}
+		return err
+	}
+	if hadOld {
+		sftpClient.RemoveAll(oldPath)
+	}
+	return nil
+}
+
+// dial connects and authenticates with the private key. The host key must be in the known hosts file.
+func (d *SFTPDeployer) dial(ctx context.Context) (*ssh.Client, error) {
+	key, err := os.ReadFile(d.cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", d.cfg.PrivateKey, err)
+	}
+
+	knownHostsFile := d.cfg.KnownHosts
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("deploy.sftp.known_hosts (YCLOUD_DEPLOY_KNOWN_HOSTS) is not set: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	addr := net.JoinHostPort(d.cfg.Host, strconv.Itoa(d.cfg.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            d.cfg.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// siteIndex is the entry page of a directory-shaped artifact
+const siteIndex = "index.html"
+
+// readSiteFiles reads the files of a directory-shaped artifact, keyed by their slash-separated
+// path relative to the directory. Hidden files, e.g. leftovers of interrupted writes, are skipped.
+func readSiteFiles(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && filePath != dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[siteIndex]; !ok {
+		return nil, fmt.Errorf("site %s has no %s", dir, siteIndex)
+	}
+	return files, nil
+}
+
+// treeHash returns the hex SHA-256 over the sorted paths and content hashes of the files,
+// which names the revision of a directory the way contentHash names a single file
+func treeHash(files map[string][]byte) string {
+	hash := sha256.New()
+	for _, name := range siteFileOrder(files) {
+		fmt.Fprintf(hash, "%s\x00%s\n", name, contentHash(files[name]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// siteFileOrder sorts the files of a site for upload: index.html goes last, so the
+// entry page only changes once the pages and styles it links to are in place
+func siteFileOrder(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == siteIndex) != (names[j] == siteIndex) {
+			return names[j] == siteIndex
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// writeSiteFiles writes the files under dir with the given mode, creating subdirectories
+func writeSiteFiles(dir string, files map[string][]byte, perm os.FileMode) error {
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, content, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceSiteFiles replaces the site directory with the files: they are written next to it and
+// swapped in, so the directory is never a mix of the old and the new site. A missing directory is created.
+func replaceSiteFiles(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".site-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := writeSiteFiles(tmp, files, 0644); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	old := tmp + ".old"
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.Rename(old, dir)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// artifactHash returns the revision hash of an artifact, a single file or a site directory
+func artifactHash(artifact string) (string, error) {
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		files, err := readSiteFiles(artifact)
+		if err != nil {
+			return "", err
+		}
+		return treeHash(files), nil
+	}
+
+	content, err := os.ReadFile(artifact)
+	if err != nil {
+		return "", err
+	}
+	return contentHash(content), nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SSE event names sent by the streaming endpoints
+const (
+	SSEEventToken = "token"
+	SSEEventDone  = "done"
+	SSEEventError = "error"
+
+	// SSEEventProgress is sent by /jobs/{id}/events on every job state change
+	SSEEventProgress = "progress"
+)
+
+// SSETokenEvent is the payload of a "token" event
+type SSETokenEvent struct {
+	Token string `json:"token"`
+}
+
+// SSEErrorEvent is the payload of an "error" event
+type SSEErrorEvent struct {
+	Error string `json:"error"`
+}
+
+// SSEWriter writes Server-Sent Events and flushes every event immediately
+type SSEWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewSSEWriter sets the event-stream headers. It fails if the connection cannot be flushed.
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by the connection")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &SSEWriter{w: w, flusher: flusher}, nil
+}
+
+// Send writes a single event with a JSON payload
+func (s *SSEWriter) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Token sends a "token" event. It matches the onToken callback of LLMProvider.Stream.
+func (s *SSEWriter) Token(token string) error {
+	return s.Send(SSEEventToken, SSETokenEvent{Token: token})
+}
+
+// Error sends an "error" event
+func (s *SSEWriter) Error(err error) error {
+	return s.Send(SSEEventError, SSEErrorEvent{Error: err.Error()})
+}
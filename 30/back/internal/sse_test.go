@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readSSEEvent reads one event up to the blank line that ends it
+func readSSEEvent(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+
+	var event strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v (read %q)", err, event.String())
+		}
+		if line == "\n" {
+			return event.String()
+		}
+		event.WriteString(line)
+	}
+}
+
+func TestSSEWriter(t *testing.T) {
+	read := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse, err := NewSSEWriter(w)
+		if err != nil {
+			t.Errorf("NewSSEWriter failed: %v", err)
+			return
+		}
+
+		// Every event is flushed at once: the client reads it before the next one is written
+		sse.Token("При")
+		<-read
+		sse.Token("вет\nмир")
+		sse.Error(errors.New(`bad "input"`))
+		sse.Send(SSEEventDone, AskResponse{Status: "success", Message: "Привет"})
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" || resp.Header.Get("Cache-Control") != "no-cache" || resp.Header.Get("X-Accel-Buffering") != "no" {
+		t.Errorf("headers = %v", resp.Header)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event := readSSEEvent(t, reader); event != "event: token\ndata: {\"token\":\"При\"}\n" {
+		t.Errorf("first event = %q", event)
+	}
+	close(read)
+
+	for _, want := range []string{
+		// A newline in the token stays inside the JSON payload and does not break the framing
+		"event: token\ndata: {\"token\":\"вет\\nмир\"}\n",
+		"event: error\ndata: {\"error\":\"bad \\\"input\\\"\"}\n",
+		"event: done\ndata: {\"status\":\"success\",\"message\":\"Привет\"}\n",
+	} {
+		if event := readSSEEvent(t, reader); !strings.HasPrefix(event, want) {
+			t.Errorf("event = %q, want prefix %q", event, want)
+		}
+	}
+}
+
+// unflushableWriter is a ResponseWriter that cannot stream
+type unflushableWriter struct {
+	http.ResponseWriter
+}
+
+func TestSSEWriterRequiresFlusher(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	if _, err := NewSSEWriter(unflushableWriter{recorder}); err == nil {
+		t.Fatalf("NewSSEWriter accepted a writer without Flush")
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 || recorder.Header().Get("Content-Type") != "" {
+		t.Errorf("NewSSEWriter wrote to the response: %d %q", recorder.Code, recorder.Body.String())
+	}
+}
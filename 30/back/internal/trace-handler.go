@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"chat-web-service-backend/repo"
+)
+
+// ProjectTraceResponse is the reasoning trace of a generated project
+type ProjectTraceResponse struct {
+	ProjectID             int64                  `json:"project_id"`
+	ProjectName           string                 `json:"project_name"`
+	Steps                 []*repo.GenerationStep `json:"steps"`
+	TotalLatencyMS        int64                  `json:"total_latency_ms"`
+	TotalPromptTokens     int                    `json:"total_prompt_tokens"`
+	TotalCompletionTokens int                    `json:"total_completion_tokens"`
+	GuardFindings         []*repo.GuardFinding   `json:"guard_findings"`
+}
+
+// ProjectTraceHandler returns every stored GenerateWebsite step of the project:
+// prompts, responses, model, latency and token counts, and the guard rules that fired
+func (a *App) ProjectTraceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	project, ok := a.projectFromPath(w, r)
+	if !ok {
+		return
+	}
+	projectID := project.ID
+	ctx := r.Context()
+
+	steps, err := a.Repository.GetGenerationSteps(ctx, projectID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get generation trace: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	findings, err := a.Repository.GetGuardFindings(ctx, projectID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get guard findings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if findings == nil {
+		findings = []*repo.GuardFinding{}
+	}
+
+	response := ProjectTraceResponse{
+		ProjectID:     project.ID,
+		ProjectName:   project.Name,
+		Steps:         []*repo.GenerationStep{},
+		GuardFindings: findings,
+	}
+	for _, step := range steps {
+		response.Steps = append(response.Steps, step)
+		response.TotalLatencyMS += step.LatencyMS
+		response.TotalPromptTokens += step.PromptTokens
+		response.TotalCompletionTokens += step.CompletionTokens
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
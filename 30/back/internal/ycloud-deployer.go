@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// YCloudDeployRequest represents the request payload for ycloud-mcp
+type YCloudDeployRequest struct {
+	HTMLContent string `json:"htmlContent"`
+	Filename    string `json:"filename"`
+}
+
+// YCloudDeployResponse represents the response from ycloud-mcp
+type YCloudDeployResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Success    bool   `json:"success"`
+		Message    string `json:"message"`
+		RemotePath string `json:"remotePath"`
+	} `json:"data"`
+	Error string `json:"error,omitempty"`
+}
+
+// YCloudPublicIPResponse represents the response of the ycloud-mcp /api/vm/public-ip endpoint
+type YCloudPublicIPResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		PublicIP string `json:"publicIP"`
+	} `json:"data"`
+	Error string `json:"error,omitempty"`
+}
+
+// YCloudMCPDeployer uploads sites through the ycloud-mcp server, which copies them to the VM over SFTP
+type YCloudMCPDeployer struct {
+	cfg    YCloudMCPDeployConfig
+	Client *http.Client
+}
+
+func NewYCloudMCPDeployer(cfg YCloudMCPDeployConfig) *YCloudMCPDeployer {
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &YCloudMCPDeployer{cfg: cfg, Client: http.DefaultClient}
+}
+
+func (d *YCloudMCPDeployer) Name() string {
+	return DeployTargetYCloudMCP
+}
+
+func (d *YCloudMCPDeployer) Deploy(ctx context.Context, name string, content []byte) (DeployResult, error) {
+	var deployResp YCloudDeployResponse
+	if err := d.call(ctx, http.MethodPost, "/api/deploy/html", YCloudDeployRequest{HTMLContent: string(content), Filename: name}, &deployResp); err != nil {
+		return DeployResult{}, err
+	}
+	if !deployResp.Success {
+		return DeployResult{}, fmt.Errorf("ycloud-mcp: %s", deployResp.Error)
+	}
+
+	baseURL := d.cfg.BaseURL
+	if baseURL == "" {
+		// Without a configured URL the site is served by the VM itself
+		var ipResp YCloudPublicIPResponse
+		if err := d.call(ctx, http.MethodGet, "/api/vm/public-ip", nil, &ipResp); err != nil || !ipResp.Success || ipResp.Data.PublicIP == "" {
+			return DeployResult{RemotePath: deployResp.Data.RemotePath}, nil
+		}
+		baseURL = "http://" + ipResp.Data.PublicIP
+	}
+
+	return DeployResult{URL: publicURL(baseURL, name), RemotePath: deployResp.Data.RemotePath}, nil
+}
+
+// call sends a request to the ycloud-mcp API and decodes its JSON response, which is
+// returned with an error status too
+func (d *YCloudMCPDeployer) call(ctx context.Context, method, endpoint string, payload, response any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, d.cfg.URL+endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call ycloud-mcp: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read ycloud-mcp response: %w", err)
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("ycloud-mcp returned status %d: %s", resp.StatusCode, string(data))
+	}
+	return nil
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"chat-web-service-backend/internal"
+	"chat-web-service-backend/mcp"
+	"chat-web-service-backend/repo"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -28,53 +38,250 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func getPort() int {
-	if portStr := os.Getenv("PORT"); portStr != "" {
-		if port, err := strconv.Atoi(portStr); err == nil && port > 0 {
-			return port
+// issueAPIKey creates an API key from a USER:ROLE spec and prints it to stdout
+func issueAPIKey(dsn, spec string) error {
+	userID, role, ok := strings.Cut(spec, ":")
+	if !ok {
+		return fmt.Errorf("expected USER:ROLE, got %q", spec)
+	}
+
+	repository, err := repo.NewRepository(dsn)
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	key, apiKey, err := internal.CreateAPIKey(context.Background(), repository, userID, role, "cli")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Created API key %d (%s...) for %s with role %s", apiKey.ID, apiKey.Prefix, apiKey.UserID, apiKey.Role)
+	fmt.Println(key)
+	return nil
+}
+
+// runMigrate applies, reverts or lists the schema migrations: migrate up | down [N] | status
+func runMigrate(dsn string, args []string) error {
+	migrator, err := repo.OpenMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
 		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("expected a positive number of migrations to revert, got %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Local().Format(time.DateTime)
+			}
+			if status.Unknown {
+				applied += " (unknown to this build)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down [N] or status", command)
 	}
-	return 8080
 }
 
 func main() {
+	mcpStdio := flag.Bool("mcp-stdio", false, "serve the MCP tools over stdin/stdout instead of HTTP")
+	createAPIKey := flag.String("create-api-key", "", "issue an API key for USER:ROLE, print it and exit")
+	checkConfig := flag.Bool("check-config", false, "print the effective config with secrets redacted, validate it and exit")
+	configFlags := internal.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: .env file not found, using defaults")
 	}
 
+	cfg, configErr := configFlags.Load()
+	if *checkConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			log.Fatalf("Failed to print config: %v", err)
+		}
+		if configErr != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", configErr)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Configuration is valid")
+		return
+	}
+
+	// migrate up | down [N] | status works on the database only
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(cfg.Database.DSN, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if *createAPIKey != "" {
+		if err := issueAPIKey(cfg.Database.DSN, *createAPIKey); err != nil {
+			log.Fatalf("Failed to create API key: %v", err)
+		}
+		return
+	}
+
+	// Every problem of the config is reported at once instead of failing requests later
+	if configErr != nil {
+		log.Fatalf("Invalid configuration:\n%v", configErr)
+	}
+
 	log.Printf("Starting chat web service backend...")
 
-	port := getPort()
-	log.Printf("Using port: %d", port)
+	// SIGTERM and Ctrl+C start the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app, err := internal.NewApp(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	if err := app.Start(ctx); err != nil {
+		app.Close()
+		log.Fatalf("Failed to start job queue: %v", err)
+	}
+
+	if *mcpStdio {
+		// stdout carries the MCP protocol, logs go to stderr
+		log.Printf("Serving MCP over stdio")
+		if err := app.NewMCPServer().ServeStdio(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Printf("MCP stdio server stopped: %v", err)
+		}
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		app.Jobs.Drain(drainCtx)
+		if err := app.Close(); err != nil {
+			log.Printf("Failed to close: %v", err)
+		}
+		return
+	}
+
+	auth, quotas := app.Auth, app.Quotas
+	if cfg.Auth.AnonymousRole != "" {
+		log.Printf("Warning: requests without credentials are allowed with role %s", cfg.Auth.AnonymousRole)
+	}
 
 	r := mux.NewRouter()
 
 	r.HandleFunc("/health", healthHandler).Methods("GET")
-	r.HandleFunc("/ask", internal.AskHandler).Methods("POST")
-	r.HandleFunc("/requirements", internal.RequirementsHandler).Methods("GET")
-	r.HandleFunc("/build", internal.BuildHandler).Methods("POST")
-	r.HandleFunc("/publish", internal.PublishHandler).Methods("POST")
-	r.HandleFunc("/generate-image", internal.GenerateImageHandler).Methods("POST")
-	r.HandleFunc("/improve-prompt", internal.ImprovePromptHandler).Methods("POST")
-	r.HandleFunc("/latest", internal.LatestHandler).Methods("GET")
-	r.HandleFunc("/analyze-project", internal.AnalyzeProjectHandler).Methods("GET")
-	r.HandleFunc("/idea", internal.IdeaHandler).Methods("POST")
-	r.HandleFunc("/builder22", internal.Builder22Handler).Methods("POST")
-	r.HandleFunc("/clear", internal.ClearHandler).Methods("POST")
-
-	// Serve static files from result directory
-	r.PathPrefix("/result/").Handler(http.StripPrefix("/result/", http.FileServer(http.Dir("./result/"))))
+
+	// Every route except /health requires an API key or JWT
+	// with at least the given role and counts against the caller's quota of the endpoint.
+	// Changes are recorded in the audit log under the endpoint name, on admin routes reads too.
+	// Anonymous callers, when allowed, only reach the shared routes: they would all share
+	// one dialog, job list and project set.
+	viewer := func(endpoint string, h http.HandlerFunc) http.Handler {
+		return auth.RequireUser(internal.UserRoleViewer, app.Audit(endpoint, quotas.Limit(endpoint, h)))
+	}
+	builder := func(endpoint string, h http.HandlerFunc) http.Handler {
+		return auth.RequireUser(internal.UserRoleBuilder, app.Audit(endpoint, quotas.Limit(endpoint, h)))
+	}
+	publisher := func(endpoint string, h http.HandlerFunc) http.Handler {
+		return auth.RequireUser(internal.UserRolePublisher, app.Audit(endpoint, quotas.Limit(endpoint, h)))
+	}
+	admin := func(endpoint string, h http.HandlerFunc) http.Handler {
+		return auth.RequireUser(internal.UserRoleAdmin, app.AuditAll(endpoint, quotas.Limit(endpoint, h)))
+	}
+	shared := func(role, endpoint string, h http.HandlerFunc) http.Handler {
+		return auth.Require(role, app.Audit(endpoint, quotas.Limit(endpoint, h)))
+	}
+
+	r.Handle("/whoami", shared(internal.UserRoleViewer, "whoami", internal.WhoAmIHandler)).Methods("GET")
+	r.Handle("/quota", shared(internal.UserRoleViewer, "quotas", quotas.QuotaHandler)).Methods("GET")
+	r.Handle("/ask", builder("ask", app.AskHandler)).Methods("POST")
+	r.Handle("/ask/stream", builder("ask", app.AskStreamHandler)).Methods("POST")
+	r.Handle("/requirements", viewer("requirements", app.RequirementsHandler)).Methods("GET")
+	r.Handle("/build", builder("build", app.BuildHandler)).Methods("POST")
+	r.Handle("/jobs/{id}", viewer("jobs", app.GetJobHandler)).Methods("GET")
+	r.Handle("/jobs/{id}", builder("jobs", app.CancelJobHandler)).Methods("DELETE")
+	r.Handle("/jobs/{id}/events", viewer("jobs", app.JobEventsHandler)).Methods("GET")
+	r.Handle("/pipelines", shared(internal.UserRoleViewer, "pipelines", app.ListPipelinesHandler)).Methods("GET")
+	r.Handle("/pipelines/{name}/runs", builder("pipeline", app.CreatePipelineRunHandler)).Methods("POST")
+	r.Handle("/pipelines/{name}/runs/{id}", viewer("pipelines", app.GetPipelineRunHandler)).Methods("GET")
+	r.Handle("/publish", publisher("publish", app.PublishHandler)).Methods("POST")
+	r.Handle("/generate-image", shared(internal.UserRoleBuilder, "generate-image", app.GenerateImageHandler)).Methods("POST")
+	r.Handle("/improve-prompt", shared(internal.UserRoleBuilder, "improve-prompt", app.ImprovePromptHandler)).Methods("POST")
+	r.Handle("/latest", viewer("latest", app.LatestHandler)).Methods("GET")
+	r.Handle("/search", viewer("search", app.SearchHandler)).Methods("GET")
+	r.Handle("/projects/{id}/trace", viewer("trace", app.ProjectTraceHandler)).Methods("GET")
+	r.Handle("/projects/{id}/preview", viewer("preview", app.Preview.PreviewURLHandler)).Methods("GET")
+	r.Handle("/projects/{id}/deployments", viewer("deployments", app.ProjectDeploymentsHandler)).Methods("GET")
+	r.Handle("/projects/{id}/revisions", viewer("revisions", app.ProjectRevisionsHandler)).Methods("GET")
+	r.Handle("/projects/{id}/rollback", publisher("publish", app.RollbackHandler)).Methods("POST")
+	r.Handle("/projects/{id}/revise", builder("revise", app.ReviseHandler)).Methods("POST")
+	r.Handle("/analyze-project", admin("analyze-project", app.AnalyzeProjectHandler)).Methods("GET")
+	r.Handle("/idea", shared(internal.UserRoleBuilder, "idea", app.IdeaHandler)).Methods("POST")
+	r.Handle("/idea/stream", shared(internal.UserRoleBuilder, "idea", app.IdeaStreamHandler)).Methods("POST")
+	r.Handle("/builder22", shared(internal.UserRoleBuilder, "builder22", app.Builder22Handler)).Methods("POST")
+	r.Handle("/builder22/stream", shared(internal.UserRoleBuilder, "builder22", app.Builder22StreamHandler)).Methods("POST")
+	r.Handle("/clear", shared(internal.UserRoleBuilder, "clear", internal.ClearHandler)).Methods("POST")
+	// MCP tool calls are audited one by one instead of the protocol requests
+	r.Handle("/mcp", auth.RequireUser(internal.UserRoleBuilder, quotas.Limit("mcp", app.NewMCPServer().ServeHTTP))).Methods("GET", "POST", "DELETE")
+	r.Handle("/api-keys", admin("api-keys", app.ListAPIKeysHandler)).Methods("GET")
+	r.Handle("/api-keys", admin("api-keys", app.CreateAPIKeyHandler)).Methods("POST")
+	r.Handle("/api-keys/{id}", admin("api-keys", app.RevokeAPIKeyHandler)).Methods("DELETE")
+	r.Handle("/quotas", admin("quotas", quotas.ConfigHandler)).Methods("GET")
+	r.Handle("/quotas/users/{user_id}", admin("quotas", quotas.UserQuotaHandler)).Methods("GET")
+	r.Handle("/quotas/users/{user_id}", admin("quotas", quotas.ResetUserQuotaHandler)).Methods("DELETE")
+	r.Handle("/audit", admin("audit", app.ListAuditEventsHandler)).Methods("GET")
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{mcp.SessionHeader, "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 	})
 
 	handler := c.Handler(r)
 
+	port := cfg.Server.Port
 	log.Printf("Chat web service backend running on port %d", port)
 	log.Printf("Health endpoint available at: http://localhost:%d/health", port)
+	log.Printf("MCP endpoint available at: http://localhost:%d/mcp", port)
+	// Generated sites are served from their own origin, without CORS and the API routes
+	log.Printf("Preview server running on %s", app.Preview.BaseURL())
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), handler))
+	if err := app.Run(ctx, handler); err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
+	log.Printf("Server stopped")
 }
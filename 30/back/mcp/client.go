@@ -0,0 +1,586 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default client settings
+const (
+	DefaultRequestTimeout = 60 * time.Second
+	DefaultStartTimeout   = 30 * time.Second
+	DefaultMaxRestarts    = 5
+	DefaultRestartWindow  = time.Minute
+
+	// stderrLimit is how much of the server stderr is kept for error messages
+	stderrLimit = 16 * 1024
+	// maxMessageSize limits a single JSON-RPC line from the server
+	maxMessageSize = 16 * 1024 * 1024
+)
+
+var (
+	// ErrClosed is returned by calls on a closed client
+	ErrClosed = errors.New("MCP client is closed")
+	// ErrTooManyRestarts is returned when the server keeps crashing
+	ErrTooManyRestarts = errors.New("MCP server restarted too many times")
+)
+
+// ServerConfig describes how to launch a stdio MCP server
+type ServerConfig struct {
+	Name           string        // used in logs and errors
+	Command        string        // executable, e.g. "node"
+	Args           []string      // e.g. ["index.js"]
+	Dir            string        // working directory of the server
+	Env            []string      // additional KEY=VALUE variables
+	RequestTimeout time.Duration // default timeout of a single request
+	StartTimeout   time.Duration // timeout of the initialize handshake
+	MaxRestarts    int           // restarts allowed within RestartWindow
+	RestartWindow  time.Duration
+}
+
+// Client is a persistent connection to a stdio MCP server. The server is started
+// on the first call and restarted automatically when it exits. Requests are
+// multiplexed over the connection by JSON-RPC ID, so a Client is safe for concurrent use.
+type Client struct {
+	cfg    ServerConfig
+	nextID atomic.Int64
+
+	mu         sync.Mutex
+	proc       *process
+	closed     bool
+	restarts   []time.Time
+	serverInfo InitializeResult
+
+	// toolsMu is separate from mu: the reader goroutine resets the tools
+	// while mu is held during the handshake
+	toolsMu sync.Mutex
+	tools   []Tool
+}
+
+// NewClient creates a client; the server is launched lazily by Start or the first call
+func NewClient(cfg ServerConfig) *Client {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Command
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	if cfg.StartTimeout <= 0 {
+		cfg.StartTimeout = DefaultStartTimeout
+	}
+	if cfg.MaxRestarts <= 0 {
+		cfg.MaxRestarts = DefaultMaxRestarts
+	}
+	if cfg.RestartWindow <= 0 {
+		cfg.RestartWindow = DefaultRestartWindow
+	}
+
+	return &Client{cfg: cfg}
+}
+
+// Start launches the server and performs the handshake if it is not running yet
+func (c *Client) Start(ctx context.Context) error {
+	_, err := c.running(ctx)
+	return err
+}
+
+// ServerInfo returns the initialize result of the running server
+func (c *Client) ServerInfo() InitializeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serverInfo
+}
+
+// Stderr returns the latest stderr output of the server
+func (c *Client) Stderr() string {
+	c.mu.Lock()
+	proc := c.proc
+	c.mu.Unlock()
+
+	if proc == nil {
+		return ""
+	}
+	return proc.stderr.String()
+}
+
+// Tools returns the tools discovered with tools/list
+func (c *Client) Tools(ctx context.Context) ([]Tool, error) {
+	if _, err := c.running(ctx); err != nil {
+		return nil, err
+	}
+
+	c.toolsMu.Lock()
+	tools := c.tools
+	c.toolsMu.Unlock()
+
+	if tools == nil {
+		// Список сбрасывается уведомлением notifications/tools/list_changed
+		var err error
+		if tools, err = c.listTools(ctx); err != nil {
+			return nil, err
+		}
+		c.toolsMu.Lock()
+		c.tools = tools
+		c.toolsMu.Unlock()
+	}
+	return tools, nil
+}
+
+// CallTool calls a discovered tool with the given arguments
+func (c *Client) CallTool(ctx context.Context, name string, arguments interface{}) (*CallToolResult, error) {
+	tools, err := c.Tools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, tool := range tools {
+		if tool.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("MCP server %s has no tool %q", c.cfg.Name, name)
+	}
+
+	var result CallToolResult
+	if err := c.Call(ctx, "tools/call", callToolParams{Name: name, Arguments: arguments}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Call sends a request and decodes its result. Without a deadline in ctx
+// the request is limited by RequestTimeout.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	proc, err := c.running(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.RequestTimeout)
+		defer cancel()
+	}
+
+	return c.call(ctx, proc, method, params, result)
+}
+
+// Close stops the server and fails all pending requests
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	proc := c.proc
+	c.proc = nil
+	c.mu.Unlock()
+
+	if proc != nil {
+		proc.stop()
+	}
+	return nil
+}
+
+// running returns the live server process, starting or restarting it when needed
+func (c *Client) running(ctx context.Context) (*process, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.proc != nil && !c.proc.exited() {
+		return c.proc, nil
+	}
+
+	if c.proc != nil {
+		log.Printf("MCP server %s exited (%v), restarting", c.cfg.Name, c.proc.exitErr())
+
+		now := time.Now()
+		recent := c.restarts[:0]
+		for _, restart := range c.restarts {
+			if now.Sub(restart) < c.cfg.RestartWindow {
+				recent = append(recent, restart)
+			}
+		}
+		c.restarts = append(recent, now)
+		if len(c.restarts) > c.cfg.MaxRestarts {
+			return nil, fmt.Errorf("%w: %s, stderr: %s", ErrTooManyRestarts, c.cfg.Name, c.proc.stderr.String())
+		}
+	}
+
+	proc, err := c.launch()
+	if err != nil {
+		return nil, err
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, c.cfg.StartTimeout)
+	defer cancel()
+
+	info, err := c.handshake(startCtx, proc)
+	if err != nil {
+		proc.stop()
+		return nil, fmt.Errorf("MCP server %s handshake failed: %w, stderr: %s", c.cfg.Name, err, proc.stderr.String())
+	}
+
+	c.proc = proc
+	c.serverInfo = info
+	c.resetTools()
+	log.Printf("MCP server %s started: %s %s (protocol %s)", c.cfg.Name, info.ServerInfo.Name, info.ServerInfo.Version, info.ProtocolVersion)
+	return proc, nil
+}
+
+// launch starts the server process and its reader goroutines
+func (c *Client) launch() (*process, error) {
+	cmd := exec.Command(c.cfg.Command, c.cfg.Args...)
+	cmd.Dir = c.cfg.Dir
+	cmd.Env = append(os.Environ(), c.cfg.Env...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start MCP server %s: %w", c.cfg.Name, err)
+	}
+
+	proc := &process{
+		name:           c.cfg.Name,
+		cmd:            cmd,
+		stdin:          stdin,
+		pending:        make(map[int64]chan *message),
+		done:           make(chan struct{}),
+		stderr:         &tailBuffer{limit: stderrLimit},
+		onToolsChanged: c.resetTools,
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		proc.readStderr(stderr)
+	}()
+	go func() {
+		defer readers.Done()
+		proc.readMessages(stdout)
+	}()
+	go func() {
+		readers.Wait()
+		proc.finish(cmd.Wait())
+	}()
+
+	return proc, nil
+}
+
+// resetTools makes the next Tools call repeat tools/list
+func (c *Client) resetTools() {
+	c.toolsMu.Lock()
+	c.tools = nil
+	c.toolsMu.Unlock()
+}
+
+// handshake performs initialize/initialized; tools are discovered by the first Tools call
+func (c *Client) handshake(ctx context.Context, proc *process) (InitializeResult, error) {
+	var info InitializeResult
+	err := c.call(ctx, proc, "initialize", initializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]interface{}{},
+		ClientInfo:      Implementation{Name: "chat-web-service-backend", Version: "1.0.0"},
+	}, &info)
+	if err != nil {
+		return info, err
+	}
+
+	if err := proc.notify("notifications/initialized", nil); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// listTools reads all pages of tools/list
+func (c *Client) listTools(ctx context.Context) ([]Tool, error) {
+	tools := []Tool{}
+	cursor := ""
+	for {
+		var page listToolsResult
+		if err := c.Call(ctx, "tools/list", listToolsParams{Cursor: cursor}, &page); err != nil {
+			return nil, fmt.Errorf("tools/list failed: %w", err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// call sends a request to the process and waits for the matching response
+func (c *Client) call(ctx context.Context, proc *process, method string, params, result interface{}) error {
+	id := c.nextID.Add(1)
+
+	response, err := proc.request(ctx, id, method, params)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Сообщаем серверу, что ответ больше не нужен
+			proc.notify("notifications/cancelled", cancelledParams{RequestID: id, Reason: ctx.Err().Error()})
+		}
+		return fmt.Errorf("MCP %s %s: %w", c.cfg.Name, method, err)
+	}
+
+	if response.Error != nil {
+		return response.Error
+	}
+	if result != nil && len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// process is a single run of the server
+type process struct {
+	name           string
+	cmd            *exec.Cmd
+	stdin          io.WriteCloser
+	stderr         *tailBuffer
+	onToolsChanged func()
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int64]chan *message
+	done    chan struct{}
+	err     error
+}
+
+// request writes a request and waits for its response, the context or the process exit
+func (p *process) request(ctx context.Context, id int64, method string, params interface{}) (*message, error) {
+	responses := make(chan *message, 1)
+
+	p.mu.Lock()
+	if p.exitedLocked() {
+		p.mu.Unlock()
+		return nil, p.exitErr()
+	}
+	p.pending[id] = responses
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	rawID := json.RawMessage(fmt.Sprintf("%d", id))
+	if err := p.write(message{ID: &rawID, Method: method}, params); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responses:
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+		return nil, p.exitErr()
+	}
+}
+
+// notify sends a notification, which has no response
+func (p *process) notify(method string, params interface{}) error {
+	return p.write(message{Method: method}, params)
+}
+
+// write encodes the message as a single line
+func (p *process) write(msg message, params interface{}) error {
+	msg.JSONRPC = "2.0"
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode params: %w", err)
+		}
+		msg.Params = data
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to MCP server: %w", err)
+	}
+	return nil
+}
+
+// readMessages dispatches responses to the waiting requests and answers server requests
+func (p *process) readMessages(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			// Серверы иногда пишут логи в stdout - такие строки пропускаем
+			log.Printf("MCP %s: skipping non JSON-RPC output: %s", p.name, line)
+			continue
+		}
+
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			p.answerServerRequest(&msg)
+		case msg.Method != "":
+			if msg.Method == "notifications/tools/list_changed" {
+				p.onToolsChanged()
+			}
+		case msg.ID != nil:
+			var id int64
+			if err := json.Unmarshal(*msg.ID, &id); err != nil {
+				log.Printf("MCP %s: response with unexpected id %s", p.name, string(*msg.ID))
+				continue
+			}
+
+			p.mu.Lock()
+			responses, ok := p.pending[id]
+			p.mu.Unlock()
+			if ok {
+				select {
+				case responses <- &msg:
+				default:
+					log.Printf("MCP %s: duplicate response for id %d", p.name, id)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("MCP %s: failed to read stdout: %v", p.name, err)
+		// Без stdout процесс бесполезен - останавливаем его, чтобы клиент перезапустил сервер
+		p.cmd.Process.Kill()
+	}
+}
+
+// answerServerRequest replies to requests sent by the server; only ping is supported
+func (p *process) answerServerRequest(request *message) {
+	response := message{ID: request.ID}
+	if request.Method == "ping" {
+		response.Result = json.RawMessage("{}")
+	} else {
+		response.Error = &RPCError{Code: CodeMethodNotFound, Message: "method not found: " + request.Method}
+	}
+
+	if err := p.write(response, nil); err != nil {
+		log.Printf("MCP %s: failed to answer %s: %v", p.name, request.Method, err)
+	}
+}
+
+// readStderr keeps the tail of stderr and logs every line
+func (p *process) readStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		p.stderr.WriteLine(line)
+		log.Printf("MCP %s stderr: %s", p.name, line)
+	}
+}
+
+// finish records the exit status and wakes up all waiting requests
+func (p *process) finish(err error) {
+	if err == nil {
+		err = errors.New("exited")
+	}
+
+	p.mu.Lock()
+	p.err = fmt.Errorf("MCP server %s stopped: %w, stderr: %s", p.name, err, p.stderr.String())
+	close(p.done)
+	p.mu.Unlock()
+}
+
+func (p *process) exited() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitedLocked()
+}
+
+func (p *process) exitedLocked() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *process) exitErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// stop closes stdin, giving the server a chance to exit, and kills it after a grace period
+func (p *process) stop() {
+	p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// tailBuffer keeps the last limit bytes of written lines
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+// WriteLine appends a line, dropping the oldest output above the limit
+func (b *tailBuffer) WriteLine(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, line...)
+	b.data = append(b.data, '\n')
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.data))
+}
@@ -0,0 +1,210 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain turns the test binary into a fake stdio MCP server when MCP_TEST_SERVER is set
+func TestMain(m *testing.M) {
+	if os.Getenv("MCP_TEST_SERVER") == "1" {
+		runTestServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestServer answers initialize, tools/list and tools/call for the echo, slow and crash tools
+func runTestServer() {
+	var writeMu sync.Mutex
+	write := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		os.Stdout.Write(append(data, '\n'))
+	}
+
+	fmt.Fprintln(os.Stderr, "test server started")
+	fmt.Println("not a JSON-RPC line")
+
+	initialized := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		respond := func(result interface{}) {
+			data, _ := json.Marshal(result)
+			write(message{JSONRPC: "2.0", ID: msg.ID, Result: data})
+		}
+
+		switch msg.Method {
+		case "initialize":
+			respond(InitializeResult{ProtocolVersion: ProtocolVersion, ServerInfo: Implementation{Name: "test-server", Version: "0.1.0"}})
+		case "notifications/initialized":
+			initialized = true
+		case "tools/list":
+			var params listToolsParams
+			json.Unmarshal(msg.Params, &params)
+			if params.Cursor == "" {
+				respond(listToolsResult{Tools: []Tool{{Name: "echo"}, {Name: "slow"}}, NextCursor: "page2"})
+			} else {
+				respond(listToolsResult{Tools: []Tool{{Name: "crash"}}})
+			}
+		case "tools/call":
+			if !initialized {
+				write(message{JSONRPC: "2.0", ID: msg.ID, Error: &RPCError{Code: CodeInvalidRequest, Message: "not initialized"}})
+				continue
+			}
+
+			var params struct {
+				Name      string            `json:"name"`
+				Arguments map[string]string `json:"arguments"`
+			}
+			json.Unmarshal(msg.Params, &params)
+
+			switch params.Name {
+			case "echo":
+				respond(CallToolResult{Content: []Content{{Type: "text", Text: params.Arguments["text"]}}})
+			case "slow":
+				go func(msg message) {
+					time.Sleep(200 * time.Millisecond)
+					data, _ := json.Marshal(CallToolResult{Content: []Content{{Type: "text", Text: "slow done"}}})
+					write(message{JSONRPC: "2.0", ID: msg.ID, Result: data})
+				}(msg)
+			case "crash":
+				fmt.Fprintln(os.Stderr, "fatal: crash requested")
+				os.Exit(3)
+			}
+		}
+	}
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	client := NewClient(ServerConfig{
+		Name:           "test",
+		Command:        os.Args[0],
+		Env:            []string{"MCP_TEST_SERVER=1"},
+		RequestTimeout: 5 * time.Second,
+		StartTimeout:   5 * time.Second,
+		MaxRestarts:    2,
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	t.Run("handshakeAndDiscovery", func(t *testing.T) {
+		tools, err := client.Tools(ctx)
+		if err != nil {
+			t.Fatalf("Tools failed: %v", err)
+		}
+		if len(tools) != 3 {
+			t.Errorf("tools = %+v, want both pages", tools)
+		}
+		if client.ServerInfo().ServerInfo.Name != "test-server" {
+			t.Errorf("server info = %+v", client.ServerInfo())
+		}
+		if !strings.Contains(client.Stderr(), "test server started") {
+			t.Errorf("stderr was not captured: %q", client.Stderr())
+		}
+	})
+
+	t.Run("multiplexing", func(t *testing.T) {
+		var wg sync.WaitGroup
+		order := make(chan string, 2)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			result, err := client.CallTool(ctx, "slow", nil)
+			if err != nil {
+				t.Errorf("slow failed: %v", err)
+				return
+			}
+			order <- result.Text()
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(20 * time.Millisecond)
+			result, err := client.CallTool(ctx, "echo", map[string]string{"text": "hello"})
+			if err != nil {
+				t.Errorf("echo failed: %v", err)
+				return
+			}
+			order <- result.Text()
+		}()
+		wg.Wait()
+		close(order)
+
+		var got []string
+		for text := range order {
+			got = append(got, text)
+		}
+		if strings.Join(got, ",") != "hello,slow done" {
+			t.Errorf("responses = %v, want echo answered before the slow call", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		if _, err := client.CallTool(timeoutCtx, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("unknownTool", func(t *testing.T) {
+		if _, err := client.CallTool(ctx, "missing", nil); err == nil {
+			t.Errorf("expected error for unknown tool")
+		}
+	})
+
+	t.Run("restartAfterCrash", func(t *testing.T) {
+		_, err := client.CallTool(ctx, "crash", nil)
+		if err == nil || !strings.Contains(err.Error(), "crash requested") {
+			t.Fatalf("err = %v, want the crash with stderr", err)
+		}
+
+		result, err := client.CallTool(ctx, "echo", map[string]string{"text": "again"})
+		if err != nil {
+			t.Fatalf("echo after restart failed: %v", err)
+		}
+		if result.Text() != "again" {
+			t.Errorf("result = %q", result.Text())
+		}
+	})
+
+	t.Run("tooManyRestarts", func(t *testing.T) {
+		var err error
+		for i := 0; i < 3 && !errors.Is(err, ErrTooManyRestarts); i++ {
+			_, err = client.CallTool(ctx, "crash", nil)
+		}
+		if !errors.Is(err, ErrTooManyRestarts) {
+			t.Errorf("err = %v, want ErrTooManyRestarts", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		client.Close()
+		if _, err := client.Tools(ctx); !errors.Is(err, ErrClosed) {
+			t.Errorf("err = %v, want ErrClosed", err)
+		}
+	})
+}
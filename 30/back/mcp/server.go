@@ -0,0 +1,404 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SessionHeader carries the session ID of the streamable HTTP transport
+const SessionHeader = "Mcp-Session-Id"
+
+// sessionIdleTimeout is how long an unused HTTP session is kept
+const sessionIdleTimeout = 24 * time.Hour
+
+// serverProtocolVersions are the revisions the server accepts, newest first.
+// Streamable HTTP appeared in 2025-03-26; stdio clients may still ask for 2024-11-05.
+var serverProtocolVersions = []string{"2025-03-26", ProtocolVersion}
+
+// ToolHandler runs a tool with the raw tools/call arguments.
+// Returning an *RPCError fails the request itself; failures of the tool belong in CallToolResult.IsError.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (*CallToolResult, error)
+
+// Server exposes registered tools over stdio (ServeStdio) and streamable HTTP (ServeHTTP)
+type Server struct {
+	info         Implementation
+	instructions string
+
+	mu       sync.RWMutex
+	tools    []Tool
+	handlers map[string]ToolHandler
+
+	sessionsMu sync.Mutex
+	sessions   map[string]time.Time // session ID -> last request
+}
+
+// NewServer creates a server without tools; instructions are sent to clients in the initialize result
+func NewServer(info Implementation, instructions string) *Server {
+	return &Server{
+		info:         info,
+		instructions: instructions,
+		handlers:     make(map[string]ToolHandler),
+		sessions:     make(map[string]time.Time),
+	}
+}
+
+// AddTool registers a tool, replacing a tool with the same name
+func (s *Server) AddTool(tool Tool, handler ToolHandler) {
+	if len(tool.InputSchema) == 0 {
+		tool.InputSchema = json.RawMessage(`{"type":"object"}`)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.handlers[tool.Name]; exists {
+		s.tools = slices.DeleteFunc(s.tools, func(t Tool) bool { return t.Name == tool.Name })
+	}
+	s.tools = append(s.tools, tool)
+	s.handlers[tool.Name] = handler
+}
+
+// AddTypedTool registers a tool whose arguments are decoded into In and whose input
+// schema is generated from In. The Out value is returned as JSON text, an error as an
+// IsError result so the model can see it.
+func AddTypedTool[In, Out any](s *Server, name, description string, fn func(ctx context.Context, input In) (Out, error)) {
+	s.AddTool(Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: InputSchema[In](),
+	}, func(ctx context.Context, arguments json.RawMessage) (*CallToolResult, error) {
+		var input In
+		if len(arguments) > 0 && string(arguments) != "null" {
+			if err := json.Unmarshal(arguments, &input); err != nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid arguments of %s: %v", name, err)}
+			}
+		}
+
+		output, err := fn(ctx, input)
+		if err != nil {
+			return ErrorResult(err), nil
+		}
+
+		data, err := json.Marshal(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s result: %w", name, err)
+		}
+		return &CallToolResult{Content: []Content{{Type: "text", Text: string(data)}}}, nil
+	})
+}
+
+// ErrorResult is a tool result reporting err to the client
+func ErrorResult(err error) *CallToolResult {
+	return &CallToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}
+}
+
+// Tools returns the registered tools in registration order
+func (s *Server) Tools() []Tool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.tools)
+}
+
+// handle processes one incoming message and returns the response, or nil for notifications and responses
+func (s *Server) handle(ctx context.Context, msg *message) *message {
+	if msg.Method == "" || msg.ID == nil {
+		// The server sends no requests, so responses are unexpected; notifications need no answer
+		return nil
+	}
+
+	result, err := s.dispatch(ctx, msg.Method, msg.Params)
+	response := &message{JSONRPC: "2.0", ID: msg.ID}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: CodeInternalError, Message: err.Error()}
+		}
+		response.Error = rpcErr
+		return response
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		response.Error = &RPCError{Code: CodeInternalError, Message: err.Error()}
+		return response
+	}
+	response.Result = data
+	return response
+}
+
+func (s *Server) dispatch(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		var p initializeParams
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: err.Error()}
+			}
+		}
+
+		version := serverProtocolVersions[0]
+		if slices.Contains(serverProtocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+		log.Printf("MCP client connected: %s %s (protocol %s)", p.ClientInfo.Name, p.ClientInfo.Version, version)
+
+		return InitializeResult{
+			ProtocolVersion: version,
+			Capabilities:    map[string]json.RawMessage{"tools": json.RawMessage(`{}`)},
+			ServerInfo:      s.info,
+			Instructions:    s.instructions,
+		}, nil
+
+	case "ping":
+		return struct{}{}, nil
+
+	case "tools/list":
+		return listToolsResult{Tools: s.Tools()}, nil
+
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+
+		s.mu.RLock()
+		handler, ok := s.handlers[p.Name]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: fmt.Sprintf("unknown tool %q", p.Name)}
+		}
+
+		start := time.Now()
+		result, err := handler(ctx, p.Arguments)
+		if err != nil {
+			log.Printf("MCP tool %s failed after %v: %v", p.Name, time.Since(start), err)
+			return nil, err
+		}
+		log.Printf("MCP tool %s finished in %v (error: %t)", p.Name, time.Since(start), result.IsError)
+		return result, nil
+
+	default:
+		return nil, &RPCError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+	}
+}
+
+// ServeStdio serves newline-delimited JSON-RPC from in to out until in is closed.
+// Requests run concurrently; ctx is the parent of every request context, and
+// notifications/cancelled cancels a single request. Logs must not go to out.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	var writeMu sync.Mutex
+	write := func(msg *message) {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("MCP server: failed to encode response: %v", err)
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if _, err := out.Write(append(data, '\n')); err != nil {
+			log.Printf("MCP server: failed to write response: %v", err)
+		}
+	}
+
+	var (
+		wg         sync.WaitGroup
+		inflightMu sync.Mutex
+		inflight   = make(map[string]context.CancelFunc)
+	)
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			write(&message{JSONRPC: "2.0", ID: nullID(), Error: &RPCError{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+
+		if msg.Method == "notifications/cancelled" {
+			var params struct {
+				RequestID json.RawMessage `json:"requestId"`
+			}
+			json.Unmarshal(msg.Params, &params)
+
+			inflightMu.Lock()
+			if cancel, ok := inflight[string(params.RequestID)]; ok {
+				// Removing the entry also suppresses the response, as the spec asks
+				delete(inflight, string(params.RequestID))
+				cancel()
+			}
+			inflightMu.Unlock()
+			continue
+		}
+		if msg.ID == nil {
+			s.handle(ctx, &msg)
+			continue
+		}
+
+		key := string(*msg.ID)
+		reqCtx, cancel := context.WithCancel(ctx)
+		inflightMu.Lock()
+		inflight[key] = cancel
+		inflightMu.Unlock()
+
+		wg.Add(1)
+		go func(msg message) {
+			defer wg.Done()
+			defer cancel()
+
+			response := s.handle(reqCtx, &msg)
+
+			inflightMu.Lock()
+			_, active := inflight[key]
+			delete(inflight, key)
+			inflightMu.Unlock()
+
+			if active && response != nil {
+				write(response)
+			}
+		}(msg)
+	}
+
+	return scanner.Err()
+}
+
+// ServeHTTP implements the streamable HTTP transport: every JSON-RPC request is a
+// POST answered with a single JSON response. The server never initiates messages,
+// so GET (server-sent event stream) is not offered. DELETE ends the session.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.servePost(w, r)
+	case http.MethodDelete:
+		sessionID := r.Header.Get(SessionHeader)
+		s.sessionsMu.Lock()
+		_, ok := s.sessions[sessionID]
+		delete(s.sessions, sessionID)
+		s.sessionsMu.Unlock()
+
+		if !ok {
+			http.Error(w, "Unknown MCP session", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) servePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		writeHTTPMessage(w, http.StatusBadRequest, &message{JSONRPC: "2.0", ID: nullID(), Error: &RPCError{Code: CodeInvalidRequest, Message: "batch requests are not supported"}})
+		return
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		writeHTTPMessage(w, http.StatusBadRequest, &message{JSONRPC: "2.0", ID: nullID(), Error: &RPCError{Code: CodeParseError, Message: err.Error()}})
+		return
+	}
+
+	if msg.Method != "initialize" {
+		sessionID := r.Header.Get(SessionHeader)
+		if sessionID == "" {
+			http.Error(w, "Missing "+SessionHeader+" header", http.StatusBadRequest)
+			return
+		}
+		if !s.touchSession(sessionID) {
+			http.Error(w, "Unknown MCP session", http.StatusNotFound)
+			return
+		}
+	}
+
+	response := s.handle(r.Context(), &msg)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if msg.Method == "initialize" && response.Error == nil {
+		sessionID, err := s.newSession()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(SessionHeader, sessionID)
+	}
+
+	writeHTTPMessage(w, http.StatusOK, response)
+}
+
+// newSession creates a session ID and drops the sessions that were idle for too long
+func (s *Server) newSession() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(buf)
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	now := time.Now()
+	for id, lastSeen := range s.sessions {
+		if now.Sub(lastSeen) > sessionIdleTimeout {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[sessionID] = now
+	return sessionID, nil
+}
+
+// touchSession reports whether the session exists and marks it as used
+func (s *Server) touchSession(sessionID string) bool {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if _, ok := s.sessions[sessionID]; !ok {
+		return false
+	}
+	s.sessions[sessionID] = time.Now()
+	return true
+}
+
+func writeHTTPMessage(w http.ResponseWriter, status int, msg *message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// nullID is the id of error responses to messages whose id could not be read
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
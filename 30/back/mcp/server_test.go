@@ -0,0 +1,242 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaTestEmbedded struct {
+	Tags []string `json:"tags,omitempty"`
+}
+
+type schemaTestRequest struct {
+	schemaTestEmbedded
+	Message  string            `json:"message" description:"the message"`
+	Count    int               `json:"count,omitempty"`
+	Ratio    *float64          `json:"ratio"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Internal string            `json:"-"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema := SchemaFor(reflect.TypeFor[schemaTestRequest]())
+
+	if schema.Type != "object" {
+		t.Fatalf("type = %q", schema.Type)
+	}
+	want := map[string]string{"message": "string", "count": "integer", "ratio": "number", "labels": "object", "tags": "array"}
+	if len(schema.Properties) != len(want) {
+		t.Errorf("properties = %v", schema.Properties)
+	}
+	for name, typ := range want {
+		if property := schema.Properties[name]; property == nil || property.Type != typ {
+			t.Errorf("property %s = %+v, want %s", name, property, typ)
+		}
+	}
+	if schema.Properties["message"].Description != "the message" {
+		t.Errorf("description = %q", schema.Properties["message"].Description)
+	}
+	if schema.Properties["tags"].Items.Type != "string" || schema.Properties["labels"].AdditionalProperties.Type != "string" {
+		t.Errorf("element schemas are missing")
+	}
+	if strings.Join(schema.Required, ",") != "message" {
+		t.Errorf("required = %v, want only message", schema.Required)
+	}
+}
+
+func newTestServer() *Server {
+	server := NewServer(Implementation{Name: "test", Version: "1.0.0"}, "test instructions")
+
+	type echoArgs struct {
+		Text string `json:"text"`
+	}
+	AddTypedTool(server, "echo", "echoes the text", func(_ context.Context, args echoArgs) (echoArgs, error) {
+		if args.Text == "" {
+			return echoArgs{}, errors.New("text cannot be empty")
+		}
+		return args, nil
+	})
+	AddTypedTool(server, "wait", "waits until cancelled", func(ctx context.Context, _ struct{}) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	return server
+}
+
+func TestServerStdio(t *testing.T) {
+	server := newTestServer()
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeStdio(context.Background(), inReader, outWriter)
+		outWriter.Close()
+	}()
+
+	responses := bufio.NewScanner(outReader)
+	send := func(line string) {
+		if _, err := io.WriteString(inWriter, line+"\n"); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	receive := func() message {
+		if !responses.Scan() {
+			t.Fatalf("no response: %v", responses.Err())
+		}
+		var msg message
+		if err := json.Unmarshal(responses.Bytes(), &msg); err != nil {
+			t.Fatalf("invalid response %q: %v", responses.Text(), err)
+		}
+		return msg
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","clientInfo":{"name":"test","version":"1"}}}`)
+	var initResult InitializeResult
+	json.Unmarshal(receive().Result, &initResult)
+	if initResult.ProtocolVersion != "2024-11-05" || initResult.ServerInfo.Name != "test" || initResult.Instructions != "test instructions" {
+		t.Errorf("initialize result = %+v", initResult)
+	}
+	send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	send(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	var list listToolsResult
+	json.Unmarshal(receive().Result, &list)
+	if len(list.Tools) != 2 || list.Tools[0].Name != "echo" {
+		t.Fatalf("tools = %+v", list.Tools)
+	}
+	if !strings.Contains(string(list.Tools[0].InputSchema), `"required":["text"]`) {
+		t.Errorf("echo schema = %s", list.Tools[0].InputSchema)
+	}
+
+	// The waiting call must not block the echo call behind it
+	send(`{"jsonrpc":"2.0","id":"wait-1","method":"tools/call","params":{"name":"wait"}}`)
+	send(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`)
+	response := receive()
+	var result CallToolResult
+	json.Unmarshal(response.Result, &result)
+	if string(*response.ID) != "3" || result.IsError || result.Text() != `{"text":"hi"}` {
+		t.Errorf("echo response = %s %+v", *response.ID, result)
+	}
+
+	send(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"wait-1"}}`)
+
+	send(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"echo","arguments":{}}}`)
+	response = receive()
+	result = CallToolResult{}
+	json.Unmarshal(response.Result, &result)
+	if string(*response.ID) != "4" || !result.IsError || result.Text() != "text cannot be empty" {
+		t.Errorf("cancelled call was answered or tool error not reported: %s %+v", *response.ID, result)
+	}
+
+	send(`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"missing"}}`)
+	if response := receive(); response.Error == nil || response.Error.Code != CodeInvalidParams {
+		t.Errorf("unknown tool response = %+v", response)
+	}
+
+	send(`{"jsonrpc":"2.0","id":6,"method":"resources/list"}`)
+	if response := receive(); response.Error == nil || response.Error.Code != CodeMethodNotFound {
+		t.Errorf("unknown method response = %+v", response)
+	}
+
+	send(`not json`)
+	if response := receive(); response.Error == nil || response.Error.Code != CodeParseError {
+		t.Errorf("parse error response = %+v", response)
+	}
+
+	inWriter.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeStdio returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ServeStdio did not return after stdin was closed")
+	}
+}
+
+func TestServerHTTP(t *testing.T) {
+	httpServer := httptest.NewServer(newTestServer())
+	defer httpServer.Close()
+
+	post := func(sessionID, body string) (*http.Response, message) {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		if sessionID != "" {
+			req.Header.Set(SessionHeader, sessionID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var msg message
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return resp, msg
+	}
+
+	resp, msg := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	sessionID := resp.Header.Get(SessionHeader)
+	if resp.StatusCode != http.StatusOK || sessionID == "" {
+		t.Fatalf("initialize status = %d, session = %q", resp.StatusCode, sessionID)
+	}
+	var initResult InitializeResult
+	json.Unmarshal(msg.Result, &initResult)
+	if initResult.ProtocolVersion != "2025-03-26" {
+		t.Errorf("protocol version = %q", initResult.ProtocolVersion)
+	}
+
+	if resp, _ := post(sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("notification status = %d, want 202", resp.StatusCode)
+	}
+
+	resp, msg = post(sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo","arguments":{"text":"over http"}}}`)
+	var result CallToolResult
+	json.Unmarshal(msg.Result, &result)
+	if resp.StatusCode != http.StatusOK || result.Text() != `{"text":"over http"}` {
+		t.Errorf("tools/call = %d %+v", resp.StatusCode, result)
+	}
+
+	if resp, _ := post("", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("request without session status = %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := post("unknown", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request with unknown session status = %d, want 404", resp.StatusCode)
+	}
+
+	getResp, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	getResp.Body.Close()
+	if getResp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", getResp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, httpServer.URL, nil)
+	req.Header.Set(SessionHeader, sessionID)
+	deleteResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	deleteResp.Body.Close()
+	if deleteResp.StatusCode != http.StatusOK {
+		t.Errorf("DELETE status = %d", deleteResp.StatusCode)
+	}
+	if resp, _ := post(sessionID, `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request after DELETE status = %d, want 404", resp.StatusCode)
+	}
+}
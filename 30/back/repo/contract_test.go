@@ -0,0 +1,497 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRepositoryContract runs the behaviour every Repository implementation must
+// share against fresh, migrated repositories returned by open
+func testRepositoryContract(t *testing.T, open func(t *testing.T) Repository) {
+	ctx := context.Background()
+
+	t.Run("chats", func(t *testing.T) {
+		r := open(t)
+		first, err := r.CreateChat(ctx, "first", "alice")
+		if err != nil || first.ID == 0 || first.UserID != "alice" {
+			t.Fatalf("CreateChat = %+v, %v", first, err)
+		}
+		second, _ := r.CreateChat(ctx, "second", "")
+
+		time.Sleep(5 * time.Millisecond)
+		if err := r.UpdateChat(ctx, first.ID, "renamed"); err != nil {
+			t.Fatalf("UpdateChat failed: %v", err)
+		}
+		chats, err := r.GetChats(ctx, 10, 0)
+		if err != nil || len(chats) != 2 || chats[0].Title != "renamed" || chats[1].ID != second.ID {
+			t.Fatalf("GetChats = %+v, %v", chats, err)
+		}
+		if chats, _ := r.GetChats(ctx, 1, 1); len(chats) != 1 || chats[0].ID != second.ID {
+			t.Errorf("GetChats(1, 1) = %+v", chats)
+		}
+
+		if err := r.DeleteChat(ctx, first.ID); err != nil {
+			t.Fatalf("DeleteChat failed: %v", err)
+		}
+		if chat, err := r.GetChat(ctx, second.ID); err != nil || chat.UserID != "" {
+			t.Errorf("GetChat = %+v, %v", chat, err)
+		}
+		if _, err := r.GetChat(ctx, first.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetChat of a deleted chat: %v, want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("messages, projects and images", func(t *testing.T) {
+		r := open(t)
+		chat, _ := r.CreateChat(ctx, "chat", "")
+
+		for _, content := range []string{"one", "two", "three"} {
+			if _, err := r.CreateMessage(ctx, chat.ID, "user", content); err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+		}
+		messages, err := r.GetMessages(ctx, chat.ID, 2, 1)
+		if err != nil || len(messages) != 2 || messages[0].Content != "two" || messages[1].Content != "three" {
+			t.Fatalf("GetMessages = %+v, %v", messages, err)
+		}
+		recent, err := r.GetRecentMessages(ctx, chat.ID, 2)
+		if err != nil || len(recent) != 2 || recent[0].Content != "two" || recent[1].Content != "three" {
+			t.Errorf("GetRecentMessages = %+v, %v", recent, err)
+		}
+		if msg, err := r.GetMessage(ctx, messages[0].ID); err != nil || msg.Role != "user" || msg.ChatID != chat.ID {
+			t.Errorf("GetMessage = %+v, %v", msg, err)
+		}
+		if _, err := r.CreateMessage(ctx, chat.ID+100, "user", "orphan"); err == nil {
+			t.Errorf("CreateMessage accepted a missing chat")
+		}
+
+		project, err := r.CreateProject(ctx, chat.ID, "site", "a site", "result/site.html", "alice")
+		if err != nil || project.Status != "building" || project.UserID != "alice" {
+			t.Fatalf("CreateProject = %+v, %v", project, err)
+		}
+		if err := r.UpdateProjectStatus(ctx, project.ID, "completed"); err != nil {
+			t.Fatalf("UpdateProjectStatus failed: %v", err)
+		}
+		projects, err := r.GetProjectsByChat(ctx, chat.ID)
+		if err != nil || len(projects) != 1 || projects[0].Status != "completed" || projects[0].FilePath != "result/site.html" || projects[0].UserID != "alice" {
+			t.Errorf("GetProjectsByChat = %+v, %v", projects, err)
+		}
+
+		image, err := r.CreateImage(ctx, chat.ID, "a cat", "result/cat.png")
+		if err != nil {
+			t.Fatalf("CreateImage failed: %v", err)
+		}
+		if got, err := r.GetImage(ctx, image.ID); err != nil || got.Prompt != "a cat" {
+			t.Errorf("GetImage = %+v, %v", got, err)
+		}
+
+		// Deleting the chat deletes everything in it
+		if err := r.DeleteChat(ctx, chat.ID); err != nil {
+			t.Fatalf("DeleteChat failed: %v", err)
+		}
+		if messages, _ := r.GetMessages(ctx, chat.ID, 10, 0); len(messages) != 0 {
+			t.Errorf("messages of a deleted chat: %+v", messages)
+		}
+		if _, err := r.GetProject(ctx, project.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetProject of a deleted chat: %v, want sql.ErrNoRows", err)
+		}
+		if images, _ := r.GetImagesByChat(ctx, chat.ID); len(images) != 0 {
+			t.Errorf("images of a deleted chat: %+v", images)
+		}
+	})
+
+	t.Run("latest project", func(t *testing.T) {
+		r := open(t)
+		if _, err := r.GetLatestProject(ctx); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetLatestProject without projects: %v, want sql.ErrNoRows", err)
+		}
+
+		first, _ := r.CreateChat(ctx, "first", "")
+		second, _ := r.CreateChat(ctx, "second", "")
+		r.CreateProject(ctx, second.ID, "old", "", "", "")
+		time.Sleep(5 * time.Millisecond)
+		latest, _ := r.CreateProject(ctx, first.ID, "new", "", "", "")
+		if project, err := r.GetLatestProject(ctx); err != nil || project.ID != latest.ID || project.ChatID != first.ID {
+			t.Errorf("GetLatestProject = %+v, %v", project, err)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		r := open(t)
+		coffee, _ := r.CreateChat(ctx, "Кофейня <Зерно>", "alice")
+		other, _ := r.CreateChat(ctx, "Другое", "bob")
+		r.CreateMessage(ctx, coffee.ID, "user", "Хочу сайт для кофейни с меню и <b>ценами</b>")
+		r.CreateMessage(ctx, coffee.ID, "assistant", "Какие цвета у кофейни?")
+		project, _ := r.CreateProject(ctx, coffee.ID, "Кофейня Зерно", "Лендинг с меню", "", "")
+		r.CreateImage(ctx, coffee.ID, "логотип кофейни в тёплых тонах", "")
+		r.CreateMessage(ctx, other.ID, "user", "Сайт для автосервиса")
+
+		hits, err := r.Search(ctx, SearchQuery{Text: "кофейн"})
+		if err != nil || len(hits) != 4 {
+			t.Fatalf("Search = %+v, %v", hits, err)
+		}
+		// The project name weighs the most
+		if hits[0].Kind != SearchKindProject || hits[0].ID != project.ID || hits[0].Title != "<mark>Кофейня</mark> Зерно" {
+			t.Errorf("best hit = %+v", hits[0])
+		}
+		for i := 1; i < len(hits); i++ {
+			if hits[i].Score > hits[i-1].Score {
+				t.Errorf("hits are not ranked: %+v", hits)
+			}
+		}
+
+		// Every word must match; titles and snippets are escaped HTML
+		hits, err = r.Search(ctx, SearchQuery{Text: "Меню, ЦЕНАМИ!"})
+		if err != nil || len(hits) != 1 || hits[0].Kind != SearchKindMessage || hits[0].Title != "Кофейня &lt;Зерно&gt;" {
+			t.Fatalf("Search(меню ценами) = %+v, %v", hits, err)
+		}
+		if want := "Хочу сайт для кофейни с <mark>меню</mark> и &lt;b&gt;<mark>ценами</mark>&lt;/b&gt;"; hits[0].Snippet != want {
+			t.Errorf("snippet = %q, want %q", hits[0].Snippet, want)
+		}
+
+		if hits, _ := r.Search(ctx, SearchQuery{Text: "сайт", Kind: SearchKindMessage, ChatID: other.ID}); len(hits) != 1 || hits[0].ChatID != other.ID {
+			t.Errorf("Search in a chat = %+v", hits)
+		}
+		if hits, _ := r.Search(ctx, SearchQuery{Text: "сайт", UserID: "bob"}); len(hits) != 1 || hits[0].ChatID != other.ID {
+			t.Errorf("Search of a user = %+v", hits)
+		}
+		if hits, _ := r.Search(ctx, SearchQuery{Text: "кофейн", UserID: "bob"}); len(hits) != 0 {
+			t.Errorf("Search finds chats of other users: %+v", hits)
+		}
+		if hits, _ := r.Search(ctx, SearchQuery{Text: "кофейн", Kind: SearchKindImage}); len(hits) != 1 || hits[0].Kind != SearchKindImage {
+			t.Errorf("Search of images = %+v", hits)
+		}
+		if hits, err := r.Search(ctx, SearchQuery{Text: `" OR * -- '`}); err != nil || len(hits) != 0 {
+			t.Errorf("Search without words = %+v, %v", hits, err)
+		}
+
+		// The pages of the cursor make up the whole result
+		var paged []*SearchHit
+		query := SearchQuery{Text: "кофейн", Limit: 3}
+		for page := 0; page < 3; page++ {
+			hits, err := r.Search(ctx, query)
+			if err != nil {
+				t.Fatalf("Search page %d failed: %v", page, err)
+			}
+			paged = append(paged, hits...)
+			if len(hits) < query.Limit {
+				break
+			}
+			query.After = hits[len(hits)-1].Cursor()
+		}
+		if all, _ := r.Search(ctx, SearchQuery{Text: "кофейн"}); len(paged) != len(all) || paged[3].ID != all[3].ID || paged[3].Kind != all[3].Kind {
+			t.Errorf("paged hits = %+v, want %+v", paged, all)
+		}
+
+		// The index follows deletes
+		if err := r.DeleteChat(ctx, coffee.ID); err != nil {
+			t.Fatalf("DeleteChat failed: %v", err)
+		}
+		if hits, _ := r.Search(ctx, SearchQuery{Text: "кофейн"}); len(hits) != 0 {
+			t.Errorf("hits of a deleted chat: %+v", hits)
+		}
+	})
+
+	t.Run("user requests and dialog sessions", func(t *testing.T) {
+		r := open(t)
+		if count, err := r.GetUserRequestCount(ctx, "alice", "2026-01-02"); err != nil || count != 0 {
+			t.Errorf("GetUserRequestCount before requests = %d, %v", count, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.IncrementUserRequestCount(ctx, "alice", "2026-01-02"); err != nil {
+				t.Fatalf("IncrementUserRequestCount failed: %v", err)
+			}
+		}
+		if count, _ := r.GetUserRequestCount(ctx, "alice", "2026-01-02"); count != 2 {
+			t.Errorf("GetUserRequestCount = %d, want 2", count)
+		}
+
+		chat, _ := r.CreateChat(ctx, "dialog", "")
+		if _, err := r.CreateDialogSession(ctx, "alice", chat.ID); err != nil {
+			t.Fatalf("CreateDialogSession failed: %v", err)
+		}
+		session, err := r.GetDialogSession(ctx, "alice")
+		if err != nil || session.IsComplete || session.Requirements != "{}" {
+			t.Fatalf("GetDialogSession = %+v, %v", session, err)
+		}
+		session.IsComplete = true
+		session.Requirements = `{"goal":"shop"}`
+		if err := r.UpdateDialogSession(ctx, session); err != nil {
+			t.Fatalf("UpdateDialogSession failed: %v", err)
+		}
+		if session, _ := r.GetDialogSession(ctx, "alice"); !session.IsComplete || session.Requirements != `{"goal":"shop"}` {
+			t.Errorf("updated session = %+v", session)
+		}
+	})
+
+	t.Run("jobs and pipelines", func(t *testing.T) {
+		r := open(t)
+		for _, id := range []string{"a", "b", "c"} {
+			if err := r.CreateJob(ctx, &Job{ID: id, Type: "build", Status: JobStatusQueued, Payload: "{}"}); err != nil {
+				t.Fatalf("CreateJob failed: %v", err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		job, err := r.GetJob(ctx, "b")
+		if err != nil || job.StartedAt != nil {
+			t.Fatalf("GetJob = %+v, %v", job, err)
+		}
+		now := time.Now()
+		job.Status, job.StartedAt = JobStatusRunning, &now
+		if err := r.UpdateJob(ctx, job); err != nil {
+			t.Fatalf("UpdateJob failed: %v", err)
+		}
+		jobs, err := r.GetJobsByStatus(ctx, JobStatusQueued, JobStatusRunning)
+		if err != nil || len(jobs) != 3 || jobs[0].ID != "a" || jobs[2].ID != "c" {
+			t.Fatalf("GetJobsByStatus = %+v, %v", jobs, err)
+		}
+		if running, _ := r.GetJobsByStatus(ctx, JobStatusRunning); len(running) != 1 || running[0].StartedAt == nil || !running[0].StartedAt.Round(time.Millisecond).Equal(now.Round(time.Millisecond)) {
+			t.Errorf("running jobs = %+v", running)
+		}
+
+		run := &PipelineRun{ID: "run", Pipeline: "site", Status: "running", Input: "{}"}
+		if err := r.CreatePipelineRun(ctx, run); err != nil {
+			t.Fatalf("CreatePipelineRun failed: %v", err)
+		}
+		artifacts := []*PipelineArtifact{
+			{RunID: "run", Stage: "idea", Attempt: 1, Status: "running"},
+			{RunID: "run", Stage: "build", Attempt: 1, Status: "running"},
+		}
+		for _, artifact := range artifacts {
+			if err := r.CreatePipelineArtifact(ctx, artifact); err != nil || artifact.ID == 0 {
+				t.Fatalf("CreatePipelineArtifact = %+v, %v", artifact, err)
+			}
+		}
+		artifacts[0].Status, artifacts[0].Output, artifacts[0].FinishedAt = "succeeded", `"idea"`, &now
+		if err := r.UpdatePipelineArtifact(ctx, artifacts[0]); err != nil {
+			t.Fatalf("UpdatePipelineArtifact failed: %v", err)
+		}
+		stored, err := r.GetPipelineArtifacts(ctx, "run")
+		if err != nil || len(stored) != 2 || stored[0].Status != "succeeded" || stored[0].FinishedAt == nil || stored[1].FinishedAt != nil {
+			t.Errorf("GetPipelineArtifacts = %+v, %v", stored, err)
+		}
+
+		run.Status, run.Output, run.FinishedAt = "succeeded", `"site"`, &now
+		if err := r.UpdatePipelineRun(ctx, run); err != nil {
+			t.Fatalf("UpdatePipelineRun failed: %v", err)
+		}
+		if got, err := r.GetPipelineRun(ctx, "run"); err != nil || got.Status != "succeeded" || got.FinishedAt == nil {
+			t.Errorf("GetPipelineRun = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("generation steps and guard findings", func(t *testing.T) {
+		r := open(t)
+		chat, _ := r.CreateChat(ctx, "chat", "")
+		project, _ := r.CreateProject(ctx, chat.ID, "site", "", "", "")
+
+		for i, name := range []string{"plan", "draft"} {
+			step := &GenerationStep{ProjectID: project.ID, Step: i + 1, Name: name, LatencyMS: 1500, PromptTokens: 10}
+			if err := r.CreateGenerationStep(ctx, step); err != nil || step.ID == 0 {
+				t.Fatalf("CreateGenerationStep = %+v, %v", step, err)
+			}
+		}
+		steps, err := r.GetGenerationSteps(ctx, project.ID)
+		if err != nil || len(steps) != 2 || steps[0].Name != "plan" || steps[1].LatencyMS != 1500 {
+			t.Errorf("GetGenerationSteps = %+v, %v", steps, err)
+		}
+
+		finding := &GuardFinding{ProjectID: project.ID, Stage: GuardStageOutput, Rule: "script", Action: GuardActionRemove, Count: 2}
+		if err := r.CreateGuardFinding(ctx, finding); err != nil || finding.ID == 0 {
+			t.Fatalf("CreateGuardFinding = %+v, %v", finding, err)
+		}
+		if findings, err := r.GetGuardFindings(ctx, project.ID); err != nil || len(findings) != 1 || findings[0].Count != 2 {
+			t.Errorf("GetGuardFindings = %+v, %v", findings, err)
+		}
+	})
+
+	t.Run("deployments", func(t *testing.T) {
+		r := open(t)
+		chat, _ := r.CreateChat(ctx, "chat", "")
+		project, _ := r.CreateProject(ctx, chat.ID, "site", "", "result/site.html", "")
+
+		for _, target := range []string{"local", "s3"} {
+			deployment := &Deployment{ProjectID: &project.ID, Target: target, Status: DeploymentStatusRunning, File: "site.html"}
+			if err := r.CreateDeployment(ctx, deployment); err != nil || deployment.ID == 0 {
+				t.Fatalf("CreateDeployment = %+v, %v", deployment, err)
+			}
+			if target == "s3" {
+				finishedAt := time.Now()
+				deployment.Status = DeploymentStatusVerified
+				deployment.URL = "https://bucket.example.com/site.html"
+				deployment.Verification = `{"attempts":1}`
+				deployment.FinishedAt = &finishedAt
+				if err := r.UpdateDeployment(ctx, deployment); err != nil {
+					t.Fatalf("UpdateDeployment failed: %v", err)
+				}
+			}
+		}
+		// Files published by pipelines have no project
+		if err := r.CreateDeployment(ctx, &Deployment{Target: "local", Status: DeploymentStatusRunning}); err != nil {
+			t.Fatalf("CreateDeployment without a project failed: %v", err)
+		}
+
+		deployments, err := r.GetDeployments(ctx, project.ID)
+		if err != nil || len(deployments) != 2 {
+			t.Fatalf("GetDeployments = %+v, %v", deployments, err)
+		}
+		if latest := deployments[0]; latest.Target != "s3" || latest.Status != DeploymentStatusVerified || latest.URL == "" || latest.Verification != `{"attempts":1}` ||
+			latest.FinishedAt == nil || latest.ProjectID == nil || *latest.ProjectID != project.ID {
+			t.Errorf("latest deployment = %+v", latest)
+		}
+		if deployments[1].Status != DeploymentStatusRunning || deployments[1].FinishedAt != nil {
+			t.Errorf("first deployment = %+v", deployments[1])
+		}
+		if deployment, err := r.GetDeployment(ctx, deployments[0].ID); err != nil || deployment.Verification != `{"attempts":1}` || *deployment.ProjectID != project.ID {
+			t.Errorf("GetDeployment = %+v, %v", deployment, err)
+		}
+		if _, err := r.GetDeployment(ctx, 999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetDeployment of a missing deployment = %v", err)
+		}
+	})
+
+	t.Run("revisions", func(t *testing.T) {
+		r := open(t)
+		chat, _ := r.CreateChat(ctx, "chat", "")
+		project, _ := r.CreateProject(ctx, chat.ID, "site", "", "result/site.html", "")
+
+		first := &ProjectRevision{ProjectID: project.ID, Hash: "aaa", FilePath: "result/revisions/aaa.html", Size: 10, Source: RevisionSourceBuild}
+		if err := r.CreateProjectRevision(ctx, first); err != nil || first.ID == 0 || first.CreatedAt.IsZero() {
+			t.Fatalf("CreateProjectRevision = %+v, %v", first, err)
+		}
+		second := &ProjectRevision{ProjectID: project.ID, Hash: "bbb", FilePath: "result/revisions/bbb.html", Source: RevisionSourceRevise,
+			Parent: "aaa", Instruction: "make the header green"}
+		if err := r.CreateProjectRevision(ctx, second); err != nil || second.ID == first.ID {
+			t.Fatalf("CreateProjectRevision = %+v, %v", second, err)
+		}
+
+		// The same content is the same revision
+		again := &ProjectRevision{ProjectID: project.ID, Hash: "aaa", FilePath: "other", Source: RevisionSourcePublish}
+		if err := r.CreateProjectRevision(ctx, again); err != nil || again.ID != first.ID || again.Source != RevisionSourceBuild || again.FilePath != first.FilePath {
+			t.Errorf("CreateProjectRevision of a known hash = %+v, %v", again, err)
+		}
+
+		revisions, err := r.GetProjectRevisions(ctx, project.ID)
+		if err != nil || len(revisions) != 2 || revisions[0].Hash != "bbb" || revisions[1].Size != 10 ||
+			revisions[0].Parent != "aaa" || revisions[0].Instruction != "make the header green" || revisions[1].Parent != "" {
+			t.Errorf("GetProjectRevisions = %+v, %v", revisions, err)
+		}
+
+		deployment := &Deployment{ProjectID: &project.ID, Target: "local", Status: DeploymentStatusRunning, Revision: "bbb"}
+		r.CreateDeployment(ctx, deployment)
+		if deployments, _ := r.GetDeployments(ctx, project.ID); len(deployments) != 1 || deployments[0].Revision != "bbb" {
+			t.Errorf("GetDeployments = %+v", deployments)
+		}
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		r := open(t)
+		key := &APIKey{UserID: "alice", Role: "admin", Name: "cli", Prefix: "sk_abc", KeyHash: "hash"}
+		if err := r.CreateAPIKey(ctx, key); err != nil || key.ID == 0 {
+			t.Fatalf("CreateAPIKey = %+v, %v", key, err)
+		}
+		if err := r.CreateAPIKey(ctx, &APIKey{UserID: "bob", Role: "viewer", KeyHash: "hash"}); err == nil {
+			t.Errorf("CreateAPIKey accepted a duplicate hash")
+		}
+
+		if err := r.TouchAPIKey(ctx, key.ID); err != nil {
+			t.Fatalf("TouchAPIKey failed: %v", err)
+		}
+		if err := r.RevokeAPIKey(ctx, key.ID); err != nil {
+			t.Fatalf("RevokeAPIKey failed: %v", err)
+		}
+		if err := r.RevokeAPIKey(ctx, key.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("second RevokeAPIKey: %v, want sql.ErrNoRows", err)
+		}
+		got, err := r.GetAPIKeyByHash(ctx, "hash")
+		if err != nil || got.LastUsedAt == nil || got.RevokedAt == nil || got.Prefix != "sk_abc" {
+			t.Errorf("GetAPIKeyByHash = %+v, %v", got, err)
+		}
+		if keys, err := r.GetAPIKeys(ctx); err != nil || len(keys) != 1 {
+			t.Errorf("GetAPIKeys = %+v, %v", keys, err)
+		}
+	})
+
+	t.Run("quota counters", func(t *testing.T) {
+		r := open(t)
+		day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+		for _, usage := range []QuotaCounter{
+			{UserID: "alice", Scope: "build", Period: QuotaPeriodDay, PeriodStart: day, Requests: 1, Tokens: 100},
+			{UserID: "alice", Scope: "build", Period: QuotaPeriodDay, PeriodStart: day, Requests: 1, Tokens: 50},
+			{UserID: "alice", Scope: "ask", Period: QuotaPeriodDay, PeriodStart: day.AddDate(0, 0, -1), Requests: 1},
+		} {
+			counter := usage
+			if err := r.AddQuotaUsage(ctx, &counter); err != nil {
+				t.Fatalf("AddQuotaUsage failed: %v", err)
+			}
+			if counter.Scope == "build" && counter.Tokens < usage.Tokens {
+				t.Errorf("AddQuotaUsage returned %+v", counter)
+			}
+		}
+
+		counters, err := r.GetQuotaCounters(ctx, "alice", day)
+		if err != nil || len(counters) != 1 || counters[0].Requests != 2 || counters[0].Tokens != 150 || !counters[0].PeriodStart.Equal(day) {
+			t.Fatalf("GetQuotaCounters = %+v, %v", counters, err)
+		}
+		if deleted, err := r.DeleteQuotaCountersBefore(ctx, day); err != nil || deleted != 1 {
+			t.Errorf("DeleteQuotaCountersBefore = %d, %v", deleted, err)
+		}
+		if deleted, err := r.ResetQuotaCounters(ctx, "alice", "ask"); err != nil || deleted != 0 {
+			t.Errorf("ResetQuotaCounters(ask) = %d, %v", deleted, err)
+		}
+		if deleted, err := r.ResetQuotaCounters(ctx, "alice", ""); err != nil || deleted != 1 {
+			t.Errorf("ResetQuotaCounters = %d, %v", deleted, err)
+		}
+	})
+
+	t.Run("audit events", func(t *testing.T) {
+		r := open(t)
+		start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+		projectID := int64(7)
+		for i, action := range []string{"build", "publish", "build"} {
+			event := &AuditEvent{
+				Actor:      "alice",
+				Action:     action,
+				Outcome:    AuditOutcomeSuccess,
+				StartedAt:  start.Add(time.Duration(i) * time.Hour),
+				FinishedAt: start.Add(time.Duration(i)*time.Hour + time.Second),
+			}
+			if action == "publish" {
+				event.ProjectID = &projectID
+			}
+			if err := r.CreateAuditEvent(ctx, event); err != nil || event.ID == 0 {
+				t.Fatalf("CreateAuditEvent = %+v, %v", event, err)
+			}
+		}
+
+		events, err := r.GetAuditEvents(ctx, AuditFilter{Action: "build", Limit: 1})
+		if err != nil || len(events) != 1 || !events[0].StartedAt.Equal(start.Add(2*time.Hour)) {
+			t.Fatalf("GetAuditEvents(build, 1) = %+v, %v", events, err)
+		}
+		if events, _ := r.GetAuditEvents(ctx, AuditFilter{Action: "build", BeforeID: events[0].ID}); len(events) != 1 || !events[0].StartedAt.Equal(start) {
+			t.Errorf("next page = %+v", events)
+		}
+		if events, _ := r.GetAuditEvents(ctx, AuditFilter{ProjectID: projectID}); len(events) != 1 || events[0].ProjectID == nil || *events[0].ProjectID != projectID {
+			t.Errorf("events of the project = %+v", events)
+		}
+		if events, _ := r.GetAuditEvents(ctx, AuditFilter{Since: start.Add(30 * time.Minute), Until: start.Add(90 * time.Minute)}); len(events) != 1 || events[0].Action != "publish" {
+			t.Errorf("events between = %+v", events)
+		}
+	})
+}
+
+func TestSQLiteRepositoryContract(t *testing.T) {
+	testRepositoryContract(t, func(t *testing.T) Repository {
+		r, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository failed: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	})
+}
@@ -9,7 +9,7 @@ import (
 // ExampleUsage demonstrates how to use the repository
 func ExampleUsage() {
 	// Create repository instance
-	repo, err := NewRepository()
+	repo, err := NewRepository("")
 	if err != nil {
 		log.Fatalf("Failed to create repository: %v", err)
 	}
@@ -18,7 +18,7 @@ func ExampleUsage() {
 	ctx := context.Background()
 
 	// Create a chat
-	chat, err := repo.CreateChat(ctx, "My First Chat")
+	chat, err := repo.CreateChat(ctx, "My First Chat", "user-1")
 	if err != nil {
 		log.Fatalf("Failed to create chat: %v", err)
 	}
@@ -45,7 +45,7 @@ func ExampleUsage() {
 	fmt.Printf("Retrieved %d messages\n", len(messages))
 
 	// Create a project
-	project, err := repo.CreateProject(ctx, chat.ID, "My Web App", "A simple web application", "/path/to/project")
+	project, err := repo.CreateProject(ctx, chat.ID, "My Web App", "A simple web application", "/path/to/project", chat.UserID)
 	if err != nil {
 		log.Fatalf("Failed to create project: %v", err)
 	}
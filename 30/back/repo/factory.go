@@ -2,25 +2,57 @@ package repo
 
 import (
 	"log"
+	"strings"
 )
 
-// NewRepository creates a new repository instance
-// Currently returns SQLite persistent repository, but can be extended
-// to support other database types based on configuration
-func NewRepository() (Repository, error) {
-	repo, err := NewSQLiteRepository()
+// ParseDSN tells the dialect of a database DSN and the connection string of its driver:
+// postgres:// and postgresql:// URLs are PostgreSQL, anything else is a SQLite file path,
+// optionally prefixed with sqlite:. An empty DSN is the default SQLite file.
+func ParseDSN(dsn string) (Dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DialectPostgres, dsn
+	case dsn == "":
+		return DialectSQLite, DefaultSQLiteFile
+	default:
+		return DialectSQLite, strings.TrimPrefix(dsn, "sqlite:")
+	}
+}
+
+// NewRepository creates the repository of the DSN, see ParseDSN
+func NewRepository(dsn string) (Repository, error) {
+	dialect, conn := ParseDSN(dsn)
+	if dialect == DialectPostgres {
+		repo, err := NewPostgresRepository(conn)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("PostgreSQL repository initialized successfully")
+		return repo, nil
+	}
+
+	repo, err := NewSQLiteRepository(conn)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("SQLite persistent repository initialized successfully")
+	log.Printf("SQLite persistent repository initialized successfully (%s)", conn)
 	return repo, nil
 }
 
+// OpenMigrator opens the database of the DSN without migrating it, for the migrate command
+func OpenMigrator(dsn string) (*Migrator, error) {
+	dialect, conn := ParseDSN(dsn)
+	if dialect == DialectPostgres {
+		return NewPostgresMigrator(conn)
+	}
+	return NewSQLiteMigrator(conn)
+}
+
 // MustNewRepository creates a new repository and panics on error
 // Useful for initialization where failure should stop the application
-func MustNewRepository() Repository {
-	repo, err := NewRepository()
+func MustNewRepository(dsn string) Repository {
+	repo, err := NewRepository(dsn)
 	if err != nil {
 		log.Fatalf("Failed to initialize repository: %v", err)
 	}
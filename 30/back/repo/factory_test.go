@@ -0,0 +1,20 @@
+package repo
+
+import "testing"
+
+func TestParseDSN(t *testing.T) {
+	for dsn, want := range map[string]struct {
+		dialect Dialect
+		conn    string
+	}{
+		"":                                     {DialectSQLite, DefaultSQLiteFile},
+		"data/chat.db":                         {DialectSQLite, "data/chat.db"},
+		"sqlite:/var/lib/chat.db":              {DialectSQLite, "/var/lib/chat.db"},
+		"postgres://u:p@db:5432/chat":          {DialectPostgres, "postgres://u:p@db:5432/chat"},
+		"postgresql://db/chat?sslmode=disable": {DialectPostgres, "postgresql://db/chat?sslmode=disable"},
+	} {
+		if dialect, conn := ParseDSN(dsn); dialect != want.dialect || conn != want.conn {
+			t.Errorf("ParseDSN(%q) = %s, %q", dsn, dialect, conn)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableNames(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		t.Fatalf("Failed to list tables: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down":  {"m/0001_a.up.sql": {Data: []byte("SELECT 1;")}},
+		"bad name":      {"m/first.up.sql": {Data: []byte("SELECT 1;")}, "m/first.down.sql": {Data: []byte("SELECT 1;")}},
+		"renamed":       {"m/0001_a.up.sql": {Data: []byte("SELECT 1;")}, "m/0001_b.down.sql": {Data: []byte("SELECT 1;")}},
+		"unknown files": {"m/0001_a.sideways.sql": {Data: []byte("SELECT 1;")}},
+	}
+	for name, fsys := range tests {
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: LoadMigrations accepted %v", name, fsys)
+		}
+	}
+
+	migrations, err := DialectMigrations(DialectSQLite)
+	if err != nil {
+		t.Fatalf("embedded SQLite migrations: %v", err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d_%s: versions must be numbered without gaps", migration.Version, migration.Name)
+		}
+	}
+
+	// Both dialects share one migration set
+	postgres, err := DialectMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatalf("embedded Postgres migrations: %v", err)
+	}
+	if len(postgres) != len(migrations) {
+		t.Fatalf("%d Postgres migrations, %d SQLite migrations", len(postgres), len(migrations))
+	}
+	for i := range migrations {
+		if postgres[i].Version != migrations[i].Version || postgres[i].Name != migrations[i].Name {
+			t.Errorf("Postgres migration %d_%s, SQLite migration %d_%s", postgres[i].Version, postgres[i].Name, migrations[i].Version, migrations[i].Name)
+		}
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	migrations, err := DialectMigrations(DialectSQLite)
+	if err != nil {
+		t.Fatalf("LoadMigrations failed: %v", err)
+	}
+	migrator := NewMigrator(db, DialectSQLite, migrations)
+
+	applied, err := migrator.Up(ctx)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Up = %d migrations, %v", len(applied), err)
+	}
+	if applied, err := migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("repeated Up = %d migrations, %v", len(applied), err)
+	}
+
+	reverted, err := migrator.Down(ctx, 1)
+	if err != nil || len(reverted) != 1 || reverted[0].Name != migrations[len(migrations)-1].Name {
+		t.Fatalf("Down(1) = %+v, %v", reverted, err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil || len(statuses) != len(migrations) {
+		t.Fatalf("Status = %+v, %v", statuses, err)
+	}
+	if statuses[0].AppliedAt == nil || statuses[len(statuses)-1].AppliedAt != nil {
+		t.Errorf("status after Down(1) = %+v", statuses)
+	}
+
+	// Every down migration reverts its up migration completely
+	if _, err := migrator.Down(ctx, len(migrations)); err != nil {
+		t.Fatalf("Down(all) failed: %v", err)
+	}
+	if names := tableNames(t, db); len(names) != 1 || names[0] != "schema_migrations" {
+		t.Errorf("tables after Down(all) = %v", names)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Errorf("Up after Down(all) failed: %v", err)
+	}
+}
+
+func TestMigratorAdoptsExistingDatabase(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	// A database created before versioned migrations, with data
+	if _, err := db.Exec(`CREATE TABLE chats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	); INSERT INTO chats (title) VALUES ('old chat');`); err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	migrations, err := DialectMigrations(DialectSQLite)
+	if err != nil {
+		t.Fatalf("LoadMigrations failed: %v", err)
+	}
+	if _, err := NewMigrator(db, DialectSQLite, migrations).Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	var title string
+	if err := db.QueryRow(`SELECT title FROM chats`).Scan(&title); err != nil || title != "old chat" {
+		t.Errorf("existing chat = %q, %v", title, err)
+	}
+	if names := strings.Join(tableNames(t, db), ","); !strings.Contains(names, "audit_events") {
+		t.Errorf("tables = %s", names)
+	}
+}
+
+func TestMigratorFailedMigrationIsRolledBack(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"m/0001_things.up.sql":   {Data: []byte("CREATE TABLE things (id INTEGER);")},
+		"m/0001_things.down.sql": {Data: []byte("DROP TABLE things;")},
+		"m/0002_broken.up.sql":   {Data: []byte("CREATE TABLE broken (id INTEGER); INSERT INTO missing VALUES (1);")},
+		"m/0002_broken.down.sql": {Data: []byte("DROP TABLE broken;")},
+	}, "m")
+	if err != nil {
+		t.Fatalf("LoadMigrations failed: %v", err)
+	}
+
+	migrator := NewMigrator(db, DialectSQLite, migrations)
+	applied, err := migrator.Up(ctx)
+	if err == nil || len(applied) != 1 || !strings.Contains(err.Error(), "2_broken") {
+		t.Fatalf("Up = %+v, %v", applied, err)
+	}
+	if names := strings.Join(tableNames(t, db), ","); names != "schema_migrations,things" {
+		t.Errorf("tables after the failed migration = %s", names)
+	}
+}
@@ -0,0 +1,43 @@
+-- Chats with their messages, generated projects and images.
+-- The Postgres migrations carry the same versions as the SQLite ones, see
+-- migrations/sqlite; a schema change is added to both directories.
+
+CREATE TABLE IF NOT EXISTS chats (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	role TEXT NOT NULL,
+	content TEXT NOT NULL,
+	sent_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS projects (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	description TEXT,
+	file_path TEXT,
+	status TEXT DEFAULT 'building',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS images (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	prompt TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+
+CREATE INDEX IF NOT EXISTS idx_projects_chat_id ON projects(chat_id);
+
+CREATE INDEX IF NOT EXISTS idx_images_chat_id ON images(chat_id);
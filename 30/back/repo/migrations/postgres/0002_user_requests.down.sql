@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_requests_user_date;
+DROP TABLE IF EXISTS user_requests;
@@ -0,0 +1,13 @@
+-- Daily request counters of the users
+
+CREATE TABLE IF NOT EXISTS user_requests (
+	id BIGSERIAL PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	request_date TEXT NOT NULL,
+	request_count INTEGER DEFAULT 1,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(user_id, request_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_requests_user_date ON user_requests(user_id, request_date);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dialog_sessions;
@@ -0,0 +1,11 @@
+-- Requirements gathering dialog of a user
+
+CREATE TABLE IF NOT EXISTS dialog_sessions (
+	user_id TEXT PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	requirements TEXT NOT NULL DEFAULT '{}',
+	current_question TEXT NOT NULL DEFAULT '',
+	is_complete BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
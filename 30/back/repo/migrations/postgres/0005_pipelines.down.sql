@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_pipeline_artifacts_run_id;
+DROP TABLE IF EXISTS pipeline_artifacts;
+DROP TABLE IF EXISTS pipeline_runs;
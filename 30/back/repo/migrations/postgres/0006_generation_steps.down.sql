@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_generation_steps_project_id;
+DROP TABLE IF EXISTS generation_steps;
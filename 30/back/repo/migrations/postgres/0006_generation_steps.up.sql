@@ -0,0 +1,20 @@
+-- Reasoning steps of the website generation
+
+CREATE TABLE IF NOT EXISTS generation_steps (
+	id BIGSERIAL PRIMARY KEY,
+	project_id BIGINT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	step INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	provider TEXT NOT NULL DEFAULT '',
+	model TEXT NOT NULL DEFAULT '',
+	system_prompt TEXT NOT NULL DEFAULT '',
+	prompt TEXT NOT NULL DEFAULT '',
+	response TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	latency_ms BIGINT NOT NULL DEFAULT 0,
+	prompt_tokens INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_generation_steps_project_id ON generation_steps(project_id);
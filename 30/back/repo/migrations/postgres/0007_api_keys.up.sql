@@ -0,0 +1,13 @@
+-- Hashed API keys
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id BIGSERIAL PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	role TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL DEFAULT '',
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_quota_counters_period_start;
+DROP TABLE IF EXISTS quota_counters;
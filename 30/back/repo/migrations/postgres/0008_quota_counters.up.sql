@@ -0,0 +1,14 @@
+-- Per-user quota counters
+
+CREATE TABLE IF NOT EXISTS quota_counters (
+	user_id TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	period TEXT NOT NULL,
+	period_start BIGINT NOT NULL,
+	requests BIGINT NOT NULL DEFAULT 0,
+	tokens BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, scope, period, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_counters_period_start ON quota_counters(period_start);
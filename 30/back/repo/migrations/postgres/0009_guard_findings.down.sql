@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_guard_findings_project_id;
+DROP TABLE IF EXISTS guard_findings;
@@ -0,0 +1,14 @@
+-- Guard rules that fired on the input and output of a project
+
+CREATE TABLE IF NOT EXISTS guard_findings (
+	id BIGSERIAL PRIMARY KEY,
+	project_id BIGINT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	stage TEXT NOT NULL,
+	rule TEXT NOT NULL,
+	action TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	count INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_guard_findings_project_id ON guard_findings(project_id);
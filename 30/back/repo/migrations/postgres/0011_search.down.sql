@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_projects_created_at;
+DROP INDEX IF EXISTS idx_messages_search;
+DROP INDEX IF EXISTS idx_projects_search;
+DROP INDEX IF EXISTS idx_images_search;
+ALTER TABLE messages DROP COLUMN IF EXISTS search;
+ALTER TABLE projects DROP COLUMN IF EXISTS search;
+ALTER TABLE images DROP COLUMN IF EXISTS search;
@@ -0,0 +1,22 @@
+-- Full-text search of message contents, project names and descriptions and image prompts.
+-- The 'simple' configuration lowercases words without stemming, like the unicode61
+-- tokenizer of the SQLite index, so both databases match the same words. Project
+-- names weigh more than descriptions, message contents and image prompts.
+
+ALTER TABLE messages ADD COLUMN search tsvector
+	GENERATED ALWAYS AS (setweight(to_tsvector('simple', content), 'B')) STORED;
+
+ALTER TABLE projects ADD COLUMN search tsvector
+	GENERATED ALWAYS AS (setweight(to_tsvector('simple', name), 'A') || setweight(to_tsvector('simple', COALESCE(description, '')), 'B')) STORED;
+
+ALTER TABLE images ADD COLUMN search tsvector
+	GENERATED ALWAYS AS (setweight(to_tsvector('simple', prompt), 'B')) STORED;
+
+CREATE INDEX idx_messages_search ON messages USING GIN (search);
+
+CREATE INDEX idx_projects_search ON projects USING GIN (search);
+
+CREATE INDEX idx_images_search ON images USING GIN (search);
+
+-- GetLatestProject
+CREATE INDEX idx_projects_created_at ON projects(created_at);
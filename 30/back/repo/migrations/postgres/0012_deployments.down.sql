@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_deployments_project_id;
+DROP TABLE IF EXISTS deployments;
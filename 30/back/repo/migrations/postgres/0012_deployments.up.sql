@@ -0,0 +1,16 @@
+-- Deployments of published sites to the deploy targets
+
+CREATE TABLE IF NOT EXISTS deployments (
+	id BIGSERIAL PRIMARY KEY,
+	project_id BIGINT REFERENCES projects(id) ON DELETE CASCADE,
+	target TEXT NOT NULL,
+	status TEXT NOT NULL,
+	file TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	remote_path TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployments_project_id ON deployments(project_id);
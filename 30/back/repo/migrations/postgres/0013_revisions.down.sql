@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN revision;
+DROP TABLE IF EXISTS project_revisions;
@@ -0,0 +1,14 @@
+-- Immutable content-addressed revisions of the generated sites
+
+CREATE TABLE IF NOT EXISTS project_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	project_id BIGINT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	hash TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	size BIGINT NOT NULL DEFAULT 0,
+	source TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (project_id, hash)
+);
+
+ALTER TABLE deployments ADD COLUMN revision TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE project_revisions DROP COLUMN instruction;
+ALTER TABLE project_revisions DROP COLUMN parent;
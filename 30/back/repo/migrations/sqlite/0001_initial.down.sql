@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_chat_id;
+DROP INDEX IF EXISTS idx_projects_chat_id;
+DROP INDEX IF EXISTS idx_images_chat_id;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chats;
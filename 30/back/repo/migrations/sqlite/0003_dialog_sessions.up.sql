@@ -0,0 +1,12 @@
+-- Requirements gathering dialog of a user
+
+CREATE TABLE IF NOT EXISTS dialog_sessions (
+	user_id TEXT PRIMARY KEY,
+	chat_id INTEGER NOT NULL,
+	requirements TEXT NOT NULL DEFAULT '{}',
+	current_question TEXT NOT NULL DEFAULT '',
+	is_complete BOOLEAN NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
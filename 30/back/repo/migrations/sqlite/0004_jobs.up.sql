@@ -0,0 +1,18 @@
+-- Persistent job queue
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	user_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'queued',
+	progress TEXT NOT NULL DEFAULT '',
+	payload TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	started_at DATETIME,
+	finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
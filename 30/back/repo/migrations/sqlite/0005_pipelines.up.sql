@@ -0,0 +1,31 @@
+-- Pipeline runs and the artifacts of their stages
+
+CREATE TABLE IF NOT EXISTS pipeline_runs (
+	id TEXT PRIMARY KEY,
+	pipeline TEXT NOT NULL,
+	job_id TEXT NOT NULL DEFAULT '',
+	user_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'queued',
+	input TEXT NOT NULL DEFAULT '{}',
+	output TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	finished_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS pipeline_artifacts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id TEXT NOT NULL,
+	stage TEXT NOT NULL,
+	attempt INTEGER NOT NULL DEFAULT 1,
+	status TEXT NOT NULL,
+	input TEXT NOT NULL DEFAULT '',
+	output TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	finished_at DATETIME,
+	FOREIGN KEY (run_id) REFERENCES pipeline_runs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_pipeline_artifacts_run_id ON pipeline_artifacts(run_id);
@@ -0,0 +1,15 @@
+-- Guard rules that fired on the input and output of a project
+
+CREATE TABLE IF NOT EXISTS guard_findings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL,
+	stage TEXT NOT NULL,
+	rule TEXT NOT NULL,
+	action TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	count INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_guard_findings_project_id ON guard_findings(project_id);
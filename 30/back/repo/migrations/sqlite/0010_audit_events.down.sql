@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS audit_events_no_update;
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+DROP INDEX IF EXISTS idx_audit_events_actor;
+DROP INDEX IF EXISTS idx_audit_events_action;
+DROP INDEX IF EXISTS idx_audit_events_project_id;
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,31 @@
+-- Append-only audit log of the agent actions
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor TEXT NOT NULL,
+	actor_role TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	request_hash TEXT NOT NULL DEFAULT '',
+	outcome TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	project_id INTEGER,
+	chat_id INTEGER,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The audit trail is append-only
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_project_id ON audit_events(project_id);
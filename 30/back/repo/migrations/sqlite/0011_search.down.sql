@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_projects_created_at;
+DROP TRIGGER IF EXISTS messages_search_insert;
+DROP TRIGGER IF EXISTS messages_search_update;
+DROP TRIGGER IF EXISTS messages_search_delete;
+DROP TRIGGER IF EXISTS projects_search_insert;
+DROP TRIGGER IF EXISTS projects_search_update;
+DROP TRIGGER IF EXISTS projects_search_delete;
+DROP TRIGGER IF EXISTS images_search_insert;
+DROP TRIGGER IF EXISTS images_search_update;
+DROP TRIGGER IF EXISTS images_search_delete;
+DROP TABLE IF EXISTS search_index;
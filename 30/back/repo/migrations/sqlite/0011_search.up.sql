@@ -0,0 +1,60 @@
+-- Full-text index of message contents, project names and descriptions and image prompts.
+-- The rowid of an entry is the id of the indexed row times 4 plus the kind:
+-- 1 message, 2 project, 3 image. Triggers keep the index in sync.
+
+CREATE VIRTUAL TABLE search_index USING fts5(
+	kind UNINDEXED,
+	ref_id UNINDEXED,
+	chat_id UNINDEXED,
+	title,
+	body
+);
+
+CREATE TRIGGER messages_search_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body) VALUES (new.id * 4 + 1, 'message', new.id, new.chat_id, '', new.content);
+END;
+
+CREATE TRIGGER messages_search_update AFTER UPDATE OF content ON messages BEGIN
+	UPDATE search_index SET body = new.content WHERE rowid = new.id * 4 + 1;
+END;
+
+CREATE TRIGGER messages_search_delete AFTER DELETE ON messages BEGIN
+	DELETE FROM search_index WHERE rowid = old.id * 4 + 1;
+END;
+
+CREATE TRIGGER projects_search_insert AFTER INSERT ON projects BEGIN
+	INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body) VALUES (new.id * 4 + 2, 'project', new.id, new.chat_id, new.name, COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER projects_search_update AFTER UPDATE OF name, description ON projects BEGIN
+	UPDATE search_index SET title = new.name, body = COALESCE(new.description, '') WHERE rowid = new.id * 4 + 2;
+END;
+
+CREATE TRIGGER projects_search_delete AFTER DELETE ON projects BEGIN
+	DELETE FROM search_index WHERE rowid = old.id * 4 + 2;
+END;
+
+CREATE TRIGGER images_search_insert AFTER INSERT ON images BEGIN
+	INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body) VALUES (new.id * 4 + 3, 'image', new.id, new.chat_id, '', new.prompt);
+END;
+
+CREATE TRIGGER images_search_update AFTER UPDATE OF prompt ON images BEGIN
+	UPDATE search_index SET body = new.prompt WHERE rowid = new.id * 4 + 3;
+END;
+
+CREATE TRIGGER images_search_delete AFTER DELETE ON images BEGIN
+	DELETE FROM search_index WHERE rowid = old.id * 4 + 3;
+END;
+
+-- Rows written before the index existed
+INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body)
+SELECT id * 4 + 1, 'message', id, chat_id, '', content FROM messages;
+
+INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body)
+SELECT id * 4 + 2, 'project', id, chat_id, name, COALESCE(description, '') FROM projects;
+
+INSERT INTO search_index (rowid, kind, ref_id, chat_id, title, body)
+SELECT id * 4 + 3, 'image', id, chat_id, '', prompt FROM images;
+
+-- GetLatestProject
+CREATE INDEX idx_projects_created_at ON projects(created_at);
@@ -0,0 +1,17 @@
+-- Deployments of published sites to the deploy targets
+
+CREATE TABLE IF NOT EXISTS deployments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER,
+	target TEXT NOT NULL,
+	status TEXT NOT NULL,
+	file TEXT NOT NULL DEFAULT '',
+	url TEXT NOT NULL DEFAULT '',
+	remote_path TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_deployments_project_id ON deployments(project_id);
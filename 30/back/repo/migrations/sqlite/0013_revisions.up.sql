@@ -0,0 +1,15 @@
+-- Immutable content-addressed revisions of the generated sites
+
+CREATE TABLE IF NOT EXISTS project_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	size INTEGER NOT NULL DEFAULT 0,
+	source TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	UNIQUE (project_id, hash),
+	FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+ALTER TABLE deployments ADD COLUMN revision TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Result of the check of the published site after a deployment
+
+ALTER TABLE deployments ADD COLUMN verification TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Revisions made by /revise link to the revision they were made from
+
+ALTER TABLE project_revisions ADD COLUMN parent TEXT NOT NULL DEFAULT '';
+ALTER TABLE project_revisions ADD COLUMN instruction TEXT NOT NULL DEFAULT '';
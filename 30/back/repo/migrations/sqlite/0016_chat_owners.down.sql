@@ -0,0 +1,2 @@
+DROP INDEX idx_chats_user_id;
+ALTER TABLE chats DROP COLUMN user_id;
@@ -0,0 +1,9 @@
+-- Chats belong to the user who started them, so searches only see the caller's chats.
+-- Requirements dialogs know their user; older chats stay without an owner and are visible to admins.
+
+ALTER TABLE chats ADD COLUMN user_id TEXT NOT NULL DEFAULT '';
+
+UPDATE chats SET user_id = (SELECT user_id FROM dialog_sessions WHERE dialog_sessions.chat_id = chats.id)
+WHERE id IN (SELECT chat_id FROM dialog_sessions);
+
+CREATE INDEX idx_chats_user_id ON chats(user_id);
@@ -0,0 +1,7 @@
+-- Projects belong to the user who built them, so only they can revise, roll back or inspect them.
+-- Existing projects inherit the owner of their chat.
+
+ALTER TABLE projects ADD COLUMN user_id TEXT NOT NULL DEFAULT '';
+
+UPDATE projects SET user_id = (SELECT user_id FROM chats WHERE chats.id = projects.chat_id)
+WHERE chat_id IN (SELECT id FROM chats);
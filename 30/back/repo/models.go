@@ -8,23 +8,25 @@ import (
 type Chat struct {
 	ID        int64     `json:"id"`
 	Title     string    `json:"title"`
+	UserID    string    `json:"user_id,omitempty"` // owner; empty for chats created before owners were recorded
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Message represents a single message in a chat
 type Message struct {
-	ID       int64     `json:"id"`
-	ChatID   int64     `json:"chat_id"`
-	Role     string    `json:"role"` // "user" or "assistant"
-	Content  string    `json:"content"`
-	SentAt   time.Time `json:"sent_at"`
+	ID      int64     `json:"id"`
+	ChatID  int64     `json:"chat_id"`
+	Role    string    `json:"role"` // "user" or "assistant"
+	Content string    `json:"content"`
+	SentAt  time.Time `json:"sent_at"`
 }
 
 // Project represents a generated project
 type Project struct {
 	ID          int64     `json:"id"`
 	ChatID      int64     `json:"chat_id"`
+	UserID      string    `json:"user_id,omitempty"` // owner; empty for projects of chats without an owner
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	FilePath    string    `json:"file_path"`
@@ -51,3 +53,267 @@ type UserRequest struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
+
+// DialogSession represents the persisted state of a user's requirements dialog.
+// The dialog messages are stored in the messages table of the linked chat.
+type DialogSession struct {
+	UserID          string    `json:"user_id"`
+	ChatID          int64     `json:"chat_id"`
+	Requirements    string    `json:"requirements"` // JSON-encoded requirements
+	CurrentQuestion string    `json:"current_question"`
+	IsComplete      bool      `json:"is_complete"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Job statuses
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// Job represents a background job such as a website build
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"` // "build"
+	UserID     string     `json:"user_id"`
+	Status     string     `json:"status"`   // "queued", "running", "succeeded", "failed", "cancelled"
+	Progress   string     `json:"progress"` // current stage description
+	Payload    string     `json:"payload"`  // JSON-encoded request
+	Result     string     `json:"result"`   // JSON-encoded result
+	Error      string     `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// PipelineRun represents a single execution of a declared pipeline.
+// Status uses the job statuses.
+type PipelineRun struct {
+	ID         string     `json:"id"`
+	Pipeline   string     `json:"pipeline"`
+	JobID      string     `json:"job_id"`
+	UserID     string     `json:"user_id"`
+	Status     string     `json:"status"`
+	Input      string     `json:"input"`  // JSON-encoded run input
+	Output     string     `json:"output"` // JSON-encoded output of the last stage
+	Error      string     `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// PipelineArtifact records the input and output of one attempt of a pipeline stage
+type PipelineArtifact struct {
+	ID         int64      `json:"id"`
+	RunID      string     `json:"run_id"`
+	Stage      string     `json:"stage"`
+	Attempt    int        `json:"attempt"`
+	Status     string     `json:"status"` // "running", "succeeded", "failed"
+	Input      string     `json:"input"`  // JSON-encoded stage input
+	Output     string     `json:"output"` // JSON-encoded stage output
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// GenerationStep is one recorded LLM call of the website generation chain
+type GenerationStep struct {
+	ID               int64     `json:"id"`
+	ProjectID        int64     `json:"project_id"`
+	Step             int       `json:"step"` // 1-based order within the generation
+	Name             string    `json:"name"` // "plan", "draft", "verify"
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	SystemPrompt     string    `json:"system_prompt"`
+	Prompt           string    `json:"prompt"`
+	Response         string    `json:"response"`
+	Error            string    `json:"error,omitempty"`
+	LatencyMS        int64     `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// APIKey is a user's API key; only the SHA-256 hash of the key is stored
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     string     `json:"user_id"`
+	Role       string     `json:"role"` // "viewer", "builder", "publisher", "admin"
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the key, to recognise it in lists
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Quota periods
+const (
+	QuotaPeriodMinute = "minute"
+	QuotaPeriodDay    = "day"
+)
+
+// QuotaCounter counts the requests and LLM tokens of a user within one period
+type QuotaCounter struct {
+	UserID      string    `json:"user_id"`
+	Scope       string    `json:"scope"`  // endpoint name, "*" for all endpoints together
+	Period      string    `json:"period"` // "minute" or "day"
+	PeriodStart time.Time `json:"period_start"`
+	Requests    int       `json:"requests"`
+	Tokens      int       `json:"tokens"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Audit outcomes
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailed  = "failed"
+	AuditOutcomeDenied  = "denied" // rejected by authorization or quota
+)
+
+// AuditEvent records who did what to which target; events are never updated or deleted
+type AuditEvent struct {
+	ID          int64     `json:"id"`
+	Actor       string    `json:"actor"` // user ID, "stdio" for the operator over MCP stdio
+	ActorRole   string    `json:"actor_role,omitempty"`
+	Action      string    `json:"action"`                 // e.g. "build", "publish", "github.push", "mcp.analyze_project"
+	Target      string    `json:"target,omitempty"`       // e.g. "POST /build", "job:<id>", "project:12"
+	RequestHash string    `json:"request_hash,omitempty"` // SHA-256 of the request
+	Outcome     string    `json:"outcome"`                // "success", "failed", "denied"
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ProjectID   *int64    `json:"project_id,omitempty"`
+	ChatID      *int64    `json:"chat_id,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit events; empty fields match everything.
+// Events are returned newest first, BeforeID continues a previous page.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Outcome   string
+	Target    string
+	ProjectID int64
+	ChatID    int64
+	Since     time.Time
+	Until     time.Time
+	BeforeID  int64
+	Limit     int
+}
+
+// Guard stages and actions
+const (
+	GuardStageInput      = "input"      // pattern screening of user text
+	GuardStageClassifier = "classifier" // classifier LLM screening of user text
+	GuardStageOutput     = "output"     // sanitising of generated HTML
+
+	GuardActionBlock  = "block"  // the request was rejected
+	GuardActionFlag   = "flag"   // the rule fired, the text was let through
+	GuardActionRemove = "remove" // the offending HTML was removed
+)
+
+// GuardFinding is a guard rule that fired during a build
+type GuardFinding struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"project_id"`
+	Stage     string    `json:"stage"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail,omitempty"` // input field or HTML element
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Deployment statuses
+const (
+	DeploymentStatusRunning   = "running"
+	DeploymentStatusSucceeded = "succeeded" // uploaded, not verified
+	DeploymentStatusVerifying = "verifying" // uploaded, the verification is running
+	DeploymentStatusVerified  = "verified"  // uploaded and served as uploaded
+	DeploymentStatusFailed    = "failed"    // the upload or the verification failed
+)
+
+// Deployment records the upload of a published site to a deploy target
+type Deployment struct {
+	ID           int64      `json:"id"`
+	ProjectID    *int64     `json:"project_id,omitempty"` // nil for files published by pipelines before they created projects
+	Target       string     `json:"target"`               // "local", "sftp", "ycloud-mcp" or "s3"
+	Status       string     `json:"status"`
+	Revision     string     `json:"revision,omitempty"`    // hash of the deployed content, see ProjectRevision
+	File         string     `json:"file"`                  // published file name
+	URL          string     `json:"url,omitempty"`         // public URL of the published site
+	RemotePath   string     `json:"remote_path,omitempty"` // location on the target
+	Error        string     `json:"error,omitempty"`
+	Verification string     `json:"verification,omitempty"` // JSON-encoded check of the published site
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Revision sources
+const (
+	RevisionSourceBuild   = "build"   // saved by a build
+	RevisionSourcePublish = "publish" // the artifact as it was published
+	RevisionSourceRevise  = "revise"  // made by /revise from its parent revision
+)
+
+// ProjectRevision is an immutable version of the generated site of a project, named by
+// the SHA-256 of its content. The content is stored once under FilePath and never changed.
+type ProjectRevision struct {
+	ID          int64     `json:"id"`
+	ProjectID   int64     `json:"project_id"`
+	Hash        string    `json:"hash"`
+	FilePath    string    `json:"file_path"`
+	Size        int64     `json:"size"`
+	Source      string    `json:"source"`
+	Parent      string    `json:"parent,omitempty"`      // hash of the revision a revise started from
+	Instruction string    `json:"instruction,omitempty"` // what the revise asked to change
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Search hit kinds
+const (
+	SearchKindMessage = "message"
+	SearchKindProject = "project"
+	SearchKindImage   = "image"
+)
+
+// SearchQuery is a full-text search over messages, projects and images
+type SearchQuery struct {
+	Text   string        // words to find; every word must match, the last one as a prefix
+	Kind   string        // only hits of this kind when not empty
+	ChatID int64         // only hits in this chat when not zero
+	UserID string        // only hits in the chats of this user when not empty
+	After  *SearchCursor // the last hit of the previous page
+	Limit  int
+}
+
+// SearchCursor is the position of a hit in the ranked results
+type SearchCursor struct {
+	Score float64 `json:"s"`
+	Kind  string  `json:"k"`
+	ID    int64   `json:"i"`
+}
+
+// SearchHit is a message, project or image matching a search, best first.
+// Title and Snippet are HTML with the matched words in <mark> elements.
+type SearchHit struct {
+	Kind    string  `json:"kind"` // "message", "project" or "image"
+	ID      int64   `json:"id"`
+	ChatID  int64   `json:"chat_id"`
+	Title   string  `json:"title"`   // project name, the chat title for messages and images
+	Snippet string  `json:"snippet"` // fragment of the message, project description or image prompt
+	Score   float64 `json:"score"`   // higher is better; comparable within one search only
+}
+
+// Cursor returns the position of the hit, to continue the search after it
+func (h *SearchHit) Cursor() *SearchCursor {
+	return &SearchCursor{Score: h.Score, Kind: h.Kind, ID: h.ID}
+}
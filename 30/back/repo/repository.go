@@ -2,12 +2,13 @@ package repo
 
 import (
 	"context"
+	"time"
 )
 
 // Repository defines the interface for data operations
 type Repository interface {
 	// Chat operations
-	CreateChat(ctx context.Context, title string) (*Chat, error)
+	CreateChat(ctx context.Context, title, userID string) (*Chat, error)
 	GetChat(ctx context.Context, id int64) (*Chat, error)
 	GetChats(ctx context.Context, limit, offset int) ([]*Chat, error)
 	UpdateChat(ctx context.Context, id int64, title string) error
@@ -16,13 +17,15 @@ type Repository interface {
 	// Message operations
 	CreateMessage(ctx context.Context, chatID int64, role, content string) (*Message, error)
 	GetMessages(ctx context.Context, chatID int64, limit, offset int) ([]*Message, error)
+	GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error) // the newest limit messages, oldest first
 	GetMessage(ctx context.Context, id int64) (*Message, error)
 	DeleteMessage(ctx context.Context, id int64) error
 
 	// Project operations
-	CreateProject(ctx context.Context, chatID int64, name, description, filePath string) (*Project, error)
+	CreateProject(ctx context.Context, chatID int64, name, description, filePath, userID string) (*Project, error)
 	GetProject(ctx context.Context, id int64) (*Project, error)
 	GetProjectsByChat(ctx context.Context, chatID int64) ([]*Project, error)
+	GetLatestProject(ctx context.Context) (*Project, error)
 	UpdateProjectStatus(ctx context.Context, id int64, status string) error
 	DeleteProject(ctx context.Context, id int64) error
 
@@ -36,6 +39,63 @@ type Repository interface {
 	GetUserRequestCount(ctx context.Context, userID, requestDate string) (int, error)
 	IncrementUserRequestCount(ctx context.Context, userID, requestDate string) error
 
+	// Dialog session operations
+	CreateDialogSession(ctx context.Context, userID string, chatID int64) (*DialogSession, error)
+	GetDialogSession(ctx context.Context, userID string) (*DialogSession, error)
+	UpdateDialogSession(ctx context.Context, session *DialogSession) error
+
+	// Job operations
+	CreateJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	GetJobsByStatus(ctx context.Context, statuses ...string) ([]*Job, error)
+	UpdateJob(ctx context.Context, job *Job) error
+
+	// Pipeline operations
+	CreatePipelineRun(ctx context.Context, run *PipelineRun) error
+	GetPipelineRun(ctx context.Context, id string) (*PipelineRun, error)
+	UpdatePipelineRun(ctx context.Context, run *PipelineRun) error
+	CreatePipelineArtifact(ctx context.Context, artifact *PipelineArtifact) error
+	UpdatePipelineArtifact(ctx context.Context, artifact *PipelineArtifact) error
+	GetPipelineArtifacts(ctx context.Context, runID string) ([]*PipelineArtifact, error)
+
+	// Generation trace operations
+	CreateGenerationStep(ctx context.Context, step *GenerationStep) error
+	GetGenerationSteps(ctx context.Context, projectID int64) ([]*GenerationStep, error)
+
+	// API key operations
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64) error
+	RevokeAPIKey(ctx context.Context, id int64) error
+
+	// Quota operations
+	AddQuotaUsage(ctx context.Context, counter *QuotaCounter) error
+	GetQuotaCounters(ctx context.Context, userID string, since time.Time) ([]*QuotaCounter, error)
+	ResetQuotaCounters(ctx context.Context, userID, scope string) (int64, error)
+	DeleteQuotaCountersBefore(ctx context.Context, before time.Time) (int64, error)
+
+	// Guard finding operations
+	CreateGuardFinding(ctx context.Context, finding *GuardFinding) error
+	GetGuardFindings(ctx context.Context, projectID int64) ([]*GuardFinding, error)
+
+	// Deployment operations
+	CreateDeployment(ctx context.Context, deployment *Deployment) error
+	UpdateDeployment(ctx context.Context, deployment *Deployment) error
+	GetDeployment(ctx context.Context, id int64) (*Deployment, error)
+	GetDeployments(ctx context.Context, projectID int64) ([]*Deployment, error) // newest first
+
+	// Revision operations
+	CreateProjectRevision(ctx context.Context, rev *ProjectRevision) error                // keeps the existing revision of the same hash
+	GetProjectRevisions(ctx context.Context, projectID int64) ([]*ProjectRevision, error) // newest first
+
+	// Audit operations
+	CreateAuditEvent(ctx context.Context, event *AuditEvent) error
+	GetAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+
+	// Search operations
+	Search(ctx context.Context, query SearchQuery) ([]*SearchHit, error)
+
 	// Database operations
 	Close() error
 	Migrate() error
@@ -0,0 +1,27 @@
+package repo
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// The databases put these markers around the matched words of a highlight;
+// highlightHTML turns them into <mark> elements after escaping the text
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// searchTerms splits the search text into lowercase words of letters and digits,
+// which is also all the query syntax of the databases ever sees
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// highlightHTML escapes a highlighted text and marks the matched words
+func highlightHTML(text string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(html.EscapeString(text))
+}
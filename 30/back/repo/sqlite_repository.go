@@ -3,6 +3,8 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"slices"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -13,16 +15,26 @@ type SQLiteRepository struct {
 	db *sql.DB
 }
 
-// NewSQLiteRepository creates a new SQLite repository with persistent database
-func NewSQLiteRepository() (*SQLiteRepository, error) {
-	// Use persistent SQLite database file
-	db, err := sql.Open("sqlite", "chat_service.db")
+// DefaultSQLiteFile is the database file used when no DSN is configured
+const DefaultSQLiteFile = "chat_service.db"
+
+// sqliteDSN opens the database file with foreign keys enforced, as on Postgres;
+// busy_timeout lets concurrent connections (handlers and build workers) wait
+// for locks instead of failing
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+}
+
+// NewSQLiteRepository creates a new SQLite repository with the persistent database
+// file at path and applies the pending migrations
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(path))
 	if err != nil {
 		return nil, err
 	}
 
 	repo := &SQLiteRepository{db: db}
-	
+
 	// Run migrations
 	if err := repo.Migrate(); err != nil {
 		db.Close()
@@ -32,64 +44,24 @@ func NewSQLiteRepository() (*SQLiteRepository, error) {
 	return repo, nil
 }
 
-// Migrate creates all necessary tables
-func (r *SQLiteRepository) Migrate() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS chats (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			chat_id INTEGER NOT NULL,
-			role TEXT NOT NULL,
-			content TEXT NOT NULL,
-			sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
-		)`,
-		`CREATE TABLE IF NOT EXISTS projects (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			chat_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			description TEXT,
-			file_path TEXT,
-			status TEXT DEFAULT 'building',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
-		)`,
-		`CREATE TABLE IF NOT EXISTS images (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			chat_id INTEGER NOT NULL,
-			prompt TEXT NOT NULL,
-			file_path TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
-		)`,
-		`CREATE TABLE IF NOT EXISTS user_requests (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL,
-			request_date TEXT NOT NULL,
-			request_count INTEGER DEFAULT 1,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(user_id, request_date)
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_projects_chat_id ON projects(chat_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_images_chat_id ON images(chat_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_user_requests_user_date ON user_requests(user_id, request_date)`,
-	}
-
-	for _, query := range queries {
-		if _, err := r.db.Exec(query); err != nil {
-			return err
-		}
+// NewSQLiteMigrator opens the SQLite database without applying migrations
+func NewSQLiteMigrator(path string) (*Migrator, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	migrations, err := DialectMigrations(DialectSQLite)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewMigrator(db, DialectSQLite, migrations), nil
+}
+
+// Migrate applies the pending schema migrations
+func (r *SQLiteRepository) Migrate() error {
+	return migrateUp(r.db, DialectSQLite)
 }
 
 // Close closes the database connection
@@ -98,11 +70,11 @@ func (r *SQLiteRepository) Close() error {
 }
 
 // Chat operations
-func (r *SQLiteRepository) CreateChat(ctx context.Context, title string) (*Chat, error) {
+func (r *SQLiteRepository) CreateChat(ctx context.Context, title, userID string) (*Chat, error) {
 	now := time.Now()
-	result, err := r.db.ExecContext(ctx, 
-		"INSERT INTO chats (title, created_at, updated_at) VALUES (?, ?, ?)",
-		title, now, now)
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO chats (title, user_id, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		title, userID, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +87,7 @@ func (r *SQLiteRepository) CreateChat(ctx context.Context, title string) (*Chat,
 	return &Chat{
 		ID:        id,
 		Title:     title,
+		UserID:    userID,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}, nil
@@ -123,8 +96,8 @@ func (r *SQLiteRepository) CreateChat(ctx context.Context, title string) (*Chat,
 func (r *SQLiteRepository) GetChat(ctx context.Context, id int64) (*Chat, error) {
 	chat := &Chat{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, title, created_at, updated_at FROM chats WHERE id = ?", id).
-		Scan(&chat.ID, &chat.Title, &chat.CreatedAt, &chat.UpdatedAt)
+		"SELECT id, title, user_id, created_at, updated_at FROM chats WHERE id = ?", id).
+		Scan(&chat.ID, &chat.Title, &chat.UserID, &chat.CreatedAt, &chat.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -133,7 +106,7 @@ func (r *SQLiteRepository) GetChat(ctx context.Context, id int64) (*Chat, error)
 
 func (r *SQLiteRepository) GetChats(ctx context.Context, limit, offset int) ([]*Chat, error) {
 	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, title, created_at, updated_at FROM chats ORDER BY updated_at DESC LIMIT ? OFFSET ?",
+		"SELECT id, title, user_id, created_at, updated_at FROM chats ORDER BY updated_at DESC LIMIT ? OFFSET ?",
 		limit, offset)
 	if err != nil {
 		return nil, err
@@ -143,7 +116,7 @@ func (r *SQLiteRepository) GetChats(ctx context.Context, limit, offset int) ([]*
 	var chats []*Chat
 	for rows.Next() {
 		chat := &Chat{}
-		if err := rows.Scan(&chat.ID, &chat.Title, &chat.CreatedAt, &chat.UpdatedAt); err != nil {
+		if err := rows.Scan(&chat.ID, &chat.Title, &chat.UserID, &chat.CreatedAt, &chat.UpdatedAt); err != nil {
 			return nil, err
 		}
 		chats = append(chats, chat)
@@ -190,7 +163,7 @@ func (r *SQLiteRepository) CreateMessage(ctx context.Context, chatID int64, role
 
 func (r *SQLiteRepository) GetMessages(ctx context.Context, chatID int64, limit, offset int) ([]*Message, error) {
 	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, chat_id, role, content, sent_at FROM messages WHERE chat_id = ? ORDER BY sent_at ASC LIMIT ? OFFSET ?",
+		"SELECT id, chat_id, role, content, sent_at FROM messages WHERE chat_id = ? ORDER BY sent_at ASC, id ASC LIMIT ? OFFSET ?",
 		chatID, limit, offset)
 	if err != nil {
 		return nil, err
@@ -209,6 +182,28 @@ func (r *SQLiteRepository) GetMessages(ctx context.Context, chatID int64, limit,
 	return messages, rows.Err()
 }
 
+func (r *SQLiteRepository) GetRecentMessages(ctx context.Context, chatID int64, limit int) ([]*Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, chat_id, role, content, sent_at FROM messages WHERE chat_id = ? ORDER BY sent_at DESC, id DESC LIMIT ?",
+		chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.Role, &msg.Content, &msg.SentAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	slices.Reverse(messages)
+
+	return messages, rows.Err()
+}
+
 func (r *SQLiteRepository) GetMessage(ctx context.Context, id int64) (*Message, error) {
 	msg := &Message{}
 	err := r.db.QueryRowContext(ctx,
@@ -226,11 +221,11 @@ func (r *SQLiteRepository) DeleteMessage(ctx context.Context, id int64) error {
 }
 
 // Project operations
-func (r *SQLiteRepository) CreateProject(ctx context.Context, chatID int64, name, description, filePath string) (*Project, error) {
+func (r *SQLiteRepository) CreateProject(ctx context.Context, chatID int64, name, description, filePath, userID string) (*Project, error) {
 	now := time.Now()
 	result, err := r.db.ExecContext(ctx,
-		"INSERT INTO projects (chat_id, name, description, file_path, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		chatID, name, description, filePath, now, now)
+		"INSERT INTO projects (chat_id, user_id, name, description, file_path, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		chatID, userID, name, description, filePath, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -243,6 +238,7 @@ func (r *SQLiteRepository) CreateProject(ctx context.Context, chatID int64, name
 	return &Project{
 		ID:          id,
 		ChatID:      chatID,
+		UserID:      userID,
 		Name:        name,
 		Description: description,
 		FilePath:    filePath,
@@ -255,8 +251,8 @@ func (r *SQLiteRepository) CreateProject(ctx context.Context, chatID int64, name
 func (r *SQLiteRepository) GetProject(ctx context.Context, id int64) (*Project, error) {
 	project := &Project{}
 	err := r.db.QueryRowContext(ctx,
-		"SELECT id, chat_id, name, description, file_path, status, created_at, updated_at FROM projects WHERE id = ?", id).
-		Scan(&project.ID, &project.ChatID, &project.Name, &project.Description, &project.FilePath, &project.Status, &project.CreatedAt, &project.UpdatedAt)
+		"SELECT id, chat_id, user_id, name, description, file_path, status, created_at, updated_at FROM projects WHERE id = ?", id).
+		Scan(&project.ID, &project.ChatID, &project.UserID, &project.Name, &project.Description, &project.FilePath, &project.Status, &project.CreatedAt, &project.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -265,7 +261,7 @@ func (r *SQLiteRepository) GetProject(ctx context.Context, id int64) (*Project,
 
 func (r *SQLiteRepository) GetProjectsByChat(ctx context.Context, chatID int64) ([]*Project, error) {
 	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, chat_id, name, description, file_path, status, created_at, updated_at FROM projects WHERE chat_id = ? ORDER BY created_at DESC",
+		"SELECT id, chat_id, user_id, name, description, file_path, status, created_at, updated_at FROM projects WHERE chat_id = ? ORDER BY created_at DESC",
 		chatID)
 	if err != nil {
 		return nil, err
@@ -275,7 +271,7 @@ func (r *SQLiteRepository) GetProjectsByChat(ctx context.Context, chatID int64)
 	var projects []*Project
 	for rows.Next() {
 		project := &Project{}
-		if err := rows.Scan(&project.ID, &project.ChatID, &project.Name, &project.Description, &project.FilePath, &project.Status, &project.CreatedAt, &project.UpdatedAt); err != nil {
+		if err := rows.Scan(&project.ID, &project.ChatID, &project.UserID, &project.Name, &project.Description, &project.FilePath, &project.Status, &project.CreatedAt, &project.UpdatedAt); err != nil {
 			return nil, err
 		}
 		projects = append(projects, project)
@@ -284,6 +280,18 @@ func (r *SQLiteRepository) GetProjectsByChat(ctx context.Context, chatID int64)
 	return projects, rows.Err()
 }
 
+// GetLatestProject returns the most recently created project, sql.ErrNoRows when there is none
+func (r *SQLiteRepository) GetLatestProject(ctx context.Context) (*Project, error) {
+	project := &Project{}
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, chat_id, user_id, name, description, file_path, status, created_at, updated_at FROM projects ORDER BY created_at DESC, id DESC LIMIT 1").
+		Scan(&project.ID, &project.ChatID, &project.UserID, &project.Name, &project.Description, &project.FilePath, &project.Status, &project.CreatedAt, &project.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
 func (r *SQLiteRepository) UpdateProjectStatus(ctx context.Context, id int64, status string) error {
 	_, err := r.db.ExecContext(ctx,
 		"UPDATE projects SET status = ?, updated_at = ? WHERE id = ?",
@@ -374,7 +382,7 @@ func (r *SQLiteRepository) GetUserRequestCount(ctx context.Context, userID, requ
 
 func (r *SQLiteRepository) IncrementUserRequestCount(ctx context.Context, userID, requestDate string) error {
 	now := time.Now()
-	
+
 	// Try to increment existing record
 	result, err := r.db.ExecContext(ctx,
 		"UPDATE user_requests SET request_count = request_count + 1, updated_at = ? WHERE user_id = ? AND request_date = ?",
@@ -382,12 +390,12 @@ func (r *SQLiteRepository) IncrementUserRequestCount(ctx context.Context, userID
 	if err != nil {
 		return err
 	}
-	
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	
+
 	// If no rows were updated, create a new record
 	if rowsAffected == 0 {
 		_, err = r.db.ExecContext(ctx,
@@ -395,6 +403,676 @@ func (r *SQLiteRepository) IncrementUserRequestCount(ctx context.Context, userID
 			userID, requestDate, now, now)
 		return err
 	}
-	
+
 	return nil
 }
+
+// Dialog session operations
+func (r *SQLiteRepository) CreateDialogSession(ctx context.Context, userID string, chatID int64) (*DialogSession, error) {
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO dialog_sessions (user_id, chat_id, requirements, current_question, is_complete, created_at, updated_at) VALUES (?, ?, '{}', '', 0, ?, ?)",
+		userID, chatID, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DialogSession{
+		UserID:       userID,
+		ChatID:       chatID,
+		Requirements: "{}",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+func (r *SQLiteRepository) GetDialogSession(ctx context.Context, userID string) (*DialogSession, error) {
+	session := &DialogSession{}
+	err := r.db.QueryRowContext(ctx,
+		"SELECT user_id, chat_id, requirements, current_question, is_complete, created_at, updated_at FROM dialog_sessions WHERE user_id = ?", userID).
+		Scan(&session.UserID, &session.ChatID, &session.Requirements, &session.CurrentQuestion, &session.IsComplete, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *SQLiteRepository) UpdateDialogSession(ctx context.Context, session *DialogSession) error {
+	session.UpdatedAt = time.Now()
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE dialog_sessions SET requirements = ?, current_question = ?, is_complete = ?, updated_at = ? WHERE user_id = ?",
+		session.Requirements, session.CurrentQuestion, session.IsComplete, session.UpdatedAt, session.UserID)
+	return err
+}
+
+// Job operations
+func (r *SQLiteRepository) CreateJob(ctx context.Context, job *Job) error {
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO jobs (id, type, user_id, status, progress, payload, result, error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		job.ID, job.Type, job.UserID, job.Status, job.Progress, job.Payload, job.Result, job.Error, now, now)
+	return err
+}
+
+func (r *SQLiteRepository) GetJob(ctx context.Context, id string) (*Job, error) {
+	row := r.db.QueryRowContext(ctx,
+		"SELECT id, type, user_id, status, progress, payload, result, error, created_at, updated_at, started_at, finished_at FROM jobs WHERE id = ?", id)
+	return scanJob(row)
+}
+
+func (r *SQLiteRepository) GetJobsByStatus(ctx context.Context, statuses ...string) ([]*Job, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, type, user_id, status, progress, payload, result, error, created_at, updated_at, started_at, finished_at FROM jobs WHERE status IN ("+placeholders+") ORDER BY created_at ASC",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (r *SQLiteRepository) UpdateJob(ctx context.Context, job *Job) error {
+	job.UpdatedAt = time.Now()
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE jobs SET status = ?, progress = ?, result = ?, error = ?, updated_at = ?, started_at = ?, finished_at = ? WHERE id = ?",
+		job.Status, job.Progress, job.Result, job.Error, job.UpdatedAt, job.StartedAt, job.FinishedAt, job.ID)
+	return err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	job := &Job{}
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Type, &job.UserID, &job.Status, &job.Progress, &job.Payload, &job.Result, &job.Error,
+		&job.CreatedAt, &job.UpdatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+// Pipeline operations
+func (r *SQLiteRepository) CreatePipelineRun(ctx context.Context, run *PipelineRun) error {
+	now := time.Now()
+	run.CreatedAt = now
+	run.UpdatedAt = now
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO pipeline_runs (id, pipeline, job_id, user_id, status, input, output, error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		run.ID, run.Pipeline, run.JobID, run.UserID, run.Status, run.Input, run.Output, run.Error, now, now)
+	return err
+}
+
+func (r *SQLiteRepository) GetPipelineRun(ctx context.Context, id string) (*PipelineRun, error) {
+	run := &PipelineRun{}
+	var finishedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, pipeline, job_id, user_id, status, input, output, error, created_at, updated_at, finished_at FROM pipeline_runs WHERE id = ?", id).
+		Scan(&run.ID, &run.Pipeline, &run.JobID, &run.UserID, &run.Status, &run.Input, &run.Output, &run.Error, &run.CreatedAt, &run.UpdatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, nil
+}
+
+func (r *SQLiteRepository) UpdatePipelineRun(ctx context.Context, run *PipelineRun) error {
+	run.UpdatedAt = time.Now()
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE pipeline_runs SET job_id = ?, status = ?, output = ?, error = ?, updated_at = ?, finished_at = ? WHERE id = ?",
+		run.JobID, run.Status, run.Output, run.Error, run.UpdatedAt, run.FinishedAt, run.ID)
+	return err
+}
+
+func (r *SQLiteRepository) CreatePipelineArtifact(ctx context.Context, artifact *PipelineArtifact) error {
+	artifact.StartedAt = time.Now()
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO pipeline_artifacts (run_id, stage, attempt, status, input, output, error, started_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		artifact.RunID, artifact.Stage, artifact.Attempt, artifact.Status, artifact.Input, artifact.Output, artifact.Error, artifact.StartedAt)
+	if err != nil {
+		return err
+	}
+
+	artifact.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLiteRepository) UpdatePipelineArtifact(ctx context.Context, artifact *PipelineArtifact) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE pipeline_artifacts SET status = ?, output = ?, error = ?, finished_at = ? WHERE id = ?",
+		artifact.Status, artifact.Output, artifact.Error, artifact.FinishedAt, artifact.ID)
+	return err
+}
+
+func (r *SQLiteRepository) GetPipelineArtifacts(ctx context.Context, runID string) ([]*PipelineArtifact, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, run_id, stage, attempt, status, input, output, error, started_at, finished_at FROM pipeline_artifacts WHERE run_id = ? ORDER BY id ASC", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []*PipelineArtifact
+	for rows.Next() {
+		artifact := &PipelineArtifact{}
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&artifact.ID, &artifact.RunID, &artifact.Stage, &artifact.Attempt, &artifact.Status,
+			&artifact.Input, &artifact.Output, &artifact.Error, &artifact.StartedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			artifact.FinishedAt = &finishedAt.Time
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// Generation trace operations
+func (r *SQLiteRepository) CreateGenerationStep(ctx context.Context, step *GenerationStep) error {
+	step.CreatedAt = time.Now()
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO generation_steps (project_id, step, name, provider, model, system_prompt, prompt, response, error, latency_ms, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		step.ProjectID, step.Step, step.Name, step.Provider, step.Model, step.SystemPrompt, step.Prompt, step.Response, step.Error,
+		step.LatencyMS, step.PromptTokens, step.CompletionTokens, step.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	step.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLiteRepository) GetGenerationSteps(ctx context.Context, projectID int64) ([]*GenerationStep, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, project_id, step, name, provider, model, system_prompt, prompt, response, error, latency_ms, prompt_tokens, completion_tokens, created_at FROM generation_steps WHERE project_id = ? ORDER BY step ASC, id ASC",
+		projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []*GenerationStep
+	for rows.Next() {
+		step := &GenerationStep{}
+		if err := rows.Scan(&step.ID, &step.ProjectID, &step.Step, &step.Name, &step.Provider, &step.Model, &step.SystemPrompt,
+			&step.Prompt, &step.Response, &step.Error, &step.LatencyMS, &step.PromptTokens, &step.CompletionTokens, &step.CreatedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, rows.Err()
+}
+
+// API key operations
+func (r *SQLiteRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	key.CreatedAt = time.Now()
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO api_keys (user_id, role, name, prefix, key_hash, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		key.UserID, key.Role, key.Name, key.Prefix, key.KeyHash, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	key.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLiteRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	row := r.db.QueryRowContext(ctx,
+		"SELECT id, user_id, role, name, prefix, key_hash, created_at, last_used_at, revoked_at FROM api_keys WHERE key_hash = ?", keyHash)
+	return scanAPIKey(row)
+}
+
+func (r *SQLiteRepository) GetAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, user_id, role, name, prefix, key_hash, created_at, last_used_at, revoked_at FROM api_keys ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *SQLiteRepository) TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", time.Now(), id)
+	return err
+}
+
+func (r *SQLiteRepository) RevokeAPIKey(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.UserID, &key.Role, &key.Name, &key.Prefix, &key.KeyHash, &key.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+// Quota operations
+
+// AddQuotaUsage adds the requests and tokens of counter to the stored counter
+// of its period and writes the resulting totals back into counter
+func (r *SQLiteRepository) AddQuotaUsage(ctx context.Context, counter *QuotaCounter) error {
+	counter.UpdatedAt = time.Now()
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO quota_counters (user_id, scope, period, period_start, requests, tokens, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, scope, period, period_start) DO UPDATE SET
+			requests = requests + excluded.requests,
+			tokens = tokens + excluded.tokens,
+			updated_at = excluded.updated_at
+		RETURNING requests, tokens`,
+		counter.UserID, counter.Scope, counter.Period, counter.PeriodStart.Unix(), counter.Requests, counter.Tokens, counter.UpdatedAt).
+		Scan(&counter.Requests, &counter.Tokens)
+}
+
+// GetQuotaCounters returns the counters of the user whose period started at or after since
+func (r *SQLiteRepository) GetQuotaCounters(ctx context.Context, userID string, since time.Time) ([]*QuotaCounter, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT user_id, scope, period, period_start, requests, tokens, updated_at FROM quota_counters WHERE user_id = ? AND period_start >= ? ORDER BY scope ASC, period ASC, period_start ASC",
+		userID, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []*QuotaCounter
+	for rows.Next() {
+		counter := &QuotaCounter{}
+		var periodStart int64
+		if err := rows.Scan(&counter.UserID, &counter.Scope, &counter.Period, &periodStart, &counter.Requests, &counter.Tokens, &counter.UpdatedAt); err != nil {
+			return nil, err
+		}
+		counter.PeriodStart = time.Unix(periodStart, 0).UTC()
+		counters = append(counters, counter)
+	}
+
+	return counters, rows.Err()
+}
+
+// ResetQuotaCounters deletes the counters of the user, only of one scope when scope is not empty
+func (r *SQLiteRepository) ResetQuotaCounters(ctx context.Context, userID, scope string) (int64, error) {
+	query := "DELETE FROM quota_counters WHERE user_id = ?"
+	args := []interface{}{userID}
+	if scope != "" {
+		query += " AND scope = ?"
+		args = append(args, scope)
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteQuotaCountersBefore deletes counters of periods that started before the given time
+func (r *SQLiteRepository) DeleteQuotaCountersBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM quota_counters WHERE period_start < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Guard finding operations
+func (r *SQLiteRepository) CreateGuardFinding(ctx context.Context, finding *GuardFinding) error {
+	finding.CreatedAt = time.Now()
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO guard_findings (project_id, stage, rule, action, detail, count, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		finding.ProjectID, finding.Stage, finding.Rule, finding.Action, finding.Detail, finding.Count, finding.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	finding.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLiteRepository) GetGuardFindings(ctx context.Context, projectID int64) ([]*GuardFinding, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, project_id, stage, rule, action, detail, count, created_at FROM guard_findings WHERE project_id = ? ORDER BY id ASC", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var findings []*GuardFinding
+	for rows.Next() {
+		finding := &GuardFinding{}
+		if err := rows.Scan(&finding.ID, &finding.ProjectID, &finding.Stage, &finding.Rule, &finding.Action, &finding.Detail, &finding.Count, &finding.CreatedAt); err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings, rows.Err()
+}
+
+// Deployment operations
+func (r *SQLiteRepository) CreateDeployment(ctx context.Context, deployment *Deployment) error {
+	deployment.StartedAt = time.Now()
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO deployments (project_id, target, status, revision, file, url, remote_path, error, started_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		deployment.ProjectID, deployment.Target, deployment.Status, deployment.Revision, deployment.File, deployment.URL, deployment.RemotePath,
+		deployment.Error, deployment.StartedAt)
+	if err != nil {
+		return err
+	}
+
+	deployment.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *SQLiteRepository) UpdateDeployment(ctx context.Context, deployment *Deployment) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE deployments SET status = ?, url = ?, remote_path = ?, error = ?, verification = ?, finished_at = ? WHERE id = ?",
+		deployment.Status, deployment.URL, deployment.RemotePath, deployment.Error, deployment.Verification, deployment.FinishedAt, deployment.ID)
+	return err
+}
+
+func (r *SQLiteRepository) GetDeployment(ctx context.Context, id int64) (*Deployment, error) {
+	row := r.db.QueryRowContext(ctx,
+		"SELECT id, project_id, target, status, revision, file, url, remote_path, error, verification, started_at, finished_at FROM deployments WHERE id = ?", id)
+	return scanDeployment(row)
+}
+
+func (r *SQLiteRepository) GetDeployments(ctx context.Context, projectID int64) ([]*Deployment, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, project_id, target, status, revision, file, url, remote_path, error, verification, started_at, finished_at FROM deployments WHERE project_id = ? ORDER BY id DESC", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deployments []*Deployment
+	for rows.Next() {
+		deployment, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, deployment)
+	}
+
+	return deployments, rows.Err()
+}
+
+func scanDeployment(row rowScanner) (*Deployment, error) {
+	deployment := &Deployment{}
+	var projectID sql.NullInt64
+	var finishedAt sql.NullTime
+	if err := row.Scan(&deployment.ID, &projectID, &deployment.Target, &deployment.Status, &deployment.Revision, &deployment.File, &deployment.URL,
+		&deployment.RemotePath, &deployment.Error, &deployment.Verification, &deployment.StartedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+
+	if projectID.Valid {
+		deployment.ProjectID = &projectID.Int64
+	}
+	if finishedAt.Valid {
+		deployment.FinishedAt = &finishedAt.Time
+	}
+	return deployment, nil
+}
+
+// Revision operations
+
+// CreateProjectRevision records the revision unless the project already has one with the
+// same hash; either way the stored revision is written back into rev
+func (r *SQLiteRepository) CreateProjectRevision(ctx context.Context, rev *ProjectRevision) error {
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO project_revisions (project_id, hash, file_path, size, source, parent, instruction, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (project_id, hash) DO NOTHING",
+		rev.ProjectID, rev.Hash, rev.FilePath, rev.Size, rev.Source, rev.Parent, rev.Instruction, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(ctx,
+		"SELECT id, project_id, hash, file_path, size, source, parent, instruction, created_at FROM project_revisions WHERE project_id = ? AND hash = ?",
+		rev.ProjectID, rev.Hash).
+		Scan(&rev.ID, &rev.ProjectID, &rev.Hash, &rev.FilePath, &rev.Size, &rev.Source, &rev.Parent, &rev.Instruction, &rev.CreatedAt)
+}
+
+func (r *SQLiteRepository) GetProjectRevisions(ctx context.Context, projectID int64) ([]*ProjectRevision, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, project_id, hash, file_path, size, source, parent, instruction, created_at FROM project_revisions WHERE project_id = ? ORDER BY id DESC", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*ProjectRevision
+	for rows.Next() {
+		rev := &ProjectRevision{}
+		if err := rows.Scan(&rev.ID, &rev.ProjectID, &rev.Hash, &rev.FilePath, &rev.Size, &rev.Source, &rev.Parent, &rev.Instruction, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Audit operations
+func (r *SQLiteRepository) CreateAuditEvent(ctx context.Context, event *AuditEvent) error {
+	// Times are stored in UTC, so the text comparison of the time filters holds
+	event.StartedAt = event.StartedAt.UTC()
+	event.FinishedAt = event.FinishedAt.UTC()
+	event.CreatedAt = time.Now().UTC()
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO audit_events (actor, actor_role, action, target, request_hash, outcome, status_code, error, project_id, chat_id, started_at, finished_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Actor, event.ActorRole, event.Action, event.Target, event.RequestHash, event.Outcome, event.StatusCode, event.Error,
+		event.ProjectID, event.ChatID, event.StartedAt, event.FinishedAt, event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetAuditEvents returns the events matching the filter, newest first
+func (r *SQLiteRepository) GetAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	query := `SELECT id, actor, actor_role, action, target, request_hash, outcome, status_code, error, project_id, chat_id, started_at, finished_at, created_at
+		FROM audit_events WHERE 1 = 1`
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		query += " AND " + condition
+		args = append(args, arg)
+	}
+
+	if filter.Actor != "" {
+		where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ?", filter.Outcome)
+	}
+	if filter.Target != "" {
+		where("target = ?", filter.Target)
+	}
+	if filter.ProjectID != 0 {
+		where("project_id = ?", filter.ProjectID)
+	}
+	if filter.ChatID != 0 {
+		where("chat_id = ?", filter.ChatID)
+	}
+	if !filter.Since.IsZero() {
+		where("started_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("started_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		where("id < ?", filter.BeforeID)
+	}
+
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		event := &AuditEvent{}
+		var projectID, chatID sql.NullInt64
+		if err := rows.Scan(&event.ID, &event.Actor, &event.ActorRole, &event.Action, &event.Target, &event.RequestHash, &event.Outcome,
+			&event.StatusCode, &event.Error, &projectID, &chatID, &event.StartedAt, &event.FinishedAt, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if projectID.Valid {
+			event.ProjectID = &projectID.Int64
+		}
+		if chatID.Valid {
+			event.ChatID = &chatID.Int64
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Search operations
+
+// Search returns the messages, projects and images matching the query, best first.
+// The score is the BM25 rank of the search_index FTS5 table, negated so that higher is better.
+func (r *SQLiteRepository) Search(ctx context.Context, query SearchQuery) ([]*SearchHit, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	match := make([]string, len(terms))
+	for i, term := range terms {
+		match[i] = `"` + term + `"*`
+	}
+
+	sqlQuery := `SELECT kind, ref_id, chat_id, title, snippet, score FROM (
+			SELECT search_index.kind, search_index.ref_id, search_index.chat_id,
+				CASE WHEN search_index.kind = 'project' THEN highlight(search_index, 3, char(2), char(3)) ELSE COALESCE(c.title, '') END AS title,
+				snippet(search_index, 4, char(2), char(3), '…', 16) AS snippet,
+				-bm25(search_index, 0, 0, 0, 2.0, 1.0) AS score
+			FROM search_index LEFT JOIN chats c ON c.id = search_index.chat_id
+			WHERE search_index MATCH ?
+		) WHERE 1 = 1`
+	args := []interface{}{strings.Join(match, " ")}
+
+	if query.Kind != "" {
+		sqlQuery += " AND kind = ?"
+		args = append(args, query.Kind)
+	}
+	if query.ChatID != 0 {
+		sqlQuery += " AND chat_id = ?"
+		args = append(args, query.ChatID)
+	}
+	if query.UserID != "" {
+		sqlQuery += " AND chat_id IN (SELECT id FROM chats WHERE user_id = ?)"
+		args = append(args, query.UserID)
+	}
+	if after := query.After; after != nil {
+		sqlQuery += " AND (score < ? OR (score = ? AND (kind > ? OR (kind = ? AND ref_id > ?))))"
+		args = append(args, after.Score, after.Score, after.Kind, after.Kind, after.ID)
+	}
+	sqlQuery += " ORDER BY score DESC, kind ASC, ref_id ASC"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []*SearchHit
+	for rows.Next() {
+		hit := &SearchHit{}
+		if err := rows.Scan(&hit.Kind, &hit.ID, &hit.ChatID, &hit.Title, &hit.Snippet, &hit.Score); err != nil {
+			return nil, err
+		}
+		hit.Title = highlightHTML(hit.Title)
+		hit.Snippet = highlightHTML(hit.Snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
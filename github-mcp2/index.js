@@ -67,6 +67,28 @@ class GitHubPushMCP {
               required: ['filePath'],
             },
           },
+          {
+            name: 'push_directory_to_github',
+            description: 'Push all files of a directory to the GitHub repository https://github.com/p12s/ai-advent-package.git in a single commit',
+            inputSchema: {
+              type: 'object',
+              properties: {
+                dirPath: {
+                  type: 'string',
+                  description: 'Path to the directory to push to GitHub',
+                },
+                targetPath: {
+                  type: 'string',
+                  description: 'Target directory in the repository (optional, defaults to the directory name)',
+                },
+                commitMessage: {
+                  type: 'string',
+                  description: 'Custom commit message (optional, auto-generated if not provided)',
+                },
+              },
+              required: ['dirPath'],
+            },
+          },
         ],
       };
     });
@@ -78,6 +100,10 @@ class GitHubPushMCP {
         return await this.pushFileToGitHub(args);
       }
 
+      if (name === 'push_directory_to_github') {
+        return await this.pushDirectoryToGitHub(args);
+      }
+
       throw new McpError(
         ErrorCode.MethodNotFound,
         `Unknown tool: ${name}`
@@ -191,6 +217,120 @@ class GitHubPushMCP {
     }
   }
 
+  // Collects the files of a directory recursively as paths relative to it
+  async listFiles(dir, prefix = '') {
+    const files = [];
+    for (const entry of await fs.readdir(dir, { withFileTypes: true })) {
+      if (entry.name.startsWith('.')) {
+        continue;
+      }
+      const relativePath = prefix ? `${prefix}/${entry.name}` : entry.name;
+      if (entry.isDirectory()) {
+        files.push(...await this.listFiles(path.join(dir, entry.name), relativePath));
+      } else {
+        files.push(relativePath);
+      }
+    }
+    return files;
+  }
+
+  // Pushes the whole directory as one commit through the Git Data API,
+  // so a multi-page site never appears in the repository half-updated
+  async pushDirectoryToGitHub(args) {
+    try {
+      const { dirPath, targetPath, commitMessage } = args;
+
+      if (!await fs.pathExists(dirPath) || !(await fs.stat(dirPath)).isDirectory()) {
+        throw new Error(`Directory not found: ${dirPath}`);
+      }
+
+      const dirName = path.basename(dirPath);
+      const repoTargetPath = (targetPath || dirName).replace(/^\/+|\/+$/g, '');
+      const files = await this.listFiles(dirPath);
+      if (files.length === 0) {
+        throw new Error(`Directory is empty: ${dirPath}`);
+      }
+
+      const owner = 'p12s';
+      const repo = 'ai-advent-package';
+      const branch = 'main';
+      const finalCommitMessage = commitMessage || `Add ${dirName} - automated commit via MCP`;
+
+      const { data: refData } = await this.octokit.rest.git.getRef({
+        owner,
+        repo,
+        ref: `heads/${branch}`,
+      });
+      const parentSha = refData.object.sha;
+      const { data: parentCommit } = await this.octokit.rest.git.getCommit({
+        owner,
+        repo,
+        commit_sha: parentSha,
+      });
+
+      const tree = [];
+      for (const file of files) {
+        const content = await fs.readFile(path.join(dirPath, file));
+        const { data: blob } = await this.octokit.rest.git.createBlob({
+          owner,
+          repo,
+          content: content.toString('base64'),
+          encoding: 'base64',
+        });
+        tree.push({ path: `${repoTargetPath}/${file}`, mode: '100644', type: 'blob', sha: blob.sha });
+      }
+
+      const { data: newTree } = await this.octokit.rest.git.createTree({
+        owner,
+        repo,
+        base_tree: parentCommit.tree.sha,
+        tree,
+      });
+      const { data: commit } = await this.octokit.rest.git.createCommit({
+        owner,
+        repo,
+        message: finalCommitMessage,
+        tree: newTree.sha,
+        parents: [parentSha],
+      });
+      await this.octokit.rest.git.updateRef({
+        owner,
+        repo,
+        ref: `heads/${branch}`,
+        sha: commit.sha,
+      });
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `Successfully pushed directory to GitHub!\n\nDetails:\n- Directory: ${dirName}\n- Files: ${files.length}\n- Target path: ${repoTargetPath}\n- Repository: ${owner}/${repo}\n- Branch: ${branch}\n- Commit message: ${finalCommitMessage}\n- Commit SHA: ${commit.sha}\n- Commit URL: ${commit.html_url}`,
+          },
+        ],
+      };
+    } catch (error) {
+      console.error('Error pushing directory to GitHub:', error);
+
+      let errorMessage = 'Failed to push directory to GitHub';
+      if (error.message) {
+        errorMessage += `: ${error.message}`;
+      }
+      if (error.status) {
+        errorMessage += ` (HTTP ${error.status})`;
+      }
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: errorMessage,
+          },
+        ],
+        isError: true,
+      };
+    }
+  }
+
   async run() {
     const transport = new StdioServerTransport();
     await this.server.connect(transport);